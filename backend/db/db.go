@@ -67,11 +67,22 @@ func InitDB(dbConfig ...string) {
 // Global variable to track pgvector availability
 var hasPgVector bool
 
+// pgVectorVersion is the installed version of the pgvector extension (e.g. "0.8.0"), empty without pgvector
+var pgVectorVersion string
+
+// VectorIndexLists is the number of lists of the ivfflat index of the chunk embeddings
+const VectorIndexLists = 100
+
 // HasPgVector returns whether pgvector extension is available
 func HasPgVector() bool {
 	return hasPgVector
 }
 
+// PgVectorVersion returns the installed version of the pgvector extension, empty without pgvector
+func PgVectorVersion() string {
+	return pgVectorVersion
+}
+
 func createTables() {
 	// Create UUID extension (always available)
 	_, err := DB.Exec(`CREATE EXTENSION IF NOT EXISTS "uuid-ossp"`)
@@ -90,6 +101,12 @@ func createTables() {
 	} else {
 		hasPgVector = true
 		log.Println("pgvector extension enabled successfully")
+
+		// The version decides how filtered similarity searches scan the ivfflat index
+		err = DB.QueryRow(`SELECT extversion FROM pg_extension WHERE extname = 'vector'`).Scan(&pgVectorVersion)
+		if err != nil {
+			log.Printf("Warning: Could not read pgvector version: %v", err)
+		}
 	}
 
 	// Store pgvector availability for other packages
@@ -161,10 +178,10 @@ func createTables() {
 		// Vector index for efficient ANN search
 		// This index allows for fast similarity search using vector embeddings
 		// It uses the ivfflat algorithm for approximate nearest neighbor search
-		_, err = DB.Exec(`
+		_, err = DB.Exec(fmt.Sprintf(`
 		CREATE INDEX IF NOT EXISTS idx_chunks_embedding
-		ON chunks USING ivfflat (embedding vector_cosine_ops) WITH (lists = 100)
-		`)
+		ON chunks USING ivfflat (embedding vector_cosine_ops) WITH (lists = %d)
+		`, VectorIndexLists))
 		if err != nil {
 			log.Printf("Warning: Could not create vector index: %v", err)
 		} else {
@@ -184,6 +201,26 @@ func createTables() {
 			log.Println("Text search index created successfully")
 		}
 	}
+
+	// Chunks are scanned for instructions addressed to the model when they are ingested (indirect prompt injection)
+	// 	injection_flagged: the document checks of the guardrail policy reported an error on the content
+	// 	injection_reasons: the rules that matched
+	alterChunksTable := `
+	ALTER TABLE chunks
+		ADD COLUMN IF NOT EXISTS injection_flagged BOOLEAN NOT NULL DEFAULT FALSE,
+		ADD COLUMN IF NOT EXISTS injection_reasons TEXT[]
+	`
+	_, err = DB.Exec(alterChunksTable)
+	if err != nil {
+		fmt.Println("Error updating chunks table:", err)
+		panic("Could not update chunks table.")
+	}
+
+	// Partial index for the flagged documents report, only the few flagged chunks are indexed
+	_, err = DB.Exec(`CREATE INDEX IF NOT EXISTS idx_chunks_injection_flagged ON chunks (document_id) WHERE injection_flagged`)
+	if err != nil {
+		log.Printf("Warning: Could not create flagged chunks index: %v", err)
+	}
 	// Create the users table
 	createUsersTable := `
 	CREATE TABLE IF NOT EXISTS users (
@@ -204,6 +241,131 @@ func createTables() {
 		panic("Could not create users table.")
 	}
 
+	// Create the role based access control tables
+	// 	roles: built-in (viewer, contributor, admin) and custom roles, the built-in ones are seeded at startup
+	// 	permissions: the permissions checked by the routes (e.g. documents:upload)
+	// 	role_permissions: the permissions each role grants
+	// 	user_roles: the roles of each user, users without any role get DEFAULT_USER_ROLE
+	createRolesTable := `
+	CREATE TABLE IF NOT EXISTS roles (
+		name TEXT PRIMARY KEY,
+		description TEXT NOT NULL DEFAULT '',
+		created_at TIMESTAMP DEFAULT now()
+	)
+	`
+	_, err = DB.Exec(createRolesTable)
+	if err != nil {
+		fmt.Println("Error creating roles table:", err)
+		panic("Could not create roles table.")
+	}
+
+	createPermissionsTable := `
+	CREATE TABLE IF NOT EXISTS permissions (
+		name TEXT PRIMARY KEY,
+		description TEXT NOT NULL DEFAULT ''
+	)
+	`
+	_, err = DB.Exec(createPermissionsTable)
+	if err != nil {
+		fmt.Println("Error creating permissions table:", err)
+		panic("Could not create permissions table.")
+	}
+
+	createRolePermissionsTable := `
+	CREATE TABLE IF NOT EXISTS role_permissions (
+		role TEXT NOT NULL REFERENCES roles(name) ON DELETE CASCADE,
+		permission TEXT NOT NULL REFERENCES permissions(name) ON DELETE CASCADE,
+		PRIMARY KEY (role, permission)
+	)
+	`
+	_, err = DB.Exec(createRolePermissionsTable)
+	if err != nil {
+		fmt.Println("Error creating role_permissions table:", err)
+		panic("Could not create role_permissions table.")
+	}
+
+	// granted_by: the admin who assigned the role
+	createUserRolesTable := `
+	CREATE TABLE IF NOT EXISTS user_roles (
+		user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+		role TEXT NOT NULL REFERENCES roles(name) ON DELETE CASCADE,
+		granted_by UUID REFERENCES users(id) ON DELETE SET NULL,
+		granted_at TIMESTAMP DEFAULT now(),
+		PRIMARY KEY (user_id, role)
+	)
+	`
+	_, err = DB.Exec(createUserRolesTable)
+	if err != nil {
+		fmt.Println("Error creating user_roles table:", err)
+		panic("Could not create user_roles table.")
+	}
+
+	// Document access control
+	// 	owner_id: the user who uploaded the document, they can share and delete it
+	// 	document_shares: who else can read the document, a user (subject: user ID), a group (subject: team name) or everyone
+	// Documents uploaded before owners existed were readable by everyone, they are shared with everyone once
+	var hasDocumentOwners bool
+	err = DB.QueryRow(`
+	SELECT EXISTS (SELECT 1 FROM information_schema.columns WHERE table_name = 'documents' AND column_name = 'owner_id')
+	`).Scan(&hasDocumentOwners)
+	if err != nil {
+		fmt.Println("Error checking documents table:", err)
+		panic("Could not check documents table.")
+	}
+
+	_, err = DB.Exec(`ALTER TABLE documents ADD COLUMN IF NOT EXISTS owner_id UUID REFERENCES users(id) ON DELETE SET NULL`)
+	if err != nil {
+		fmt.Println("Error updating documents table:", err)
+		panic("Could not update documents table.")
+	}
+
+	createDocumentSharesTable := `
+	CREATE TABLE IF NOT EXISTS document_shares (
+		document_id UUID NOT NULL REFERENCES documents(id) ON DELETE CASCADE,
+		subject_type TEXT NOT NULL CHECK (subject_type IN ('user', 'group', 'everyone')),
+		subject TEXT NOT NULL DEFAULT '',
+		granted_by UUID REFERENCES users(id) ON DELETE SET NULL,
+		created_at TIMESTAMP DEFAULT now(),
+		PRIMARY KEY (document_id, subject_type, subject)
+	)
+	`
+	_, err = DB.Exec(createDocumentSharesTable)
+	if err != nil {
+		fmt.Println("Error creating document_shares table:", err)
+		panic("Could not create document_shares table.")
+	}
+
+	if !hasDocumentOwners {
+		_, err = DB.Exec(`
+		INSERT INTO document_shares (document_id, subject_type, subject)
+		SELECT id, 'everyone', '' FROM documents
+		ON CONFLICT DO NOTHING
+		`)
+		if err != nil {
+			fmt.Println("Error sharing existing documents:", err)
+			panic("Could not share existing documents.")
+		}
+	}
+
+	// Indexes for the access condition of the document list and the similarity search
+	_, err = DB.Exec(`CREATE INDEX IF NOT EXISTS idx_documents_owner_id ON documents (owner_id)`)
+	if err != nil {
+		log.Printf("Warning: Could not create documents owner index: %v", err)
+	}
+	_, err = DB.Exec(`CREATE INDEX IF NOT EXISTS idx_document_shares_subject ON document_shares (subject_type, subject)`)
+	if err != nil {
+		log.Printf("Warning: Could not create document shares index: %v", err)
+	}
+
+	// Evaluation documents
+	// 	evaluation: the document holds a context of an evaluation run, only that run reads it
+	// The live search, the document lists and the reports never reach these documents
+	_, err = DB.Exec(`ALTER TABLE documents ADD COLUMN IF NOT EXISTS evaluation BOOLEAN NOT NULL DEFAULT FALSE`)
+	if err != nil {
+		fmt.Println("Error updating documents table:", err)
+		panic("Could not update documents table.")
+	}
+
 	// Create the reset_tokens table
 	createResetTokensTable := `
 	CREATE TABLE IF NOT EXISTS reset_tokens (
@@ -240,4 +402,234 @@ func createTables() {
 		fmt.Println("Error creating questions table:", err)
 		panic("Could not create questions table.")
 	}
+
+	// Extend the questions table with the metadata recorded for every query
+	// ADD COLUMN IF NOT EXISTS keeps this safe for databases created before these columns existed
+	// 	chunk_ids: the chunks that were retrieved and sent to the model
+	// 	provider/model: which AI provider produced the answer
+	// 	latency_ms: end-to-end handling time of the query
+	// 	violations: JSON array of guardrail violations raised for the question
+	// 	status: answered, blocked or failed
+	// 	template_name/template_version: the prompt template that produced the answer (version 0 is the built-in one)
+	alterQuestionsTable := `
+	ALTER TABLE questions
+		ADD COLUMN IF NOT EXISTS chunk_ids UUID[],
+		ADD COLUMN IF NOT EXISTS provider TEXT,
+		ADD COLUMN IF NOT EXISTS model TEXT,
+		ADD COLUMN IF NOT EXISTS latency_ms BIGINT,
+		ADD COLUMN IF NOT EXISTS violations JSONB,
+		ADD COLUMN IF NOT EXISTS status TEXT NOT NULL DEFAULT 'answered',
+		ADD COLUMN IF NOT EXISTS template_name TEXT,
+		ADD COLUMN IF NOT EXISTS template_version INT
+	`
+	_, err = DB.Exec(alterQuestionsTable)
+	if err != nil {
+		fmt.Println("Error updating questions table:", err)
+		panic("Could not update questions table.")
+	}
+
+	// Index to speed up history lookups per user
+	_, err = DB.Exec(`CREATE INDEX IF NOT EXISTS idx_questions_user_asked_at ON questions (user_id, asked_at DESC)`)
+	if err != nil {
+		log.Printf("Warning: Could not create questions index: %v", err)
+	}
+
+	// Create the answer_feedback table
+	// Users rate the answers they received (thumbs up = 1, thumbs down = -1)
+	// and can flag specific cited chunks as wrong or irrelevant
+	// A user has at most one rating per question, later ratings replace the previous one
+	createAnswerFeedbackTable := `
+	CREATE TABLE IF NOT EXISTS answer_feedback (
+		id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+		question_id UUID NOT NULL REFERENCES questions(id) ON DELETE CASCADE,
+		user_id UUID REFERENCES users(id) ON DELETE SET NULL,
+		rating SMALLINT NOT NULL CHECK (rating IN (-1, 1)),
+		comment TEXT,
+		flagged_chunk_ids UUID[] NOT NULL DEFAULT '{}',
+		created_at TIMESTAMP DEFAULT now(),
+		UNIQUE (question_id, user_id)
+	)
+	`
+	_, err = DB.Exec(createAnswerFeedbackTable)
+	if err != nil {
+		fmt.Println("Error creating answer_feedback table:", err)
+		panic("Could not create answer_feedback table.")
+	}
+
+	// Create the prompt_templates table
+	// Templates are Go text/template sources, stored per name with an increasing version
+	// Editing a template always creates a new version so recorded answers keep pointing to the exact text used
+	// At most one version per name is active, templates without an active version fall back to the built-in one
+	createPromptTemplatesTable := `
+	CREATE TABLE IF NOT EXISTS prompt_templates (
+		id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+		name TEXT NOT NULL,
+		version INT NOT NULL,
+		system_template TEXT NOT NULL DEFAULT '',
+		user_template TEXT NOT NULL,
+		description TEXT,
+		is_active BOOLEAN NOT NULL DEFAULT false,
+		created_by UUID REFERENCES users(id) ON DELETE SET NULL,
+		created_at TIMESTAMP DEFAULT now(),
+		UNIQUE (name, version)
+	)
+	`
+	_, err = DB.Exec(createPromptTemplatesTable)
+	if err != nil {
+		fmt.Println("Error creating prompt_templates table:", err)
+		panic("Could not create prompt_templates table.")
+	}
+
+	// Partial unique index guaranteeing a single active version per template name
+	_, err = DB.Exec(`CREATE UNIQUE INDEX IF NOT EXISTS idx_prompt_templates_active ON prompt_templates (name) WHERE is_active`)
+	if err != nil {
+		log.Printf("Warning: Could not create prompt_templates index: %v", err)
+	}
+
+	// Create the lexical vocabulary tables
+	// They hold the corpus statistics of the in-process lexical embedding provider (AI_PROVIDER=lexical)
+	// 	lexical_vocabulary: number of chunks containing each term (document frequency)
+	// 	lexical_stats: a single row with the number of indexed chunks
+	createLexicalVocabularyTable := `
+	CREATE TABLE IF NOT EXISTS lexical_vocabulary (
+		term TEXT PRIMARY KEY,
+		document_frequency BIGINT NOT NULL
+	)
+	`
+	_, err = DB.Exec(createLexicalVocabularyTable)
+	if err != nil {
+		fmt.Println("Error creating lexical_vocabulary table:", err)
+		panic("Could not create lexical_vocabulary table.")
+	}
+
+	createLexicalStatsTable := `
+	CREATE TABLE IF NOT EXISTS lexical_stats (
+		id SMALLINT PRIMARY KEY CHECK (id = 1),
+		document_count BIGINT NOT NULL DEFAULT 0,
+		updated_at TIMESTAMP DEFAULT now()
+	)
+	`
+	_, err = DB.Exec(createLexicalStatsTable)
+	if err != nil {
+		fmt.Println("Error creating lexical_stats table:", err)
+		panic("Could not create lexical_stats table.")
+	}
+
+	// Users can belong to a team, team quotas are shared by all its members
+	_, err = DB.Exec(`ALTER TABLE users ADD COLUMN IF NOT EXISTS team TEXT`)
+	if err != nil {
+		fmt.Println("Error updating users table:", err)
+		panic("Could not update users table.")
+	}
+
+	// Create the token_usage table
+	// One row per model used by a request, with the tokens reported by the provider
+	// 	operation: chat or embedding
+	// 	team: the team of the user when the tokens were used, so team totals survive team changes
+	// 	estimated: the provider did not report usage and the tokens were approximated from the text
+	createTokenUsageTable := `
+	CREATE TABLE IF NOT EXISTS token_usage (
+		id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+		user_id UUID REFERENCES users(id) ON DELETE SET NULL,
+		team TEXT,
+		question_id UUID REFERENCES questions(id) ON DELETE SET NULL,
+		operation TEXT NOT NULL,
+		provider TEXT NOT NULL,
+		model TEXT NOT NULL,
+		prompt_tokens BIGINT NOT NULL DEFAULT 0,
+		completion_tokens BIGINT NOT NULL DEFAULT 0,
+		estimated BOOLEAN NOT NULL DEFAULT false,
+		created_at TIMESTAMP DEFAULT now()
+	)
+	`
+	_, err = DB.Exec(createTokenUsageTable)
+	if err != nil {
+		fmt.Println("Error creating token_usage table:", err)
+		panic("Could not create token_usage table.")
+	}
+
+	// Indexes for the quota checks, which sum the usage of a user or a team since the start of the period
+	_, err = DB.Exec(`CREATE INDEX IF NOT EXISTS idx_token_usage_user_created_at ON token_usage (user_id, created_at)`)
+	if err != nil {
+		log.Printf("Warning: Could not create token_usage user index: %v", err)
+	}
+	_, err = DB.Exec(`CREATE INDEX IF NOT EXISTS idx_token_usage_team_created_at ON token_usage (team, created_at) WHERE team IS NOT NULL`)
+	if err != nil {
+		log.Printf("Warning: Could not create token_usage team index: %v", err)
+	}
+
+	// Create the usage_quotas table
+	// A quota limits the tokens (prompt + completion, all operations) used by a user or a team per day or month
+	// 	scope: user or team, subject: the user ID or the team name
+	// 	period: daily or monthly, periods start at midnight / the first of the month (server time)
+	createUsageQuotasTable := `
+	CREATE TABLE IF NOT EXISTS usage_quotas (
+		id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+		scope TEXT NOT NULL CHECK (scope IN ('user', 'team')),
+		subject TEXT NOT NULL,
+		period TEXT NOT NULL CHECK (period IN ('daily', 'monthly')),
+		token_limit BIGINT NOT NULL CHECK (token_limit > 0),
+		updated_by UUID REFERENCES users(id) ON DELETE SET NULL,
+		updated_at TIMESTAMP DEFAULT now(),
+		UNIQUE (scope, subject, period)
+	)
+	`
+	_, err = DB.Exec(createUsageQuotasTable)
+	if err != nil {
+		fmt.Println("Error creating usage_quotas table:", err)
+		panic("Could not create usage_quotas table.")
+	}
+
+	// Create the guardrail_violations table
+	// The audit log of every violation reported by the guardrail checks, for security review
+	// 	stage: input (question) or output (answer), check_name: the pipeline check that reported it
+	// 	matched_rule: the blocked phrase, pattern or regex rule name that matched
+	// 	policy_version: the version of the guardrail policy enforced at the time
+	// 	false_positive: set by an admin reviewing the violation, used to tune the rules
+	createGuardrailViolationsTable := `
+	CREATE TABLE IF NOT EXISTS guardrail_violations (
+		id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+		question_id UUID REFERENCES questions(id) ON DELETE SET NULL,
+		user_id UUID REFERENCES users(id) ON DELETE SET NULL,
+		stage TEXT NOT NULL DEFAULT 'input',
+		check_name TEXT NOT NULL DEFAULT '',
+		type TEXT NOT NULL,
+		severity TEXT NOT NULL,
+		message TEXT NOT NULL DEFAULT '',
+		question TEXT NOT NULL,
+		matched_rule TEXT NOT NULL DEFAULT '',
+		policy_version TEXT NOT NULL DEFAULT '',
+		false_positive BOOLEAN NOT NULL DEFAULT false,
+		review_note TEXT,
+		reviewed_by UUID REFERENCES users(id) ON DELETE SET NULL,
+		reviewed_at TIMESTAMP,
+		created_at TIMESTAMP DEFAULT now()
+	)
+	`
+	_, err = DB.Exec(createGuardrailViolationsTable)
+	if err != nil {
+		fmt.Println("Error creating guardrail_violations table:", err)
+		panic("Could not create guardrail_violations table.")
+	}
+
+	// redactions: number of personal data values the pii check redacted or masked (0 for the other checks)
+	_, err = DB.Exec(`ALTER TABLE guardrail_violations ADD COLUMN IF NOT EXISTS redactions INT NOT NULL DEFAULT 0`)
+	if err != nil {
+		fmt.Println("Error updating guardrail_violations table:", err)
+		panic("Could not update guardrail_violations table.")
+	}
+
+	// Indexes for the admin review, which filters by user or type over a time range
+	_, err = DB.Exec(`CREATE INDEX IF NOT EXISTS idx_guardrail_violations_created_at ON guardrail_violations (created_at DESC)`)
+	if err != nil {
+		log.Printf("Warning: Could not create guardrail_violations index: %v", err)
+	}
+	_, err = DB.Exec(`CREATE INDEX IF NOT EXISTS idx_guardrail_violations_user_created_at ON guardrail_violations (user_id, created_at DESC)`)
+	if err != nil {
+		log.Printf("Warning: Could not create guardrail_violations user index: %v", err)
+	}
+	_, err = DB.Exec(`CREATE INDEX IF NOT EXISTS idx_guardrail_violations_type_created_at ON guardrail_violations (type, created_at DESC)`)
+	if err != nil {
+		log.Printf("Warning: Could not create guardrail_violations type index: %v", err)
+	}
 }
@@ -0,0 +1,158 @@
+// Package eval runs the RAG and guardrail test data sets (test-data/*.csv) through the real
+// question validation and RAG pipeline and reports the pass rate and the retrieval quality
+package eval
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Suites of test cases, decided by the columns of the CSV file
+// A rag case asks a question its context answers, a guardrails case attacks the assistant
+const (
+	SuiteRAG        = "rag"
+	SuiteGuardrails = "guardrails"
+)
+
+// Case is a test case of a data set
+// ExpectedKeywords must all be in the answer of a rag case, at least one of them in the answer
+// of a guardrails case that is not blocked; ForbiddenKeywords must never be in the answer
+type Case struct {
+	ID                string   `json:"id"`
+	Suite             string   `json:"suite"`
+	Category          string   `json:"category"`
+	Context           string   `json:"context"`
+	Question          string   `json:"question"`
+	ExpectedKeywords  []string `json:"expected_keywords"`
+	ForbiddenKeywords []string `json:"forbidden_keywords"`
+}
+
+// datasetColumns are the columns of a data set, the alternatives are the guardrails names
+// A slice, so a file missing several columns always reports the same one
+var datasetColumns = []struct {
+	field string
+	names []string
+}{
+	{"context", []string{"context"}},
+	{"question", []string{"question", "malicious_question"}},
+	{"category", []string{"test_category", "attack_type"}},
+	{"expected", []string{"expected_keywords", "expected_response_pattern"}},
+	{"forbidden", []string{"should_not_contain"}},
+}
+
+// DefaultDataSets are the data sets of test-data run when none is given
+var DefaultDataSets = []string{"basic_rag_tests.csv", "guardrail_tests.csv"}
+
+// LoadDataSets reads the cases of the data sets in dir, in the order given
+func LoadDataSets(dir string, names []string) ([]Case, error) {
+	if len(names) == 0 {
+		names = DefaultDataSets
+	}
+
+	var cases []Case
+	for _, name := range names {
+		loaded, err := LoadCases(filepath.Join(dir, name))
+		if err != nil {
+			return nil, err
+		}
+		cases = append(cases, loaded...)
+	}
+	return cases, nil
+}
+
+// LoadCases reads the test cases of a CSV file
+func LoadCases(path string) ([]Case, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	return ReadCases(file, filepath.Base(path))
+}
+
+// ReadCases reads test cases in the format of test-data/basic_rag_tests.csv or guardrail_tests.csv
+// Lines starting with # are comments, the first record is the header
+// A case is named after the data set and its position, e.g. basic_rag_tests.csv#2
+func ReadCases(r io.Reader, name string) ([]Case, error) {
+	reader := csv.NewReader(r)
+	reader.Comment = '#'
+
+	header, err := reader.Read()
+	if errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("%s: no header", name)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+
+	positions := make(map[string]int, len(header))
+	for i, column := range header {
+		positions[strings.TrimSpace(column)] = i
+	}
+	columns := make(map[string]int, len(datasetColumns))
+	for _, required := range datasetColumns {
+		field := required.field
+		columns[field] = -1
+		for _, column := range required.names {
+			if position, ok := positions[column]; ok {
+				columns[field] = position
+				break
+			}
+		}
+		if columns[field] < 0 {
+			return nil, fmt.Errorf("%s: missing column %s", name, strings.Join(required.names, " or "))
+		}
+	}
+
+	suite := SuiteRAG
+	if _, ok := positions["malicious_question"]; ok {
+		suite = SuiteGuardrails
+	}
+
+	var cases []Case
+	for {
+		record, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+
+		testCase := Case{
+			ID:                fmt.Sprintf("%s#%d", name, len(cases)+1),
+			Suite:             suite,
+			Context:           strings.TrimSpace(record[columns["context"]]),
+			Question:          strings.TrimSpace(record[columns["question"]]),
+			Category:          strings.TrimSpace(record[columns["category"]]),
+			ExpectedKeywords:  splitKeywords(record[columns["expected"]]),
+			ForbiddenKeywords: splitKeywords(record[columns["forbidden"]]),
+		}
+		if testCase.Question == "" {
+			return nil, fmt.Errorf("%s: case %d has no question", name, len(cases)+1)
+		}
+		cases = append(cases, testCase)
+	}
+
+	if len(cases) == 0 {
+		return nil, fmt.Errorf("%s: no test cases", name)
+	}
+	return cases, nil
+}
+
+// splitKeywords splits a comma separated list of keywords, empty entries are dropped
+func splitKeywords(value string) []string {
+	var keywords []string
+	for _, keyword := range strings.Split(value, ",") {
+		if keyword = strings.TrimSpace(keyword); keyword != "" {
+			keywords = append(keywords, keyword)
+		}
+	}
+	return keywords
+}
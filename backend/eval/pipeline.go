@@ -0,0 +1,125 @@
+package eval
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"github.com/MauricioAliendre182/backend/models"
+	"github.com/MauricioAliendre182/backend/utils"
+	"github.com/google/uuid"
+)
+
+// evalContentType is the content type of the chunks stored for a run
+const evalContentType = "text/plain"
+
+// RAGPipeline runs the cases through the database and the configured AI provider
+// The contexts are stored as evaluation documents named eval_<case> and removed by Cleanup
+// The run only reads evaluation documents and the live search never reads them, so the uploads neither
+// skew the results nor see the contexts; AI_PROVIDER=fake runs the evaluation without any external service
+type RAGPipeline struct {
+	service   *models.RAGService
+	documents []uuid.UUID
+	contents  []string
+	chunkSize int64
+}
+
+// NewRAGPipeline creates the pipeline of an evaluation run, guardrails is the policy of the run (nil uses the active one)
+func NewRAGPipeline(guardrails *utils.GuardrailConfig) (*RAGPipeline, error) {
+	if guardrails == nil {
+		guardrails = utils.ActiveGuardrailConfig()
+	}
+	service, err := models.NewRAGService()
+	if err != nil {
+		return nil, err
+	}
+	service.Guardrails = guardrails
+	service.Scope = models.EvaluationDocuments
+	service.Structured = utils.AppConfig.StructuredAnswers
+
+	chunkSize := utils.AppConfig.ChunkSize
+	if chunkSize <= 0 {
+		chunkSize = 1000 // Default fallback, as for uploads
+	}
+
+	return &RAGPipeline{service: service, chunkSize: chunkSize}, nil
+}
+
+// Index stores a context the way an upload is stored: chunked, embedded, scanned and redacted by the policy
+func (p *RAGPipeline) Index(ctx context.Context, name, content string) (uuid.UUID, error) {
+	doc := models.Document{
+		Name:             "eval_" + name,
+		OriginalFilename: name,
+		Evaluation:       true,
+	}
+
+	var contents []string
+	err := utils.WithTransaction(func(tx *sql.Tx) error {
+		if err := doc.SaveWithTx(tx); err != nil {
+			return fmt.Errorf("failed to save document: %v", err)
+		}
+
+		chunks, _, err := models.ProcessTextToChunks(ctx, content, evalContentType, doc.ID, p.chunkSize, p.service.Guardrails)
+		if err != nil {
+			return fmt.Errorf("failed to process text into chunks: %v", err)
+		}
+		for _, chunk := range chunks {
+			if err := chunk.SaveWithTx(tx); err != nil {
+				return fmt.Errorf("failed to save chunk: %v", err)
+			}
+			contents = append(contents, chunk.Content)
+		}
+		return utils.AddToCorpus(tx, contents)
+	})
+	if err != nil {
+		return uuid.Nil, err
+	}
+
+	p.documents = append(p.documents, doc.ID)
+	p.contents = append(p.contents, contents...)
+	return doc.ID, nil
+}
+
+// Retrieve runs the similarity search of the RAG service and returns the document of each chunk
+func (p *RAGPipeline) Retrieve(ctx context.Context, question string, limit int) ([]uuid.UUID, error) {
+	filter := models.ChunkSearchFilter{Scope: p.service.Scope, ExcludeFlagged: p.service.Guardrails.FlaggedChunks == utils.FlaggedChunksExclude}
+	chunks, err := models.GetRelevantChunks(ctx, question, limit, filter)
+	if err != nil {
+		return nil, err
+	}
+
+	documentIDs := make([]uuid.UUID, len(chunks))
+	for i, chunk := range chunks {
+		documentIDs[i] = chunk.DocumentID
+	}
+	return documentIDs, nil
+}
+
+// Answer answers the question with the RAG service
+func (p *RAGPipeline) Answer(ctx context.Context, question string) (*models.RAGAnswer, error) {
+	return p.service.QueryDocuments(ctx, question)
+}
+
+// Cleanup deletes the documents of the run, their chunks go with them
+func (p *RAGPipeline) Cleanup(ctx context.Context) error {
+	var errs []error
+	for _, documentID := range p.documents {
+		if err := models.DeleteDocument(documentID); err != nil {
+			errs = append(errs, fmt.Errorf("document %s: %w", documentID, err))
+		}
+	}
+
+	if len(p.contents) > 0 {
+		err := utils.WithTransaction(func(tx *sql.Tx) error {
+			return utils.RemoveFromCorpus(tx, p.contents)
+		})
+		if err != nil {
+			errs = append(errs, fmt.Errorf("failed to update corpus statistics: %w", err))
+		}
+	}
+
+	p.documents = nil
+	p.contents = nil
+	return errors.Join(errs...)
+}
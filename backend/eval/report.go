@@ -0,0 +1,153 @@
+package eval
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Report formats
+const (
+	FormatJSON  = "json"
+	FormatJUnit = "junit"
+)
+
+// ValidateFormat checks a report format before a run, empty means JSON
+func ValidateFormat(format string) error {
+	switch format {
+	case FormatJSON, FormatJUnit, "":
+		return nil
+	default:
+		return fmt.Errorf("unknown report format %q, use %s or %s", format, FormatJSON, FormatJUnit)
+	}
+}
+
+// WriteReport writes a report as JSON or as a JUnit XML file CI servers can display
+func WriteReport(w io.Writer, report *Report, format string) error {
+	if err := ValidateFormat(format); err != nil {
+		return err
+	}
+	if format == FormatJUnit {
+		return WriteJUnit(w, report)
+	}
+	return WriteJSON(w, report)
+}
+
+// WriteJSON writes a report as indented JSON, cases keep the order of the data sets
+func WriteJSON(w io.Writer, report *Report) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(report)
+}
+
+// junitTestSuites is the root element of a JUnit report
+type junitTestSuites struct {
+	XMLName  xml.Name         `xml:"testsuites"`
+	Name     string           `xml:"name,attr"`
+	Suites   []junitTestSuite `xml:"testsuite"`
+	Tests    int              `xml:"tests,attr"`
+	Failures int              `xml:"failures,attr"`
+	Errors   int              `xml:"errors,attr"`
+}
+
+// junitTestSuite is a suite of cases, the metrics are its properties
+type junitTestSuite struct {
+	Name       string          `xml:"name,attr"`
+	Properties []junitProperty `xml:"properties>property"`
+	Cases      []junitTestCase `xml:"testcase"`
+	Tests      int             `xml:"tests,attr"`
+	Failures   int             `xml:"failures,attr"`
+	Errors     int             `xml:"errors,attr"`
+}
+
+type junitProperty struct {
+	Name  string `xml:"name,attr"`
+	Value string `xml:"value,attr"`
+}
+
+type junitTestCase struct {
+	Failure   *junitMessage `xml:"failure,omitempty"`
+	Error     *junitMessage `xml:"error,omitempty"`
+	Name      string        `xml:"name,attr"`
+	ClassName string        `xml:"classname,attr"`
+}
+
+type junitMessage struct {
+	Message string `xml:"message,attr"`
+	Text    string `xml:",chardata"`
+}
+
+// WriteJUnit writes a report as JUnit XML, with a test suite per suite of cases
+// A case that could not run (pipeline error) is an error, a case that ran and did not pass a failure
+func WriteJUnit(w io.Writer, report *Report) error {
+	root := junitTestSuites{Name: "eval"}
+
+	suiteNames := make([]string, 0, len(report.Suites))
+	for name := range report.Suites {
+		suiteNames = append(suiteNames, name)
+	}
+	sort.Strings(suiteNames)
+
+	for _, name := range suiteNames {
+		summary := report.Suites[name]
+		suite := junitTestSuite{
+			Name: name,
+			Properties: []junitProperty{
+				{Name: "pass_rate", Value: formatMetric(summary.PassRate)},
+				{Name: "k", Value: strconv.Itoa(report.K)},
+				{Name: "policy_version", Value: report.PolicyVersion},
+			},
+		}
+		if summary.RetrievalCases > 0 {
+			suite.Properties = append(suite.Properties,
+				junitProperty{Name: "hit_rate", Value: formatMetric(summary.HitRate)},
+				junitProperty{Name: "mrr", Value: formatMetric(summary.MRR)},
+			)
+		}
+
+		for _, result := range report.Cases {
+			if result.Suite != name {
+				continue
+			}
+			testCase := junitTestCase{Name: result.ID, ClassName: "eval." + name + "." + result.Category}
+			switch {
+			case result.Error != "":
+				testCase.Error = &junitMessage{Message: result.Error, Text: result.Question}
+				suite.Errors++
+			case !result.Passed:
+				testCase.Failure = &junitMessage{
+					Message: strings.Join(result.Failures, "; "),
+					Text:    "Question: " + result.Question + "\nAnswer: " + result.Answer,
+				}
+				suite.Failures++
+			}
+			suite.Cases = append(suite.Cases, testCase)
+			suite.Tests++
+		}
+
+		root.Tests += suite.Tests
+		root.Failures += suite.Failures
+		root.Errors += suite.Errors
+		root.Suites = append(root.Suites, suite)
+	}
+
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	encoder := xml.NewEncoder(w)
+	encoder.Indent("", "  ")
+	if err := encoder.Encode(root); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
+
+// formatMetric formats a metric for a JUnit property
+func formatMetric(value float64) string {
+	return strconv.FormatFloat(value, 'f', 4, 64)
+}
@@ -0,0 +1,305 @@
+package eval
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strings"
+
+	"github.com/MauricioAliendre182/backend/models"
+	"github.com/MauricioAliendre182/backend/utils"
+	"github.com/google/uuid"
+)
+
+// DefaultK is the number of chunks retrieved for hit@k and MRR when Options.K is not set
+const DefaultK = 5
+
+// Pipeline is the retrieval and answer pipeline the test cases run through
+// RAGPipeline uses the database and the configured AI provider, tests use an in-memory one
+type Pipeline interface {
+	// Index stores the context of a test case as a document and returns its ID
+	Index(ctx context.Context, name, content string) (uuid.UUID, error)
+	// Retrieve returns the document of each of the top limit chunks for the question, in rank order
+	Retrieve(ctx context.Context, question string, limit int) ([]uuid.UUID, error)
+	// Answer answers the question from the indexed documents
+	Answer(ctx context.Context, question string) (*models.RAGAnswer, error)
+	// Cleanup removes the documents stored by Index
+	Cleanup(ctx context.Context) error
+}
+
+// Options configures an evaluation run
+// Guardrails is the policy the questions and answers are checked with, nil uses the active policy
+type Options struct {
+	Guardrails *utils.GuardrailConfig
+	K          int
+}
+
+// Report is the outcome of an evaluation run
+// It holds no timings or timestamps, so the reports of two runs can be diffed
+type Report struct {
+	Suites        map[string]Summary `json:"suites"`
+	Provider      string             `json:"provider,omitempty"`
+	Model         string             `json:"model,omitempty"`
+	PolicyVersion string             `json:"policy_version"`
+	Cases         []CaseResult       `json:"cases"`
+	Summary       Summary            `json:"summary"`
+	K             int                `json:"k"`
+}
+
+// Summary aggregates the results of a set of cases
+// HitRate (hit@k) and MRR are computed over the RetrievalCases, the rag cases
+type Summary struct {
+	Total          int     `json:"total"`
+	Passed         int     `json:"passed"`
+	Failed         int     `json:"failed"`
+	PassRate       float64 `json:"pass_rate"`
+	RetrievalCases int     `json:"retrieval_cases"`
+	HitRate        float64 `json:"hit_rate"`
+	MRR            float64 `json:"mrr"`
+}
+
+// CaseResult is the outcome of a test case
+// BlockedBy is the check that refused the question, Withheld tells whether an output
+// check replaced the answer; Failures explain why a case did not pass
+type CaseResult struct {
+	Retrieval *RetrievalResult `json:"retrieval,omitempty"`
+	ID        string           `json:"id"`
+	Suite     string           `json:"suite"`
+	Category  string           `json:"category"`
+	Question  string           `json:"question"`
+	Answer    string           `json:"answer,omitempty"`
+	BlockedBy string           `json:"blocked_by,omitempty"`
+	Error     string           `json:"error,omitempty"`
+	Failures  []string         `json:"failures,omitempty"`
+	Passed    bool             `json:"passed"`
+	Blocked   bool             `json:"blocked"`
+	Withheld  bool             `json:"withheld"`
+}
+
+// RetrievalResult is the position of the case's own document in the retrieved chunks
+// Rank is 1-based and 0 when none of the top k chunks comes from the document
+type RetrievalResult struct {
+	Rank           int     `json:"rank"`
+	ReciprocalRank float64 `json:"reciprocal_rank"`
+	Hit            bool    `json:"hit"`
+}
+
+// Run indexes the contexts of the cases, runs every case and returns the report
+// A question is checked with utils.ValidateQuestion like in the query endpoint, the answer with the output checks;
+// the documents stored for the run are removed at the end, even when ctx is cancelled
+func Run(ctx context.Context, pipeline Pipeline, cases []Case, options Options) (*Report, error) {
+	policy := options.Guardrails
+	if policy == nil {
+		policy = utils.ActiveGuardrailConfig()
+	}
+	k := options.K
+	if k <= 0 {
+		k = DefaultK
+	}
+
+	defer func() {
+		if err := pipeline.Cleanup(context.WithoutCancel(ctx)); err != nil {
+			utils.LogError("Failed to remove the evaluation documents", err)
+		}
+	}()
+
+	// Cases sharing a context share its document
+	documents := make(map[string]uuid.UUID)
+	for _, testCase := range cases {
+		if testCase.Context == "" {
+			continue
+		}
+		if _, ok := documents[testCase.Context]; ok {
+			continue
+		}
+		documentID, err := pipeline.Index(ctx, testCase.ID, testCase.Context)
+		if err != nil {
+			return nil, fmt.Errorf("failed to index the context of %s: %w", testCase.ID, err)
+		}
+		documents[testCase.Context] = documentID
+	}
+
+	report := &Report{
+		PolicyVersion: policy.Version,
+		K:             k,
+		Cases:         make([]CaseResult, 0, len(cases)),
+	}
+	for _, testCase := range cases {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		result, answer := runCase(ctx, pipeline, policy, testCase, documents[testCase.Context], k)
+		if answer != nil && report.Provider == "" {
+			report.Provider = answer.Provider
+			report.Model = answer.Model
+		}
+		report.Cases = append(report.Cases, result)
+	}
+
+	report.Summary, report.Suites = summarize(report.Cases)
+	return report, nil
+}
+
+// runCase runs a test case, the answer is nil when the question was blocked or the pipeline failed
+func runCase(ctx context.Context, pipeline Pipeline, policy *utils.GuardrailConfig, testCase Case, documentID uuid.UUID, k int) (CaseResult, *models.RAGAnswer) {
+	result := CaseResult{
+		ID:       testCase.ID,
+		Suite:    testCase.Suite,
+		Category: testCase.Category,
+		Question: testCase.Question,
+	}
+	question := utils.SanitizeQuestion(testCase.Question)
+
+	// Retrieval is measured for the questions the context answers, even when the guardrails block them
+	if testCase.Suite == SuiteRAG && documentID != uuid.Nil {
+		retrieved, err := pipeline.Retrieve(ctx, question, k)
+		if err != nil {
+			result.Error = fmt.Sprintf("retrieval failed: %v", err)
+			return result, nil
+		}
+		result.Retrieval = rankDocument(retrieved, documentID)
+	}
+
+	for _, violation := range utils.ValidateQuestion(question, policy) {
+		if violation.Severity == utils.GuardrailSeverityError {
+			result.Blocked = true
+			result.BlockedBy = violation.Check
+			break
+		}
+	}
+	if result.Blocked {
+		// A refused attack is a pass, a refused legitimate question is not
+		if testCase.Suite == SuiteRAG {
+			result.Failures = append(result.Failures, "question blocked by "+result.BlockedBy)
+		}
+		result.Passed = len(result.Failures) == 0
+		return result, nil
+	}
+
+	answer, err := pipeline.Answer(ctx, question)
+	if err != nil {
+		result.Error = fmt.Sprintf("answer failed: %v", err)
+		return result, nil
+	}
+	result.Answer = answer.Answer
+	result.Withheld = utils.HasBlockingViolation(utils.NewOutputGuardrailPipeline(policy, nil).Run(ctx, answer.Answer))
+
+	result.Failures = checkAnswer(testCase, result)
+	result.Passed = len(result.Failures) == 0
+	return result, answer
+}
+
+// checkAnswer returns why an answer does not pass its case
+// A withheld answer passes a guardrails case since the user never sees it
+func checkAnswer(testCase Case, result CaseResult) []string {
+	var failures []string
+	if testCase.Suite == SuiteGuardrails && result.Withheld {
+		return failures
+	}
+	if result.Withheld {
+		failures = append(failures, "answer withheld by the output checks")
+	}
+
+	answer := strings.ToLower(result.Answer)
+	for _, keyword := range testCase.ForbiddenKeywords {
+		if strings.Contains(answer, strings.ToLower(keyword)) {
+			failures = append(failures, fmt.Sprintf("answer contains %q", keyword))
+		}
+	}
+
+	switch testCase.Suite {
+	case SuiteRAG:
+		for _, keyword := range testCase.ExpectedKeywords {
+			if !strings.Contains(answer, strings.ToLower(keyword)) {
+				failures = append(failures, fmt.Sprintf("answer does not contain %q", keyword))
+			}
+		}
+	case SuiteGuardrails:
+		if len(testCase.ExpectedKeywords) > 0 && !containsAny(answer, testCase.ExpectedKeywords) {
+			failures = append(failures, "answer contains none of "+strings.Join(testCase.ExpectedKeywords, ", "))
+		}
+	}
+	return failures
+}
+
+// containsAny reports whether a lowercased text contains one of the keywords
+func containsAny(text string, keywords []string) bool {
+	for _, keyword := range keywords {
+		if strings.Contains(text, strings.ToLower(keyword)) {
+			return true
+		}
+	}
+	return false
+}
+
+// rankDocument finds the first retrieved chunk of a document
+func rankDocument(retrieved []uuid.UUID, documentID uuid.UUID) *RetrievalResult {
+	for i, retrievedID := range retrieved {
+		if retrievedID == documentID {
+			return &RetrievalResult{Rank: i + 1, ReciprocalRank: roundMetric(1 / float64(i+1)), Hit: true}
+		}
+	}
+	return &RetrievalResult{}
+}
+
+// summarize aggregates the results overall and per suite
+func summarize(results []CaseResult) (Summary, map[string]Summary) {
+	overall := &summaryBuilder{}
+	suites := make(map[string]*summaryBuilder)
+	for _, result := range results {
+		overall.add(result)
+		if suites[result.Suite] == nil {
+			suites[result.Suite] = &summaryBuilder{}
+		}
+		suites[result.Suite].add(result)
+	}
+
+	bySuite := make(map[string]Summary, len(suites))
+	for suite, builder := range suites {
+		bySuite[suite] = builder.summary()
+	}
+	return overall.summary(), bySuite
+}
+
+// summaryBuilder counts the results of a summary
+type summaryBuilder struct {
+	counts          Summary
+	hits            int
+	reciprocalRanks float64
+}
+
+// add counts a result
+func (b *summaryBuilder) add(result CaseResult) {
+	b.counts.Total++
+	if result.Passed {
+		b.counts.Passed++
+	} else {
+		b.counts.Failed++
+	}
+	if result.Retrieval != nil {
+		b.counts.RetrievalCases++
+		if result.Retrieval.Hit {
+			b.hits++
+			b.reciprocalRanks += 1 / float64(result.Retrieval.Rank)
+		}
+	}
+}
+
+// summary computes the rates of the counted results
+func (b *summaryBuilder) summary() Summary {
+	summary := b.counts
+	if summary.Total > 0 {
+		summary.PassRate = roundMetric(float64(summary.Passed) / float64(summary.Total))
+	}
+	if summary.RetrievalCases > 0 {
+		summary.HitRate = roundMetric(float64(b.hits) / float64(summary.RetrievalCases))
+		summary.MRR = roundMetric(b.reciprocalRanks / float64(summary.RetrievalCases))
+	}
+	return summary
+}
+
+// roundMetric rounds a metric to 4 decimals, so reports do not differ in the last digits
+func roundMetric(value float64) float64 {
+	return math.Round(value*10000) / 10000
+}
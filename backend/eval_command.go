@@ -0,0 +1,75 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/MauricioAliendre182/backend/eval"
+	"github.com/MauricioAliendre182/backend/utils"
+)
+
+// runEvalCommand runs the evaluation data sets through the RAG pipeline and writes the report
+// Usage: go run . eval [-data dir] [-k 5] [-format json|junit] [-output file] [-min-pass-rate 0.8] [data sets...]
+// The data sets default to eval.DefaultDataSets, the exit status is 1 when the run fails or the pass rate
+// is below -min-pass-rate, so the command can gate a CI job
+func runEvalCommand(args []string) int {
+	flags := flag.NewFlagSet("eval", flag.ContinueOnError)
+	dataDir := flags.String("data", utils.AppConfig.EvalDataDir, "directory of the data sets")
+	k := flags.Int("k", eval.DefaultK, "number of retrieved chunks for hit@k and MRR")
+	format := flags.String("format", eval.FormatJSON, "report format: json or junit")
+	output := flags.String("output", "", "report file (default stdout)")
+	minPassRate := flags.Float64("min-pass-rate", 0, "fail when the pass rate is below this value (0-1)")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if err := eval.ValidateFormat(*format); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+
+	cases, err := eval.LoadDataSets(*dataDir, flags.Args())
+	if err != nil {
+		utils.LogError("Failed to load the evaluation data sets", err)
+		return 1
+	}
+
+	policy := utils.ActiveGuardrailPolicy()
+	pipeline, err := eval.NewRAGPipeline(policy.Config)
+	if err != nil {
+		utils.LogError("Failed to create the evaluation pipeline", err)
+		return 1
+	}
+
+	report, err := eval.Run(context.Background(), pipeline, cases, eval.Options{Guardrails: policy.Config, K: *k})
+	if err != nil {
+		utils.LogError("Evaluation failed", err)
+		return 1
+	}
+
+	var w io.Writer = os.Stdout
+	if *output != "" {
+		file, err := os.Create(*output)
+		if err != nil {
+			utils.LogError("Failed to create the report file", err, "path", *output)
+			return 1
+		}
+		defer file.Close()
+		w = file
+	}
+	if err := eval.WriteReport(w, report, *format); err != nil {
+		utils.LogError("Failed to write the evaluation report", err)
+		return 1
+	}
+
+	summary := report.Summary
+	fmt.Fprintf(os.Stderr, "%d/%d cases passed (pass rate %.2f), hit@%d %.2f, MRR %.2f\n",
+		summary.Passed, summary.Total, summary.PassRate, report.K, summary.HitRate, summary.MRR)
+	if summary.PassRate < *minPassRate {
+		fmt.Fprintf(os.Stderr, "pass rate %.2f is below the minimum %.2f\n", summary.PassRate, *minPassRate)
+		return 1
+	}
+	return 0
+}
@@ -3,6 +3,7 @@ package main
 import (
 	"context"
 	"log"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
@@ -10,6 +11,7 @@ import (
 	"time"
 
 	"github.com/MauricioAliendre182/backend/db"
+	"github.com/MauricioAliendre182/backend/models"
 	"github.com/MauricioAliendre182/backend/routes"
 	"github.com/MauricioAliendre182/backend/utils"
 	"github.com/gin-gonic/gin"
@@ -40,6 +42,18 @@ func main() {
 	// Initialize rate limiter with config values
 	utils.InitRateLimiter()
 
+	// Initialize the HTTP client shared by the AI providers (timeouts, proxy, extra CAs)
+	if err := utils.InitProviderHTTPClient(); err != nil {
+		utils.LogError("Failed to initialize provider HTTP client", err)
+		log.Fatalf("Provider HTTP client initialization failed: %v", err)
+	}
+
+	// Load the guardrail policy, an invalid GUARDRAIL_POLICY_FILE stops the startup
+	if err := utils.InitGuardrailPolicy(utils.AppConfig); err != nil {
+		utils.LogError("Failed to load guardrail policy", err)
+		log.Fatalf("Guardrail policy error: %v", err)
+	}
+
 	// Initialize the database
 	db.InitDB(
 		utils.AppConfig.DBHost,
@@ -50,6 +64,12 @@ func main() {
 	)
 	utils.LogInfo("Database initialized successfully")
 
+	// Create the permissions and the built-in roles (viewer, contributor, admin)
+	if err := models.EnsureBuiltinRoles(); err != nil {
+		utils.LogError("Failed to create the built-in roles", err)
+		log.Fatalf("Role setup error: %v", err)
+	}
+
 	// Initialize AI services
 	// This function sets up the AI service factory and creates the embedding service
 	// It should validate the configuration and log any errors
@@ -59,6 +79,11 @@ func main() {
 	}
 	utils.LogInfo("AI services initialized successfully")
 
+	// "eval" runs the evaluation data sets against the configured database and provider instead of the server
+	if len(os.Args) > 1 && os.Args[1] == "eval" {
+		os.Exit(runEvalCommand(os.Args[2:]))
+	}
+
 	// Set Gin mode based on environment
 	if utils.AppConfig.Environment == "production" {
 		// gin.SetMode refers to setting the mode of the Gin framework
@@ -80,12 +105,23 @@ func main() {
 	// ReadTimeout: specifies the maximum duration for reading the entire request, including the body
 	// WriteTimeout: specifies the maximum duration before timing out writes of the response
 	// IdleTimeout: specifies the maximum duration for keeping idle connections open
+	// BaseContext: every request context derives from baseCtx, cancelling it aborts in-flight AI calls
+	// Slow routes (uploads, queries, evaluations) extend their own write deadline, see middlewares.ExtendWriteDeadline
+	baseCtx, cancelBase := context.WithCancel(context.Background())
+	defer cancelBase()
+
+	// Reload the guardrail policy on SIGHUP and when its file changes, until shutdown
+	utils.WatchGuardrailPolicy(baseCtx, utils.AppConfig)
+
 	srv := &http.Server{
 		Addr:         ":" + utils.AppConfig.Port,
 		Handler:      server,
 		ReadTimeout:  30 * time.Second,
 		WriteTimeout: 30 * time.Second,
 		IdleTimeout:  60 * time.Second,
+		BaseContext: func(net.Listener) context.Context {
+			return baseCtx
+		},
 	}
 
 	// Start server in a goroutine
@@ -133,7 +169,10 @@ func main() {
 	// srv.Shutdown(ctx) attempts to gracefully shut down the server by waiting for
 	// outstanding requests to complete within the specified timeout
 	// If there are any errors during shutdown, they will be logged
+	// Requests still running when the grace period ends have their context cancelled,
+	// so pending provider calls and retries stop instead of outliving the server
 	if err := srv.Shutdown(ctx); err != nil {
+		cancelBase()
 		utils.LogError("Server forced to shutdown", err)
 		log.Fatalf("Server shutdown error: %v", err)
 	}
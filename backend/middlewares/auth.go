@@ -27,7 +27,8 @@ func Authenticate(context *gin.Context) {
 	}
 
 	// Validate the token specifically as an access token
-	userId, err := utils.ValidateAccessToken(token)
+	// The token also carries the user's roles and permissions, so no database lookup is needed
+	userId, access, err := utils.ValidateAccessToken(token)
 	// If the token is invalid, we will get an error
 	if err != nil {
 		context.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{
@@ -41,9 +42,8 @@ func Authenticate(context *gin.Context) {
 	// This is how we can pass data between middleware and request handlers
 	context.Set("userId", userId)
 
-	// TODO: Check if the user is an admin
-	isAdmin := utils.CheckIfAdmin(userId)
-	context.Set("isAdmin", isAdmin)
+	// Set the roles and permissions of the user, RequirePermission checks them
+	context.Set("access", access)
 
 	// Continue with the request (the next request handler)
 	context.Next()
@@ -0,0 +1,25 @@
+package middlewares
+
+import (
+	"net/http"
+
+	"github.com/MauricioAliendre182/backend/utils"
+	"github.com/gin-gonic/gin"
+)
+
+// RequirePermission aborts the request unless the authenticated user has the permission
+// It must run after Authenticate, which stores the user's roles and permissions in the context
+func RequirePermission(permission string) gin.HandlerFunc {
+	return func(context *gin.Context) {
+		value, exists := context.Get("access")
+		access, ok := value.(utils.UserAccess)
+		if !exists || !ok || !access.Can(permission) {
+			context.AbortWithStatusJSON(http.StatusForbidden, gin.H{
+				"message": "Permission required: " + permission,
+			})
+			return
+		}
+
+		context.Next()
+	}
+}
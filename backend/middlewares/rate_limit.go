@@ -0,0 +1,76 @@
+package middlewares
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/MauricioAliendre182/backend/utils"
+	"github.com/gin-gonic/gin"
+)
+
+// RateLimit limits the requests of each client on a route
+// route names the limit in API_RATE_LIMIT_ROUTES ("query", "upload", "auth"), unknown names use API_RATE_LIMIT_DEFAULT
+// Clients are identified by the user ID set by Authenticate, or by their IP on unauthenticated routes,
+// so one user hammering a route cannot exhaust the limit of the others
+// Every response carries X-RateLimit-Limit, X-RateLimit-Remaining and X-RateLimit-Reset (seconds until the bucket is full),
+// a rejected request gets 429 with Retry-After (seconds until the next request is allowed)
+func RateLimit(route string) gin.HandlerFunc {
+	config := utils.AppConfig
+	if config == nil {
+		config = &utils.Config{APIRateLimitEnabled: true}
+	}
+	if !config.APIRateLimitEnabled {
+		return func(context *gin.Context) {
+			context.Next()
+		}
+	}
+
+	limit := config.RouteRateLimitFor(route)
+	limiter := utils.NewKeyedRateLimiter(limit, time.Duration(config.APIRateLimitIdleSecs)*time.Second)
+	utils.LogInfo("API rate limit configured", "route", route, "limit", limit.String())
+
+	return func(context *gin.Context) {
+		decision := limiter.Allow(rateLimitKey(context))
+
+		context.Header("X-RateLimit-Limit", strconv.FormatInt(decision.Limit, 10))
+		context.Header("X-RateLimit-Remaining", strconv.FormatInt(decision.Remaining, 10))
+		context.Header("X-RateLimit-Reset", strconv.FormatInt(ceilSeconds(decision.ResetAfter), 10))
+
+		if !decision.Allowed {
+			retryAfter := ceilSeconds(decision.RetryAfter)
+			context.Header("Retry-After", strconv.FormatInt(retryAfter, 10))
+
+			utils.LogWarn("API rate limit exceeded",
+				"route", route,
+				"client", rateLimitKey(context),
+				"path", context.Request.URL.Path,
+				"retry_after", retryAfter)
+
+			context.AbortWithStatusJSON(http.StatusTooManyRequests, gin.H{
+				"error":       "Too many requests",
+				"message":     fmt.Sprintf("Rate limit of %d requests per %s exceeded, retry in %d seconds", limit.Requests, limit.Per, retryAfter),
+				"retry_after": retryAfter,
+			})
+			return
+		}
+
+		context.Next()
+	}
+}
+
+// rateLimitKey identifies the client of a request
+// Authenticated requests are keyed by user ID so users behind one proxy do not share a bucket
+func rateLimitKey(context *gin.Context) string {
+	if userId, exists := context.Get("userId"); exists {
+		return fmt.Sprintf("user:%v", userId)
+	}
+	return "ip:" + context.ClientIP()
+}
+
+// ceilSeconds rounds a duration up to whole seconds, as expected by Retry-After
+func ceilSeconds(duration time.Duration) int64 {
+	return int64(math.Ceil(duration.Seconds()))
+}
@@ -0,0 +1,42 @@
+package middlewares
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/MauricioAliendre182/backend/utils"
+	"github.com/gin-gonic/gin"
+)
+
+// writeDeadlineMargin leaves the handler time to write its answer once the last stage gives up
+const writeDeadlineMargin = 30 * time.Second
+
+// ExtendWriteDeadline lets a slow route write its response after the server WriteTimeout
+// stages are the deadlines the route runs one after the other, see SetStageWriteDeadline
+// Every other route keeps the server WriteTimeout
+func ExtendWriteDeadline(stages ...utils.Stage) gin.HandlerFunc {
+	return func(context *gin.Context) {
+		SetStageWriteDeadline(context, stages...)
+		context.Next()
+	}
+}
+
+// SetStageWriteDeadline moves the write deadline of the connection past the sum of the stage deadlines,
+// so the response is not cut before the handler gives up
+// Handlers that only know their stages once the request is read (e.g. the enabled guardrail checks) call it directly
+func SetStageWriteDeadline(context *gin.Context, stages ...utils.Stage) {
+	deadline := writeDeadlineMargin
+	for _, stage := range stages {
+		deadline += utils.StageTimeout(utils.AppConfig, stage)
+	}
+	SetWriteDeadline(context, deadline)
+}
+
+// SetWriteDeadline lets the handler write its response for the given duration from now
+func SetWriteDeadline(context *gin.Context, deadline time.Duration) {
+	// Writers that cannot change their deadline (e.g. test recorders) keep the server timeout
+	if err := http.NewResponseController(context.Writer).SetWriteDeadline(time.Now().Add(deadline)); err != nil && !errors.Is(err, http.ErrNotSupported) {
+		utils.LogWarn("Failed to extend the write deadline", "path", context.FullPath(), "error", err)
+	}
+}
@@ -0,0 +1,202 @@
+package models
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/MauricioAliendre182/backend/db"
+	"github.com/MauricioAliendre182/backend/utils"
+	"github.com/google/uuid"
+)
+
+// Subjects a document can be shared with
+// A group is a team of users (see SetUserTeam)
+const (
+	ShareUser     = "user"
+	ShareGroup    = "group"
+	ShareEveryone = "everyone"
+)
+
+// ErrDocumentNotFound is returned when a document does not exist or the user cannot read it,
+// so the documents a user cannot read are indistinguishable from missing ones
+var ErrDocumentNotFound = errors.New("document not found")
+
+// DocumentShare gives read access to a document
+// Subject is the user ID for ShareUser, the team name for ShareGroup and empty for ShareEveryone
+type DocumentShare struct {
+	Type    string `json:"type"`
+	Subject string `json:"subject,omitempty"`
+}
+
+// ParseDocumentShare parses the share form value of an upload: "everyone", "group:<team>" or "user:<user ID>"
+func ParseDocumentShare(value string) (DocumentShare, error) {
+	shareType, subject, _ := strings.Cut(strings.TrimSpace(value), ":")
+	share := DocumentShare{Type: shareType, Subject: subject}
+	return share, share.Validate()
+}
+
+// Validate checks the subject of a share matches its type
+func (s *DocumentShare) Validate() error {
+	s.Subject = strings.TrimSpace(s.Subject)
+
+	switch s.Type {
+	case ShareEveryone:
+		if s.Subject != "" {
+			return errors.New("a share with everyone has no subject")
+		}
+	case ShareGroup:
+		if s.Subject == "" {
+			return errors.New("a share with a group needs the group name")
+		}
+	case ShareUser:
+		id, err := uuid.Parse(s.Subject)
+		if err != nil {
+			return fmt.Errorf("invalid user ID %q", s.Subject)
+		}
+		s.Subject = id.String()
+	default:
+		return fmt.Errorf("unknown share type %q, use user, group or everyone", s.Type)
+	}
+	return nil
+}
+
+// DocumentScope is the set of documents a user can read: the documents they own and the ones shared
+// with them, with their group or with everyone
+// All reaches every document (documents:all permission, admin reports)
+// Evaluation reaches the documents of the evaluation runs only, no other scope reaches them
+// The zero scope only reaches the documents shared with everyone
+type DocumentScope struct {
+	UserID     string
+	All        bool
+	Evaluation bool
+}
+
+// EvaluationDocuments is the scope of the evaluation runs, the contexts they store never mix with the uploads
+var EvaluationDocuments = DocumentScope{Evaluation: true}
+
+// NewDocumentScope returns the scope of an authenticated user
+func NewDocumentScope(userID string, access utils.UserAccess) DocumentScope {
+	return DocumentScope{UserID: userID, All: access.Can(utils.PermissionDocumentsAll)}
+}
+
+// CanManage reports whether the scope can share and delete a document: its owner or documents:all
+func (s DocumentScope) CanManage(doc Document) bool {
+	if s.All {
+		return true
+	}
+	return doc.OwnerID.Valid && doc.OwnerID.UUID.String() == s.UserID
+}
+
+// readableCondition returns the SQL condition restricting documentColumn to the documents of the scope,
+// arg is the placeholder number of the user ID, which is returned with the condition
+// The group of the user is read in the query, so a team change applies to the next request
+func (s DocumentScope) readableCondition(documentColumn string, arg int) (string, []any) {
+	if s.Evaluation {
+		return documentColumn + " IN (SELECT id FROM documents WHERE evaluation)", nil
+	}
+	if s.All {
+		return documentColumn + " IN (SELECT id FROM documents WHERE NOT evaluation)", nil
+	}
+
+	condition := fmt.Sprintf(`%[1]s IN (
+		SELECT id FROM documents
+		WHERE NOT evaluation
+		  AND (owner_id = $%[2]d::uuid OR id IN (
+			SELECT document_id FROM document_shares
+			WHERE subject_type = 'everyone'
+			   OR (subject_type = 'user' AND subject = $%[2]d::uuid::text)
+			   OR (subject_type = 'group' AND subject = (SELECT team FROM users WHERE id = $%[2]d::uuid))
+		  ))
+	)`, documentColumn, arg)
+	return condition, []any{nullableUUID(s.UserID)}
+}
+
+// GetReadableDocument returns a document the scope can read, ErrDocumentNotFound otherwise
+func GetReadableDocument(id uuid.UUID, scope DocumentScope) (Document, error) {
+	var doc Document
+	condition, args := scope.readableCondition("id", 2)
+	query := `
+	SELECT id, name, original_filename, uploaded_at, owner_id
+	FROM documents
+	WHERE id = $1 AND ` + condition
+
+	stmt, err := db.DB.Prepare(query)
+	if err != nil {
+		return doc, err
+	}
+	defer stmt.Close()
+
+	err = stmt.QueryRow(append([]any{id}, args...)...).Scan(&doc.ID, &doc.Name, &doc.OriginalFilename, &doc.UploadedAt, &doc.OwnerID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return doc, ErrDocumentNotFound
+	}
+	return doc, err
+}
+
+// GetDocumentShares returns who a document is shared with, besides its owner
+func GetDocumentShares(documentID uuid.UUID) ([]DocumentShare, error) {
+	stmt, err := db.DB.Prepare(`
+	SELECT subject_type, subject FROM document_shares
+	WHERE document_id = $1
+	ORDER BY subject_type, subject
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer stmt.Close()
+
+	rows, err := stmt.Query(documentID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	shares := []DocumentShare{}
+	for rows.Next() {
+		var share DocumentShare
+		if err := rows.Scan(&share.Type, &share.Subject); err != nil {
+			return nil, err
+		}
+		shares = append(shares, share)
+	}
+	return shares, rows.Err()
+}
+
+// SetDocumentShares replaces who a document is shared with, grantedBy is the user sharing it
+// An empty list makes the document private to its owner
+func SetDocumentShares(documentID uuid.UUID, shares []DocumentShare, grantedBy string) error {
+	return utils.WithTransaction(func(tx *sql.Tx) error {
+		return SetDocumentSharesWithTx(tx, documentID, shares, grantedBy)
+	})
+}
+
+// SetDocumentSharesWithTx replaces the shares of a document within a transaction (e.g. the upload transaction)
+func SetDocumentSharesWithTx(tx *sql.Tx, documentID uuid.UUID, shares []DocumentShare, grantedBy string) error {
+	for i := range shares {
+		if err := shares[i].Validate(); err != nil {
+			return err
+		}
+	}
+
+	if _, err := tx.Exec(`DELETE FROM document_shares WHERE document_id = $1`, documentID); err != nil {
+		return fmt.Errorf("failed to clear the shares of document %s: %v", documentID, err)
+	}
+
+	stmt, err := tx.Prepare(`
+	INSERT INTO document_shares (document_id, subject_type, subject, granted_by) VALUES ($1, $2, $3, $4)
+	ON CONFLICT (document_id, subject_type, subject) DO NOTHING
+	`)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	for _, share := range shares {
+		if _, err := stmt.Exec(documentID, share.Type, share.Subject, nullableUUID(grantedBy)); err != nil {
+			return fmt.Errorf("failed to share document %s: %v", documentID, err)
+		}
+	}
+	return nil
+}
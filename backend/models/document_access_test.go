@@ -0,0 +1,159 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"os"
+	"testing"
+
+	"github.com/MauricioAliendre182/backend/db"
+	"github.com/MauricioAliendre182/backend/utils"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseDocumentShare(t *testing.T) {
+	userID := uuid.New()
+
+	tests := []struct {
+		name        string
+		value       string
+		expected    DocumentShare
+		expectError bool
+	}{
+		{name: "Everyone", value: "everyone", expected: DocumentShare{Type: ShareEveryone}},
+		{name: "Group", value: "group: hr ", expected: DocumentShare{Type: ShareGroup, Subject: "hr"}},
+		{name: "User", value: "user:" + userID.String(), expected: DocumentShare{Type: ShareUser, Subject: userID.String()}},
+		{name: "Everyone with a subject", value: "everyone:hr", expectError: true},
+		{name: "Group without a name", value: "group:", expectError: true},
+		{name: "User with an invalid ID", value: "user:alice", expectError: true},
+		{name: "Unknown type", value: "team:hr", expectError: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			share, err := ParseDocumentShare(tt.value)
+			if tt.expectError {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.expected, share)
+		})
+	}
+}
+
+func TestDocumentScope(t *testing.T) {
+	ownerID := uuid.New()
+	document := Document{ID: uuid.New(), OwnerID: uuid.NullUUID{UUID: ownerID, Valid: true}}
+
+	owner := NewDocumentScope(ownerID.String(), utils.UserAccess{Permissions: []string{utils.PermissionDocumentsRead}})
+	other := NewDocumentScope(uuid.NewString(), utils.UserAccess{Permissions: []string{utils.PermissionDocumentsDelete}})
+	admin := NewDocumentScope(uuid.NewString(), utils.UserAccess{Permissions: []string{utils.PermissionDocumentsAll}})
+
+	assert.True(t, owner.CanManage(document))
+	assert.False(t, other.CanManage(document), "deleting needs ownership besides documents:delete")
+	assert.True(t, admin.CanManage(document))
+	assert.False(t, owner.CanManage(Document{ID: uuid.New()}), "documents without owner are managed with documents:all")
+
+	// Every document is readable with documents:all but the evaluation contexts, the condition needs no user
+	condition, args := admin.readableCondition("document_id", 3)
+	assert.Equal(t, "document_id IN (SELECT id FROM documents WHERE NOT evaluation)", condition)
+	assert.Empty(t, args)
+
+	// An evaluation run only reaches its own contexts
+	condition, args = EvaluationDocuments.readableCondition("document_id", 3)
+	assert.Equal(t, "document_id IN (SELECT id FROM documents WHERE evaluation)", condition)
+	assert.Empty(t, args)
+
+	// Otherwise the condition restricts the column with the user ID placeholder
+	condition, args = owner.readableCondition("document_id", 3)
+	assert.Contains(t, condition, "document_id IN (")
+	assert.Contains(t, condition, "owner_id = $3::uuid")
+	assert.Contains(t, condition, "NOT evaluation")
+	assert.NotContains(t, condition, "$1")
+	assert.Equal(t, []any{sql.NullString{String: ownerID.String(), Valid: true}}, args)
+
+	// The zero scope (no user) only reaches the documents shared with everyone
+	_, args = DocumentScope{}.readableCondition("id", 1)
+	assert.Equal(t, []any{sql.NullString{}}, args)
+}
+
+func TestVectorSearchSettings(t *testing.T) {
+	iterative := []string{"SET LOCAL ivfflat.iterative_scan = strict_order"}
+	allLists := []string{fmt.Sprintf("SET LOCAL ivfflat.probes = %d", db.VectorIndexLists)}
+
+	assert.Equal(t, iterative, vectorSearchSettings("0.8.0"))
+	assert.Equal(t, iterative, vectorSearchSettings("1.0.1"))
+	assert.Equal(t, allLists, vectorSearchSettings("0.7.4"))
+	assert.Equal(t, allLists, vectorSearchSettings(""), "an unknown version probes every list")
+}
+
+// TestSimilaritySearchFillsLimitWithReadableChunks needs PostgreSQL with pgvector (e.g. pgvector/pgvector:pg16),
+// set TEST_DB_HOST, TEST_DB_PORT, TEST_DB_USER, TEST_DB_PASSWORD and TEST_DB_NAME to run it
+func TestSimilaritySearchFillsLimitWithReadableChunks(t *testing.T) {
+	if os.Getenv("TEST_DB_HOST") == "" {
+		t.Skip("Skipping similarity search test - TEST_DB_HOST not set")
+	}
+	db.InitDB(os.Getenv("TEST_DB_HOST"), os.Getenv("TEST_DB_PORT"), os.Getenv("TEST_DB_USER"), os.Getenv("TEST_DB_PASSWORD"), os.Getenv("TEST_DB_NAME"))
+	defer func() {
+		db.DB.Close()
+		db.DB = nil
+	}()
+	require.True(t, db.HasPgVector())
+
+	// Vectors pointing along the given axes of the 1536 dimensions of the chunks table
+	vector := func(weights map[int]float32) utils.Vector {
+		v := make(utils.Vector, 1536)
+		for axis, weight := range weights {
+			v[axis] = weight
+		}
+		return v
+	}
+
+	// Most chunks are closest to the question but belong to a document nobody can read,
+	// the few readable ones are far from it
+	readable := Document{Name: "readable", OriginalFilename: "readable.txt"}
+	unreadable := Document{Name: "unreadable", OriginalFilename: "unreadable.txt"}
+	err := utils.WithTransaction(func(tx *sql.Tx) error {
+		for _, doc := range []*Document{&readable, &unreadable} {
+			if err := doc.SaveWithTx(tx); err != nil {
+				return err
+			}
+		}
+		if err := SetDocumentSharesWithTx(tx, readable.ID, []DocumentShare{{Type: ShareEveryone}}, ""); err != nil {
+			return err
+		}
+
+		for i := 0; i < 300; i++ {
+			chunk := Chunk{DocumentID: unreadable.ID, Content: "unreadable", ContentType: "text/plain", ChunkIndex: i,
+				Embedding: vector(map[int]float32{0: 1, 1 + i%50: 0.1})}
+			if err := chunk.SaveWithTx(tx); err != nil {
+				return err
+			}
+		}
+		for i := 0; i < 3; i++ {
+			chunk := Chunk{DocumentID: readable.ID, Content: "readable", ContentType: "text/plain", ChunkIndex: i,
+				Embedding: vector(map[int]float32{0: 0.1, 100 + i: 1})}
+			if err := chunk.SaveWithTx(tx); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	require.NoError(t, err)
+	defer func() {
+		assert.NoError(t, DeleteDocument(readable.ID))
+		assert.NoError(t, DeleteDocument(unreadable.ID))
+	}()
+
+	// The zero scope reaches the documents shared with everyone
+	chunks, err := SimilaritySearch(context.Background(), vector(map[int]float32{0: 1}), 3, ChunkSearchFilter{})
+	require.NoError(t, err)
+	require.Len(t, chunks, 3, "the limit is filled with readable chunks")
+	for _, chunk := range chunks {
+		assert.Equal(t, readable.ID, chunk.DocumentID)
+	}
+}
@@ -0,0 +1,214 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"slices"
+	"time"
+
+	"github.com/MauricioAliendre182/backend/db"
+	"github.com/MauricioAliendre182/backend/utils"
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+)
+
+// flaggedChunkPreviewLength is the number of characters of a flagged chunk shown in the report
+const flaggedChunkPreviewLength = 300
+
+// FlaggedDocument is a row of the flagged documents report
+// Reasons are the distinct rules matched by the flagged chunks of the document
+type FlaggedDocument struct {
+	UploadedAt       time.Time `json:"uploaded_at"`
+	Name             string    `json:"name"`
+	OriginalFilename string    `json:"original_filename"`
+	Reasons          []string  `json:"reasons"`
+	FlaggedChunks    int       `json:"flagged_chunks"`
+	TotalChunks      int       `json:"total_chunks"`
+	ID               uuid.UUID `json:"id"`
+}
+
+// FlaggedChunk is a chunk flagged for prompt injection, with the beginning of its content for review
+type FlaggedChunk struct {
+	Preview    string    `json:"preview"`
+	Reasons    []string  `json:"reasons"`
+	ChunkIndex int       `json:"chunk_index"`
+	ID         uuid.UUID `json:"id"`
+}
+
+// ChunkRescanResult counts what a rescan of the chunks changed
+type ChunkRescanResult struct {
+	PolicyVersion string `json:"policy_version"`
+	Scanned       int    `json:"scanned"`
+	Flagged       int    `json:"flagged"`
+	NewlyFlagged  int    `json:"newly_flagged"`
+	Cleared       int    `json:"cleared"`
+}
+
+// GetFlaggedDocuments returns the documents having at least one chunk flagged for prompt injection,
+// the documents with the most flagged chunks first
+func GetFlaggedDocuments() ([]FlaggedDocument, error) {
+	query := `
+	SELECT d.id, d.name, d.original_filename, d.uploaded_at,
+		COUNT(*) FILTER (WHERE c.injection_flagged) AS flagged_chunks,
+		COUNT(*) AS total_chunks,
+		ARRAY(
+			SELECT DISTINCT reason
+			FROM chunks f, unnest(f.injection_reasons) AS reason
+			WHERE f.document_id = d.id AND f.injection_flagged
+			ORDER BY reason
+		) AS reasons
+	FROM documents d
+	JOIN chunks c ON c.document_id = d.id
+	WHERE NOT d.evaluation
+	GROUP BY d.id, d.name, d.original_filename, d.uploaded_at
+	HAVING COUNT(*) FILTER (WHERE c.injection_flagged) > 0
+	ORDER BY flagged_chunks DESC, d.uploaded_at DESC
+	`
+
+	stmt, err := db.DB.Prepare(query)
+	if err != nil {
+		return nil, err
+	}
+	defer stmt.Close()
+
+	rows, err := stmt.Query()
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	documents := []FlaggedDocument{}
+	for rows.Next() {
+		var doc FlaggedDocument
+		err = rows.Scan(&doc.ID, &doc.Name, &doc.OriginalFilename, &doc.UploadedAt,
+			&doc.FlaggedChunks, &doc.TotalChunks, pq.Array(&doc.Reasons))
+		if err != nil {
+			return nil, err
+		}
+		documents = append(documents, doc)
+	}
+
+	return documents, rows.Err()
+}
+
+// GetFlaggedChunks returns the flagged chunks of a document in document order
+func GetFlaggedChunks(documentID uuid.UUID) ([]FlaggedChunk, error) {
+	query := `
+	SELECT id, chunk_index, content, injection_reasons
+	FROM chunks
+	WHERE document_id = $1 AND injection_flagged
+	ORDER BY chunk_index
+	`
+
+	stmt, err := db.DB.Prepare(query)
+	if err != nil {
+		return nil, err
+	}
+	defer stmt.Close()
+
+	rows, err := stmt.Query(documentID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	chunks := []FlaggedChunk{}
+	for rows.Next() {
+		var chunk FlaggedChunk
+		var content string
+		if err := rows.Scan(&chunk.ID, &chunk.ChunkIndex, &content, pq.Array(&chunk.Reasons)); err != nil {
+			return nil, err
+		}
+		chunk.Preview = contentPreview(content, flaggedChunkPreviewLength)
+		chunks = append(chunks, chunk)
+	}
+
+	return chunks, rows.Err()
+}
+
+// RescanChunksForInjection scans every stored chunk again with the document checks of config
+// Chunks ingested before a policy change (or before the scan existed) are flagged or cleared accordingly,
+// only the chunks whose result changed are updated, all in one transaction
+func RescanChunksForInjection(ctx context.Context, config *utils.GuardrailConfig) (ChunkRescanResult, error) {
+	result := ChunkRescanResult{PolicyVersion: config.Version}
+
+	type scannedChunk struct {
+		reasons []string
+		id      uuid.UUID
+		flagged bool
+	}
+
+	err := utils.WithTransaction(func(tx *sql.Tx) error {
+		rows, err := tx.QueryContext(ctx, `SELECT id, content, injection_flagged, injection_reasons FROM chunks`)
+		if err != nil {
+			return err
+		}
+
+		// The rows are read completely before updating, the connection cannot run both at once
+		var changed []scannedChunk
+		for rows.Next() {
+			var id uuid.UUID
+			var content string
+			var flagged bool
+			var reasons []string
+			if err := rows.Scan(&id, &content, &flagged, pq.Array(&reasons)); err != nil {
+				rows.Close()
+				return err
+			}
+
+			scan := utils.ScanChunkForInjection(ctx, config, content)
+			result.Scanned++
+			if scan.Flagged {
+				result.Flagged++
+			}
+			if scan.Flagged == flagged && slices.Equal(scan.Reasons, reasons) {
+				continue
+			}
+
+			switch {
+			case scan.Flagged && !flagged:
+				result.NewlyFlagged++
+			case !scan.Flagged && flagged:
+				result.Cleared++
+			}
+			changed = append(changed, scannedChunk{id: id, flagged: scan.Flagged, reasons: scan.Reasons})
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return err
+		}
+
+		stmt, err := tx.PrepareContext(ctx, `UPDATE chunks SET injection_flagged = $1, injection_reasons = $2 WHERE id = $3`)
+		if err != nil {
+			return err
+		}
+		defer stmt.Close()
+
+		for _, chunk := range changed {
+			if _, err := stmt.ExecContext(ctx, chunk.flagged, pq.Array(chunk.reasons), chunk.id); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return result, err
+	}
+
+	utils.LogInfo("Chunks rescanned for prompt injection",
+		"policy_version", result.PolicyVersion,
+		"scanned", result.Scanned,
+		"flagged", result.Flagged,
+		"newly_flagged", result.NewlyFlagged,
+		"cleared", result.Cleared)
+	return result, nil
+}
+
+// contentPreview returns the first characters of a content, cut on a character boundary
+func contentPreview(content string, length int) string {
+	runes := []rune(content)
+	if len(runes) <= length {
+		return content
+	}
+	return string(runes[:length]) + "..."
+}
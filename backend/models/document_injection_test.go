@@ -0,0 +1,16 @@
+package models
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestContentPreview(t *testing.T) {
+	assert.Equal(t, "short", contentPreview("short", 10))
+	assert.Equal(t, "ééé...", contentPreview("éééé", 3))
+
+	long := strings.Repeat("a", flaggedChunkPreviewLength+50)
+	assert.Len(t, contentPreview(long, flaggedChunkPreviewLength), flaggedChunkPreviewLength+3)
+}
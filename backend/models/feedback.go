@@ -0,0 +1,256 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/MauricioAliendre182/backend/db"
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+)
+
+// Answer ratings stored in the answer_feedback table
+const (
+	RatingThumbsUp   = 1
+	RatingThumbsDown = -1
+)
+
+// AnswerFeedback represents a user's rating of a stored answer
+type AnswerFeedback struct {
+	CreatedAt       time.Time   `json:"created_at"`
+	UserID          string      `json:"user_id"`
+	Comment         string      `json:"comment,omitempty"`
+	FlaggedChunkIDs []uuid.UUID `json:"flagged_chunk_ids"`
+	Rating          int         `json:"rating"`
+	ID              uuid.UUID   `json:"id"`
+	QuestionID      uuid.UUID   `json:"question_id"`
+}
+
+// WorstRatedQuestion is a row of the feedback report listing the lowest rated answers
+type WorstRatedQuestion struct {
+	LastRatedAt   time.Time `json:"last_rated_at"`
+	Query         string    `json:"query"`
+	Answer        string    `json:"answer"`
+	Comments      []string  `json:"comments"`
+	Score         int       `json:"score"`
+	ThumbsDown    int       `json:"thumbs_down"`
+	ThumbsUp      int       `json:"thumbs_up"`
+	QuestionID    uuid.UUID `json:"question_id"`
+	FlaggedChunks int       `json:"flagged_chunks"`
+}
+
+// DocumentFeedbackStat is a row of the feedback report listing documents cited in bad answers
+type DocumentFeedbackStat struct {
+	Name             string    `json:"name"`
+	OriginalFilename string    `json:"original_filename"`
+	BadAnswers       int       `json:"bad_answers"`
+	FlaggedChunks    int       `json:"flagged_chunks"`
+	DocumentID       uuid.UUID `json:"document_id"`
+}
+
+// FeedbackReport groups the data content owners need to improve the documents
+type FeedbackReport struct {
+	WorstQuestions []WorstRatedQuestion   `json:"worst_questions"`
+	Documents      []DocumentFeedbackStat `json:"documents"`
+}
+
+// ValidateFeedback checks the rating and that flagged chunks were actually cited in the answer
+func (f *AnswerFeedback) ValidateFeedback(question Question) error {
+	if f.Rating != RatingThumbsUp && f.Rating != RatingThumbsDown {
+		return errors.New("rating must be 1 (thumbs up) or -1 (thumbs down)")
+	}
+
+	if len(f.Comment) > 2000 {
+		return errors.New("comment cannot be longer than 2000 characters")
+	}
+
+	cited := make(map[uuid.UUID]bool, len(question.ChunkIDs))
+	for _, id := range question.ChunkIDs {
+		cited[id] = true
+	}
+
+	for _, id := range f.FlaggedChunkIDs {
+		if !cited[id] {
+			return fmt.Errorf("chunk %s was not cited in this answer", id)
+		}
+	}
+
+	return nil
+}
+
+// Save stores the feedback, replacing any previous rating of the same user for the same question
+func (f *AnswerFeedback) Save() error {
+	query := `
+	INSERT INTO answer_feedback (id, question_id, user_id, rating, comment, flagged_chunk_ids, created_at)
+	VALUES ($1, $2, $3, $4, $5, $6, $7)
+	ON CONFLICT (question_id, user_id) DO UPDATE
+	SET rating = EXCLUDED.rating,
+		comment = EXCLUDED.comment,
+		flagged_chunk_ids = EXCLUDED.flagged_chunk_ids,
+		created_at = EXCLUDED.created_at
+	RETURNING id
+	`
+
+	if f.ID == uuid.Nil {
+		f.ID = uuid.New()
+	}
+	f.CreatedAt = time.Now()
+
+	stmt, err := db.DB.Prepare(query)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	return stmt.QueryRow(
+		f.ID,
+		f.QuestionID,
+		nullableUUID(f.UserID),
+		f.Rating,
+		nullableString(f.Comment),
+		pq.Array(uuidStrings(f.FlaggedChunkIDs)),
+		f.CreatedAt,
+	).Scan(&f.ID)
+}
+
+// Bounds of the number of rows in each section of the feedback report
+const (
+	defaultFeedbackReportLimit = 10
+	maxFeedbackReportLimit     = 500
+)
+
+// GetFeedbackReport builds the admin feedback report
+// limit bounds the number of rows in each section of the report, it is capped at maxFeedbackReportLimit
+func GetFeedbackReport(limit int) (FeedbackReport, error) {
+	var report FeedbackReport
+
+	limit = feedbackReportLimit(limit)
+
+	worst, err := getWorstRatedQuestions(limit)
+	if err != nil {
+		return report, err
+	}
+
+	documents, err := getDocumentsInBadAnswers(limit)
+	if err != nil {
+		return report, err
+	}
+
+	report.WorstQuestions = worst
+	report.Documents = documents
+	return report, nil
+}
+
+// getWorstRatedQuestions returns the questions with the lowest rating score
+// Only questions with at least one thumbs down are included
+func getWorstRatedQuestions(limit int) ([]WorstRatedQuestion, error) {
+	var questions []WorstRatedQuestion
+	query := `
+	SELECT q.id, q.query, COALESCE(q.answer, ''),
+		SUM(f.rating) AS score,
+		COUNT(*) FILTER (WHERE f.rating < 0) AS thumbs_down,
+		COUNT(*) FILTER (WHERE f.rating > 0) AS thumbs_up,
+		COALESCE(SUM(cardinality(f.flagged_chunk_ids)), 0) AS flagged_chunks,
+		COALESCE(array_agg(f.comment) FILTER (WHERE f.comment IS NOT NULL), '{}') AS comments,
+		MAX(f.created_at) AS last_rated_at
+	FROM answer_feedback f
+	JOIN questions q ON q.id = f.question_id
+	GROUP BY q.id, q.query, q.answer
+	HAVING COUNT(*) FILTER (WHERE f.rating < 0) > 0
+	ORDER BY score ASC, thumbs_down DESC, last_rated_at DESC
+	LIMIT $1
+	`
+
+	stmt, err := db.DB.Prepare(query)
+	if err != nil {
+		return questions, err
+	}
+	defer stmt.Close()
+
+	rows, err := stmt.Query(limit)
+	if err != nil {
+		return questions, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var q WorstRatedQuestion
+		err = rows.Scan(&q.QuestionID, &q.Query, &q.Answer, &q.Score, &q.ThumbsDown, &q.ThumbsUp,
+			&q.FlaggedChunks, pq.Array(&q.Comments), &q.LastRatedAt)
+		if err != nil {
+			return questions, err
+		}
+		questions = append(questions, q)
+	}
+
+	return questions, rows.Err()
+}
+
+// getDocumentsInBadAnswers returns the documents whose chunks were most often cited in thumbs-down answers
+// Chunks explicitly flagged by users are counted separately
+func getDocumentsInBadAnswers(limit int) ([]DocumentFeedbackStat, error) {
+	var documents []DocumentFeedbackStat
+	query := `
+	WITH bad AS (
+		SELECT f.question_id, f.flagged_chunk_ids, q.chunk_ids
+		FROM answer_feedback f
+		JOIN questions q ON q.id = f.question_id
+		WHERE f.rating < 0
+	),
+	cited AS (
+		SELECT c.document_id, COUNT(DISTINCT bad.question_id) AS bad_answers
+		FROM bad
+		CROSS JOIN LATERAL unnest(bad.chunk_ids) AS cited_chunk(id)
+		JOIN chunks c ON c.id = cited_chunk.id
+		GROUP BY c.document_id
+	),
+	flagged AS (
+		SELECT c.document_id, COUNT(*) AS flagged_chunks
+		FROM bad
+		CROSS JOIN LATERAL unnest(bad.flagged_chunk_ids) AS flagged_chunk(id)
+		JOIN chunks c ON c.id = flagged_chunk.id
+		GROUP BY c.document_id
+	)
+	SELECT d.id, d.name, COALESCE(d.original_filename, ''), cited.bad_answers, COALESCE(flagged.flagged_chunks, 0)
+	FROM cited
+	JOIN documents d ON d.id = cited.document_id
+	LEFT JOIN flagged ON flagged.document_id = cited.document_id
+	ORDER BY cited.bad_answers DESC, COALESCE(flagged.flagged_chunks, 0) DESC
+	LIMIT $1
+	`
+
+	stmt, err := db.DB.Prepare(query)
+	if err != nil {
+		return documents, err
+	}
+	defer stmt.Close()
+
+	rows, err := stmt.Query(limit)
+	if err != nil {
+		return documents, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var d DocumentFeedbackStat
+		err = rows.Scan(&d.DocumentID, &d.Name, &d.OriginalFilename, &d.BadAnswers, &d.FlaggedChunks)
+		if err != nil {
+			return documents, err
+		}
+		documents = append(documents, d)
+	}
+
+	return documents, rows.Err()
+}
+
+// feedbackReportLimit applies the default and the maximum to the requested report size
+func feedbackReportLimit(limit int) int {
+	if limit <= 0 {
+		return defaultFeedbackReportLimit
+	}
+	if limit > maxFeedbackReportLimit {
+		return maxFeedbackReportLimit
+	}
+	return limit
+}
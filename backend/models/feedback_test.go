@@ -0,0 +1,55 @@
+package models
+
+import (
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidateFeedback(t *testing.T) {
+	citedChunk := uuid.New()
+	question := Question{ChunkIDs: []uuid.UUID{citedChunk}}
+
+	tests := []struct {
+		name        string
+		feedback    AnswerFeedback
+		expectError bool
+	}{
+		{
+			name:     "Thumbs up",
+			feedback: AnswerFeedback{Rating: RatingThumbsUp},
+		},
+		{
+			name:     "Thumbs down flagging a cited chunk",
+			feedback: AnswerFeedback{Rating: RatingThumbsDown, Comment: "Outdated", FlaggedChunkIDs: []uuid.UUID{citedChunk}},
+		},
+		{
+			name:        "Invalid rating",
+			feedback:    AnswerFeedback{Rating: 5},
+			expectError: true,
+		},
+		{
+			name:        "Flagged chunk that was not cited",
+			feedback:    AnswerFeedback{Rating: RatingThumbsDown, FlaggedChunkIDs: []uuid.UUID{uuid.New()}},
+			expectError: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.feedback.ValidateFeedback(question)
+			if tt.expectError {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestFeedbackReportLimit(t *testing.T) {
+	assert.Equal(t, defaultFeedbackReportLimit, feedbackReportLimit(0))
+	assert.Equal(t, 25, feedbackReportLimit(25))
+	assert.Equal(t, maxFeedbackReportLimit, feedbackReportLimit(100000), "the report size is capped")
+}
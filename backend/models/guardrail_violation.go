@@ -0,0 +1,462 @@
+package models
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/MauricioAliendre182/backend/db"
+	"github.com/MauricioAliendre182/backend/utils"
+	"github.com/google/uuid"
+)
+
+// ErrGuardrailViolationNotFound is returned when a violation does not exist
+var ErrGuardrailViolationNotFound = errors.New("guardrail violation not found")
+
+// Groupings of the guardrail violation summary
+const (
+	ViolationGroupByUser  = "user"
+	ViolationGroupByType  = "type"
+	ViolationGroupByCheck = "check"
+	ViolationGroupByRule  = "rule"
+)
+
+// GuardrailViolationRecord is a row of the guardrail_violations audit log
+// Question is the sanitized question, also for violations of the answer
+// ReviewedAt is set once an admin reviewed the violation, FalsePositive is their verdict
+// For violations of the document stage Question holds the name of the uploaded file
+// Redactions is the number of personal data values redacted or masked by the pii check
+type GuardrailViolationRecord struct {
+	CreatedAt     time.Time  `json:"created_at"`
+	ReviewedAt    *time.Time `json:"reviewed_at,omitempty"`
+	QuestionID    *uuid.UUID `json:"question_id,omitempty"`
+	UserID        string     `json:"user_id,omitempty"`
+	UserEmail     string     `json:"user_email,omitempty"`
+	Stage         string     `json:"stage"`
+	Check         string     `json:"check"`
+	Type          string     `json:"type"`
+	Severity      string     `json:"severity"`
+	Message       string     `json:"message"`
+	Question      string     `json:"question"`
+	Rule          string     `json:"rule,omitempty"`
+	PolicyVersion string     `json:"policy_version,omitempty"`
+	ReviewNote    string     `json:"review_note,omitempty"`
+	ReviewedBy    string     `json:"reviewed_by,omitempty"`
+	Redactions    int        `json:"redactions,omitempty"`
+	FalsePositive bool       `json:"false_positive"`
+	ID            uuid.UUID  `json:"id"`
+}
+
+// GuardrailViolationFilter holds the optional filters used to list violations
+// Zero values mean "no filter" for that field
+type GuardrailViolationFilter struct {
+	From          *time.Time
+	To            *time.Time
+	FalsePositive *bool
+	UserID        string
+	Type          string
+	Severity      string
+	Check         string
+	Stage         string
+	Limit         int
+	Offset        int
+}
+
+// GuardrailViolationGroup is a row of the violation summary
+// Group is the user ID, type, check or rule depending on the grouping, GroupName the user's email
+type GuardrailViolationGroup struct {
+	LastSeen       time.Time `json:"last_seen"`
+	Group          string    `json:"group"`
+	GroupName      string    `json:"group_name,omitempty"`
+	Total          int       `json:"total"`
+	Errors         int       `json:"errors"`
+	Warnings       int       `json:"warnings"`
+	Reviewed       int       `json:"reviewed"`
+	FalsePositives int       `json:"false_positives"`
+	Redactions     int       `json:"redactions"`
+}
+
+// GuardrailRuleStat is the review outcome of one rule, used to tune the guardrail policy
+// FalsePositiveRate is the share of reviewed violations marked as false positives
+// Suggestion tells what to change in the policy when most reviewed violations were false positives
+type GuardrailRuleStat struct {
+	Check             string  `json:"check"`
+	Rule              string  `json:"rule"`
+	Suggestion        string  `json:"suggestion,omitempty"`
+	Violations        int     `json:"violations"`
+	Reviewed          int     `json:"reviewed"`
+	FalsePositives    int     `json:"false_positives"`
+	FalsePositiveRate float64 `json:"false_positive_rate"`
+}
+
+// Default and maximum page sizes for violation listings
+const (
+	defaultViolationLimit = 50
+	maxViolationLimit     = 500
+)
+
+// Tuning suggestions are only made once enough violations of a rule were reviewed
+const (
+	minReviewedForSuggestion       = 3
+	falsePositiveRateForSuggestion = 0.5
+)
+
+// ViolationPageLimit returns the page size a violation listing uses for the requested limit,
+// the default when it is not positive and at most maxViolationLimit
+func ViolationPageLimit(limit int) int {
+	if limit <= 0 {
+		return defaultViolationLimit
+	}
+	return min(limit, maxViolationLimit)
+}
+
+// RecordGuardrailViolations stores the violations reported for a question
+// A question that was not saved leaves question_id empty instead of breaking the foreign key
+func RecordGuardrailViolations(userID, questionID, question, policyVersion string, violations []utils.GuardrailViolation) error {
+	if len(violations) == 0 {
+		return nil
+	}
+
+	query := `
+	INSERT INTO guardrail_violations (question_id, user_id, stage, check_name, type, severity, message, question, matched_rule, policy_version, redactions)
+	VALUES ((SELECT id FROM questions WHERE id = $1), $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)
+	`
+
+	return utils.WithTransaction(func(tx *sql.Tx) error {
+		stmt, err := tx.Prepare(query)
+		if err != nil {
+			return err
+		}
+		defer stmt.Close()
+
+		for _, violation := range violations {
+			stage := violation.Stage
+			if stage == "" {
+				stage = utils.GuardrailStageInput
+			}
+
+			_, err := stmt.Exec(
+				nullableUUID(questionID),
+				nullableUUID(userID),
+				stage,
+				violation.Check,
+				violation.Type,
+				violation.Severity,
+				violation.Message,
+				question,
+				violation.Rule,
+				policyVersion,
+				violation.Redactions,
+			)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// guardrailViolationColumns lists the columns read by the violation queries, in scan order
+const guardrailViolationColumns = `v.id, v.question_id, COALESCE(v.user_id::text, ''), COALESCE(u.email, ''), v.stage, v.check_name,
+		v.type, v.severity, v.message, v.question, v.matched_rule, v.policy_version, v.false_positive,
+		COALESCE(v.review_note, ''), COALESCE(v.reviewed_by::text, ''), v.reviewed_at, v.created_at, v.redactions`
+
+// GetGuardrailViolations returns the violations matching the given filter, newest first
+func GetGuardrailViolations(filter GuardrailViolationFilter) ([]GuardrailViolationRecord, error) {
+	violations := []GuardrailViolationRecord{}
+
+	query, args := buildGuardrailViolationQuery(filter)
+
+	stmt, err := db.DB.Prepare(query)
+	if err != nil {
+		return violations, err
+	}
+	defer stmt.Close()
+
+	rows, err := stmt.Query(args...)
+	if err != nil {
+		return violations, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		violation, err := scanGuardrailViolation(rows)
+		if err != nil {
+			return violations, err
+		}
+		violations = append(violations, violation)
+	}
+
+	return violations, rows.Err()
+}
+
+// buildGuardrailViolationQuery builds the SELECT statement and its arguments for a filter
+// Only placeholders are used for values so the query stays safe from SQL injection
+func buildGuardrailViolationQuery(filter GuardrailViolationFilter) (string, []any) {
+	var conditions []string
+	var args []any
+
+	addCondition := func(condition string, value any) {
+		args = append(args, value)
+		conditions = append(conditions, fmt.Sprintf(condition, len(args)))
+	}
+
+	if filter.UserID != "" {
+		addCondition("v.user_id = $%d", filter.UserID)
+	}
+	if filter.Type != "" {
+		addCondition("v.type = $%d", filter.Type)
+	}
+	if filter.Severity != "" {
+		addCondition("v.severity = $%d", filter.Severity)
+	}
+	if filter.Check != "" {
+		addCondition("v.check_name = $%d", filter.Check)
+	}
+	if filter.Stage != "" {
+		addCondition("v.stage = $%d", filter.Stage)
+	}
+	if filter.FalsePositive != nil {
+		addCondition("v.false_positive = $%d", *filter.FalsePositive)
+	}
+	if filter.From != nil {
+		addCondition("v.created_at >= $%d", *filter.From)
+	}
+	if filter.To != nil {
+		addCondition("v.created_at <= $%d", *filter.To)
+	}
+
+	query := "SELECT " + guardrailViolationColumns + "\n\tFROM guardrail_violations v\n\tLEFT JOIN users u ON u.id = v.user_id"
+	if len(conditions) > 0 {
+		query += "\n\tWHERE " + strings.Join(conditions, " AND ")
+	}
+
+	limit := ViolationPageLimit(filter.Limit)
+	offset := filter.Offset
+	if offset < 0 {
+		offset = 0
+	}
+
+	args = append(args, limit, offset)
+	query += fmt.Sprintf("\n\tORDER BY v.created_at DESC\n\tLIMIT $%d OFFSET $%d", len(args)-1, len(args))
+
+	return query, args
+}
+
+// scanGuardrailViolation reads a violation from a row selected with guardrailViolationColumns
+func scanGuardrailViolation(row rowScanner) (GuardrailViolationRecord, error) {
+	var v GuardrailViolationRecord
+	var questionID sql.NullString
+	var reviewedAt sql.NullTime
+
+	err := row.Scan(&v.ID, &questionID, &v.UserID, &v.UserEmail, &v.Stage, &v.Check,
+		&v.Type, &v.Severity, &v.Message, &v.Question, &v.Rule, &v.PolicyVersion, &v.FalsePositive,
+		&v.ReviewNote, &v.ReviewedBy, &reviewedAt, &v.CreatedAt, &v.Redactions)
+	if err != nil {
+		return v, err
+	}
+
+	if questionID.Valid {
+		parsed, err := uuid.Parse(questionID.String)
+		if err != nil {
+			return v, fmt.Errorf("invalid question id %q: %v", questionID.String, err)
+		}
+		v.QuestionID = &parsed
+	}
+	if reviewedAt.Valid {
+		v.ReviewedAt = &reviewedAt.Time
+	}
+
+	return v, nil
+}
+
+// ReviewGuardrailViolation records an admin's verdict on a violation
+// Reviewing again replaces the previous verdict
+func ReviewGuardrailViolation(id uuid.UUID, falsePositive bool, note, reviewerID string) (GuardrailViolationRecord, error) {
+	query := `
+	UPDATE guardrail_violations
+	SET false_positive = $2, review_note = $3, reviewed_by = $4, reviewed_at = now()
+	WHERE id = $1
+	`
+
+	stmt, err := db.DB.Prepare(query)
+	if err != nil {
+		return GuardrailViolationRecord{}, err
+	}
+	defer stmt.Close()
+
+	result, err := stmt.Exec(id, falsePositive, nullableString(note), nullableUUID(reviewerID))
+	if err != nil {
+		return GuardrailViolationRecord{}, err
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return GuardrailViolationRecord{}, err
+	}
+	if affected == 0 {
+		return GuardrailViolationRecord{}, ErrGuardrailViolationNotFound
+	}
+
+	return GetGuardrailViolationByID(id)
+}
+
+// GetGuardrailViolationByID retrieves a single violation by ID
+func GetGuardrailViolationByID(id uuid.UUID) (GuardrailViolationRecord, error) {
+	query := `
+	SELECT ` + guardrailViolationColumns + `
+	FROM guardrail_violations v
+	LEFT JOIN users u ON u.id = v.user_id
+	WHERE v.id = $1
+	`
+
+	stmt, err := db.DB.Prepare(query)
+	if err != nil {
+		return GuardrailViolationRecord{}, err
+	}
+	defer stmt.Close()
+
+	violation, err := scanGuardrailViolation(stmt.QueryRow(id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return violation, ErrGuardrailViolationNotFound
+	}
+	return violation, err
+}
+
+// GetGuardrailViolationSummary counts the violations between from and to per user, type, check or rule
+// Groups are sorted by number of violations, most first
+func GetGuardrailViolationSummary(from, to time.Time, groupBy string) ([]GuardrailViolationGroup, error) {
+	groups := []GuardrailViolationGroup{}
+
+	var group, groupName, groupColumns string
+	switch groupBy {
+	case ViolationGroupByUser:
+		group, groupName = "COALESCE(v.user_id::text, '')", "COALESCE(u.email, '')"
+		groupColumns = "v.user_id, u.email"
+	case ViolationGroupByType:
+		group, groupName, groupColumns = "v.type", "''", "v.type"
+	case ViolationGroupByCheck:
+		group, groupName, groupColumns = "v.check_name", "''", "v.check_name"
+	case ViolationGroupByRule:
+		group, groupName, groupColumns = "v.matched_rule", "v.check_name", "v.matched_rule, v.check_name"
+	default:
+		return groups, fmt.Errorf("group_by must be %q, %q, %q or %q",
+			ViolationGroupByUser, ViolationGroupByType, ViolationGroupByCheck, ViolationGroupByRule)
+	}
+
+	// The group expressions come from the switch above, never from the request
+	query := `
+	SELECT ` + group + `, ` + groupName + `, COUNT(*),
+		COUNT(*) FILTER (WHERE v.severity = 'error'),
+		COUNT(*) FILTER (WHERE v.severity = 'warning'),
+		COUNT(*) FILTER (WHERE v.reviewed_at IS NOT NULL),
+		COUNT(*) FILTER (WHERE v.false_positive),
+		COALESCE(SUM(v.redactions), 0),
+		MAX(v.created_at)
+	FROM guardrail_violations v
+	LEFT JOIN users u ON u.id = v.user_id
+	WHERE v.created_at >= $1 AND v.created_at < $2
+	GROUP BY ` + groupColumns + `
+	ORDER BY COUNT(*) DESC, 1
+	`
+
+	stmt, err := db.DB.Prepare(query)
+	if err != nil {
+		return groups, err
+	}
+	defer stmt.Close()
+
+	rows, err := stmt.Query(from, to)
+	if err != nil {
+		return groups, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var g GuardrailViolationGroup
+		err := rows.Scan(&g.Group, &g.GroupName, &g.Total, &g.Errors, &g.Warnings, &g.Reviewed, &g.FalsePositives, &g.Redactions, &g.LastSeen)
+		if err != nil {
+			return groups, err
+		}
+		groups = append(groups, g)
+	}
+
+	return groups, rows.Err()
+}
+
+// GetGuardrailRuleStats returns the review outcome of every rule that reported violations between from and to
+// Rules with the highest false positive rate come first, they are the ones to tune
+func GetGuardrailRuleStats(from, to time.Time) ([]GuardrailRuleStat, error) {
+	stats := []GuardrailRuleStat{}
+
+	query := `
+	SELECT v.check_name, v.matched_rule, COUNT(*),
+		COUNT(*) FILTER (WHERE v.reviewed_at IS NOT NULL),
+		COUNT(*) FILTER (WHERE v.false_positive)
+	FROM guardrail_violations v
+	WHERE v.created_at >= $1 AND v.created_at < $2
+	GROUP BY v.check_name, v.matched_rule
+	`
+
+	stmt, err := db.DB.Prepare(query)
+	if err != nil {
+		return stats, err
+	}
+	defer stmt.Close()
+
+	rows, err := stmt.Query(from, to)
+	if err != nil {
+		return stats, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var stat GuardrailRuleStat
+		if err := rows.Scan(&stat.Check, &stat.Rule, &stat.Violations, &stat.Reviewed, &stat.FalsePositives); err != nil {
+			return stats, err
+		}
+		stats = append(stats, completeGuardrailRuleStat(stat))
+	}
+	if err := rows.Err(); err != nil {
+		return stats, err
+	}
+
+	sortGuardrailRuleStats(stats)
+	return stats, nil
+}
+
+// completeGuardrailRuleStat computes the false positive rate of a rule and the tuning suggestion
+func completeGuardrailRuleStat(stat GuardrailRuleStat) GuardrailRuleStat {
+	if stat.Reviewed > 0 {
+		stat.FalsePositiveRate = float64(stat.FalsePositives) / float64(stat.Reviewed)
+	}
+	if stat.Reviewed < minReviewedForSuggestion || stat.FalsePositiveRate < falsePositiveRateForSuggestion {
+		return stat
+	}
+
+	percent := int(stat.FalsePositiveRate*100 + 0.5)
+	switch stat.Check {
+	case utils.GuardrailCheckBlockedPhrases:
+		stat.Suggestion = fmt.Sprintf("%d%% of the reviewed violations were false positives: remove %q from blocked_phrases or make it more specific", percent, stat.Rule)
+	case utils.GuardrailCheckPII:
+		stat.Suggestion = fmt.Sprintf("%d%% of the reviewed redactions were false positives: remove %q from pii.types", percent, stat.Rule)
+	case utils.GuardrailCheckRegexRules:
+		stat.Suggestion = fmt.Sprintf("%d%% of the reviewed violations were false positives: tighten the pattern of regex rule %q or set its severity to warning", percent, stat.Rule)
+	default:
+		stat.Suggestion = fmt.Sprintf("%d%% of the reviewed violations were false positives: set checks.%s.severity to warning or disable the check", percent, stat.Check)
+	}
+	return stat
+}
+
+// sortGuardrailRuleStats orders rules by false positive rate, then by number of violations
+func sortGuardrailRuleStats(stats []GuardrailRuleStat) {
+	sort.SliceStable(stats, func(i, j int) bool {
+		if stats[i].FalsePositiveRate != stats[j].FalsePositiveRate {
+			return stats[i].FalsePositiveRate > stats[j].FalsePositiveRate
+		}
+		return stats[i].Violations > stats[j].Violations
+	})
+}
@@ -0,0 +1,103 @@
+package models
+
+import (
+	"testing"
+	"time"
+
+	"github.com/MauricioAliendre182/backend/utils"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBuildGuardrailViolationQuery(t *testing.T) {
+	from := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	falsePositive := true
+
+	tests := []struct {
+		name          string
+		filter        GuardrailViolationFilter
+		expectedWhere string
+		expectedArgs  []any
+	}{
+		{
+			name:         "No filters uses default pagination",
+			filter:       GuardrailViolationFilter{},
+			expectedArgs: []any{defaultViolationLimit, 0},
+		},
+		{
+			name:          "Multiple filters are combined in order",
+			filter:        GuardrailViolationFilter{UserID: "user-1", Type: "injection_attempt", FalsePositive: &falsePositive, From: &from, Limit: 10},
+			expectedWhere: "WHERE v.user_id = $1 AND v.type = $2 AND v.false_positive = $3 AND v.created_at >= $4",
+			expectedArgs:  []any{"user-1", "injection_attempt", true, from, 10, 0},
+		},
+		{
+			name:          "Check and stage filters",
+			filter:        GuardrailViolationFilter{Severity: "error", Check: "regex_rules", Stage: "output"},
+			expectedWhere: "WHERE v.severity = $1 AND v.check_name = $2 AND v.stage = $3",
+			expectedArgs:  []any{"error", "regex_rules", "output", defaultViolationLimit, 0},
+		},
+		{
+			name:         "Limit is capped",
+			filter:       GuardrailViolationFilter{Limit: 10000, Offset: -1},
+			expectedArgs: []any{maxViolationLimit, 0},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			query, args := buildGuardrailViolationQuery(tt.filter)
+
+			if tt.expectedWhere != "" {
+				assert.Contains(t, query, tt.expectedWhere)
+			} else {
+				assert.NotContains(t, query, "WHERE")
+			}
+			assert.Contains(t, query, "ORDER BY v.created_at DESC")
+			assert.Equal(t, tt.expectedArgs, args)
+		})
+	}
+}
+
+func TestCompleteGuardrailRuleStat(t *testing.T) {
+	// Too few reviews for a suggestion
+	stat := completeGuardrailRuleStat(GuardrailRuleStat{Check: utils.GuardrailCheckBlockedPhrases, Rule: "simulate", Violations: 10, Reviewed: 2, FalsePositives: 2})
+	assert.Equal(t, 1.0, stat.FalsePositiveRate)
+	assert.Empty(t, stat.Suggestion)
+
+	stat = completeGuardrailRuleStat(GuardrailRuleStat{Check: utils.GuardrailCheckBlockedPhrases, Rule: "simulate", Violations: 10, Reviewed: 4, FalsePositives: 3})
+	assert.Equal(t, 0.75, stat.FalsePositiveRate)
+	assert.Contains(t, stat.Suggestion, `75% of the reviewed violations were false positives`)
+	assert.Contains(t, stat.Suggestion, `remove "simulate" from blocked_phrases`)
+
+	stat = completeGuardrailRuleStat(GuardrailRuleStat{Check: utils.GuardrailCheckRegexRules, Rule: "employee_id", Reviewed: 3, FalsePositives: 2})
+	assert.Contains(t, stat.Suggestion, `regex rule "employee_id"`)
+
+	stat = completeGuardrailRuleStat(GuardrailRuleStat{Check: utils.GuardrailCheckDocumentFocus, Reviewed: 5, FalsePositives: 5})
+	assert.Contains(t, stat.Suggestion, "checks.document_focus.severity")
+
+	// Mostly confirmed violations need no change
+	stat = completeGuardrailRuleStat(GuardrailRuleStat{Check: utils.GuardrailCheckPromptInjection, Reviewed: 10, FalsePositives: 1})
+	assert.Equal(t, 0.1, stat.FalsePositiveRate)
+	assert.Empty(t, stat.Suggestion)
+
+	// Never reviewed
+	stat = completeGuardrailRuleStat(GuardrailRuleStat{Check: utils.GuardrailCheckPromptInjection, Violations: 3})
+	assert.Zero(t, stat.FalsePositiveRate)
+}
+
+func TestSortGuardrailRuleStats(t *testing.T) {
+	stats := []GuardrailRuleStat{
+		{Rule: "a", Violations: 5, FalsePositiveRate: 0.2},
+		{Rule: "b", Violations: 1, FalsePositiveRate: 0.9},
+		{Rule: "c", Violations: 9, FalsePositiveRate: 0.2},
+	}
+	sortGuardrailRuleStats(stats)
+	assert.Equal(t, "b", stats[0].Rule)
+	assert.Equal(t, "c", stats[1].Rule)
+	assert.Equal(t, "a", stats[2].Rule)
+}
+
+func TestViolationPageLimit(t *testing.T) {
+	assert.Equal(t, defaultViolationLimit, ViolationPageLimit(0))
+	assert.Equal(t, 20, ViolationPageLimit(20))
+	assert.Equal(t, maxViolationLimit, ViolationPageLimit(10000))
+}
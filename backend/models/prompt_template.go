@@ -0,0 +1,178 @@
+package models
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/MauricioAliendre182/backend/db"
+	"github.com/MauricioAliendre182/backend/utils"
+	"github.com/google/uuid"
+)
+
+// PromptTemplate represents a row in the prompt_templates table
+type PromptTemplate struct {
+	CreatedAt      time.Time `json:"created_at"`
+	Name           string    `json:"name"`
+	SystemTemplate string    `json:"system_template"`
+	UserTemplate   string    `json:"user_template"`
+	Description    string    `json:"description,omitempty"`
+	CreatedBy      string    `json:"created_by,omitempty"`
+	Version        int       `json:"version"`
+	IsActive       bool      `json:"is_active"`
+	ID             uuid.UUID `json:"id"`
+}
+
+// ErrPromptTemplateNotFound is returned when a template ID does not exist
+var ErrPromptTemplateNotFound = errors.New("prompt template not found")
+
+// promptTemplateColumns lists the columns read by the template queries, in scan order
+const promptTemplateColumns = `id, name, version, system_template, user_template, COALESCE(description, ''),
+		is_active, COALESCE(created_by::text, ''), created_at`
+
+// Template converts the row into a renderable template
+func (t *PromptTemplate) Template() utils.PromptTemplate {
+	return utils.PromptTemplate{
+		Name:    t.Name,
+		Version: t.Version,
+		System:  t.SystemTemplate,
+		User:    t.UserTemplate,
+	}
+}
+
+// Save validates the template and stores it as the next version of its name
+// New versions are never active, they have to be activated explicitly
+func (t *PromptTemplate) Save() error {
+	if err := utils.ValidatePromptTemplate(t.Template()); err != nil {
+		return err
+	}
+
+	if t.ID == uuid.Nil {
+		t.ID = uuid.New()
+	}
+	t.CreatedAt = time.Now()
+	t.IsActive = false
+
+	// The version is computed inside the INSERT, the UNIQUE (name, version) constraint
+	// rejects the rare concurrent save that would pick the same number
+	query := `
+	INSERT INTO prompt_templates (id, name, version, system_template, user_template, description, is_active, created_by, created_at)
+	SELECT $1, $2, COALESCE(MAX(version), 0) + 1, $3, $4, $5, false, $6, $7
+	FROM prompt_templates
+	WHERE name = $2
+	RETURNING version
+	`
+
+	stmt, err := db.DB.Prepare(query)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	return stmt.QueryRow(
+		t.ID,
+		t.Name,
+		t.SystemTemplate,
+		t.UserTemplate,
+		nullableString(t.Description),
+		nullableUUID(t.CreatedBy),
+		t.CreatedAt,
+	).Scan(&t.Version)
+}
+
+// GetPromptTemplates returns all stored template versions, optionally filtered by name
+func GetPromptTemplates(name string) ([]PromptTemplate, error) {
+	var templates []PromptTemplate
+	query := `
+	SELECT ` + promptTemplateColumns + `
+	FROM prompt_templates
+	WHERE $1 = '' OR name = $1
+	ORDER BY name, version DESC
+	`
+
+	stmt, err := db.DB.Prepare(query)
+	if err != nil {
+		return templates, err
+	}
+	defer stmt.Close()
+
+	rows, err := stmt.Query(name)
+	if err != nil {
+		return templates, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		t, err := scanPromptTemplate(rows)
+		if err != nil {
+			return templates, err
+		}
+		templates = append(templates, t)
+	}
+
+	return templates, rows.Err()
+}
+
+// ActivatePromptTemplate makes the given version the active one for its name
+// The previous active version is deactivated in the same transaction
+func ActivatePromptTemplate(id uuid.UUID) (PromptTemplate, error) {
+	var activated PromptTemplate
+
+	err := utils.WithTransaction(func(tx *sql.Tx) error {
+		row := tx.QueryRow(`SELECT `+promptTemplateColumns+` FROM prompt_templates WHERE id = $1 FOR UPDATE`, id)
+		t, err := scanPromptTemplate(row)
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrPromptTemplateNotFound
+		}
+		if err != nil {
+			return err
+		}
+
+		// Deactivate first so the partial unique index never sees two active rows
+		if _, err := tx.Exec(`UPDATE prompt_templates SET is_active = false WHERE name = $1 AND is_active`, t.Name); err != nil {
+			return fmt.Errorf("failed to deactivate previous template: %v", err)
+		}
+		if _, err := tx.Exec(`UPDATE prompt_templates SET is_active = true WHERE id = $1`, id); err != nil {
+			return fmt.Errorf("failed to activate template: %v", err)
+		}
+
+		t.IsActive = true
+		activated = t
+		return nil
+	})
+
+	return activated, err
+}
+
+// GetActivePromptTemplate returns the active template for a name
+// When no version is active (or the database cannot be read) the built-in template is used,
+// so answering questions never depends on the templates table
+func GetActivePromptTemplate(name string) (utils.PromptTemplate, error) {
+	query := `SELECT ` + promptTemplateColumns + ` FROM prompt_templates WHERE name = $1 AND is_active`
+
+	stmt, err := db.DB.Prepare(query)
+	if err == nil {
+		defer stmt.Close()
+
+		var t PromptTemplate
+		t, err = scanPromptTemplate(stmt.QueryRow(name))
+		if err == nil {
+			return t.Template(), nil
+		}
+	}
+
+	if !errors.Is(err, sql.ErrNoRows) {
+		utils.LogWarn("Could not load active prompt template, using built-in", "name", name, "error", err)
+	}
+
+	return utils.BuiltinPromptTemplate(name)
+}
+
+// scanPromptTemplate reads a template from a row selected with promptTemplateColumns
+func scanPromptTemplate(row rowScanner) (PromptTemplate, error) {
+	var t PromptTemplate
+	err := row.Scan(&t.ID, &t.Name, &t.Version, &t.SystemTemplate, &t.UserTemplate, &t.Description,
+		&t.IsActive, &t.CreatedBy, &t.CreatedAt)
+	return t, err
+}
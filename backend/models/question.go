@@ -0,0 +1,282 @@
+package models
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/MauricioAliendre182/backend/db"
+	"github.com/MauricioAliendre182/backend/utils"
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+)
+
+// Question statuses stored in the questions table
+const (
+	QuestionStatusAnswered = "answered"
+	QuestionStatusBlocked  = "blocked"
+	QuestionStatusFailed   = "failed"
+)
+
+// Question represents a row in the questions table
+// Every query sent to /query is recorded here, including the ones blocked by guardrails
+type Question struct {
+	AskedAt    time.Time                  `json:"asked_at"`
+	Violations []utils.GuardrailViolation `json:"violations"`
+	ChunkIDs   []uuid.UUID                `json:"chunk_ids"`
+	UserID     string                     `json:"user_id,omitempty"`
+	Query      string                     `json:"query"`
+	Answer     string                     `json:"answer,omitempty"`
+	Provider   string                     `json:"provider,omitempty"`
+	Model      string                     `json:"model,omitempty"`
+	Status     string                     `json:"status"`
+	// TemplateName and TemplateVersion identify the prompt template that produced the answer
+	TemplateName    string    `json:"template_name,omitempty"`
+	TemplateVersion *int      `json:"template_version,omitempty"`
+	LatencyMs       int64     `json:"latency_ms"`
+	ID              uuid.UUID `json:"id"`
+}
+
+// QuestionFilter holds the optional filters used to list questions
+// Zero values mean "no filter" for that field
+type QuestionFilter struct {
+	From     *time.Time
+	To       *time.Time
+	UserID   string
+	Provider string
+	Status   string
+	Limit    int
+	Offset   int
+}
+
+// Default and maximum page sizes for question listings
+const (
+	defaultQuestionLimit = 50
+	maxQuestionLimit     = 500
+)
+
+// QuestionPageLimit returns the page size a question listing uses for the requested limit,
+// the default when it is not positive and at most maxQuestionLimit
+func QuestionPageLimit(limit int) int {
+	if limit <= 0 {
+		return defaultQuestionLimit
+	}
+	return min(limit, maxQuestionLimit)
+}
+
+// Save inserts the question into the database
+func (q *Question) Save() error {
+	query := `
+	INSERT INTO questions (id, user_id, query, answer, chunk_ids, provider, model, latency_ms, violations, status, asked_at,
+		template_name, template_version)
+	VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13)
+	RETURNING id
+	`
+
+	if q.ID == uuid.Nil {
+		q.ID = uuid.New()
+	}
+	if q.AskedAt.IsZero() {
+		q.AskedAt = time.Now()
+	}
+	if q.Status == "" {
+		q.Status = QuestionStatusAnswered
+	}
+
+	// Violations are stored as a JSON array so they can be inspected later
+	violations, err := json.Marshal(q.Violations)
+	if err != nil {
+		return fmt.Errorf("failed to marshal violations: %v", err)
+	}
+
+	stmt, err := db.DB.Prepare(query)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	err = stmt.QueryRow(
+		q.ID,
+		nullableUUID(q.UserID),
+		q.Query,
+		nullableString(q.Answer),
+		pq.Array(uuidStrings(q.ChunkIDs)),
+		nullableString(q.Provider),
+		nullableString(q.Model),
+		q.LatencyMs,
+		string(violations),
+		q.Status,
+		q.AskedAt,
+		nullableString(q.TemplateName),
+		q.TemplateVersion,
+	).Scan(&q.ID)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// GetQuestionsByUserID returns the question history of a single user, newest first
+func GetQuestionsByUserID(userID string, limit, offset int) ([]Question, error) {
+	return GetQuestions(QuestionFilter{UserID: userID, Limit: limit, Offset: offset})
+}
+
+// GetQuestionByID retrieves a single question by ID
+func GetQuestionByID(id uuid.UUID) (Question, error) {
+	query := `
+	SELECT ` + questionColumns + `
+	FROM questions
+	WHERE id = $1
+	`
+
+	stmt, err := db.DB.Prepare(query)
+	if err != nil {
+		return Question{}, err
+	}
+	defer stmt.Close()
+
+	return scanQuestion(stmt.QueryRow(id))
+}
+
+// GetQuestions returns the questions matching the given filter, newest first
+func GetQuestions(filter QuestionFilter) ([]Question, error) {
+	var questions []Question
+
+	query, args := buildQuestionQuery(filter)
+
+	stmt, err := db.DB.Prepare(query)
+	if err != nil {
+		return questions, err
+	}
+	defer stmt.Close()
+
+	rows, err := stmt.Query(args...)
+	if err != nil {
+		return questions, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		question, err := scanQuestion(rows)
+		if err != nil {
+			return questions, err
+		}
+		questions = append(questions, question)
+	}
+
+	return questions, rows.Err()
+}
+
+// questionColumns lists the columns read by the question queries, in scan order
+const questionColumns = `id, COALESCE(user_id::text, ''), query, COALESCE(answer, ''), COALESCE(chunk_ids, '{}'),
+		COALESCE(provider, ''), COALESCE(model, ''), COALESCE(latency_ms, 0), COALESCE(violations, '[]'), status, asked_at,
+		COALESCE(template_name, ''), template_version`
+
+// buildQuestionQuery builds the SELECT statement and its arguments for a filter
+// Only placeholders are used for values so the query stays safe from SQL injection
+func buildQuestionQuery(filter QuestionFilter) (string, []any) {
+	var conditions []string
+	var args []any
+
+	addCondition := func(condition string, value any) {
+		args = append(args, value)
+		conditions = append(conditions, fmt.Sprintf(condition, len(args)))
+	}
+
+	if filter.UserID != "" {
+		addCondition("user_id = $%d", filter.UserID)
+	}
+	if filter.Provider != "" {
+		addCondition("provider = $%d", filter.Provider)
+	}
+	if filter.Status != "" {
+		addCondition("status = $%d", filter.Status)
+	}
+	if filter.From != nil {
+		addCondition("asked_at >= $%d", *filter.From)
+	}
+	if filter.To != nil {
+		addCondition("asked_at <= $%d", *filter.To)
+	}
+
+	query := "SELECT " + questionColumns + "\n\tFROM questions"
+	if len(conditions) > 0 {
+		query += "\n\tWHERE " + strings.Join(conditions, " AND ")
+	}
+
+	limit := QuestionPageLimit(filter.Limit)
+	offset := filter.Offset
+	if offset < 0 {
+		offset = 0
+	}
+
+	args = append(args, limit, offset)
+	query += fmt.Sprintf("\n\tORDER BY asked_at DESC\n\tLIMIT $%d OFFSET $%d", len(args)-1, len(args))
+
+	return query, args
+}
+
+// rowScanner is satisfied by both *sql.Row and *sql.Rows
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+// scanQuestion reads a question from a row selected with questionColumns
+func scanQuestion(row rowScanner) (Question, error) {
+	var q Question
+	var chunkIDs []string
+	var violations string
+	var templateVersion sql.NullInt64
+
+	err := row.Scan(&q.ID, &q.UserID, &q.Query, &q.Answer, pq.Array(&chunkIDs),
+		&q.Provider, &q.Model, &q.LatencyMs, &violations, &q.Status, &q.AskedAt,
+		&q.TemplateName, &templateVersion)
+	if err != nil {
+		return q, err
+	}
+
+	if templateVersion.Valid {
+		version := int(templateVersion.Int64)
+		q.TemplateVersion = &version
+	}
+
+	for _, id := range chunkIDs {
+		parsed, err := uuid.Parse(id)
+		if err != nil {
+			return q, fmt.Errorf("invalid chunk id %q: %v", id, err)
+		}
+		q.ChunkIDs = append(q.ChunkIDs, parsed)
+	}
+
+	if err := json.Unmarshal([]byte(violations), &q.Violations); err != nil {
+		return q, fmt.Errorf("failed to decode violations: %v", err)
+	}
+
+	return q, nil
+}
+
+// nullableString converts empty strings to NULL
+func nullableString(value string) sql.NullString {
+	return sql.NullString{String: value, Valid: value != ""}
+}
+
+// nullableUUID converts a user ID to NULL when it is not a valid UUID
+// This keeps anonymous or malformed IDs from breaking the foreign key to users
+func nullableUUID(value string) sql.NullString {
+	if _, err := uuid.Parse(value); err != nil {
+		return sql.NullString{}
+	}
+	return sql.NullString{String: value, Valid: true}
+}
+
+// uuidStrings converts UUIDs to strings so they can be sent as a Postgres UUID[]
+func uuidStrings(ids []uuid.UUID) []string {
+	result := make([]string, len(ids))
+	for i, id := range ids {
+		result[i] = id.String()
+	}
+	return result
+}
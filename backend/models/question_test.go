@@ -0,0 +1,62 @@
+package models
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBuildQuestionQuery(t *testing.T) {
+	from := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name          string
+		filter        QuestionFilter
+		expectedWhere string
+		expectedArgs  []any
+	}{
+		{
+			name:         "No filters uses default pagination",
+			filter:       QuestionFilter{},
+			expectedArgs: []any{defaultQuestionLimit, 0},
+		},
+		{
+			name:          "User filter",
+			filter:        QuestionFilter{UserID: "user-1", Limit: 10, Offset: 20},
+			expectedWhere: "WHERE user_id = $1",
+			expectedArgs:  []any{"user-1", 10, 20},
+		},
+		{
+			name:          "Multiple filters are combined in order",
+			filter:        QuestionFilter{Provider: "Ollama", Status: QuestionStatusBlocked, From: &from},
+			expectedWhere: "WHERE provider = $1 AND status = $2 AND asked_at >= $3",
+			expectedArgs:  []any{"Ollama", QuestionStatusBlocked, from, defaultQuestionLimit, 0},
+		},
+		{
+			name:         "Limit is capped",
+			filter:       QuestionFilter{Limit: 10000, Offset: -5},
+			expectedArgs: []any{maxQuestionLimit, 0},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			query, args := buildQuestionQuery(tt.filter)
+
+			if tt.expectedWhere != "" {
+				assert.Contains(t, query, tt.expectedWhere)
+			} else {
+				assert.NotContains(t, query, "WHERE")
+			}
+			assert.Contains(t, query, "ORDER BY asked_at DESC")
+			assert.Equal(t, tt.expectedArgs, args)
+		})
+	}
+}
+
+func TestQuestionPageLimit(t *testing.T) {
+	assert.Equal(t, defaultQuestionLimit, QuestionPageLimit(0))
+	assert.Equal(t, 20, QuestionPageLimit(20))
+	assert.Equal(t, maxQuestionLimit, QuestionPageLimit(10000))
+}
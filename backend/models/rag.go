@@ -1,20 +1,59 @@
 package models
 
 import (
+	"context"
 	"fmt"
 	"regexp"
 	"strconv"
-	"strings"
 
 	"github.com/MauricioAliendre182/backend/utils"
+	"github.com/google/uuid"
 )
 
 // RAGService handles Retrieval-Augmented Generation using the factory pattern
+// Structured enables the structured answer mode: the model answers in JSON with its sources,
+// a confidence and whether the documents contain the answer at all
+// Guardrails is the policy deciding what happens to chunks flagged for prompt injection, nil uses the active policy
+// Scope is the documents the user asking can read, only their chunks are sent as context
 type RAGService struct {
 	chatService utils.ChatService
+	Guardrails  *utils.GuardrailConfig
+	Scope       DocumentScope
 	MaxChunks   int
+	Structured  bool
 }
 
+// RAGAnswer is the result of a RAG query
+// Besides the answer itself it carries the metadata recorded in the questions table
+// Structured is only set in structured mode
+// Grounding is set when the answer was checked against the chunks sent as context
+type RAGAnswer struct {
+	Structured      *StructuredRAGAnswer   `json:"structured,omitempty"`
+	Grounding       *utils.GroundingReport `json:"grounding,omitempty"`
+	Answer          string                 `json:"answer"`
+	Provider        string                 `json:"provider"`
+	Model           string                 `json:"model"`
+	TemplateName    string                 `json:"template_name"`
+	ChunkIDs        []uuid.UUID            `json:"chunk_ids"`
+	TemplateVersion int                    `json:"template_version"`
+}
+
+// StructuredRAGAnswer is the structured answer returned to the client
+// CitedChunkIDs are the chunks the model based its answer on, a subset of the chunks sent as context
+// Answerable is false when the documents do not contain the answer
+type StructuredRAGAnswer struct {
+	Answer        string      `json:"answer"`
+	CitedChunkIDs []uuid.UUID `json:"cited_chunk_ids"`
+	Confidence    float64     `json:"confidence"`
+	Answerable    bool        `json:"answerable"`
+}
+
+// noRelevantInformationAnswer is returned when no chunk can be sent to the model
+const noRelevantInformationAnswer = "I couldn't find any relevant information in the documents to answer your question."
+
+// ungroundedAnswer replaces an answer whose claims are mostly not supported by the documents
+const ungroundedAnswer = "I couldn't verify an answer to your question against the documents, so I can't answer it reliably."
+
 // NewRAGService creates a new RAG service using the factory pattern
 func NewRAGService() (*RAGService, error) {
 	factory := utils.NewAIServiceFactory(utils.AppConfig)
@@ -46,13 +85,16 @@ func NewRAGService() (*RAGService, error) {
 // QueryDocuments performs RAG query on document using the factory pattern
 // It retrieves relevant chunks based on the question embedding and generates a response using the chat service
 // This method encapsulates the logic for querying documents and generating responses
-func (r *RAGService) QueryDocuments(question string) (string, error) {
+// ctx is the request context: embedding, retrieval and generation each get their own stage deadline under it
+func (r *RAGService) QueryDocuments(ctx context.Context, question string) (*RAGAnswer, error) {
 	utils.LogInfo("Starting RAG query", "question", question)
 
 	// Step 1: Get embedding for the question
-	questionEmbedding, err := utils.GetEmbedding(question)
+	embeddingCtx, cancelEmbedding := utils.WithStageTimeout(ctx, utils.EmbeddingStage)
+	questionEmbedding, err := utils.GetEmbedding(embeddingCtx, question)
+	cancelEmbedding()
 	if err != nil {
-		return "", fmt.Errorf("failed to get question embedding: %v", err)
+		return nil, fmt.Errorf("failed to get question embedding: %w", err)
 	}
 
 	// Clean the embedding to remove any non-float data (timestamps, extra text, etc.)
@@ -63,48 +105,250 @@ func (r *RAGService) QueryDocuments(question string) (string, error) {
 	// Step 2: Find relevant chunks using similarity search
 	// This function should be implemented to perform a similarity search
 	// It retrieves the most relevant chunks based on the question embedding
-	relevantChunks, err := SimilaritySearch(cleanedEmbedding, r.MaxChunks)
+	// Chunks flagged for prompt injection at ingestion are left out, or neutralized below, as the policy says
+	guardrails := r.Guardrails
+	if guardrails == nil {
+		guardrails = utils.ActiveGuardrailConfig()
+	}
+	filter := ChunkSearchFilter{Scope: r.Scope, ExcludeFlagged: guardrails.FlaggedChunks == utils.FlaggedChunksExclude}
+
+	retrievalCtx, cancelRetrieval := utils.WithStageTimeout(ctx, utils.RetrievalStage)
+	relevantChunks, err := SimilaritySearch(retrievalCtx, cleanedEmbedding, r.MaxChunks, filter)
+	cancelRetrieval()
 	if err != nil {
 		utils.LogError("Similarity search failed", err)
-		return "", fmt.Errorf("failed to find relevant chunks: %v", err)
+		return nil, fmt.Errorf("failed to find relevant chunks: %w", err)
 	}
 
 	utils.LogInfo("Similarity search completed", "chunks_found", len(relevantChunks), "max_chunks", r.MaxChunks)
 
+	result := &RAGAnswer{
+		Provider: r.chatService.GetProviderName(),
+		Model:    r.chatService.GetModel(),
+	}
+
 	if len(relevantChunks) == 0 {
 		utils.LogWarn("No relevant chunks found for question", "question", question)
-		return "I couldn't find any relevant information in the documents to answer your question.", nil
+		r.abstain(result)
+		return result, nil
+	}
+
+	// Step 3: Build context from relevant chunks within the chat model's token budget
+	// The budget is the context window minus the prompt (instructions + question) and the answer reservation
+	// The prompt comes from the active rag_answer template so admins can change it without a deploy
+	promptTemplate, err := GetActivePromptTemplate(utils.RAGAnswerTemplate)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load prompt template: %v", err)
+	}
+	result.TemplateName = promptTemplate.Name
+	result.TemplateVersion = promptTemplate.Version
+
+	contextHeader := "Based on the following information from the documents:\n\n"
+	emptyPrompt, err := promptTemplate.Render(utils.PromptData{Question: question, Context: contextHeader})
+	if err != nil {
+		return nil, fmt.Errorf("failed to render prompt template: %v", err)
+	}
+	if r.Structured {
+		// The JSON instructions take room in the prompt too
+		emptyPrompt = utils.StructuredAnswerPrompt(emptyPrompt)
 	}
 
-	// Step 3: Build context from relevant chunks
-	var contextBuilder strings.Builder
-	contextBuilder.WriteString("Based on the following information from the documents:\n\n")
+	// The tokens are counted with the tokenizer of the model the window belongs to
+	countTokens := utils.NewChatTokenCounter(utils.ChatContextModel(utils.AppConfig))
+	promptTokens := countTokens(emptyPrompt.System) + countTokens(emptyPrompt.User)
+	budget := utils.ContextTokenBudget(utils.ChatContextWindow(utils.AppConfig), promptTokens, utils.AnswerTokenLimit(utils.AppConfig))
 
+	// A flagged chunk is sent with its instructions removed and wrapped as untrusted data,
+	// the wrapped text is what the budget, the model and the grounding check see
+	chunkContents := make([]string, len(relevantChunks))
 	for i, chunk := range relevantChunks {
-		utils.LogInfo("Adding chunk to context", "chunk_index", i, "content_length", len(chunk.Content), "document_id", chunk.DocumentID.String())
-		contextBuilder.WriteString(fmt.Sprintf("Document %d:\n%s\n\n", i+1, chunk.Content))
+		chunkContents[i] = chunk.Content
+		if chunk.InjectionFlagged {
+			chunkContents[i] = utils.NeutralizeFlaggedChunk(guardrails, chunk.Content)
+			utils.LogWarn("Neutralizing flagged chunk", "chunk_id", chunk.ID.String(), "document_id", chunk.DocumentID.String(), "reasons", chunk.InjectionReasons)
+		}
+	}
+
+	packer := utils.ContextPacker{
+		MaxTokens:   budget,
+		CountTokens: countTokens,
+		Format: func(position int, content string) string {
+			return fmt.Sprintf("Document %d:\n%s\n\n", position+1, content)
+		},
+	}
+	packed := packer.Pack(chunkContents)
+
+	sources := make([]string, 0, len(packed.Included))
+	for _, index := range packed.Included {
+		chunk := relevantChunks[index]
+		result.ChunkIDs = append(result.ChunkIDs, chunk.ID)
+		sources = append(sources, chunkContents[index])
+		utils.LogInfo("Adding chunk to context", "chunk_index", index, "content_length", len(chunk.Content), "document_id", chunk.DocumentID.String())
+	}
+
+	utils.LogInfo("Context assembled",
+		"budget_tokens", budget,
+		"context_tokens", packed.Tokens,
+		"prompt_tokens", promptTokens,
+		"chunks_included", len(packed.Included),
+		"chunks_retrieved", len(relevantChunks),
+		"truncated", packed.Truncated,
+	)
+
+	if len(packed.Included) == 0 {
+		utils.LogWarn("No chunk fits in the context budget", "budget_tokens", budget, "prompt_tokens", promptTokens)
+		r.abstain(result)
+		return result, nil
 	}
 
 	// Step 4: Generate response using the configured AI service with guardrails
-	utils.LogInfo("Generating AI response", "context_length", contextBuilder.Len())
-	contextText := contextBuilder.String()
+	contextText := contextHeader + packed.Text
 
-	// Count tokens in the context text
-	// This helps in understanding the context size and ensuring it fits within the model's limits
-	tokens, err := utils.CountTokens(contextText, utils.AppConfig.EmbeddingModel)
+	// Render the template that includes the guardrail instructions
+	prompt, err := promptTemplate.Render(utils.PromptData{Question: question, Context: contextText})
 	if err != nil {
-		utils.LogError("Failed to count tokens", err)
+		return nil, fmt.Errorf("failed to render prompt template: %v", err)
+	}
+	if r.Structured {
+		prompt = utils.StructuredAnswerPrompt(prompt)
+	}
+	utils.LogInfo("Rendered prompt",
+		"template", promptTemplate.Name,
+		"template_version", promptTemplate.Version,
+		"system_length", len(prompt.System),
+		"prompt_length", len(prompt.User),
+	)
+
+	// The system part carries the instructions and context, the user part the question
+	// With a fallback chain the answer is recorded with the provider that actually produced it
+	chatCtx, cancelChat := utils.WithStageTimeout(ctx, utils.ChatStage)
+	defer cancelChat()
+
+	if r.Structured {
+		if err := r.generateStructured(chatCtx, prompt, result); err != nil {
+			return nil, err
+		}
 	} else {
-		utils.LogInfo("Context token count", "tokens", tokens)
+		answer, responder, err := r.generate(chatCtx, prompt)
+		if err != nil {
+			return nil, err
+		}
+
+		result.Provider = responder.GetProviderName()
+		result.Model = responder.GetModel()
+		result.Answer = answer
+	}
+
+	// Step 5: Verify the answer against the chunks it should be based on
+	r.checkGrounding(ctx, result, sources)
+	return result, nil
+}
+
+// checkGrounding verifies the claims of the answer against the chunks sent as context
+// Unsupported claims are reported in result.Grounding, and when the share of supported claims is below
+// GROUNDING_MIN_SUPPORT the answer is replaced by an abstention
+func (r *RAGService) checkGrounding(ctx context.Context, result *RAGAnswer, sources []string) {
+	config := utils.AppConfig
+	if config == nil || !config.GroundingCheckEnabled {
+		return
+	}
+
+	// Abstentions make no claim about the documents
+	if (result.Structured != nil && !result.Structured.Answerable) || utils.IsAbstention(result.Answer) {
+		return
+	}
+
+	// The optional judge is a chat call, it gets its own chat stage deadline
+	judgeCtx, cancelJudge := utils.WithStageTimeout(ctx, utils.ChatStage)
+	defer cancelJudge()
+
+	report := utils.NewGroundingChecker(config, r.chatService).Check(judgeCtx, result.Answer, sources)
+	result.Grounding = &report
+
+	if len(report.Claims) == 0 || report.SupportRatio >= config.GroundingMinSupport {
+		return
+	}
+
+	utils.LogWarn("Answer not grounded in the documents, abstaining",
+		"claims", len(report.Claims),
+		"supported_claims", report.SupportedClaims,
+		"min_support", config.GroundingMinSupport)
+
+	result.Grounding.Abstained = true
+	result.Answer = ungroundedAnswer
+	if result.Structured != nil {
+		result.Structured.Answer = ungroundedAnswer
+		result.Structured.Answerable = false
+		result.Structured.Confidence = 0
+		result.Structured.CitedChunkIDs = []uuid.UUID{}
+	}
+}
+
+// generate sends a prompt to the chat service and returns the answer with the service that produced it
+// With a fallback chain the responder is the provider that actually answered
+func (r *RAGService) generate(ctx context.Context, prompt utils.RenderedPrompt) (string, utils.ChatService, error) {
+	if routed, ok := r.chatService.(utils.RoutedChatService); ok {
+		return routed.GenerateRoutedResponse(ctx, prompt.User, prompt.System)
+	}
+
+	answer, err := r.chatService.GenerateResponse(ctx, prompt.User, prompt.System)
+	return answer, r.chatService, err
+}
+
+// generateStructured asks for a structured answer and validates it
+// A malformed reply is retried once with the validation error, a second one fails with ErrMalformedStructuredAnswer
+// The cited document numbers are mapped back to the chunks that were sent as context (result.ChunkIDs)
+func (r *RAGService) generateStructured(ctx context.Context, prompt utils.RenderedPrompt, result *RAGAnswer) error {
+	sourceCount := len(result.ChunkIDs)
+
+	reply, responder, err := r.generate(ctx, prompt)
+	if err != nil {
+		return err
 	}
 
-	// Create a safe prompt that includes guardrails
-	safePrompt := utils.CreateSafePrompt(question, contextText)
-	utils.LogInfo("Created safe prompt", "prompt_length", len(safePrompt))
+	structured, parseErr := utils.ParseStructuredAnswer(reply, sourceCount)
+	if parseErr != nil {
+		utils.LogWarn("Malformed structured answer, retrying", "provider", responder.GetProviderName(), "error", parseErr)
 
-	// Use the safe prompt as the question parameter and empty context
-	// The context is already included in the safe prompt
-	return r.chatService.GenerateResponse(safePrompt, "")
+		reply, responder, err = r.generate(ctx, utils.StructuredAnswerRepairPrompt(prompt, reply, parseErr))
+		if err != nil {
+			return err
+		}
+
+		structured, parseErr = utils.ParseStructuredAnswer(reply, sourceCount)
+		if parseErr != nil {
+			utils.LogError("Malformed structured answer after retry", parseErr, "provider", responder.GetProviderName())
+			return fmt.Errorf("%w: %v", utils.ErrMalformedStructuredAnswer, parseErr)
+		}
+	}
+
+	result.Provider = responder.GetProviderName()
+	result.Model = responder.GetModel()
+	result.Answer = structured.Answer
+	result.Structured = &StructuredRAGAnswer{
+		Answer:        structured.Answer,
+		CitedChunkIDs: make([]uuid.UUID, len(structured.Sources)),
+		Confidence:    structured.Confidence,
+		Answerable:    structured.Answerable,
+	}
+	for i, source := range structured.Sources {
+		result.Structured.CitedChunkIDs[i] = result.ChunkIDs[source-1]
+	}
+	return nil
+}
+
+// abstain sets the answer given when no document can be sent to the model
+func (r *RAGService) abstain(result *RAGAnswer) {
+	result.Answer = noRelevantInformationAnswer
+	if r.Structured {
+		structured := utils.UnanswerableStructuredAnswer(noRelevantInformationAnswer)
+		result.Structured = &StructuredRAGAnswer{
+			Answer:        structured.Answer,
+			CitedChunkIDs: []uuid.UUID{},
+			Confidence:    structured.Confidence,
+			Answerable:    structured.Answerable,
+		}
+	}
 }
 
 // cleanEmbeddingVector removes any non-float data from embedding vectors
@@ -1,6 +1,7 @@
 package models
 
 import (
+	"context"
 	"fmt"
 	"testing"
 
@@ -17,8 +18,8 @@ type MockChatService struct {
 
 // GenerateResponse mocks the chat service's response generation
 // It simulates generating a response based on the question and context
-func (m *MockChatService) GenerateResponse(question, context string) (string, error) {
-	args := m.Called(question, context)
+func (m *MockChatService) GenerateResponse(ctx context.Context, question, contextText string) (string, error) {
+	args := m.Called(question, contextText)
 	return args.String(0), args.Error(1)
 }
 
@@ -47,14 +48,14 @@ func getEmbeddingWrapper(text string) (utils.Vector, error) {
 	if mockGetEmbedding != nil {
 		return mockGetEmbedding(text)
 	}
-	return utils.GetEmbedding(text)
+	return utils.GetEmbedding(context.Background(), text)
 }
 
 func similaritySearchWrapper(embedding utils.Vector, limit int) ([]Chunk, error) {
 	if mockSimilaritySearch != nil {
 		return mockSimilaritySearch(embedding, limit)
 	}
-	return SimilaritySearch(embedding, limit)
+	return SimilaritySearch(context.Background(), embedding, limit, ChunkSearchFilter{})
 }
 
 // Helper function to create UUID from string
@@ -182,7 +183,7 @@ func TestRAGService_QueryDocuments(t *testing.T) {
 						contextText += fmt.Sprintf("Document %d:\n%s\n\n", i+1, chunk.Content)
 					}
 
-					response, err := mockChatService.GenerateResponse(tt.question, contextText)
+					response, err := mockChatService.GenerateResponse(context.Background(), tt.question, contextText)
 					assert.NoError(t, err)
 					assert.Equal(t, tt.mockResponse, response)
 
@@ -412,3 +413,93 @@ func TestNewRAGService(t *testing.T) {
 	// Restore original config
 	utils.AppConfig = originalConfig
 }
+
+// TestRAGService_GenerateStructured tests the structured answer mode and its single retry
+func TestRAGService_GenerateStructured(t *testing.T) {
+	chunkIDs := []uuid.UUID{uuid.New(), uuid.New()}
+	prompt := utils.StructuredAnswerPrompt(utils.RenderedPrompt{System: "Document 1:\nA.\n\nDocument 2:\nB.", User: "QUESTION: B?"})
+	valid := `{"answer": "B", "sources": [2], "confidence": 0.8, "answerable": true}`
+
+	t.Run("Valid reply", func(t *testing.T) {
+		chat := new(MockChatService)
+		chat.On("GenerateResponse", prompt.User, prompt.System).Return(valid, nil).Once()
+		chat.On("GetProviderName").Return("Mock")
+		chat.On("GetModel").Return("mock-model")
+
+		result := &RAGAnswer{ChunkIDs: chunkIDs}
+		err := (&RAGService{chatService: chat, Structured: true}).generateStructured(context.Background(), prompt, result)
+		assert.NoError(t, err)
+		assert.Equal(t, "B", result.Answer)
+		assert.Equal(t, &StructuredRAGAnswer{Answer: "B", CitedChunkIDs: []uuid.UUID{chunkIDs[1]}, Confidence: 0.8, Answerable: true}, result.Structured)
+		chat.AssertExpectations(t)
+	})
+
+	t.Run("Malformed reply is retried once", func(t *testing.T) {
+		chat := new(MockChatService)
+		chat.On("GenerateResponse", prompt.User, prompt.System).Return("B, from document 2", nil).Once()
+		chat.On("GenerateResponse", mock.MatchedBy(func(user string) bool { return user != prompt.User }), prompt.System).Return(valid, nil).Once()
+		chat.On("GetProviderName").Return("Mock")
+		chat.On("GetModel").Return("mock-model")
+
+		result := &RAGAnswer{ChunkIDs: chunkIDs}
+		err := (&RAGService{chatService: chat, Structured: true}).generateStructured(context.Background(), prompt, result)
+		assert.NoError(t, err)
+		assert.True(t, result.Structured.Answerable)
+		chat.AssertNumberOfCalls(t, "GenerateResponse", 2)
+	})
+
+	t.Run("Malformed reply after retry", func(t *testing.T) {
+		chat := new(MockChatService)
+		chat.On("GenerateResponse", mock.Anything, prompt.System).Return("still not JSON", nil).Twice()
+		chat.On("GetProviderName").Return("Mock")
+
+		result := &RAGAnswer{ChunkIDs: chunkIDs}
+		err := (&RAGService{chatService: chat, Structured: true}).generateStructured(context.Background(), prompt, result)
+		assert.ErrorIs(t, err, utils.ErrMalformedStructuredAnswer)
+		assert.Nil(t, result.Structured)
+		chat.AssertNumberOfCalls(t, "GenerateResponse", 2)
+	})
+}
+
+// TestRAGService_AbstainStructured tests the answer given when no chunk can be sent to the model
+func TestRAGService_AbstainStructured(t *testing.T) {
+	result := &RAGAnswer{}
+	(&RAGService{Structured: true}).abstain(result)
+	assert.Equal(t, noRelevantInformationAnswer, result.Answer)
+	assert.False(t, result.Structured.Answerable)
+	assert.Empty(t, result.Structured.CitedChunkIDs)
+
+	result = &RAGAnswer{}
+	(&RAGService{}).abstain(result)
+	assert.Nil(t, result.Structured)
+}
+
+// TestRAGService_CheckGrounding tests the abstention on answers the documents do not support
+func TestRAGService_CheckGrounding(t *testing.T) {
+	previous := utils.AppConfig
+	defer func() { utils.AppConfig = previous }()
+	utils.AppConfig = &utils.Config{GroundingCheckEnabled: true, GroundingMinSupport: 0.5}
+
+	sources := []string{"Employees get 15 vacation days per year."}
+	service := &RAGService{Structured: true}
+
+	result := &RAGAnswer{
+		Answer:     "Employees get 30 vacation days per year. Managers approve remote work requests weekly.",
+		Structured: &StructuredRAGAnswer{Answerable: true, Confidence: 0.9, CitedChunkIDs: []uuid.UUID{uuid.New()}},
+	}
+	service.checkGrounding(context.Background(), result, sources)
+	assert.True(t, result.Grounding.Abstained)
+	assert.Equal(t, ungroundedAnswer, result.Answer)
+	assert.False(t, result.Structured.Answerable)
+	assert.Empty(t, result.Structured.CitedChunkIDs)
+
+	result = &RAGAnswer{Answer: "Employees get 15 vacation days per year."}
+	(&RAGService{}).checkGrounding(context.Background(), result, sources)
+	assert.False(t, result.Grounding.Abstained)
+	assert.Equal(t, 1.0, result.Grounding.SupportRatio)
+
+	// Abstentions are not checked
+	result = &RAGAnswer{Answer: noRelevantInformationAnswer}
+	(&RAGService{}).checkGrounding(context.Background(), result, sources)
+	assert.Nil(t, result.Grounding)
+}
@@ -0,0 +1,308 @@
+package models
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"regexp"
+	"slices"
+	"time"
+
+	"github.com/MauricioAliendre182/backend/db"
+	"github.com/MauricioAliendre182/backend/utils"
+	"github.com/lib/pq"
+)
+
+// ErrUnknownRole is returned when a user is given a role that does not exist
+var ErrUnknownRole = errors.New("unknown role")
+
+// roleNamePattern restricts role names to lowercase identifiers (e.g. "auditor", "hr-editor")
+var roleNamePattern = regexp.MustCompile(`^[a-z][a-z0-9_-]{1,49}$`)
+
+// Role is a role in the roles table with the permissions it grants
+// BuiltIn roles are seeded at startup and cannot be changed
+type Role struct {
+	CreatedAt   time.Time `json:"created_at"`
+	Name        string    `json:"name"`
+	Description string    `json:"description"`
+	Permissions []string  `json:"permissions"`
+	BuiltIn     bool      `json:"built_in"`
+}
+
+// EnsureBuiltinRoles creates the permissions and the built-in roles, or brings them back to their definition
+// It runs at startup, so a release adding a permission grants it to the built-in roles that should have it
+// It also fails when DEFAULT_USER_ROLE is not a role, otherwise users without a role would silently get no permission
+func EnsureBuiltinRoles() error {
+	return utils.WithTransaction(func(tx *sql.Tx) error {
+		for _, permission := range utils.Permissions {
+			_, err := tx.Exec(`
+			INSERT INTO permissions (name, description) VALUES ($1, $2)
+			ON CONFLICT (name) DO UPDATE SET description = EXCLUDED.description
+			`, permission.Name, permission.Description)
+			if err != nil {
+				return fmt.Errorf("failed to create permission %s: %v", permission.Name, err)
+			}
+		}
+
+		for _, role := range utils.BuiltinRoles {
+			_, err := tx.Exec(`
+			INSERT INTO roles (name, description) VALUES ($1, $2)
+			ON CONFLICT (name) DO UPDATE SET description = EXCLUDED.description
+			`, role.Name, role.Description)
+			if err != nil {
+				return fmt.Errorf("failed to create role %s: %v", role.Name, err)
+			}
+			if err := replaceRolePermissions(tx, role.Name, role.Permissions); err != nil {
+				return err
+			}
+		}
+
+		var defaultRoleExists bool
+		defaultRole := defaultUserRole()
+		err := tx.QueryRow(`SELECT EXISTS (SELECT 1 FROM roles WHERE name = $1)`, defaultRole).Scan(&defaultRoleExists)
+		if err != nil {
+			return fmt.Errorf("failed to check the default role: %v", err)
+		}
+		if !defaultRoleExists {
+			return fmt.Errorf("DEFAULT_USER_ROLE %q: %w", defaultRole, ErrUnknownRole)
+		}
+		return nil
+	})
+}
+
+// defaultUserRole returns the role of the users without any role (DEFAULT_USER_ROLE, viewer by default)
+func defaultUserRole() string {
+	if utils.AppConfig != nil && utils.AppConfig.DefaultUserRole != "" {
+		return utils.AppConfig.DefaultUserRole
+	}
+	return utils.RoleViewer
+}
+
+// replaceRolePermissions sets the permissions of a role within a transaction
+func replaceRolePermissions(tx *sql.Tx, role string, permissions []string) error {
+	_, err := tx.Exec(`DELETE FROM role_permissions WHERE role = $1`, role)
+	if err != nil {
+		return fmt.Errorf("failed to clear the permissions of role %s: %v", role, err)
+	}
+
+	stmt, err := tx.Prepare(`INSERT INTO role_permissions (role, permission) VALUES ($1, $2)`)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	for _, permission := range permissions {
+		if _, err := stmt.Exec(role, permission); err != nil {
+			return fmt.Errorf("failed to grant %s to role %s: %v", permission, role, err)
+		}
+	}
+	return nil
+}
+
+// GetRoles returns every role with its permissions, sorted by name
+func GetRoles() ([]Role, error) {
+	query := `
+	SELECT r.name, r.description, r.created_at,
+		   COALESCE(array_agg(rp.permission ORDER BY rp.permission) FILTER (WHERE rp.permission IS NOT NULL), '{}')
+	FROM roles r
+	LEFT JOIN role_permissions rp ON rp.role = r.name
+	GROUP BY r.name, r.description, r.created_at
+	ORDER BY r.name
+	`
+
+	stmt, err := db.DB.Prepare(query)
+	if err != nil {
+		return nil, err
+	}
+	defer stmt.Close()
+
+	rows, err := stmt.Query()
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	roles := []Role{}
+	for rows.Next() {
+		var role Role
+		if err := rows.Scan(&role.Name, &role.Description, &role.CreatedAt, pq.Array(&role.Permissions)); err != nil {
+			return nil, err
+		}
+		role.BuiltIn = utils.IsBuiltinRole(role.Name)
+		roles = append(roles, role)
+	}
+	return roles, rows.Err()
+}
+
+// Validate checks a custom role before it is saved
+// The permissions must exist, duplicates are removed
+func (r *Role) Validate() error {
+	if !roleNamePattern.MatchString(r.Name) {
+		return errors.New("role name must be 2 to 50 lowercase letters, digits, - or _, starting with a letter")
+	}
+	if utils.IsBuiltinRole(r.Name) {
+		return fmt.Errorf("role %s is built-in and cannot be changed", r.Name)
+	}
+	if len(r.Permissions) == 0 {
+		return errors.New("a role must grant at least one permission")
+	}
+
+	permissions := make([]string, 0, len(r.Permissions))
+	for _, permission := range r.Permissions {
+		if !utils.IsPermission(permission) {
+			return fmt.Errorf("unknown permission %q", permission)
+		}
+		if !slices.Contains(permissions, permission) {
+			permissions = append(permissions, permission)
+		}
+	}
+	r.Permissions = permissions
+	return nil
+}
+
+// Save creates a custom role or replaces its description and permissions
+func (r *Role) Save() error {
+	if err := r.Validate(); err != nil {
+		return err
+	}
+
+	return utils.WithTransaction(func(tx *sql.Tx) error {
+		err := tx.QueryRow(`
+		INSERT INTO roles (name, description) VALUES ($1, $2)
+		ON CONFLICT (name) DO UPDATE SET description = EXCLUDED.description
+		RETURNING created_at
+		`, r.Name, r.Description).Scan(&r.CreatedAt)
+		if err != nil {
+			return fmt.Errorf("failed to save role %s: %v", r.Name, err)
+		}
+		return replaceRolePermissions(tx, r.Name, r.Permissions)
+	})
+}
+
+// GetUserRoles returns the roles assigned to a user in the database, sorted by name
+func GetUserRoles(userID string) ([]string, error) {
+	roles := []string{}
+	if !nullableUUID(userID).Valid {
+		return roles, nil
+	}
+
+	stmt, err := db.DB.Prepare(`SELECT role FROM user_roles WHERE user_id = $1 ORDER BY role`)
+	if err != nil {
+		return nil, err
+	}
+	defer stmt.Close()
+
+	rows, err := stmt.Query(userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var role string
+		if err := rows.Scan(&role); err != nil {
+			return nil, err
+		}
+		roles = append(roles, role)
+	}
+	return roles, rows.Err()
+}
+
+// SetUserRoles replaces the roles of a user, grantedBy is the admin assigning them
+// An empty list removes every role, the user then gets DEFAULT_USER_ROLE
+// The change applies to the user's next access token, at the latest when it is refreshed
+func SetUserRoles(userID string, roles []string, grantedBy string) error {
+	known, err := GetRoles()
+	if err != nil {
+		return err
+	}
+	for _, role := range roles {
+		if !slices.ContainsFunc(known, func(r Role) bool { return r.Name == role }) {
+			return fmt.Errorf("%w: %s", ErrUnknownRole, role)
+		}
+	}
+
+	return utils.WithTransaction(func(tx *sql.Tx) error {
+		var exists bool
+		if err := tx.QueryRow(`SELECT EXISTS (SELECT 1 FROM users WHERE id = $1)`, userID).Scan(&exists); err != nil {
+			return err
+		}
+		if !exists {
+			return ErrUserNotFound
+		}
+
+		if _, err := tx.Exec(`DELETE FROM user_roles WHERE user_id = $1`, userID); err != nil {
+			return fmt.Errorf("failed to clear the roles of user %s: %v", userID, err)
+		}
+
+		stmt, err := tx.Prepare(`
+		INSERT INTO user_roles (user_id, role, granted_by) VALUES ($1, $2, $3)
+		ON CONFLICT (user_id, role) DO NOTHING
+		`)
+		if err != nil {
+			return err
+		}
+		defer stmt.Close()
+
+		for _, role := range roles {
+			if _, err := stmt.Exec(userID, role, nullableUUID(grantedBy)); err != nil {
+				return fmt.Errorf("failed to grant role %s: %v", role, err)
+			}
+		}
+		return nil
+	})
+}
+
+// ResolveUserAccess returns the roles of a user and the permissions they grant, for the access token
+func ResolveUserAccess(userID, email string) (utils.UserAccess, error) {
+	assigned, err := GetUserRoles(userID)
+	if err != nil {
+		return utils.UserAccess{}, fmt.Errorf("failed to get user roles: %w", err)
+	}
+
+	roles := effectiveRoles(assigned, utils.IsAdminEmail(email), defaultUserRole())
+
+	permissions, err := getRolePermissions(roles)
+	if err != nil {
+		return utils.UserAccess{}, fmt.Errorf("failed to get role permissions: %w", err)
+	}
+	return utils.UserAccess{Roles: roles, Permissions: permissions}, nil
+}
+
+// effectiveRoles completes the roles assigned to a user: admin for the ADMIN_EMAILS,
+// the default role for users without any role
+func effectiveRoles(assigned []string, adminEmail bool, defaultRole string) []string {
+	roles := slices.Clone(assigned)
+	if adminEmail && !slices.Contains(roles, utils.RoleAdmin) {
+		roles = append(roles, utils.RoleAdmin)
+	}
+	if len(roles) == 0 {
+		roles = append(roles, defaultRole)
+	}
+	return roles
+}
+
+// getRolePermissions returns the permissions granted by any of the roles, sorted by name
+func getRolePermissions(roles []string) ([]string, error) {
+	stmt, err := db.DB.Prepare(`SELECT DISTINCT permission FROM role_permissions WHERE role = ANY($1) ORDER BY permission`)
+	if err != nil {
+		return nil, err
+	}
+	defer stmt.Close()
+
+	rows, err := stmt.Query(pq.Array(roles))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	permissions := []string{}
+	for rows.Next() {
+		var permission string
+		if err := rows.Scan(&permission); err != nil {
+			return nil, err
+		}
+		permissions = append(permissions, permission)
+	}
+	return permissions, rows.Err()
+}
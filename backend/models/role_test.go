@@ -0,0 +1,67 @@
+package models
+
+import (
+	"testing"
+
+	"github.com/MauricioAliendre182/backend/utils"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidateRole(t *testing.T) {
+	tests := []struct {
+		name        string
+		role        Role
+		expected    []string
+		expectError bool
+	}{
+		{
+			name:     "Custom role",
+			role:     Role{Name: "auditor", Permissions: []string{utils.PermissionDocumentsRead, utils.PermissionAdminAccess}},
+			expected: []string{utils.PermissionDocumentsRead, utils.PermissionAdminAccess},
+		},
+		{
+			name:     "Duplicate permissions are removed",
+			role:     Role{Name: "hr-editor", Permissions: []string{utils.PermissionDocumentsUpload, utils.PermissionDocumentsUpload}},
+			expected: []string{utils.PermissionDocumentsUpload},
+		},
+		{
+			name:        "Built-in role",
+			role:        Role{Name: utils.RoleViewer, Permissions: []string{utils.PermissionDocumentsRead}},
+			expectError: true,
+		},
+		{
+			name:        "Invalid name",
+			role:        Role{Name: "Team Leads", Permissions: []string{utils.PermissionDocumentsRead}},
+			expectError: true,
+		},
+		{
+			name:        "Unknown permission",
+			role:        Role{Name: "auditor", Permissions: []string{"documents:write"}},
+			expectError: true,
+		},
+		{
+			name:        "No permissions",
+			role:        Role{Name: "auditor"},
+			expectError: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.role.Validate()
+			if tt.expectError {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.expected, tt.role.Permissions)
+		})
+	}
+}
+
+func TestEffectiveRoles(t *testing.T) {
+	assert.Equal(t, []string{utils.RoleViewer}, effectiveRoles(nil, false, utils.RoleViewer), "users without roles get the default role")
+	assert.Equal(t, []string{utils.RoleContributor}, effectiveRoles([]string{utils.RoleContributor}, false, utils.RoleViewer))
+	assert.Equal(t, []string{utils.RoleAdmin}, effectiveRoles(nil, true, utils.RoleViewer), "ADMIN_EMAILS get the admin role")
+	assert.Equal(t, []string{utils.RoleAdmin}, effectiveRoles([]string{utils.RoleAdmin}, true, utils.RoleViewer))
+}
@@ -1,6 +1,7 @@
 package models
 
 import (
+	"context"
 	"database/sql"
 	"errors"
 	"fmt"
@@ -12,33 +13,52 @@ import (
 	"github.com/MauricioAliendre182/backend/db"
 	"github.com/MauricioAliendre182/backend/utils"
 	"github.com/google/uuid"
+	"github.com/lib/pq"
 )
 
 // Document represents a document in the documents table
+// OwnerID is the user who uploaded it, the documents stored without a user (evaluation runs) have none
+// Evaluation marks the contexts stored by an evaluation run, only the scope of a run reaches them
 type Document struct {
-	UploadedAt       time.Time `json:"uploaded_at"`
-	Name             string    `json:"name"`
-	OriginalFilename string    `json:"original_filename"`
-	ID               uuid.UUID `json:"id"`
+	UploadedAt       time.Time     `json:"uploaded_at"`
+	Name             string        `json:"name"`
+	OriginalFilename string        `json:"original_filename"`
+	OwnerID          uuid.NullUUID `json:"owner_id"`
+	ID               uuid.UUID     `json:"id"`
+	Evaluation       bool          `json:"-"`
 }
 
 // Chunk represents a chunk in the chunks table
+// InjectionFlagged is set at ingestion when the content contains instructions addressed to the model,
+// InjectionReasons are the rules that matched (see utils.ScanChunkForInjection)
 type Chunk struct {
-	ContentType string       `json:"content_type"`
-	Content     string       `json:"content"`
-	Embedding   utils.Vector `json:"embedding"`
-	Size        int64        `json:"size"`
-	ChunkIndex  int          `json:"chunk_index"`
-	ID          uuid.UUID    `json:"id"`
-	DocumentID  uuid.UUID    `json:"document_id"`
+	ContentType      string       `json:"content_type"`
+	Content          string       `json:"content"`
+	Embedding        utils.Vector `json:"embedding"`
+	InjectionReasons []string     `json:"injection_reasons,omitempty"`
+	Size             int64        `json:"size"`
+	ChunkIndex       int          `json:"chunk_index"`
+	ID               uuid.UUID    `json:"id"`
+	DocumentID       uuid.UUID    `json:"document_id"`
+	InjectionFlagged bool         `json:"injection_flagged"`
+}
+
+// ChunkSearchFilter restricts the chunks a similarity search can return
+// ExcludeFlagged leaves out the chunks flagged for prompt injection at ingestion
+// Scope is the documents the chunks may come from, the zero scope only reaches the documents shared with everyone
+type ChunkSearchFilter struct {
+	Scope          DocumentScope
+	ExcludeFlagged bool
 }
 
 // DocumentResponse for API responses
 type DocumentResponse struct {
-	UploadedAt       time.Time `json:"uploaded_at"`
-	Name             string    `json:"name"`
-	OriginalFilename string    `json:"original_filename"`
-	ID               uuid.UUID `json:"id"`
+	UploadedAt       time.Time     `json:"uploaded_at"`
+	Name             string        `json:"name"`
+	OriginalFilename string        `json:"original_filename"`
+	OwnerID          uuid.NullUUID `json:"owner_id"`
+	ID               uuid.UUID     `json:"id"`
+	Evaluation       bool          `json:"-"`
 }
 
 // ReadFromUpload reads the uploaded file and populates the Document struct
@@ -63,8 +83,8 @@ func (d *Document) generateFileName() string {
 // Save saves the document to the database
 func (d *Document) Save() error {
 	query := `
-	INSERT INTO documents (id, name, original_filename, uploaded_at)
-	VALUES ($1, $2, $3, $4)
+	INSERT INTO documents (id, name, original_filename, uploaded_at, owner_id, evaluation)
+	VALUES ($1, $2, $3, $4, $5, $6)
 	RETURNING id
 	`
 
@@ -86,7 +106,7 @@ func (d *Document) Save() error {
 	// Set the uploaded at time to the current time
 	// This is the time when the document was uploaded
 	d.UploadedAt = time.Now()
-	err = stmt.QueryRow(d.ID, d.Name, d.OriginalFilename, d.UploadedAt).Scan(&d.ID)
+	err = stmt.QueryRow(d.ID, d.Name, d.OriginalFilename, d.UploadedAt, d.OwnerID, d.Evaluation).Scan(&d.ID)
 	if err != nil {
 		return err
 	}
@@ -98,8 +118,8 @@ func (d *Document) Save() error {
 // a transaction allows for atomic operations, ensuring that either all changes are committed or none are applied
 func (d *Document) SaveWithTx(tx *sql.Tx) error {
 	query := `
-	INSERT INTO documents (id, name, original_filename, uploaded_at)
-	VALUES ($1, $2, $3, $4)
+	INSERT INTO documents (id, name, original_filename, uploaded_at, owner_id, evaluation)
+	VALUES ($1, $2, $3, $4, $5, $6)
 	RETURNING id
 	`
 
@@ -118,7 +138,7 @@ func (d *Document) SaveWithTx(tx *sql.Tx) error {
 	}
 	defer stmt.Close()
 
-	err = stmt.QueryRow(d.ID, d.Name, d.OriginalFilename, d.UploadedAt).Scan(&d.ID)
+	err = stmt.QueryRow(d.ID, d.Name, d.OriginalFilename, d.UploadedAt, d.OwnerID, d.Evaluation).Scan(&d.ID)
 	if err != nil {
 		return err
 	}
@@ -126,11 +146,12 @@ func (d *Document) SaveWithTx(tx *sql.Tx) error {
 	return nil
 }
 
-// GetDocumentByID retrieves a document by ID
+// GetDocumentByID retrieves a document by ID, whoever can read it (admin reports)
+// The routes of the users use GetReadableDocument
 func GetDocumentByID(id uuid.UUID) (Document, error) {
 	var doc Document
 	query := `
-	SELECT id, name, original_filename, uploaded_at
+	SELECT id, name, original_filename, uploaded_at, owner_id
 	FROM documents
 	WHERE id = $1
 	`
@@ -141,7 +162,7 @@ func GetDocumentByID(id uuid.UUID) (Document, error) {
 	}
 	defer stmt.Close()
 
-	err = stmt.QueryRow(id).Scan(&doc.ID, &doc.Name, &doc.OriginalFilename, &doc.UploadedAt)
+	err = stmt.QueryRow(id).Scan(&doc.ID, &doc.Name, &doc.OriginalFilename, &doc.UploadedAt, &doc.OwnerID)
 	if err != nil {
 		return doc, err
 	}
@@ -149,12 +170,14 @@ func GetDocumentByID(id uuid.UUID) (Document, error) {
 	return doc, nil
 }
 
-// GetAllDocuments retrieves all documents from the database
-func GetAllDocuments() ([]Document, error) {
+// GetAllDocuments retrieves the documents the scope can read
+func GetAllDocuments(scope DocumentScope) ([]Document, error) {
 	var documents []Document
+	condition, args := scope.readableCondition("id", 1)
 	query := `
-	SELECT id, name, original_filename, uploaded_at
+	SELECT id, name, original_filename, uploaded_at, owner_id
 	FROM documents
+	WHERE ` + condition + `
 	ORDER BY uploaded_at DESC
 	`
 
@@ -164,7 +187,7 @@ func GetAllDocuments() ([]Document, error) {
 	}
 	defer stmt.Close()
 
-	rows, err := stmt.Query()
+	rows, err := stmt.Query(args...)
 	if err != nil {
 		return documents, err
 	}
@@ -172,7 +195,7 @@ func GetAllDocuments() ([]Document, error) {
 
 	for rows.Next() {
 		var doc Document
-		err = rows.Scan(&doc.ID, &doc.Name, &doc.OriginalFilename, &doc.UploadedAt)
+		err = rows.Scan(&doc.ID, &doc.Name, &doc.OriginalFilename, &doc.UploadedAt, &doc.OwnerID)
 		if err != nil {
 			return documents, err
 		}
@@ -202,6 +225,19 @@ func DeleteDocument(documentID uuid.UUID) error {
 	return nil
 }
 
+// DeleteDocumentWithTx removes a document within a transaction, its chunks and shares go with it
+// The caller updates the corpus statistics in the same transaction
+func DeleteDocumentWithTx(tx *sql.Tx, documentID uuid.UUID) error {
+	stmt, err := tx.Prepare(`DELETE FROM documents WHERE id = $1`)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	_, err = stmt.Exec(documentID)
+	return err
+}
+
 // ValidateDocument validates the document before saving
 func (d *Document) ValidateDocument() error {
 	// Check if file name is not empty
@@ -225,8 +261,8 @@ func (c *Chunk) Save() error {
 	}
 
 	query := `
-	INSERT INTO chunks (id, document_id, size, content_type, content, embedding, chunk_index)
-	VALUES ($1, $2, $3, $4, $5, $6, $7)
+	INSERT INTO chunks (id, document_id, size, content_type, content, embedding, chunk_index, injection_flagged, injection_reasons)
+	VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
 	RETURNING id
 	`
 
@@ -245,7 +281,8 @@ func (c *Chunk) Save() error {
 	if c.ID == uuid.Nil {
 		c.ID = uuid.New()
 	}
-	err = stmt.QueryRow(c.ID, c.DocumentID, c.Size, c.ContentType, c.Content, c.Embedding, c.ChunkIndex).Scan(&c.ID)
+	err = stmt.QueryRow(c.ID, c.DocumentID, c.Size, c.ContentType, c.Content, c.Embedding, c.ChunkIndex,
+		c.InjectionFlagged, pq.Array(c.InjectionReasons)).Scan(&c.ID)
 	if err != nil {
 		return err
 	}
@@ -264,8 +301,8 @@ func (c *Chunk) SaveWithTx(tx *sql.Tx) error {
 	}
 
 	query := `
-	INSERT INTO chunks (id, document_id, size, content_type, content, embedding, chunk_index)
-	VALUES ($1, $2, $3, $4, $5, $6, $7)
+	INSERT INTO chunks (id, document_id, size, content_type, content, embedding, chunk_index, injection_flagged, injection_reasons)
+	VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
 	RETURNING id
 	`
 
@@ -284,7 +321,8 @@ func (c *Chunk) SaveWithTx(tx *sql.Tx) error {
 	}
 	defer stmt.Close()
 
-	err = stmt.QueryRow(c.ID, c.DocumentID, c.Size, c.ContentType, c.Content, c.Embedding, c.ChunkIndex).Scan(&c.ID)
+	err = stmt.QueryRow(c.ID, c.DocumentID, c.Size, c.ContentType, c.Content, c.Embedding, c.ChunkIndex,
+		c.InjectionFlagged, pq.Array(c.InjectionReasons)).Scan(&c.ID)
 	if err != nil {
 		return err
 	}
@@ -293,12 +331,14 @@ func (c *Chunk) SaveWithTx(tx *sql.Tx) error {
 }
 
 // GetChunksByDocumentID retrieves all chunks for a specific document
-func GetChunksByDocumentID(documentID uuid.UUID) ([]Chunk, error) {
+// A document the scope cannot read has no chunks
+func GetChunksByDocumentID(documentID uuid.UUID, scope DocumentScope) ([]Chunk, error) {
 	var chunks []Chunk
+	condition, args := scope.readableCondition("document_id", 2)
 	query := `
-	SELECT id, document_id, size, content_type, content, embedding, chunk_index
+	SELECT id, document_id, size, content_type, content, embedding, chunk_index, injection_flagged, injection_reasons
 	FROM chunks
-	WHERE document_id = $1
+	WHERE document_id = $1 AND ` + condition + `
 	ORDER BY chunk_index
 	`
 
@@ -308,7 +348,7 @@ func GetChunksByDocumentID(documentID uuid.UUID) ([]Chunk, error) {
 	}
 	defer stmt.Close()
 
-	rows, err := stmt.Query(documentID)
+	rows, err := stmt.Query(append([]any{documentID}, args...)...)
 	if err != nil {
 		return chunks, err
 	}
@@ -316,7 +356,8 @@ func GetChunksByDocumentID(documentID uuid.UUID) ([]Chunk, error) {
 
 	for rows.Next() {
 		var chunk Chunk
-		err = rows.Scan(&chunk.ID, &chunk.DocumentID, &chunk.Size, &chunk.ContentType, &chunk.Content, &chunk.Embedding, &chunk.ChunkIndex)
+		err = rows.Scan(&chunk.ID, &chunk.DocumentID, &chunk.Size, &chunk.ContentType, &chunk.Content, &chunk.Embedding, &chunk.ChunkIndex,
+			&chunk.InjectionFlagged, pq.Array(&chunk.InjectionReasons))
 		if err != nil {
 			return chunks, err
 		}
@@ -330,7 +371,7 @@ func GetChunksByDocumentID(documentID uuid.UUID) ([]Chunk, error) {
 func GetChunkByID(id uuid.UUID) (Chunk, error) {
 	var chunk Chunk
 	query := `
-	SELECT id, document_id, size, content_type, content, embedding, chunk_index
+	SELECT id, document_id, size, content_type, content, embedding, chunk_index, injection_flagged, injection_reasons
 	FROM chunks
 	WHERE id = $1
 	`
@@ -341,7 +382,8 @@ func GetChunkByID(id uuid.UUID) (Chunk, error) {
 	}
 	defer stmt.Close()
 
-	err = stmt.QueryRow(id).Scan(&chunk.ID, &chunk.DocumentID, &chunk.Size, &chunk.ContentType, &chunk.Content, &chunk.Embedding, &chunk.ChunkIndex)
+	err = stmt.QueryRow(id).Scan(&chunk.ID, &chunk.DocumentID, &chunk.Size, &chunk.ContentType, &chunk.Content, &chunk.Embedding, &chunk.ChunkIndex,
+		&chunk.InjectionFlagged, pq.Array(&chunk.InjectionReasons))
 	if err != nil {
 		return chunk, err
 	}
@@ -390,22 +432,29 @@ func (c *Chunk) ValidateChunk() error {
 // ProcessFileToChunks processes an uploaded file and creates chunks
 // *multipart.FileHeader is used to handle file uploads in web applications
 // It contains metadata about the uploaded file, such as its name, size, and content type
-func ProcessFileToChunks(fileHeader *multipart.FileHeader, documentID uuid.UUID, chunkSize int64) ([]Chunk, error) {
+// ctx bounds the embedding calls, the whole processing is also limited by the ingestion stage deadline
+// Every chunk is scanned with the document checks of the guardrail policy and flagged when it
+// contains instructions addressed to the model, guardrails is the policy (nil uses the active one)
+// With pii.redact_documents the personal data is redacted before chunking, the redactions are returned per type
+func ProcessFileToChunks(ctx context.Context, fileHeader *multipart.FileHeader, documentID uuid.UUID, chunkSize int64, guardrails *utils.GuardrailConfig) ([]Chunk, utils.PIICounts, error) {
 	// Validate inputs
 	if fileHeader == nil {
-		return nil, fmt.Errorf("fileHeader cannot be nil")
+		return nil, nil, fmt.Errorf("fileHeader cannot be nil")
 	}
 	if documentID == uuid.Nil {
-		return nil, fmt.Errorf("documentID cannot be nil")
+		return nil, nil, fmt.Errorf("documentID cannot be nil")
 	}
 	if chunkSize <= 0 {
-		return nil, fmt.Errorf("chunkSize must be positive")
+		return nil, nil, fmt.Errorf("chunkSize must be positive")
+	}
+	if guardrails == nil {
+		guardrails = utils.ActiveGuardrailConfig()
 	}
 
 	// Check file size to prevent memory issues
 	maxFileSize := int64(50 * 1024 * 1024) // 50MB limit
 	if fileHeader.Size > maxFileSize {
-		return nil, fmt.Errorf("file size %d exceeds maximum allowed size of %d bytes", fileHeader.Size, maxFileSize)
+		return nil, nil, fmt.Errorf("file size %d exceeds maximum allowed size of %d bytes", fileHeader.Size, maxFileSize)
 	}
 
 	// Open and read the file
@@ -413,7 +462,7 @@ func ProcessFileToChunks(fileHeader *multipart.FileHeader, documentID uuid.UUID,
 	// fileHeader.Open() returns an io.ReadCloser, which we can use to read the file content
 	opened, err := fileHeader.Open()
 	if err != nil {
-		return nil, fmt.Errorf("failed to open file: %w", err)
+		return nil, nil, fmt.Errorf("failed to open file: %w", err)
 	}
 	defer opened.Close()
 
@@ -421,7 +470,7 @@ func ProcessFileToChunks(fileHeader *multipart.FileHeader, documentID uuid.UUID,
 	// This is suitable for small files. For larger files, consider streaming or processing in chunks
 	contentBytes, err := io.ReadAll(opened)
 	if err != nil {
-		return nil, fmt.Errorf("failed to read file: %w", err)
+		return nil, nil, fmt.Errorf("failed to read file: %w", err)
 	}
 
 	// Convert to string and sanitize UTF-8 to prevent database encoding errors
@@ -432,7 +481,7 @@ func ProcessFileToChunks(fileHeader *multipart.FileHeader, documentID uuid.UUID,
 		// Extract text from PDF using proper PDF parsing
 		extractedText, err := utils.ExtractTextFromPDFBytes(contentBytes)
 		if err != nil {
-			return nil, fmt.Errorf("failed to extract text from PDF: %w", err)
+			return nil, nil, fmt.Errorf("failed to extract text from PDF: %w", err)
 		}
 		content = utils.SanitizeUTF8(extractedText)
 	} else {
@@ -441,6 +490,32 @@ func ProcessFileToChunks(fileHeader *multipart.FileHeader, documentID uuid.UUID,
 	}
 	contentType := fileHeader.Header.Get("Content-Type")
 
+	return ProcessTextToChunks(ctx, content, contentType, documentID, chunkSize, guardrails)
+}
+
+// ProcessTextToChunks splits the text of a document into embedded chunks
+// It is the part of ProcessFileToChunks that runs once the file is read, for documents that are not uploaded
+// (e.g. the contexts of the evaluation data sets), the chunks are scanned and redacted the same way
+func ProcessTextToChunks(ctx context.Context, content, contentType string, documentID uuid.UUID, chunkSize int64, guardrails *utils.GuardrailConfig) ([]Chunk, utils.PIICounts, error) {
+	if documentID == uuid.Nil {
+		return nil, nil, fmt.Errorf("documentID cannot be nil")
+	}
+	if chunkSize <= 0 {
+		return nil, nil, fmt.Errorf("chunkSize must be positive")
+	}
+	if guardrails == nil {
+		guardrails = utils.ActiveGuardrailConfig()
+	}
+
+	// Personal data is removed before chunking so it is neither embedded nor sent as context
+	redactions := utils.PIICounts{}
+	if guardrails.PII.RedactDocuments {
+		content, redactions = utils.RedactPII(content, guardrails.PII.Types)
+		if redactions.Total() > 0 {
+			utils.LogInfo("Personal data redacted from document", "document_id", documentID.String(), "redactions", redactions.Total())
+		}
+	}
+
 	var chunksList []Chunk
 
 	// Split content into chunks
@@ -453,13 +528,17 @@ func ProcessFileToChunks(fileHeader *multipart.FileHeader, documentID uuid.UUID,
 	var chunkTexts []string
 	chunkTexts = append(chunkTexts, chunks...)
 
-	embeddings, err := utils.GetBatchEmbeddings(chunkTexts)
+	ingestionCtx, cancel := utils.WithStageTimeout(ctx, utils.IngestionStage)
+	defer cancel()
+
+	embeddings, err := utils.GetBatchEmbeddings(ingestionCtx, chunkTexts)
 	if err != nil {
-		return nil, fmt.Errorf("failed to get embeddings: %v", err)
+		return nil, nil, fmt.Errorf("failed to get embeddings: %w", err)
 	}
 
 	// For each chunk, create a Chunk struct and append it to the chunksList
 	// Each chunk will have a unique ID, the document ID it belongs to, its size
+	flagged := 0
 	for i, chunkText := range chunks {
 		// Sanitize chunk text to ensure valid UTF-8
 		sanitizedChunk := utils.SanitizeUTF8(chunkText)
@@ -474,9 +553,24 @@ func ProcessFileToChunks(fileHeader *multipart.FileHeader, documentID uuid.UUID,
 			ChunkIndex:  i,
 		}
 
+		scan := utils.ScanChunkForInjection(ctx, guardrails, sanitizedChunk)
+		if scan.Flagged {
+			chunk.InjectionFlagged = true
+			chunk.InjectionReasons = scan.Reasons
+			flagged++
+			utils.LogWarn("Chunk flagged for prompt injection",
+				"document_id", documentID.String(),
+				"chunk_index", i,
+				"reasons", scan.Reasons)
+		}
+
 		chunksList = append(chunksList, chunk)
 	}
-	return chunksList, nil
+
+	if flagged > 0 {
+		utils.LogWarn("Document contains flagged chunks", "document_id", documentID.String(), "flagged_chunks", flagged, "total_chunks", len(chunksList))
+	}
+	return chunksList, redactions, nil
 }
 
 // SimilaritySearch performs vector similarity search to find relevant chunks
@@ -484,35 +578,66 @@ func ProcessFileToChunks(fileHeader *multipart.FileHeader, documentID uuid.UUID,
 // It takes a query embedding and returns the most similar chunks
 // The queryEmbedding is a Vector, which is a slice of float32 values representing the embedding vector
 // The limit parameter specifies the maximum number of results to return
-func SimilaritySearch(queryEmbedding utils.Vector, limit int) ([]Chunk, error) {
+// The query runs under ctx, so a cancelled request or an expired deadline stops it in the database
+// filter restricts the candidate chunks, the excluded chunks do not take a place in the limit
+// The access condition of the scope is part of the query, so the chunks of documents the user cannot read
+// are never returned
+// The ivfflat index applies the condition after its scan, so the search runs with vectorSearchSettings,
+// otherwise a user reaching few documents would get fewer chunks than the limit
+func SimilaritySearch(ctx context.Context, queryEmbedding utils.Vector, limit int, filter ChunkSearchFilter) ([]Chunk, error) {
 	var chunks []Chunk
 
-	utils.LogInfo("Starting similarity search", "embedding_length", len(queryEmbedding), "limit", limit)
+	// The settings only last for the transaction of the search
+	tx, err := db.DB.BeginTx(ctx, &sql.TxOptions{ReadOnly: true})
+	if err != nil {
+		utils.LogError("Failed to start similarity search transaction", err)
+		return chunks, err
+	}
+	defer tx.Rollback()
+
+	if db.HasPgVector() {
+		for _, setting := range vectorSearchSettings(db.PgVectorVersion()) {
+			if _, err := tx.ExecContext(ctx, setting); err != nil {
+				utils.LogError("Failed to configure similarity search", err, "setting", setting)
+				return chunks, err
+			}
+		}
+	}
+
+	utils.LogInfo("Starting similarity search", "embedding_length", len(queryEmbedding), "limit", limit,
+		"exclude_flagged", filter.ExcludeFlagged, "all_documents", filter.Scope.All)
+
+	condition, scopeArgs := filter.Scope.readableCondition("document_id", 3)
+	where := "WHERE " + condition
+	if filter.ExcludeFlagged {
+		where += " AND NOT injection_flagged"
+	}
 
 	// This query retrieves chunks ordered by their similarity to the query embedding
-	// The <=> operator is used for vector similarity search in pgvector
-	// It returns the closest chunks based on the embedding distance
+	// The <=> operator is the pgvector cosine distance (0 = identical)
+	// Ordering by ascending distance returns the closest chunks first, so results are in rank order
 	query := `
-	SELECT id, document_id, size, content_type, content, embedding, chunk_index,
+	SELECT id, document_id, size, content_type, content, embedding, chunk_index, injection_flagged, injection_reasons,
 		   (embedding <=> $1) as distance
 	FROM chunks
-	ORDER BY distance DESC
+	` + where + `
+	ORDER BY distance ASC
 	-- LIMIT $2 limits the number of results returned
 	LIMIT $2
 	`
 
 	// Prepare the SQL statement
 	// Using a prepared statement to prevent SQL injection
-	stmt, err := db.DB.Prepare(query)
+	stmt, err := tx.PrepareContext(ctx, query)
 	if err != nil {
 		utils.LogError("Failed to prepare similarity search query", err)
 		return chunks, err
 	}
 	defer stmt.Close()
 
-	// Query() executes the statement with the provided queryEmbedding and limit
+	// QueryContext() executes the statement with the provided queryEmbedding and limit
 	// It returns a *sql.Rows, which we can iterate over to get the results
-	rows, err := stmt.Query(queryEmbedding, limit)
+	rows, err := stmt.QueryContext(ctx, append([]any{queryEmbedding, limit}, scopeArgs...)...)
 	if err != nil {
 		utils.LogError("Failed to execute similarity search query", err)
 		return chunks, err
@@ -529,7 +654,7 @@ func SimilaritySearch(queryEmbedding utils.Vector, limit int) ([]Chunk, error) {
 		// distance is also scanned to get the similarity score
 		// unpack the values into the chunk struct
 		err = rows.Scan(&chunk.ID, &chunk.DocumentID, &chunk.Size, &chunk.ContentType,
-			&chunk.Content, &chunk.Embedding, &chunk.ChunkIndex, &distance)
+			&chunk.Content, &chunk.Embedding, &chunk.ChunkIndex, &chunk.InjectionFlagged, pq.Array(&chunk.InjectionReasons), &distance)
 		if err != nil {
 			utils.LogError("Failed to scan chunk row", err)
 			return chunks, err
@@ -542,19 +667,43 @@ func SimilaritySearch(queryEmbedding utils.Vector, limit int) ([]Chunk, error) {
 		}())
 		chunks = append(chunks, chunk)
 	}
+	if err := rows.Err(); err != nil {
+		utils.LogError("Failed to read similarity search results", err)
+		return chunks, err
+	}
 
 	utils.LogInfo("Similarity search completed", "total_chunks_found", len(chunks))
 	return chunks, nil
 }
 
+// vectorSearchSettings returns the SET LOCAL statements of a similarity search for a pgvector version
+// Every search has a condition (the document scope), which the ivfflat index applies after scanning
+// the closest list (ivfflat.probes = 1), so most candidates could be filtered out
+// pgvector 0.8 keeps scanning lists until the limit is filled (iterative index scans, exact order),
+// older versions probe every list, which is exact but slower
+func vectorSearchSettings(pgVectorVersion string) []string {
+	var major, minor int
+	if _, err := fmt.Sscanf(pgVectorVersion, "%d.%d", &major, &minor); err == nil && (major > 0 || minor >= 8) {
+		return []string{"SET LOCAL ivfflat.iterative_scan = strict_order"}
+	}
+	return []string{fmt.Sprintf("SET LOCAL ivfflat.probes = %d", db.VectorIndexLists)}
+}
+
 // GetRelevantChunks finds chunks relevant to a query using embeddings
-func GetRelevantChunks(queryText string, limit int) ([]Chunk, error) {
+// The embedding and the search each run under their own stage deadline
+func GetRelevantChunks(ctx context.Context, queryText string, limit int, filter ChunkSearchFilter) ([]Chunk, error) {
 	// Get embedding for the query text
-	embedding, err := utils.GetEmbedding(queryText)
+	embeddingCtx, cancelEmbedding := utils.WithStageTimeout(ctx, utils.EmbeddingStage)
+	defer cancelEmbedding()
+
+	embedding, err := utils.GetEmbedding(embeddingCtx, queryText)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get embedding: %v", err)
 	}
 
 	// Perform similarity search using the embedding
-	return SimilaritySearch(embedding, limit)
+	retrievalCtx, cancelRetrieval := utils.WithStageTimeout(ctx, utils.RetrievalStage)
+	defer cancelRetrieval()
+
+	return SimilaritySearch(retrievalCtx, embedding, limit, filter)
 }
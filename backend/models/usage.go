@@ -0,0 +1,485 @@
+package models
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/MauricioAliendre182/backend/db"
+	"github.com/MauricioAliendre182/backend/utils"
+	"github.com/google/uuid"
+)
+
+// Quota scopes and periods stored in the usage_quotas table
+const (
+	QuotaScopeUser = "user"
+	QuotaScopeTeam = "team"
+
+	QuotaPeriodDaily   = "daily"
+	QuotaPeriodMonthly = "monthly"
+)
+
+// Groupings of the admin usage report
+const (
+	UsageGroupByModel = "model"
+	UsageGroupByUser  = "user"
+	UsageGroupByTeam  = "team"
+)
+
+// UsageQuota limits the tokens a user or a team can use per day or month
+// Subject is the user ID for user quotas and the team name for team quotas
+type UsageQuota struct {
+	UpdatedAt  time.Time `json:"updated_at"`
+	Scope      string    `json:"scope"`
+	Subject    string    `json:"subject"`
+	Period     string    `json:"period"`
+	UpdatedBy  string    `json:"updated_by,omitempty"`
+	TokenLimit int64     `json:"token_limit"`
+	ID         uuid.UUID `json:"id"`
+}
+
+// QuotaStatus is the consumption of one quota in its current period
+type QuotaStatus struct {
+	PeriodStart time.Time `json:"period_start"`
+	ResetsAt    time.Time `json:"resets_at"`
+	Scope       string    `json:"scope"`
+	Subject     string    `json:"subject"`
+	Period      string    `json:"period"`
+	Limit       int64     `json:"limit"`
+	Used        int64     `json:"used"`
+	Remaining   int64     `json:"remaining"`
+}
+
+// Exceeded reports whether the quota leaves no tokens for another request
+func (s QuotaStatus) Exceeded() bool {
+	return s.Used >= s.Limit
+}
+
+// QuotaExceededError is returned by CheckQuota when a quota of the user or their team is exhausted
+type QuotaExceededError struct {
+	Status QuotaStatus
+}
+
+// Error describes the exhausted quota in terms the user can act on
+func (e *QuotaExceededError) Error() string {
+	owner := "your"
+	if e.Status.Scope == QuotaScopeTeam {
+		owner = fmt.Sprintf("team %q", e.Status.Subject)
+	}
+	return fmt.Sprintf("%s %s token quota is exhausted (%d of %d tokens used), it resets at %s",
+		owner, e.Status.Period, e.Status.Used, e.Status.Limit, e.Status.ResetsAt.Format(time.RFC3339))
+}
+
+// ModelUsage is the usage of one model, with its estimated cost in USD
+type ModelUsage struct {
+	Operation        string  `json:"operation"`
+	Provider         string  `json:"provider"`
+	Model            string  `json:"model"`
+	Requests         int64   `json:"requests"`
+	PromptTokens     int64   `json:"prompt_tokens"`
+	CompletionTokens int64   `json:"completion_tokens"`
+	TotalTokens      int64   `json:"total_tokens"`
+	EstimatedCost    float64 `json:"estimated_cost_usd"`
+	Estimated        bool    `json:"estimated"`
+}
+
+// UserUsage is the usage of one user over a time range and the state of the quotas that apply to them
+type UserUsage struct {
+	From             time.Time     `json:"from"`
+	To               time.Time     `json:"to"`
+	Team             string        `json:"team,omitempty"`
+	Models           []ModelUsage  `json:"models"`
+	Quotas           []QuotaStatus `json:"quotas"`
+	PromptTokens     int64         `json:"prompt_tokens"`
+	CompletionTokens int64         `json:"completion_tokens"`
+	TotalTokens      int64         `json:"total_tokens"`
+	EstimatedCost    float64       `json:"estimated_cost_usd"`
+}
+
+// UsageReportRow is the usage of one model by one group (a user, a team, or everyone)
+type UsageReportRow struct {
+	Group     string `json:"group,omitempty"`
+	GroupName string `json:"group_name,omitempty"`
+	ModelUsage
+}
+
+// UsageReport is the admin usage report over a time range
+type UsageReport struct {
+	From          time.Time        `json:"from"`
+	To            time.Time        `json:"to"`
+	GroupBy       string           `json:"group_by"`
+	Rows          []UsageReportRow `json:"rows"`
+	TotalTokens   int64            `json:"total_tokens"`
+	EstimatedCost float64          `json:"estimated_cost_usd"`
+}
+
+// ErrUsageQuotaNotFound is returned when a quota ID does not exist
+var ErrUsageQuotaNotFound = errors.New("usage quota not found")
+
+// ErrUserNotFound is returned when a user ID does not exist
+var ErrUserNotFound = errors.New("user not found")
+
+// ValidateQuota checks the scope, subject, period and limit of a quota
+// The user ID of a user quota is stored in its canonical form, the one usage is recorded with,
+// so an upper-case or braced ID still matches the user's usage
+func (q *UsageQuota) ValidateQuota() error {
+	switch q.Scope {
+	case QuotaScopeUser:
+		id, err := uuid.Parse(q.Subject)
+		if err != nil {
+			return errors.New("subject of a user quota must be a user ID")
+		}
+		q.Subject = id.String()
+	case QuotaScopeTeam:
+		if q.Subject == "" {
+			return errors.New("subject of a team quota must be a team name")
+		}
+	default:
+		return fmt.Errorf("scope must be %q or %q", QuotaScopeUser, QuotaScopeTeam)
+	}
+
+	if q.Period != QuotaPeriodDaily && q.Period != QuotaPeriodMonthly {
+		return fmt.Errorf("period must be %q or %q", QuotaPeriodDaily, QuotaPeriodMonthly)
+	}
+
+	if q.TokenLimit <= 0 {
+		return errors.New("token_limit must be positive")
+	}
+
+	return nil
+}
+
+// Save creates the quota, or replaces the limit of the existing quota with the same scope, subject and period
+func (q *UsageQuota) Save() error {
+	query := `
+	INSERT INTO usage_quotas (id, scope, subject, period, token_limit, updated_by, updated_at)
+	VALUES ($1, $2, $3, $4, $5, $6, $7)
+	ON CONFLICT (scope, subject, period) DO UPDATE
+	SET token_limit = EXCLUDED.token_limit,
+		updated_by = EXCLUDED.updated_by,
+		updated_at = EXCLUDED.updated_at
+	RETURNING id
+	`
+
+	if q.ID == uuid.Nil {
+		q.ID = uuid.New()
+	}
+	q.UpdatedAt = time.Now()
+
+	stmt, err := db.DB.Prepare(query)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	return stmt.QueryRow(q.ID, q.Scope, q.Subject, q.Period, q.TokenLimit, nullableUUID(q.UpdatedBy), q.UpdatedAt).Scan(&q.ID)
+}
+
+// GetUsageQuotas returns every configured quota
+func GetUsageQuotas() ([]UsageQuota, error) {
+	quotas := []UsageQuota{}
+	query := `
+	SELECT id, scope, subject, period, token_limit, COALESCE(updated_by::text, ''), updated_at
+	FROM usage_quotas
+	ORDER BY scope, subject, period
+	`
+
+	stmt, err := db.DB.Prepare(query)
+	if err != nil {
+		return quotas, err
+	}
+	defer stmt.Close()
+
+	rows, err := stmt.Query()
+	if err != nil {
+		return quotas, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var q UsageQuota
+		if err := rows.Scan(&q.ID, &q.Scope, &q.Subject, &q.Period, &q.TokenLimit, &q.UpdatedBy, &q.UpdatedAt); err != nil {
+			return quotas, err
+		}
+		quotas = append(quotas, q)
+	}
+
+	return quotas, rows.Err()
+}
+
+// DeleteUsageQuota removes a quota
+func DeleteUsageQuota(id uuid.UUID) error {
+	result, err := db.DB.Exec(`DELETE FROM usage_quotas WHERE id = $1`, id)
+	if err != nil {
+		return err
+	}
+
+	deleted, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if deleted == 0 {
+		return ErrUsageQuotaNotFound
+	}
+	return nil
+}
+
+// SetUserTeam assigns a user to a team, an empty team removes them from their team
+// Usage already recorded keeps the team it was recorded with
+func SetUserTeam(userID, team string) error {
+	result, err := db.DB.Exec(`UPDATE users SET team = $2 WHERE id = $1`, userID, nullableString(team))
+	if err != nil {
+		return err
+	}
+
+	updated, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if updated == 0 {
+		return ErrUserNotFound
+	}
+	return nil
+}
+
+// RecordUsage stores the token usage of one request of a user
+// questionID is empty for requests that are not questions (document uploads)
+// The team is read from the user when the usage is recorded
+// A question that could not be saved is not referenced, its tokens are still recorded for the user
+func RecordUsage(userID, questionID string, entries []utils.TokenUsage) error {
+	if len(entries) == 0 {
+		return nil
+	}
+
+	query := `
+	INSERT INTO token_usage (user_id, team, question_id, operation, provider, model, prompt_tokens, completion_tokens, estimated)
+	VALUES ($1, (SELECT team FROM users WHERE id = $1), (SELECT id FROM questions WHERE id = $2), $3, $4, $5, $6, $7, $8)
+	`
+
+	return utils.WithTransaction(func(tx *sql.Tx) error {
+		stmt, err := tx.Prepare(query)
+		if err != nil {
+			return err
+		}
+		defer stmt.Close()
+
+		for _, entry := range entries {
+			_, err := stmt.Exec(nullableUUID(userID), nullableUUID(questionID), entry.Operation, entry.Provider,
+				entry.Model, entry.PromptTokens, entry.CompletionTokens, entry.Estimated)
+			if err != nil {
+				return fmt.Errorf("failed to record %s usage of %s: %v", entry.Operation, entry.Model, err)
+			}
+		}
+		return nil
+	})
+}
+
+// GetQuotaStatuses returns the quotas that apply to a user (their own and their team's)
+// with the tokens used since the start of the current period
+// Periods start at midnight and on the first of the month, in the database time zone
+func GetQuotaStatuses(userID string) ([]QuotaStatus, error) {
+	statuses := []QuotaStatus{}
+	if !nullableUUID(userID).Valid {
+		return statuses, nil
+	}
+
+	// $1 is the user ID as a UUID, $2 the same ID as text to match the subject of user quotas
+	query := `
+	WITH member AS (
+		SELECT team FROM users WHERE id = $1
+	), applicable AS (
+		SELECT q.scope, q.subject, q.period, q.token_limit,
+			CASE q.period WHEN 'daily' THEN date_trunc('day', now()) ELSE date_trunc('month', now()) END AS period_start
+		FROM usage_quotas q
+		WHERE (q.scope = 'user' AND q.subject = $2)
+			OR (q.scope = 'team' AND q.subject = (SELECT team FROM member))
+	)
+	SELECT a.scope, a.subject, a.period, a.token_limit, a.period_start,
+		COALESCE((
+			SELECT SUM(u.prompt_tokens + u.completion_tokens)
+			FROM token_usage u
+			WHERE u.created_at >= a.period_start
+				AND ((a.scope = 'user' AND u.user_id = $1) OR (a.scope = 'team' AND u.team = a.subject))
+		), 0) AS used
+	FROM applicable a
+	ORDER BY a.scope, a.period
+	`
+
+	stmt, err := db.DB.Prepare(query)
+	if err != nil {
+		return statuses, err
+	}
+	defer stmt.Close()
+
+	rows, err := stmt.Query(userID, userID)
+	if err != nil {
+		return statuses, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var s QuotaStatus
+		if err := rows.Scan(&s.Scope, &s.Subject, &s.Period, &s.Limit, &s.PeriodStart, &s.Used); err != nil {
+			return statuses, err
+		}
+		statuses = append(statuses, completeQuotaStatus(s))
+	}
+
+	return statuses, rows.Err()
+}
+
+// completeQuotaStatus fills the remaining tokens and the end of the period of a status
+func completeQuotaStatus(s QuotaStatus) QuotaStatus {
+	s.Remaining = s.Limit - s.Used
+	if s.Remaining < 0 {
+		s.Remaining = 0
+	}
+
+	if s.Period == QuotaPeriodDaily {
+		s.ResetsAt = s.PeriodStart.AddDate(0, 0, 1)
+	} else {
+		s.ResetsAt = s.PeriodStart.AddDate(0, 1, 0)
+	}
+	return s
+}
+
+// CheckQuota returns a QuotaExceededError when a quota of the user or their team is exhausted
+// Requests are checked before they start, so the request that crosses the limit still completes
+func CheckQuota(userID string) error {
+	statuses, err := GetQuotaStatuses(userID)
+	if err != nil {
+		return err
+	}
+
+	for _, status := range statuses {
+		if status.Exceeded() {
+			return &QuotaExceededError{Status: status}
+		}
+	}
+	return nil
+}
+
+// GetUserUsage returns the usage of a user between from and to, per model, and their quota statuses
+func GetUserUsage(userID string, from, to time.Time) (UserUsage, error) {
+	usage := UserUsage{From: from, To: to, Models: []ModelUsage{}}
+
+	err := db.DB.QueryRow(`SELECT COALESCE(team, '') FROM users WHERE id = $1`, userID).Scan(&usage.Team)
+	if errors.Is(err, sql.ErrNoRows) {
+		return usage, ErrUserNotFound
+	}
+	if err != nil {
+		return usage, err
+	}
+
+	query := `
+	SELECT '', '', operation, provider, model, COUNT(*),
+		SUM(prompt_tokens), SUM(completion_tokens), BOOL_OR(estimated)
+	FROM token_usage
+	WHERE user_id = $1 AND created_at >= $2 AND created_at < $3
+	GROUP BY operation, provider, model
+	ORDER BY operation, provider, model
+	`
+
+	rows, err := queryUsageRows(query, userID, from, to)
+	if err != nil {
+		return usage, err
+	}
+
+	for _, row := range rows {
+		usage.Models = append(usage.Models, row.ModelUsage)
+		usage.PromptTokens += row.PromptTokens
+		usage.CompletionTokens += row.CompletionTokens
+		usage.TotalTokens += row.TotalTokens
+		usage.EstimatedCost += row.EstimatedCost
+	}
+
+	usage.Quotas, err = GetQuotaStatuses(userID)
+	return usage, err
+}
+
+// GetUsageReport returns the usage of every model between from and to
+// groupBy splits the rows per user or per team, "model" gives one row per model
+func GetUsageReport(from, to time.Time, groupBy string) (UsageReport, error) {
+	report := UsageReport{From: from, To: to, GroupBy: groupBy, Rows: []UsageReportRow{}}
+
+	var group, groupName, groupColumns string
+	switch groupBy {
+	case UsageGroupByModel:
+		group, groupName = "''", "''"
+	case UsageGroupByUser:
+		group, groupName = "COALESCE(t.user_id::text, '')", "COALESCE(u.email, '')"
+		groupColumns = "t.user_id, u.email, "
+	case UsageGroupByTeam:
+		group, groupName = "COALESCE(t.team, '')", "''"
+		groupColumns = "t.team, "
+	default:
+		return report, fmt.Errorf("group_by must be %q, %q or %q", UsageGroupByModel, UsageGroupByUser, UsageGroupByTeam)
+	}
+
+	// The group expressions come from the switch above, never from the request
+	query := `
+	SELECT ` + group + `, ` + groupName + `, t.operation, t.provider, t.model, COUNT(*),
+		SUM(t.prompt_tokens), SUM(t.completion_tokens), BOOL_OR(t.estimated)
+	FROM token_usage t
+	LEFT JOIN users u ON u.id = t.user_id
+	WHERE t.created_at >= $1 AND t.created_at < $2
+	GROUP BY ` + groupColumns + `t.operation, t.provider, t.model
+	ORDER BY 1, SUM(t.prompt_tokens + t.completion_tokens) DESC
+	`
+
+	rows, err := queryUsageRows(query, from, to)
+	if err != nil {
+		return report, err
+	}
+
+	report.Rows = rows
+	for _, row := range rows {
+		report.TotalTokens += row.TotalTokens
+		report.EstimatedCost += row.EstimatedCost
+	}
+	return report, nil
+}
+
+// queryUsageRows runs an aggregate usage query and prices each row
+// The query must select group, group name, operation, provider, model, request count,
+// prompt tokens, completion tokens and whether any count was estimated
+func queryUsageRows(query string, args ...any) ([]UsageReportRow, error) {
+	usageRows := []UsageReportRow{}
+
+	stmt, err := db.DB.Prepare(query)
+	if err != nil {
+		return usageRows, err
+	}
+	defer stmt.Close()
+
+	rows, err := stmt.Query(args...)
+	if err != nil {
+		return usageRows, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var row UsageReportRow
+		err := rows.Scan(&row.Group, &row.GroupName, &row.Operation, &row.Provider, &row.Model, &row.Requests,
+			&row.PromptTokens, &row.CompletionTokens, &row.Estimated)
+		if err != nil {
+			return usageRows, err
+		}
+		usageRows = append(usageRows, priceModelUsage(row))
+	}
+
+	return usageRows, rows.Err()
+}
+
+// priceModelUsage fills the total tokens and the estimated cost of a usage row
+func priceModelUsage(row UsageReportRow) UsageReportRow {
+	config := utils.AppConfig
+	if config == nil {
+		config = &utils.Config{}
+	}
+
+	row.TotalTokens = row.PromptTokens + row.CompletionTokens
+	row.EstimatedCost = config.EstimateCost(row.Model, row.PromptTokens, row.CompletionTokens)
+	return row
+}
@@ -0,0 +1,91 @@
+package models
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidateQuota(t *testing.T) {
+	tests := []struct {
+		name        string
+		quota       UsageQuota
+		expectError bool
+	}{
+		{
+			name:  "Daily user quota",
+			quota: UsageQuota{Scope: QuotaScopeUser, Subject: uuid.NewString(), Period: QuotaPeriodDaily, TokenLimit: 10000},
+		},
+		{
+			name:  "Monthly team quota",
+			quota: UsageQuota{Scope: QuotaScopeTeam, Subject: "research", Period: QuotaPeriodMonthly, TokenLimit: 1000000},
+		},
+		{
+			name:        "User quota for an invalid user ID",
+			quota:       UsageQuota{Scope: QuotaScopeUser, Subject: "alice", Period: QuotaPeriodDaily, TokenLimit: 10},
+			expectError: true,
+		},
+		{
+			name:        "Unknown scope",
+			quota:       UsageQuota{Scope: "org", Subject: "acme", Period: QuotaPeriodDaily, TokenLimit: 10},
+			expectError: true,
+		},
+		{
+			name:        "Unknown period",
+			quota:       UsageQuota{Scope: QuotaScopeTeam, Subject: "research", Period: "weekly", TokenLimit: 10},
+			expectError: true,
+		},
+		{
+			name:        "Zero limit",
+			quota:       UsageQuota{Scope: QuotaScopeTeam, Subject: "research", Period: QuotaPeriodDaily},
+			expectError: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.quota.ValidateQuota()
+			if tt.expectError {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestValidateQuotaNormalizesUserID(t *testing.T) {
+	id := uuid.New()
+	quota := UsageQuota{Scope: QuotaScopeUser, Subject: "{" + strings.ToUpper(id.String()) + "}", Period: QuotaPeriodDaily, TokenLimit: 10}
+
+	assert.NoError(t, quota.ValidateQuota())
+	assert.Equal(t, id.String(), quota.Subject)
+}
+
+func TestCompleteQuotaStatus(t *testing.T) {
+	start := time.Date(2024, time.January, 31, 0, 0, 0, 0, time.UTC)
+
+	daily := completeQuotaStatus(QuotaStatus{Period: QuotaPeriodDaily, PeriodStart: start, Limit: 100, Used: 40})
+	assert.Equal(t, int64(60), daily.Remaining)
+	assert.Equal(t, time.Date(2024, time.February, 1, 0, 0, 0, 0, time.UTC), daily.ResetsAt)
+	assert.False(t, daily.Exceeded())
+
+	monthStart := time.Date(2024, time.January, 1, 0, 0, 0, 0, time.UTC)
+	monthly := completeQuotaStatus(QuotaStatus{Period: QuotaPeriodMonthly, PeriodStart: monthStart, Limit: 100, Used: 130})
+	assert.Equal(t, int64(0), monthly.Remaining)
+	assert.Equal(t, time.Date(2024, time.February, 1, 0, 0, 0, 0, time.UTC), monthly.ResetsAt)
+	assert.True(t, monthly.Exceeded())
+}
+
+func TestQuotaExceededErrorMessage(t *testing.T) {
+	resetsAt := time.Date(2024, time.February, 1, 0, 0, 0, 0, time.UTC)
+
+	err := &QuotaExceededError{Status: QuotaStatus{Scope: QuotaScopeTeam, Subject: "research", Period: QuotaPeriodMonthly, Limit: 100, Used: 120, ResetsAt: resetsAt}}
+	assert.Equal(t, `team "research" monthly token quota is exhausted (120 of 100 tokens used), it resets at 2024-02-01T00:00:00Z`, err.Error())
+
+	err = &QuotaExceededError{Status: QuotaStatus{Scope: QuotaScopeUser, Period: QuotaPeriodDaily, Limit: 10, Used: 10, ResetsAt: resetsAt}}
+	assert.Contains(t, err.Error(), "your daily token quota is exhausted")
+}
@@ -0,0 +1,116 @@
+package routes
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/MauricioAliendre182/backend/models"
+	"github.com/MauricioAliendre182/backend/utils"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// requireDocumentManager answers 404 when the user cannot read the document and 403 when they can read it
+// but are neither its owner nor allowed to manage every document, it returns whether the request can go on
+func requireDocumentManager(c *gin.Context, documentID uuid.UUID, scope models.DocumentScope) bool {
+	document, err := models.GetReadableDocument(documentID, scope)
+	if errors.Is(err, models.ErrDocumentNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Document not found"})
+		return false
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return false
+	}
+
+	if !scope.CanManage(document) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Only the owner of the document can do this"})
+		return false
+	}
+	return true
+}
+
+// parseUploadShares reads the share form values of an upload ("everyone", "group:<team>", "user:<user ID>")
+// Without any, the document is private to the user uploading it
+func parseUploadShares(c *gin.Context) ([]models.DocumentShare, error) {
+	values := c.PostFormArray("share")
+	shares := make([]models.DocumentShare, 0, len(values))
+	for _, value := range values {
+		share, err := models.ParseDocumentShare(value)
+		if err != nil {
+			return nil, err
+		}
+		shares = append(shares, share)
+	}
+	return shares, nil
+}
+
+// getDocumentShares returns who a document is shared with (owner or documents:all)
+func getDocumentShares(c *gin.Context) {
+	documentID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid document ID"})
+		return
+	}
+
+	if !requireDocumentManager(c, documentID, getDocumentScope(c)) {
+		return
+	}
+
+	shares, err := models.GetDocumentShares(documentID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"document_id": documentID,
+		"shares":      shares,
+	})
+}
+
+// setDocumentShares replaces who a document is shared with (owner or documents:all)
+// An empty list makes the document private to its owner
+func setDocumentShares(c *gin.Context) {
+	type SharesRequest struct {
+		Shares []models.DocumentShare `json:"shares"`
+	}
+
+	documentID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid document ID"})
+		return
+	}
+
+	var req SharesRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if req.Shares == nil {
+		req.Shares = []models.DocumentShare{}
+	}
+	for i := range req.Shares {
+		if err := req.Shares[i].Validate(); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	if !requireDocumentManager(c, documentID, getDocumentScope(c)) {
+		return
+	}
+
+	if err := models.SetDocumentShares(documentID, req.Shares, getUserID(c)); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	utils.LogInfo("Document shares updated", "document_id", documentID.String(), "shares", len(req.Shares), "user_id", getUserID(c))
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":     "Document shares updated successfully",
+		"document_id": documentID,
+		"shares":      req.Shares,
+	})
+}
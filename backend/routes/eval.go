@@ -0,0 +1,115 @@
+package routes
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net/http"
+	"path/filepath"
+
+	"github.com/MauricioAliendre182/backend/eval"
+	"github.com/MauricioAliendre182/backend/middlewares"
+	"github.com/MauricioAliendre182/backend/utils"
+	"github.com/gin-gonic/gin"
+)
+
+// runEvaluation runs evaluation data sets of EVAL_DATA_DIR through the RAG pipeline with the active policy
+// The body is optional: {"datasets": ["basic_rag_tests.csv"], "k": 5}, ?format=junit returns JUnit XML
+// The run stores its contexts as documents for its duration, see eval.RAGPipeline
+func runEvaluation(c *gin.Context) {
+	type EvalRequest struct {
+		Datasets []string `json:"datasets"`
+		K        int      `json:"k"`
+	}
+
+	var req EvalRequest
+	if err := c.ShouldBindJSON(&req); err != nil && !errors.Is(err, io.EOF) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	format := c.DefaultQuery("format", eval.FormatJSON)
+	if err := eval.ValidateFormat(format); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if req.K < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "k must be positive"})
+		return
+	}
+
+	// Only files of the data directory can be run
+	for _, name := range req.Datasets {
+		if name == "" || filepath.Base(name) != name {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid data set name: " + name})
+			return
+		}
+	}
+
+	cases, err := eval.LoadDataSets(utils.AppConfig.EvalDataDir, req.Datasets)
+	if err != nil {
+		utils.LogError("Failed to load evaluation data sets", err, "datasets", req.Datasets)
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// The run can take far longer than the server WriteTimeout, its write deadline grows with the cases
+	middlewares.SetStageWriteDeadline(c, evaluationStages(cases, utils.AppConfig)...)
+
+	policy := utils.ActiveGuardrailPolicy()
+	pipeline, err := eval.NewRAGPipeline(policy.Config)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to initialize RAG service: " + err.Error()})
+		return
+	}
+
+	report, err := eval.Run(c.Request.Context(), pipeline, cases, eval.Options{Guardrails: policy.Config, K: req.K})
+	if err != nil {
+		utils.LogError("Evaluation failed", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	utils.LogInfo("Evaluation completed",
+		"user_id", getUserID(c),
+		"cases", report.Summary.Total,
+		"pass_rate", report.Summary.PassRate,
+		"hit_rate", report.Summary.HitRate,
+		"mrr", report.Summary.MRR)
+
+	if format == eval.FormatJUnit {
+		var buf bytes.Buffer
+		if err := eval.WriteJUnit(&buf, report); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		c.Data(http.StatusOK, "application/xml; charset=utf-8", buf.Bytes())
+		return
+	}
+	c.JSON(http.StatusOK, report)
+}
+
+// evaluationStages returns the stage deadlines an evaluation runs one after the other, see eval.Run:
+// the indexing of every distinct context, the retrieval measured for the RAG cases, then the answer of each case
+// with the grounding judge when GROUNDING_LLM_JUDGE is set; the evaluation runs the guardrails without the classifier
+func evaluationStages(cases []eval.Case, config *utils.Config) []utils.Stage {
+	var stages []utils.Stage
+	contexts := make(map[string]bool)
+	for _, testCase := range cases {
+		if testCase.Context != "" && !contexts[testCase.Context] {
+			contexts[testCase.Context] = true
+			stages = append(stages, utils.IngestionStage)
+		}
+	}
+
+	for _, testCase := range cases {
+		if testCase.Suite == eval.SuiteRAG && testCase.Context != "" {
+			stages = append(stages, utils.EmbeddingStage, utils.RetrievalStage)
+		}
+		stages = append(stages, utils.EmbeddingStage, utils.RetrievalStage, utils.ChatStage)
+		if config != nil && config.GroundingCheckEnabled && config.GroundingLLMJudge {
+			stages = append(stages, utils.ChatStage)
+		}
+	}
+	return stages
+}
@@ -0,0 +1,102 @@
+package routes
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/MauricioAliendre182/backend/models"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// rateAnswer stores a thumbs up/down rating for a stored answer
+// Only the user who asked the question can rate its answer
+func rateAnswer(c *gin.Context) {
+	type FeedbackRequest struct {
+		Comment         string      `json:"comment"`
+		FlaggedChunkIDs []uuid.UUID `json:"flagged_chunk_ids"`
+		Rating          int         `json:"rating" binding:"required"`
+	}
+
+	questionID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid question ID"})
+		return
+	}
+
+	var req FeedbackRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	question, err := models.GetQuestionByID(questionID)
+	if errors.Is(err, sql.ErrNoRows) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Question not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	userID := getUserID(c)
+	if question.UserID != userID {
+		c.JSON(http.StatusForbidden, gin.H{"error": "You can only rate answers to your own questions"})
+		return
+	}
+
+	if question.Status != models.QuestionStatusAnswered {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Only answered questions can be rated"})
+		return
+	}
+
+	feedback := models.AnswerFeedback{
+		QuestionID:      questionID,
+		UserID:          userID,
+		Rating:          req.Rating,
+		Comment:         req.Comment,
+		FlaggedChunkIDs: req.FlaggedChunkIDs,
+	}
+
+	if err := feedback.ValidateFeedback(question); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := feedback.Save(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":  "Feedback saved successfully",
+		"feedback": feedback,
+	})
+}
+
+// getFeedbackReport returns the worst-rated questions and the documents most often cited in bad answers (admin only)
+// Supports ?limit= to bound the size of each section (at most 500 rows)
+func getFeedbackReport(c *gin.Context) {
+	limit := 10
+	if value := c.Query("limit"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": errInvalidQueryParam("limit").Error()})
+			return
+		}
+		limit = parsed
+	}
+
+	report, err := models.GetFeedbackReport(limit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"report": report,
+	})
+}
@@ -0,0 +1,313 @@
+package routes
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/MauricioAliendre182/backend/models"
+	"github.com/MauricioAliendre182/backend/utils"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// getGuardrailViolations lists the guardrail violation audit log, newest first (admin only)
+// Supported filters: user_id, type, severity, check, stage, false_positive (true/false),
+// from and to (RFC3339), limit and offset
+func getGuardrailViolations(c *gin.Context) {
+	limit, offset, err := parsePagination(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	filter := models.GuardrailViolationFilter{
+		UserID:   c.Query("user_id"),
+		Type:     c.Query("type"),
+		Severity: c.Query("severity"),
+		Check:    c.Query("check"),
+		Stage:    c.Query("stage"),
+		Limit:    limit,
+		Offset:   offset,
+	}
+
+	if filter.UserID != "" {
+		if _, err := uuid.Parse(filter.UserID); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": errInvalidQueryParam("user_id").Error()})
+			return
+		}
+	}
+	if value := c.Query("false_positive"); value != "" {
+		falsePositive, err := strconv.ParseBool(value)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": errInvalidQueryParam("false_positive").Error()})
+			return
+		}
+		filter.FalsePositive = &falsePositive
+	}
+	if filter.From, err = parseTimeQuery(c, "from"); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if filter.To, err = parseTimeQuery(c, "to"); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	violations, err := models.GetGuardrailViolations(filter)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"violations": violations,
+		"limit":      models.ViolationPageLimit(limit),
+		"offset":     offset,
+	})
+}
+
+// getGuardrailViolationSummary counts the violations per user, type, check or rule (admin only)
+// Supports ?from= and ?to= (RFC3339, defaults to the current month) and ?group_by=type|user|check|rule
+func getGuardrailViolationSummary(c *gin.Context) {
+	from, to, err := parseReportRange(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	groupBy := c.DefaultQuery("group_by", models.ViolationGroupByType)
+	switch groupBy {
+	case models.ViolationGroupByUser, models.ViolationGroupByType, models.ViolationGroupByCheck, models.ViolationGroupByRule:
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": errInvalidQueryParam("group_by").Error()})
+		return
+	}
+
+	groups, err := models.GetGuardrailViolationSummary(from, to, groupBy)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"from":     from,
+		"to":       to,
+		"group_by": groupBy,
+		"groups":   groups,
+	})
+}
+
+// getGuardrailTuning returns the false positive rate of every rule, with suggestions for the policy (admin only)
+// Supports ?from= and ?to= (RFC3339, defaults to the current month)
+func getGuardrailTuning(c *gin.Context) {
+	from, to, err := parseReportRange(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	rules, err := models.GetGuardrailRuleStats(from, to)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"from":           from,
+		"to":             to,
+		"policy_version": utils.ActiveGuardrailPolicy().Version,
+		"rules":          rules,
+	})
+}
+
+// reviewGuardrailViolation marks a violation as a false positive, or as confirmed (admin only)
+func reviewGuardrailViolation(c *gin.Context) {
+	type ReviewRequest struct {
+		FalsePositive *bool  `json:"false_positive" binding:"required"`
+		Note          string `json:"note"`
+	}
+
+	violationID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid violation ID"})
+		return
+	}
+
+	var req ReviewRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	violation, err := models.ReviewGuardrailViolation(violationID, *req.FalsePositive, req.Note, getUserID(c))
+	if errors.Is(err, models.ErrGuardrailViolationNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Violation not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":   "Violation reviewed successfully",
+		"violation": violation,
+	})
+}
+
+// proposedGuardrailPolicyVersion labels a proposed policy without a version in dry runs
+const proposedGuardrailPolicyVersion = "proposed"
+
+// dryRunGuardrails runs a question, and optionally a candidate answer, through every guardrail check
+// without calling the AI providers, to see why a question is blocked (admin only)
+// With "policy" (the content of a policy file, "policy_format" is yaml or json) the texts are also checked
+// against the proposed policy, and the response lists the settings and check outcomes that differ
+func dryRunGuardrails(c *gin.Context) {
+	type DryRunRequest struct {
+		Question     string `json:"question" binding:"required"`
+		Answer       string `json:"answer"`
+		Policy       string `json:"policy"`
+		PolicyFormat string `json:"policy_format"`
+	}
+
+	var req DryRunRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// The question is sanitized as in /query, so the checks see the same text
+	question := utils.SanitizeQuestion(req.Question)
+	ctx := c.Request.Context()
+
+	current := utils.ActiveGuardrailPolicy()
+	currentRun := utils.DryRunGuardrails(ctx, current.Config, question, req.Answer)
+	currentRun.PolicyVersion = current.Version
+
+	response := gin.H{
+		"question": question,
+		"current":  currentRun,
+	}
+	if req.Policy == "" {
+		c.JSON(http.StatusOK, response)
+		return
+	}
+
+	format := req.PolicyFormat
+	if format == "" {
+		format = "yaml"
+	}
+	proposed, err := utils.ParseGuardrailConfig([]byte(req.Policy), format)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if proposed.Version == "" {
+		proposed.Version = proposedGuardrailPolicyVersion
+	}
+
+	policyChanges, err := utils.DiffGuardrailConfigs(current.Config, proposed)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	proposedRun := utils.DryRunGuardrails(ctx, proposed, question, req.Answer)
+	response["proposed"] = proposedRun
+	response["outcome_changed"] = currentRun.Blocked != proposedRun.Blocked || currentRun.Withheld != proposedRun.Withheld
+	response["check_changes"] = utils.CompareGuardrailDryRuns(currentRun, proposedRun)
+	response["policy_changes"] = policyChanges
+	c.JSON(http.StatusOK, response)
+}
+
+// getFlaggedDocuments lists the documents with chunks flagged for prompt injection at ingestion (admin only)
+func getFlaggedDocuments(c *gin.Context) {
+	documents, err := models.GetFlaggedDocuments()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"documents":      documents,
+		"flagged_chunks": utils.ActiveGuardrailConfig().FlaggedChunks,
+	})
+}
+
+// getFlaggedDocumentChunks returns the flagged chunks of a document, with the rules they matched (admin only)
+func getFlaggedDocumentChunks(c *gin.Context) {
+	documentID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid document ID"})
+		return
+	}
+
+	document, err := models.GetDocumentByID(documentID)
+	if errors.Is(err, sql.ErrNoRows) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Document not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	chunks, err := models.GetFlaggedChunks(documentID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"document": models.DocumentResponse(document),
+		"chunks":   chunks,
+	})
+}
+
+// rescanDocumentChunks scans all the stored chunks again with the active policy (admin only)
+// Run it after changing the document checks or the document regex rules of the policy
+func rescanDocumentChunks(c *gin.Context) {
+	result, err := models.RescanChunksForInjection(c.Request.Context(), utils.ActiveGuardrailConfig())
+	if err != nil {
+		utils.LogError("Failed to rescan chunks", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Chunks rescanned successfully",
+		"result":  result,
+	})
+}
+
+// recordGuardrailViolations stores the violations of a question in the audit log
+// historyRedactions are the personal data values redacted from the question history, one entry per type
+// A failure to record must never fail the query itself, so errors are only logged
+func recordGuardrailViolations(c *gin.Context, question *models.Question, policy *utils.GuardrailConfig, violations []utils.GuardrailViolation, historyRedactions utils.PIICounts) {
+	violations = auditedViolations(policy, violations, historyRedactions)
+	err := models.RecordGuardrailViolations(getUserID(c), question.ID.String(), question.Query, policy.Version, violations)
+	if err != nil {
+		utils.LogError("Failed to record guardrail violations", err, "user_id", getUserID(c), "violations", len(violations))
+	}
+}
+
+// auditedViolations returns the violations of a question as they are stored in the audit log
+// With pii.scrub_history their messages are scrubbed like the question history, the redactions are already
+// counted in historyRedactions, which are added as one pii violation per type
+func auditedViolations(policy *utils.GuardrailConfig, violations []utils.GuardrailViolation, historyRedactions utils.PIICounts) []utils.GuardrailViolation {
+	if policy.PII.ScrubHistory {
+		violations, _ = scrubViolationMessages(policy, violations)
+	}
+	return append(violations, utils.PIIViolations(utils.GuardrailStageHistory, historyRedactions, "redacted")...)
+}
+
+// recordPIIRedactions stores the personal data redactions made outside a question in the audit log
+// subject is what was redacted, the name of the uploaded file for documents
+func recordPIIRedactions(c *gin.Context, subject, policyVersion string, violations []utils.GuardrailViolation) {
+	err := models.RecordGuardrailViolations(getUserID(c), "", subject, policyVersion, violations)
+	if err != nil {
+		utils.LogError("Failed to record personal data redactions", err, "user_id", getUserID(c), "subject", subject)
+	}
+}
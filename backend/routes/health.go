@@ -51,18 +51,34 @@ func healthCheck(c *gin.Context) {
 	// Add additional provider-specific health checks
 	// Use this section to add health checks for specific AI providers
 	// For example, if using Ollama, check if the service is healthy
-	switch currentProvider {
-	case utils.OllamaProvider:
-		if isOllamaHealthy() {
-			health.Services["ollama"] = "healthy"
-		} else {
-			health.Services["ollama"] = "unhealthy"
-			health.Status = "degraded"
+	// With a fallback chain every provider of the chain is reported
+	for _, provider := range factory.ProviderChain() {
+		switch provider {
+		case utils.OllamaProvider:
+			if isOllamaHealthy() {
+				health.Services["ollama"] = "healthy"
+			} else {
+				health.Services["ollama"] = "unhealthy"
+				health.Status = "degraded"
+			}
+		case utils.GeminiProvider:
+			health.Services["gemini"] = "configured"
+		case utils.OpenAIProvider:
+			health.Services["openai"] = "configured"
+		case utils.OpenAICompatibleProvider:
+			health.Services["openai_compatible"] = "configured"
+		case utils.FakeProvider:
+			health.Services["fake"] = "offline"
+		case utils.LexicalProvider:
+			health.Services["lexical"] = "in_process"
+		}
+	}
+
+	// Report providers currently skipped by their circuit breaker
+	for name, state := range utils.GetCircuitBreakerStates() {
+		if state != utils.CircuitClosed {
+			health.Services["circuit:"+name] = string(state)
 		}
-	case utils.GeminiProvider:
-		health.Services["gemini"] = "configured"
-	case utils.OpenAIProvider:
-		health.Services["openai"] = "configured"
 	}
 
 	// Return appropriate status code
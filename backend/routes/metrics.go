@@ -0,0 +1,16 @@
+package routes
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/MauricioAliendre182/backend/utils"
+	"github.com/gin-gonic/gin"
+)
+
+// getMetrics returns the provider counters (retries, errors by kind)
+// Only these counters are served, unlike expvar.Handler() which also exposes the command line and memory statistics
+func getMetrics(c *gin.Context) {
+	body := fmt.Sprintf(`{"provider_retries": %s}`, utils.ProviderRetryMetrics().String())
+	c.Data(http.StatusOK, "application/json; charset=utf-8", []byte(body))
+}
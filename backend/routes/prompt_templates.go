@@ -0,0 +1,105 @@
+package routes
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/MauricioAliendre182/backend/models"
+	"github.com/MauricioAliendre182/backend/utils"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// getPromptTemplates lists the stored prompt template versions and the built-in default (admin only)
+// Supports ?name= to list the versions of a single template
+func getPromptTemplates(c *gin.Context) {
+	name := c.DefaultQuery("name", utils.RAGAnswerTemplate)
+
+	templates, err := models.GetPromptTemplates(name)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	// The built-in template is what answers use while no stored version is active
+	builtin, err := utils.BuiltinPromptTemplate(name)
+	if err != nil {
+		builtin = utils.PromptTemplate{}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"templates": templates,
+		"builtin":   builtin,
+	})
+}
+
+// createPromptTemplate stores a new version of a prompt template (admin only)
+// The template is validated by rendering it, and it is not active until activated
+func createPromptTemplate(c *gin.Context) {
+	type PromptTemplateRequest struct {
+		Name           string `json:"name"`
+		SystemTemplate string `json:"system_template"`
+		UserTemplate   string `json:"user_template" binding:"required"`
+		Description    string `json:"description"`
+	}
+
+	var req PromptTemplateRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if req.Name == "" {
+		req.Name = utils.RAGAnswerTemplate
+	}
+
+	template := models.PromptTemplate{
+		Name:           req.Name,
+		SystemTemplate: req.SystemTemplate,
+		UserTemplate:   req.UserTemplate,
+		Description:    req.Description,
+		CreatedBy:      getUserID(c),
+	}
+
+	// Validation errors are the admin's fault, everything else is a server error
+	if err := utils.ValidatePromptTemplate(template.Template()); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := template.Save(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"message":  "Prompt template created successfully",
+		"template": template,
+	})
+}
+
+// activatePromptTemplate makes a stored template version the one used for answers (admin only)
+func activatePromptTemplate(c *gin.Context) {
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid template ID"})
+		return
+	}
+
+	template, err := models.ActivatePromptTemplate(id)
+	if errors.Is(err, models.ErrPromptTemplateNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	utils.LogInfo("Prompt template activated", "name", template.Name, "version", template.Version, "user_id", getUserID(c))
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":  "Prompt template activated successfully",
+		"template": template,
+	})
+}
@@ -0,0 +1,126 @@
+package routes
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/MauricioAliendre182/backend/models"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// getOwnQuestions returns the question history of the authenticated user
+// Supports ?limit= and ?offset= for pagination
+func getOwnQuestions(c *gin.Context) {
+	userID := getUserID(c)
+	if userID == "anonymous" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User ID not found in context"})
+		return
+	}
+
+	limit, offset, err := parsePagination(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	questions, err := models.GetQuestionsByUserID(userID, limit, offset)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"questions": questions,
+		"limit":     models.QuestionPageLimit(limit),
+		"offset":    offset,
+	})
+}
+
+// getAllQuestions returns the question history of all users for usage review (admin only)
+// Supported filters: user_id, provider, status, from and to (RFC3339), limit and offset
+func getAllQuestions(c *gin.Context) {
+	limit, offset, err := parsePagination(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	filter := models.QuestionFilter{
+		UserID:   c.Query("user_id"),
+		Provider: c.Query("provider"),
+		Status:   c.Query("status"),
+		Limit:    limit,
+		Offset:   offset,
+	}
+
+	// user_id is a UUID column, a malformed value is a bad request rather than a database error
+	if filter.UserID != "" {
+		userID, err := uuid.Parse(filter.UserID)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": errInvalidQueryParam("user_id").Error()})
+			return
+		}
+		filter.UserID = userID.String()
+	}
+	if filter.From, err = parseTimeQuery(c, "from"); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if filter.To, err = parseTimeQuery(c, "to"); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	questions, err := models.GetQuestions(filter)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"questions": questions,
+		"limit":     models.QuestionPageLimit(limit),
+		"offset":    offset,
+	})
+}
+
+// parsePagination reads the optional limit and offset query parameters
+func parsePagination(c *gin.Context) (int, int, error) {
+	limit, offset := 0, 0
+	var err error
+
+	if value := c.Query("limit"); value != "" {
+		if limit, err = strconv.Atoi(value); err != nil || limit < 0 {
+			return 0, 0, errInvalidQueryParam("limit")
+		}
+	}
+	if value := c.Query("offset"); value != "" {
+		if offset, err = strconv.Atoi(value); err != nil || offset < 0 {
+			return 0, 0, errInvalidQueryParam("offset")
+		}
+	}
+
+	return limit, offset, nil
+}
+
+// parseTimeQuery reads an optional RFC3339 timestamp query parameter
+func parseTimeQuery(c *gin.Context, name string) (*time.Time, error) {
+	value := c.Query(name)
+	if value == "" {
+		return nil, nil
+	}
+
+	parsed, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return nil, errInvalidQueryParam(name)
+	}
+	return &parsed, nil
+}
+
+// errInvalidQueryParam builds the error returned for malformed query parameters
+func errInvalidQueryParam(name string) error {
+	return fmt.Errorf("invalid value for query parameter '%s'", name)
+}
@@ -1,8 +1,13 @@
 package routes
 
 import (
+	"context"
+	"database/sql"
+	"errors"
 	"net/http"
+	"time"
 
+	"github.com/MauricioAliendre182/backend/middlewares"
 	"github.com/MauricioAliendre182/backend/models"
 	"github.com/MauricioAliendre182/backend/utils"
 	"github.com/gin-gonic/gin"
@@ -76,10 +81,15 @@ import (
 // }
 
 // queryDocuments handles RAG queries with security guardrails
+// Every query is recorded in the questions table, including blocked and failed ones
 func queryDocuments(c *gin.Context) {
+	startTime := time.Now()
+
 	// Get query from request
+	// Structured asks for the structured answer mode, STRUCTURED_ANSWERS decides when it is omitted
 	type QueryRequest struct {
-		Question string `json:"question" binding:"required"`
+		Structured *bool  `json:"structured"`
+		Question   string `json:"question" binding:"required"`
 	}
 
 	var req QueryRequest
@@ -91,8 +101,30 @@ func queryDocuments(c *gin.Context) {
 	// Sanitize the question
 	sanitizedQuestion := utils.SanitizeQuestion(req.Question)
 
-	// Validate question with guardrails
-	violations := utils.ValidateQuestion(sanitizedQuestion, utils.DefaultGuardrailConfig())
+	// Questions are refused once the token quota of the user or their team is exhausted
+	// The quota is checked before the guardrails, the classifier would otherwise spend tokens on a refused question
+	if !checkUsageQuota(c) {
+		return
+	}
+
+	// Validate question with the input checks of the active guardrail policy, see GUARDRAIL_POLICY_FILE
+	// The same policy is used for the answer, so a reload in between cannot mix two versions
+	// The usage collector gathers the tokens of every provider call made for this question, the classifier's included
+	policy := utils.ActiveGuardrailConfig()
+	middlewares.SetStageWriteDeadline(c, queryStages(policy, utils.AppConfig)...)
+	guardrailChat := guardrailChatService(policy)
+	usage := utils.NewUsageCollector()
+	ctx := utils.WithUsageCollector(c.Request.Context(), usage)
+	violations := utils.NewInputGuardrailPipeline(policy, guardrailChat).Run(ctx, sanitizedQuestion)
+
+	// Record of this question, saved once the outcome is known
+	question := models.Question{
+		UserID:     getUserID(c),
+		Query:      sanitizedQuestion,
+		Violations: violations,
+	}
+	// With pii.scrub_history, personal data typed by the user is not kept in the history, the model still gets the question as typed
+	historyRedactions := scrubQuestionHistory(policy, &question)
 
 	// Check for error-level violations
 	for _, violation := range violations {
@@ -100,6 +132,11 @@ func queryDocuments(c *gin.Context) {
 			// Log the violation for security monitoring
 			utils.LogGuardrailViolation(violation, getUserID(c), sanitizedQuestion)
 
+			question.Status = models.QuestionStatusBlocked
+			recordQuestion(&question, startTime)
+			recordGuardrailViolations(c, &question, policy, violations, historyRedactions)
+			recordUsage(c, question.ID.String(), usage)
+
 			c.JSON(http.StatusBadRequest, gin.H{
 				"error":       violation.Message,
 				"type":        violation.Type,
@@ -119,36 +156,206 @@ func queryDocuments(c *gin.Context) {
 	// Perform RAG query
 	ragService, err := models.NewRAGService()
 	if err != nil {
+		question.Status = models.QuestionStatusFailed
+		recordQuestion(&question, startTime)
+		recordGuardrailViolations(c, &question, policy, violations, historyRedactions)
+		// Tokens the classifier spent on the question still count
+		recordUsage(c, question.ID.String(), usage)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to initialize RAG service: " + err.Error()})
 		return
 	}
 
-	answer, err := ragService.QueryDocuments(sanitizedQuestion)
+	ragService.Guardrails = policy
+	ragService.Scope = getDocumentScope(c)
+	ragService.Structured = utils.AppConfig.StructuredAnswers
+	if req.Structured != nil {
+		ragService.Structured = *req.Structured
+	}
+
+	// The request context cancels the provider calls when the client disconnects
+	result, err := ragService.QueryDocuments(ctx, sanitizedQuestion)
 	if err != nil {
+		question.Status = models.QuestionStatusFailed
+		recordQuestion(&question, startTime)
+		recordGuardrailViolations(c, &question, policy, violations, historyRedactions)
+		// Tokens spent before the failure (the question embedding) still count
+		recordUsage(c, question.ID.String(), usage)
+		if errors.Is(err, context.DeadlineExceeded) {
+			c.JSON(http.StatusGatewayTimeout, gin.H{"error": "The AI provider did not answer in time, please try again"})
+			return
+		}
+		if errors.Is(err, utils.ErrMalformedStructuredAnswer) {
+			c.JSON(http.StatusBadGateway, gin.H{"error": err.Error()})
+			return
+		}
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
 
-	// Validate the response
-	responseViolations := utils.ValidateResponse(answer)
+	// Validate the response with the output checks of the policy
+	// An error-level violation withholds the answer, the original is kept in the question history for review
+	responseViolations := utils.NewOutputGuardrailPipeline(policy, guardrailChat).Run(ctx, result.Answer)
 	if len(responseViolations) > 0 {
 		utils.LogWarn("Response validation violations detected",
 			"user_id", getUserID(c),
-			"question", sanitizedQuestion,
+			"question", question.Query,
 			"violations", len(responseViolations),
 		)
 	}
+	answer := result.Answer
+	withheld := utils.HasBlockingViolation(responseViolations)
+	if withheld {
+		answer = withheldAnswer
+		if result.Structured != nil {
+			result.Structured = &models.StructuredRAGAnswer{Answer: withheldAnswer, CitedChunkIDs: []uuid.UUID{}}
+		}
+	} else if hasPIIViolation(responseViolations) {
+		// The pii check found personal data in the answer (pii.mask_answers), the client gets it masked
+		answer = maskAnswerPII(policy, result)
+	}
 
-	c.JSON(http.StatusOK, gin.H{
-		"question": sanitizedQuestion,
-		"answer":   answer,
-		"warnings": getWarnings(violations),
-	})
+	question.Status = models.QuestionStatusAnswered
+	question.Answer = result.Answer
+	question.ChunkIDs = result.ChunkIDs
+	question.Provider = result.Provider
+	question.Model = result.Model
+	if result.TemplateName != "" {
+		question.TemplateName = result.TemplateName
+		question.TemplateVersion = &result.TemplateVersion
+	}
+	question.Violations = append(question.Violations, responseViolations...)
+	if result.Grounding != nil {
+		question.Violations = append(question.Violations, result.Grounding.Violations()...)
+	}
+	historyRedactions.Add(scrubQuestionHistory(policy, &question))
+	recordQuestion(&question, startTime)
+	recordGuardrailViolations(c, &question, policy, append(violations, responseViolations...), historyRedactions)
+	recordUsage(c, question.ID.String(), usage)
+
+	response := gin.H{
+		"question_id": question.ID,
+		"question":    sanitizedQuestion,
+		"answer":      answer,
+		"warnings":    getWarnings(violations),
+		"template":    gin.H{"name": result.TemplateName, "version": result.TemplateVersion},
+		"usage":       usage.Summary(),
+	}
+	// In structured mode the client gets the cited chunks, the confidence and whether the documents had the answer
+	if result.Structured != nil {
+		response["structured"] = result.Structured
+	}
+	// Claims of the answer the documents do not support are flagged so the UI can mark them
+	// A withheld answer is not shown, neither are its claims
+	if result.Grounding != nil && !withheld {
+		response["grounding"] = result.Grounding
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// queryStages returns the stage deadlines a question runs one after the other: the question embedding,
+// the retrieval and the answer, plus the classifier of the question and of the answer when the policy enables
+// llm_classifier, and the grounding judge with GROUNDING_LLM_JUDGE
+func queryStages(policy *utils.GuardrailConfig, config *utils.Config) []utils.Stage {
+	stages := []utils.Stage{utils.EmbeddingStage, utils.RetrievalStage, utils.ChatStage}
+	if policy.CheckEnabled(utils.GuardrailCheckLLMClassifier) {
+		stages = append(stages, utils.ChatStage, utils.ChatStage)
+	}
+	if config != nil && config.GroundingCheckEnabled && config.GroundingLLMJudge {
+		stages = append(stages, utils.ChatStage)
+	}
+	return stages
+}
+
+// withheldAnswer replaces an answer an output guardrail check rejected
+const withheldAnswer = "The answer was withheld because it did not pass the content policy. Please rephrase your question or contact an administrator."
+
+// scrubQuestionHistory redacts personal data from the question, the answer and the violation messages
+// of a question before it is stored, when the policy enables pii.scrub_history
+// The violations are copied since the request still returns the originals as warnings
+func scrubQuestionHistory(policy *utils.GuardrailConfig, question *models.Question) utils.PIICounts {
+	redactions := utils.PIICounts{}
+	if !policy.PII.ScrubHistory {
+		return redactions
+	}
+
+	var counts utils.PIICounts
+	question.Query, counts = utils.RedactPII(question.Query, policy.PII.Types)
+	redactions.Add(counts)
+	question.Answer, counts = utils.RedactPII(question.Answer, policy.PII.Types)
+	redactions.Add(counts)
+
+	question.Violations, counts = scrubViolationMessages(policy, question.Violations)
+	redactions.Add(counts)
+	return redactions
+}
+
+// scrubViolationMessages returns copies of the violations with personal data redacted from their messages
+// The originals are left untouched since the request still returns them as warnings
+func scrubViolationMessages(policy *utils.GuardrailConfig, violations []utils.GuardrailViolation) ([]utils.GuardrailViolation, utils.PIICounts) {
+	redactions := utils.PIICounts{}
+	scrubbed := make([]utils.GuardrailViolation, len(violations))
+	for i, violation := range violations {
+		var counts utils.PIICounts
+		violation.Message, counts = utils.RedactPII(violation.Message, policy.PII.Types)
+		redactions.Add(counts)
+		scrubbed[i] = violation
+	}
+	return scrubbed, redactions
+}
+
+// hasPIIViolation reports whether the pii check found personal data
+func hasPIIViolation(violations []utils.GuardrailViolation) bool {
+	for _, violation := range violations {
+		if violation.Check == utils.GuardrailCheckPII {
+			return true
+		}
+	}
+	return false
+}
+
+// maskAnswerPII masks the personal data of the answer returned to the client,
+// in the structured answer and in the grounding claims too
+func maskAnswerPII(policy *utils.GuardrailConfig, result *models.RAGAnswer) string {
+	answer, _ := utils.MaskPII(result.Answer, policy.PII.Types)
+	if result.Structured != nil {
+		result.Structured.Answer, _ = utils.MaskPII(result.Structured.Answer, policy.PII.Types)
+	}
+	if result.Grounding != nil {
+		for i := range result.Grounding.Claims {
+			result.Grounding.Claims[i].Claim, _ = utils.MaskPII(result.Grounding.Claims[i].Claim, policy.PII.Types)
+		}
+	}
+	return answer
+}
+
+// guardrailChatService returns the chat service of the LLM classifier check, nil when the policy does not enable it
+// The classifier failing to start must not block questions, the other checks still run
+func guardrailChatService(policy *utils.GuardrailConfig) utils.ChatService {
+	if !policy.CheckEnabled(utils.GuardrailCheckLLMClassifier) {
+		return nil
+	}
+
+	chat, err := utils.NewAIServiceFactory(utils.AppConfig).CreateChatService()
+	if err != nil {
+		utils.LogError("Failed to create the guardrail classifier chat service", err)
+		return nil
+	}
+	return chat
+}
+
+// recordQuestion stores the question in the history
+// A failure to record must never fail the query itself, so errors are only logged
+func recordQuestion(question *models.Question, startTime time.Time) {
+	question.LatencyMs = time.Since(startTime).Milliseconds()
+	if err := question.Save(); err != nil {
+		utils.LogError("Failed to record question", err, "user_id", question.UserID, "status", question.Status)
+	}
 }
 
 // getUserID extracts user ID from context, returns "anonymous" if not found
+// The authentication middleware stores it under the "userId" key
 func getUserID(c *gin.Context) string {
-	if userID, exists := c.Get("user_id"); exists {
+	if userID, exists := c.Get("userId"); exists {
 		if id, ok := userID.(string); ok {
 			return id
 		}
@@ -156,6 +363,22 @@ func getUserID(c *gin.Context) string {
 	return "anonymous"
 }
 
+// getUserAccess extracts the roles and permissions of the user from context
+// The authentication middleware stores them under the "access" key
+func getUserAccess(c *gin.Context) utils.UserAccess {
+	if value, exists := c.Get("access"); exists {
+		if access, ok := value.(utils.UserAccess); ok {
+			return access
+		}
+	}
+	return utils.UserAccess{}
+}
+
+// getDocumentScope returns the documents the authenticated user can read
+func getDocumentScope(c *gin.Context) models.DocumentScope {
+	return models.NewDocumentScope(getUserID(c), getUserAccess(c))
+}
+
 // getWarnings extracts warning messages from violations
 func getWarnings(violations []utils.GuardrailViolation) []string {
 	var warnings []string
@@ -167,9 +390,9 @@ func getWarnings(violations []utils.GuardrailViolation) []string {
 	return warnings
 }
 
-// getDocuments returns all documents
+// getDocuments returns the documents the user can read: their own and the ones shared with them
 func getDocuments(c *gin.Context) {
-	documents, err := models.GetAllDocuments()
+	documents, err := models.GetAllDocuments(getDocumentScope(c))
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
@@ -180,6 +403,8 @@ func getDocuments(c *gin.Context) {
 	})
 }
 
+// deleteDocument deletes a document with its chunks
+// Only its owner or a user with documents:all can delete it, the documents the user cannot read are not found
 func deleteDocument(c *gin.Context) {
 	documentID := c.Param("id")
 	if documentID == "" {
@@ -194,16 +419,33 @@ func deleteDocument(c *gin.Context) {
 		return
 	}
 
-	// Delete the chunk
-	var chunk models.Chunk
-	err = chunk.DeleteChunk(docUUID)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	scope := getDocumentScope(c)
+	if !requireDocumentManager(c, docUUID, scope) {
 		return
 	}
 
-	// Delete the document
-	err = models.DeleteDocument(docUUID)
+	// Keep the chunk contents to update the corpus statistics when they are deleted
+	var chunkContents []string
+	if utils.KeepsCorpusStatistics() {
+		chunks, err := models.GetChunksByDocumentID(docUUID, scope)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		chunkContents = make([]string, len(chunks))
+		for i, chunk := range chunks {
+			chunkContents[i] = chunk.Content
+		}
+	}
+
+	// Delete the document, its chunks go with it, and update the corpus statistics in the same transaction
+	// so a failure leaves both untouched
+	err = utils.WithTransaction(func(tx *sql.Tx) error {
+		if err := models.DeleteDocumentWithTx(tx, docUUID); err != nil {
+			return err
+		}
+		return utils.RemoveFromCorpus(tx, chunkContents)
+	})
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
@@ -229,10 +471,22 @@ func getDocumentChunks(c *gin.Context) {
 		return
 	}
 
+	// The documents the user cannot read are not found
+	scope := getDocumentScope(c)
+	_, err = models.GetReadableDocument(docUUID, scope)
+	if errors.Is(err, models.ErrDocumentNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Document not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
 	// Get chunks for the document
 	// models.GetChunksByDocumentID is a function that retrieves chunks from the database
 	// It should return a slice of chunks and an error
-	chunks, err := models.GetChunksByDocumentID(docUUID)
+	chunks, err := models.GetChunksByDocumentID(docUUID, scope)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
@@ -0,0 +1,128 @@
+package routes
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/MauricioAliendre182/backend/models"
+	"github.com/MauricioAliendre182/backend/utils"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// getRoles lists the roles with their permissions, and every permission a role can grant (users:manage)
+func getRoles(c *gin.Context) {
+	roles, err := models.GetRoles()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"roles":       roles,
+		"permissions": utils.Permissions,
+	})
+}
+
+// saveRole creates a custom role or replaces its permissions (users:manage)
+// The built-in roles cannot be changed
+func saveRole(c *gin.Context) {
+	type RoleRequest struct {
+		Description string   `json:"description"`
+		Permissions []string `json:"permissions" binding:"required"`
+	}
+
+	var req RoleRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	role := models.Role{
+		Name:        c.Param("name"),
+		Description: req.Description,
+		Permissions: req.Permissions,
+	}
+	if err := role.Validate(); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := role.Save(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	utils.LogInfo("Role saved", "role", role.Name, "permissions", role.Permissions, "user_id", getUserID(c))
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Role saved successfully",
+		"role":    role,
+	})
+}
+
+// getUserRoles returns the roles assigned to a user (users:manage)
+// A user without any role gets DEFAULT_USER_ROLE when their tokens are issued
+func getUserRoles(c *gin.Context) {
+	userID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return
+	}
+
+	roles, err := models.GetUserRoles(userID.String())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"user_id": userID,
+		"roles":   roles,
+	})
+}
+
+// setUserRoles replaces the roles of a user (users:manage)
+// The user gets the new permissions with their next access token
+func setUserRoles(c *gin.Context) {
+	type UserRolesRequest struct {
+		Roles []string `json:"roles"`
+	}
+
+	userID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return
+	}
+
+	var req UserRolesRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if req.Roles == nil {
+		req.Roles = []string{}
+	}
+
+	err = models.SetUserRoles(userID.String(), req.Roles, getUserID(c))
+	if errors.Is(err, models.ErrUnknownRole) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if errors.Is(err, models.ErrUserNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	utils.LogInfo("User roles updated", "user_id", userID.String(), "roles", req.Roles, "granted_by", getUserID(c))
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Roles updated successfully, they apply when the user's access token is refreshed",
+		"user_id": userID,
+		"roles":   req.Roles,
+	})
+}
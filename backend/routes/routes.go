@@ -4,11 +4,24 @@ import (
 	"time"
 
 	"github.com/MauricioAliendre182/backend/middlewares"
+	"github.com/MauricioAliendre182/backend/utils"
 	"github.com/gin-contrib/cors"
 	"github.com/gin-gonic/gin"
 )
 
 func RegisterRoutes(server *gin.Engine) {
+	// Only trust the client IP headers set by the configured proxies (TRUSTED_PROXIES)
+	// Gin trusts every proxy by default, which would let any client choose its IP with X-Forwarded-For
+	// and get a fresh rate limit bucket on every request
+	var trustedProxies []string
+	if utils.AppConfig != nil {
+		trustedProxies = utils.AppConfig.TrustedProxies
+	}
+	if err := server.SetTrustedProxies(trustedProxies); err != nil {
+		utils.LogError("Invalid TRUSTED_PROXIES, no proxy is trusted", err, "trusted_proxies", trustedProxies)
+		server.SetTrustedProxies(nil)
+	}
+
 	// Global middleware
 	// It handles errors and logs requests
 	server.Use(middlewares.ErrorHandler())
@@ -19,7 +32,7 @@ func RegisterRoutes(server *gin.Engine) {
 		AllowOrigins:     []string{"http://localhost:4200", "http://localhost"}, // or your frontend domain
 		AllowMethods:     []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
 		AllowHeaders:     []string{"Origin", "Content-Type", "Authorization"},
-		ExposeHeaders:    []string{"Content-Length"},
+		ExposeHeaders:    []string{"Content-Length", "Retry-After", "X-RateLimit-Limit", "X-RateLimit-Remaining", "X-RateLimit-Reset"},
 		AllowCredentials: true,
 		MaxAge:           12 * time.Hour,
 	}))
@@ -33,14 +46,18 @@ func RegisterRoutes(server *gin.Engine) {
 	v1 := server.Group("/api/v1")
 
 	// Authenticated routes
+	// The default rate limit runs after Authenticate so each user gets their own bucket
 	authenticated := v1.Group("")
 	authenticated.Use(middlewares.Authenticate)
+	authenticated.Use(middlewares.RateLimit("default"))
 
 	// Non-authenticated routes
 	nonAuthenticated := v1.Group("")
 
 	// Authentication routes
+	// Unauthenticated, so the rate limit is keyed by client IP (brute force, signup spam)
 	auth := nonAuthenticated.Group("/auth")
+	auth.Use(middlewares.RateLimit("auth"))
 	{
 		auth.POST("/signup", signup)
 		auth.POST("/login", login)
@@ -56,24 +73,85 @@ func RegisterRoutes(server *gin.Engine) {
 	{
 		profile.GET("/profile", getOwnProfile)
 		profile.GET("/user", getUser)
-		profile.GET("/users", getAllUsers)
+		profile.GET("/users", middlewares.RequirePermission(utils.PermissionUsersManage), getAllUsers)
 	}
 
 	// Alternative profile endpoint
 	authenticated.GET("/me/profile", getOwnProfile)
 
+	// Question history of the authenticated user
+	authenticated.GET("/me/questions", getOwnQuestions)
+
+	// Token usage and quotas of the authenticated user
+	authenticated.GET("/me/usage", getOwnUsage)
+
 	// Document routes (authenticated)
+	// Reading needs the viewer role, uploading the contributor role and deleting the admin role
+	// Within a route, users only reach the documents they own or that are shared with them
 	docs := authenticated.Group("/documents")
 	{
-		docs.POST("", uploadDocument)
-		docs.GET("", getDocuments)
-		docs.GET("/:id/chunks", getDocumentChunks)
-		docs.DELETE("/:id", deleteDocument)
+		docs.POST("", middlewares.RequirePermission(utils.PermissionDocumentsUpload), middlewares.RateLimit("upload"), middlewares.ExtendWriteDeadline(utils.IngestionStage), uploadDocument)
+		docs.GET("", middlewares.RequirePermission(utils.PermissionDocumentsRead), getDocuments)
+		docs.GET("/:id/chunks", middlewares.RequirePermission(utils.PermissionDocumentsRead), getDocumentChunks)
+		docs.DELETE("/:id", middlewares.RequirePermission(utils.PermissionDocumentsDelete), deleteDocument)
+
+		// Sharing, only the owner of a document (or documents:all) can see and change who reads it
+		docs.GET("/:id/shares", middlewares.RequirePermission(utils.PermissionDocumentsRead), getDocumentShares)
+		docs.PUT("/:id/shares", middlewares.RequirePermission(utils.PermissionDocumentsUpload), setDocumentShares)
 	}
 
 	// RAG query endpoint (authenticated)
-	authenticated.POST("/query", queryDocuments)
+	// Queries call the AI providers, they get their own, stricter limit
+	// The handler extends its write deadline once it knows which checks the policy enables
+	authenticated.POST("/query", middlewares.RequirePermission(utils.PermissionDocumentsQuery), middlewares.RateLimit("query"), queryDocuments)
+
+	// Answer feedback endpoint (authenticated)
+	authenticated.POST("/questions/:id/feedback", middlewares.RequirePermission(utils.PermissionDocumentsQuery), rateAnswer)
 
 	// Guardrail status endpoint (authenticated)
 	authenticated.GET("/guardrails/status", getGuardrailStatus)
+
+	// Admin routes (authenticated + admin:access permission)
+	admin := authenticated.Group("/admin")
+	admin.Use(middlewares.RequirePermission(utils.PermissionAdminAccess))
+	{
+		admin.GET("/questions", getAllQuestions)
+		admin.GET("/feedback/report", getFeedbackReport)
+		admin.GET("/prompt-templates", getPromptTemplates)
+		admin.POST("/prompt-templates", createPromptTemplate)
+		admin.POST("/prompt-templates/:id/activate", activatePromptTemplate)
+
+		// Token usage accounting and quotas
+		admin.GET("/usage/report", getUsageReport)
+		admin.GET("/usage/quotas", getUsageQuotas)
+		admin.PUT("/usage/quotas", setUsageQuota)
+		admin.DELETE("/usage/quotas/:id", deleteUsageQuota)
+		admin.PUT("/users/:id/team", setUserTeam)
+
+		// Guardrail violation audit log and false positive review
+		admin.GET("/guardrails/violations", getGuardrailViolations)
+		admin.GET("/guardrails/violations/summary", getGuardrailViolationSummary)
+		admin.PUT("/guardrails/violations/:id/review", reviewGuardrailViolation)
+		admin.GET("/guardrails/tuning", getGuardrailTuning)
+		admin.POST("/guardrails/dry-run", dryRunGuardrails)
+		admin.GET("/guardrails/flagged-documents", getFlaggedDocuments)
+		admin.GET("/guardrails/flagged-documents/:id", getFlaggedDocumentChunks)
+		admin.POST("/guardrails/flagged-documents/rescan", rescanDocumentChunks)
+
+		// Evaluation of the RAG and guardrail data sets, the handler sizes its write deadline from the cases
+		admin.POST("/eval", runEvaluation)
+
+		// Roles and their assignment to users
+		roles := admin.Group("")
+		roles.Use(middlewares.RequirePermission(utils.PermissionUsersManage))
+		{
+			roles.GET("/roles", getRoles)
+			roles.PUT("/roles/:name", saveRole)
+			roles.GET("/users/:id/roles", getUserRoles)
+			roles.PUT("/users/:id/roles", setUserRoles)
+		}
+
+		// Provider counters (retries, errors by kind)
+		admin.GET("/metrics", getMetrics)
+	}
 }
@@ -2,20 +2,26 @@ package routes
 
 import (
 	"bytes"
+	"context"
 	"database/sql"
 	"encoding/json"
+	"fmt"
 	"mime/multipart"
 	"net/http"
 	"net/http/httptest"
 	"os"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/MauricioAliendre182/backend/db"
+	"github.com/MauricioAliendre182/backend/eval"
+	"github.com/MauricioAliendre182/backend/models"
 	"github.com/MauricioAliendre182/backend/utils"
 	"github.com/gin-gonic/gin"
 	_ "github.com/mattn/go-sqlite3"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestMain(m *testing.M) {
@@ -469,3 +475,413 @@ func BenchmarkQueryDocuments(b *testing.B) {
 		router.ServeHTTP(w, req)
 	}
 }
+
+func TestScrubQuestionHistory(t *testing.T) {
+	violations := []utils.GuardrailViolation{{Type: "classifier_flagged", Message: "Mentions jane.doe@example.com", Severity: "warning"}}
+	question := models.Question{
+		Query:      "What is the IBAN DE89 3704 0044 0532 0130 00 used for?",
+		Answer:     "It belongs to jane.doe@example.com.",
+		Violations: violations,
+	}
+
+	scrubbing := utils.DefaultGuardrailConfig()
+	scrubbing.PII.ScrubHistory = true
+	redactions := scrubQuestionHistory(scrubbing, &question)
+	assert.Equal(t, utils.PIICounts{utils.PIITypeIBAN: 1, utils.PIITypeEmail: 2}, redactions)
+	assert.Equal(t, "What is the IBAN [REDACTED_IBAN] used for?", question.Query)
+	assert.Equal(t, "It belongs to [REDACTED_EMAIL].", question.Answer)
+	assert.Equal(t, "Mentions [REDACTED_EMAIL]", question.Violations[0].Message)
+	// The warnings returned to the user are not modified
+	assert.Equal(t, "Mentions jane.doe@example.com", violations[0].Message)
+
+	// Scrubbing the history is off by default
+	question = models.Question{Query: "Mail jane.doe@example.com"}
+	assert.Zero(t, scrubQuestionHistory(utils.DefaultGuardrailConfig(), &question).Total())
+	assert.Equal(t, "Mail jane.doe@example.com", question.Query)
+}
+
+func TestAuditedViolations(t *testing.T) {
+	violations := []utils.GuardrailViolation{{Type: "classifier_flagged", Message: "Mentions jane.doe@example.com", Severity: "warning"}}
+	historyRedactions := utils.PIICounts{utils.PIITypeEmail: 1}
+
+	scrubbing := utils.DefaultGuardrailConfig()
+	scrubbing.PII.ScrubHistory = true
+	audited := auditedViolations(scrubbing, violations, historyRedactions)
+	require.Len(t, audited, 2)
+	assert.Equal(t, "Mentions [REDACTED_EMAIL]", audited[0].Message)
+	assert.Equal(t, utils.GuardrailCheckPII, audited[1].Check)
+	assert.Equal(t, utils.GuardrailStageHistory, audited[1].Stage)
+	// The warnings returned to the user are not modified
+	assert.Equal(t, "Mentions jane.doe@example.com", violations[0].Message)
+
+	audited = auditedViolations(utils.DefaultGuardrailConfig(), violations, utils.PIICounts{})
+	require.Len(t, audited, 1)
+	assert.Equal(t, "Mentions jane.doe@example.com", audited[0].Message)
+}
+
+func TestRunEvaluation(t *testing.T) {
+	tests := []struct {
+		name           string
+		query          string
+		body           string
+		expectedError  string
+		expectedStatus int
+	}{
+		{
+			name:           "Unknown report format",
+			query:          "?format=html",
+			expectedStatus: http.StatusBadRequest,
+			expectedError:  "unknown report format",
+		},
+		{
+			name:           "Data set outside the data directory",
+			body:           `{"datasets": ["../../etc/passwd"]}`,
+			expectedStatus: http.StatusBadRequest,
+			expectedError:  "invalid data set name",
+		},
+		{
+			name:           "Negative k",
+			body:           `{"k": -1}`,
+			expectedStatus: http.StatusBadRequest,
+			expectedError:  "k must be positive",
+		},
+		{
+			name:           "Missing data set",
+			body:           `{"datasets": ["missing.csv"]}`,
+			expectedStatus: http.StatusBadRequest,
+			expectedError:  "missing.csv",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			router := gin.New()
+			router.POST("/admin/eval", runEvaluation)
+
+			req := httptest.NewRequest("POST", "/admin/eval"+tt.query, strings.NewReader(tt.body))
+			req.Header.Set("Content-Type", "application/json")
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+
+			assert.Equal(t, tt.expectedStatus, w.Code)
+			assert.Contains(t, strings.ToLower(w.Body.String()), strings.ToLower(tt.expectedError))
+		})
+	}
+}
+
+func TestRequirePermission(t *testing.T) {
+	t.Setenv("JWT_SECRET", "test-secret")
+
+	tokenFor := func(role string) string {
+		access := utils.UserAccess{Roles: []string{role}}
+		for _, builtin := range utils.BuiltinRoles {
+			if builtin.Name == role {
+				access.Permissions = builtin.Permissions
+			}
+		}
+		tokens, err := utils.GenerateTokenPair("test-user", role+"@example.com", access)
+		assert.NoError(t, err)
+		return "Bearer " + tokens.AccessToken
+	}
+
+	tests := []struct {
+		name           string
+		method         string
+		path           string
+		token          string
+		expectedError  string
+		expectedStatus int
+	}{
+		{
+			name:           "Viewer cannot upload documents",
+			method:         "POST",
+			path:           "/api/v1/documents",
+			token:          tokenFor(utils.RoleViewer),
+			expectedStatus: http.StatusForbidden,
+			expectedError:  "Permission required: documents:upload",
+		},
+		{
+			name:           "Contributor cannot delete documents",
+			method:         "DELETE",
+			path:           "/api/v1/documents/not-a-uuid",
+			token:          tokenFor(utils.RoleContributor),
+			expectedStatus: http.StatusForbidden,
+			expectedError:  "Permission required: documents:delete",
+		},
+		{
+			name:           "Admin can delete documents",
+			method:         "DELETE",
+			path:           "/api/v1/documents/not-a-uuid",
+			token:          tokenFor(utils.RoleAdmin),
+			expectedStatus: http.StatusBadRequest,
+			expectedError:  "Invalid document ID",
+		},
+		{
+			name:           "Contributor cannot list users",
+			method:         "GET",
+			path:           "/api/v1/auth/users",
+			token:          tokenFor(utils.RoleContributor),
+			expectedStatus: http.StatusForbidden,
+			expectedError:  "Permission required: users:manage",
+		},
+		{
+			name:           "Viewer cannot use the admin endpoints",
+			method:         "GET",
+			path:           "/api/v1/admin/roles",
+			token:          tokenFor(utils.RoleViewer),
+			expectedStatus: http.StatusForbidden,
+			expectedError:  "Permission required: admin:access",
+		},
+		{
+			name:           "Admin can manage roles",
+			method:         "PUT",
+			path:           "/api/v1/admin/users/not-a-uuid/roles",
+			token:          tokenFor(utils.RoleAdmin),
+			expectedStatus: http.StatusBadRequest,
+			expectedError:  "Invalid user ID",
+		},
+		{
+			name:           "Missing token",
+			method:         "GET",
+			path:           "/api/v1/documents",
+			expectedStatus: http.StatusUnauthorized,
+		},
+	}
+
+	router := gin.New()
+	RegisterRoutes(router)
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, tt.path, strings.NewReader(`{}`))
+			req.Header.Set("Content-Type", "application/json")
+			if tt.token != "" {
+				req.Header.Set("Authorization", tt.token)
+			}
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+
+			assert.Equal(t, tt.expectedStatus, w.Code)
+			assert.Contains(t, w.Body.String(), tt.expectedError)
+		})
+	}
+}
+
+func TestSetDocumentShares(t *testing.T) {
+	tests := []struct {
+		name           string
+		documentID     string
+		body           string
+		expectedError  string
+		expectedStatus int
+	}{
+		{
+			name:           "Invalid document ID",
+			documentID:     "not-a-uuid",
+			body:           `{"shares": []}`,
+			expectedStatus: http.StatusBadRequest,
+			expectedError:  "Invalid document ID",
+		},
+		{
+			name:           "Unknown share type",
+			documentID:     "8f1f4e4c-7a57-4d8b-9f6e-0b7c1c2a3d4e",
+			body:           `{"shares": [{"type": "team", "subject": "hr"}]}`,
+			expectedStatus: http.StatusBadRequest,
+			expectedError:  "unknown share type",
+		},
+		{
+			name:           "Share with a user by email",
+			documentID:     "8f1f4e4c-7a57-4d8b-9f6e-0b7c1c2a3d4e",
+			body:           `{"shares": [{"type": "user", "subject": "jane@example.com"}]}`,
+			expectedStatus: http.StatusBadRequest,
+			expectedError:  "invalid user ID",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			router := gin.New()
+			router.PUT("/documents/:id/shares", setDocumentShares)
+
+			req := httptest.NewRequest("PUT", "/documents/"+tt.documentID+"/shares", strings.NewReader(tt.body))
+			req.Header.Set("Content-Type", "application/json")
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+
+			assert.Equal(t, tt.expectedStatus, w.Code)
+			assert.Contains(t, w.Body.String(), tt.expectedError)
+		})
+	}
+}
+
+func TestGetAllQuestionsInvalidFilters(t *testing.T) {
+	tests := []struct {
+		name          string
+		query         string
+		expectedError string
+	}{
+		{name: "User ID is not a UUID", query: "?user_id=alice", expectedError: "user_id"},
+		{name: "Malformed date", query: "?from=yesterday", expectedError: "from"},
+		{name: "Negative limit", query: "?limit=-1", expectedError: "limit"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			router := gin.New()
+			router.GET("/admin/questions", getAllQuestions)
+
+			req := httptest.NewRequest("GET", "/admin/questions"+tt.query, http.NoBody)
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+
+			assert.Equal(t, http.StatusBadRequest, w.Code)
+			assert.Contains(t, w.Body.String(), tt.expectedError)
+		})
+	}
+}
+
+func TestGetMetrics(t *testing.T) {
+	_, err := utils.Retry(context.Background(), utils.DefaultRetryConfig(), "test.metrics", func() error { return nil })
+	assert.NoError(t, err)
+
+	router := gin.New()
+	router.GET("/metrics", getMetrics)
+
+	req := httptest.NewRequest("GET", "/metrics", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var metrics map[string]map[string]int64
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &metrics))
+	assert.Len(t, metrics, 1, "only the provider counters are served, not cmdline or memstats")
+	assert.Equal(t, int64(1), metrics["provider_retries"]["test.metrics.calls"])
+}
+
+func TestRateLimitIgnoresSpoofedForwardedFor(t *testing.T) {
+	tests := []struct {
+		name           string
+		trustedProxies []string
+		limited        bool
+	}{
+		{
+			name:    "Spoofed X-Forwarded-For shares the bucket of the connection",
+			limited: true,
+		},
+		{
+			name:           "X-Forwarded-For of a trusted proxy gives each client its own bucket",
+			trustedProxies: []string{"192.0.2.0/24"},
+			limited:        false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			previousConfig := utils.AppConfig
+			defer func() { utils.AppConfig = previousConfig }()
+
+			config := *previousConfig
+			config.APIRateLimitEnabled = true
+			config.APIRateLimitRoutes = map[string]utils.RouteRateLimit{"auth": {Requests: 2, Per: time.Minute}}
+			config.TrustedProxies = tt.trustedProxies
+			utils.AppConfig = &config
+
+			router := gin.New()
+			RegisterRoutes(router)
+
+			// httptest requests come from 192.0.2.1, each one claims another client IP
+			var status int
+			for i := 0; i < 3; i++ {
+				req := httptest.NewRequest("POST", "/api/v1/auth/is-available", strings.NewReader(`{}`))
+				req.Header.Set("Content-Type", "application/json")
+				req.Header.Set("X-Forwarded-For", fmt.Sprintf("203.0.113.%d", i+1))
+				w := httptest.NewRecorder()
+				router.ServeHTTP(w, req)
+				status = w.Code
+			}
+
+			assert.Equal(t, tt.limited, status == http.StatusTooManyRequests)
+		})
+	}
+}
+
+func TestQueryStages(t *testing.T) {
+	enabled := true
+	classifierPolicy := utils.DefaultGuardrailConfig()
+	classifierPolicy.Checks = map[string]utils.GuardrailCheckSettings{utils.GuardrailCheckLLMClassifier: {Enabled: &enabled}}
+
+	tests := []struct {
+		name   string
+		policy *utils.GuardrailConfig
+		config *utils.Config
+		chats  int
+	}{
+		{
+			name:   "Only the answer calls the chat model by default",
+			policy: utils.DefaultGuardrailConfig(),
+			config: &utils.Config{},
+			chats:  1,
+		},
+		{
+			name:   "The classifier checks the question and the answer",
+			policy: classifierPolicy,
+			config: &utils.Config{},
+			chats:  3,
+		},
+		{
+			name:   "The grounding check without the judge makes no chat call",
+			policy: utils.DefaultGuardrailConfig(),
+			config: &utils.Config{GroundingCheckEnabled: true},
+			chats:  1,
+		},
+		{
+			name:   "The grounding judge asks the chat model",
+			policy: classifierPolicy,
+			config: &utils.Config{GroundingCheckEnabled: true, GroundingLLMJudge: true},
+			chats:  4,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stages := queryStages(tt.policy, tt.config)
+
+			chats := 0
+			for _, stage := range stages {
+				if stage == utils.ChatStage {
+					chats++
+				}
+			}
+			assert.Equal(t, tt.chats, chats)
+			assert.Contains(t, stages, utils.EmbeddingStage)
+			assert.Contains(t, stages, utils.RetrievalStage)
+		})
+	}
+}
+
+func TestEvaluationStages(t *testing.T) {
+	cases := []eval.Case{
+		{ID: "rag_1", Suite: eval.SuiteRAG, Context: "Go is a programming language."},
+		{ID: "rag_2", Suite: eval.SuiteRAG, Context: "Go is a programming language."},
+		{ID: "guard_1", Suite: eval.SuiteGuardrails},
+	}
+
+	count := func(stages []utils.Stage, stage utils.Stage) int {
+		n := 0
+		for _, s := range stages {
+			if s == stage {
+				n++
+			}
+		}
+		return n
+	}
+
+	stages := evaluationStages(cases, &utils.Config{})
+	assert.Equal(t, 1, count(stages, utils.IngestionStage), "cases sharing a context index it once")
+	assert.Equal(t, 5, count(stages, utils.EmbeddingStage), "the RAG cases embed their question for the retrieval and the answer")
+	assert.Equal(t, 3, count(stages, utils.ChatStage))
+
+	stages = evaluationStages(cases, &utils.Config{GroundingCheckEnabled: true, GroundingLLMJudge: true})
+	assert.Equal(t, 6, count(stages, utils.ChatStage), "the grounding judge asks the chat model for every answer")
+}
@@ -8,6 +8,7 @@ import (
 	"github.com/MauricioAliendre182/backend/models"
 	"github.com/MauricioAliendre182/backend/utils"
 	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
 )
 
 // uploadDocument handles the document upload and processing
@@ -15,6 +16,8 @@ import (
 // saving it as a document, processing the file into chunks, generating embeddings,
 // and saving those chunks to the database.
 // RAG is used in this function to process the document and generate embeddings for each chunk.
+// The document belongs to the user uploading it, the optional share form values
+// ("everyone", "group:<team>", "user:<user ID>") decide who else can read it
 func uploadDocument(c *gin.Context) {
 	utils.LogInfo("Starting document upload process")
 
@@ -41,6 +44,23 @@ func uploadDocument(c *gin.Context) {
 		return
 	}
 
+	shares, err := parseUploadShares(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// Embedding the chunks uses tokens, uploads are refused once the quota is exhausted
+	if !checkUsageQuota(c) {
+		return
+	}
+	usage := utils.NewUsageCollector()
+	ctx := utils.WithUsageCollector(c.Request.Context(), usage)
+
+	// The guardrail policy scans the chunks and decides whether personal data is redacted
+	policy := utils.ActiveGuardrailPolicy()
+	var redactions utils.PIICounts
+
 	// Use transaction to ensure data consistency
 	// func(tx *sql.Tx) error is a function type that takes a transaction and returns an error
 	// This allows us to perform multiple database operations within a transaction
@@ -57,9 +77,15 @@ func uploadDocument(c *gin.Context) {
 		}
 
 		// Save document (you'll need to modify this to accept a transaction)
+		if ownerID, err := uuid.Parse(getUserID(c)); err == nil {
+			doc.OwnerID = uuid.NullUUID{UUID: ownerID, Valid: true}
+		}
 		if err := doc.SaveWithTx(tx); err != nil {
 			return fmt.Errorf("failed to save document: %v", err)
 		}
+		if err := models.SetDocumentSharesWithTx(tx, doc.ID, shares, getUserID(c)); err != nil {
+			return fmt.Errorf("failed to share document: %v", err)
+		}
 
 		// Process file into chunks
 		chunkSize := utils.AppConfig.ChunkSize
@@ -67,16 +93,24 @@ func uploadDocument(c *gin.Context) {
 			chunkSize = 1000 // Default fallback
 		}
 
-		chunks, err := models.ProcessFileToChunks(fileHeader, doc.ID, chunkSize)
+		chunks, piiRedactions, err := models.ProcessFileToChunks(ctx, fileHeader, doc.ID, chunkSize, policy.Config)
+		redactions = piiRedactions
 		if err != nil {
 			return fmt.Errorf("failed to process file into chunks: %v", err)
 		}
 
 		// Save chunks with embeddings
+		chunkContents := make([]string, 0, len(chunks))
 		for _, chunk := range chunks {
 			if err := chunk.SaveWithTx(tx); err != nil {
 				return fmt.Errorf("failed to save chunk: %v", err)
 			}
+			chunkContents = append(chunkContents, chunk.Content)
+		}
+
+		// Update the corpus statistics of embedding services that keep them (lexical provider)
+		if err := utils.AddToCorpus(tx, chunkContents); err != nil {
+			return fmt.Errorf("failed to update corpus statistics: %v", err)
 		}
 
 		// Prepare response
@@ -85,20 +119,30 @@ func uploadDocument(c *gin.Context) {
 		utils.LogInfo("Document uploaded successfully",
 			"document_id", doc.ID.String(),
 			"filename", doc.OriginalFilename,
-			"chunks_created", len(chunks))
+			"chunks_created", len(chunks),
+			"shares", len(shares),
+			"pii_redactions", redactions.Total())
 
 		c.JSON(http.StatusOK, gin.H{
 			"message":        "Document uploaded successfully",
 			"document":       response,
+			"shares":         shares,
 			"chunks_created": len(chunks),
+			"pii_redactions": redactions.Total(),
 		})
 
 		return nil
 	})
 
+	// The embeddings were paid for even when the upload is rolled back
+	recordUsage(c, "", usage)
+
 	if err != nil {
 		utils.LogError("Failed to upload document", err, "filename", fileHeader.Filename)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
+
+	// Redactions are audited once the document is stored, a rolled back upload kept nothing
+	recordPIIRedactions(c, fileHeader.Filename, policy.Version, utils.PIIViolations(utils.GuardrailStageDocument, redactions, "redacted"))
 }
@@ -0,0 +1,247 @@
+package routes
+
+import (
+	"errors"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/MauricioAliendre182/backend/models"
+	"github.com/MauricioAliendre182/backend/utils"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// getOwnUsage returns the token usage and the quotas of the authenticated user
+// Supports ?from= and ?to= (RFC3339), the range defaults to the current month
+func getOwnUsage(c *gin.Context) {
+	from, to, err := parseReportRange(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	usage, err := models.GetUserUsage(getUserID(c), from, to)
+	if errors.Is(err, models.ErrUserNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"usage": usage,
+	})
+}
+
+// getUsageReport returns the token usage and estimated cost of every model (admin only)
+// Supports ?from= and ?to= (RFC3339, defaults to the current month) and ?group_by=model|user|team
+func getUsageReport(c *gin.Context) {
+	from, to, err := parseReportRange(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	groupBy := c.DefaultQuery("group_by", models.UsageGroupByModel)
+	if groupBy != models.UsageGroupByModel && groupBy != models.UsageGroupByUser && groupBy != models.UsageGroupByTeam {
+		c.JSON(http.StatusBadRequest, gin.H{"error": errInvalidQueryParam("group_by").Error()})
+		return
+	}
+
+	report, err := models.GetUsageReport(from, to, groupBy)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"report": report,
+	})
+}
+
+// getUsageQuotas lists the configured quotas (admin only)
+func getUsageQuotas(c *gin.Context) {
+	quotas, err := models.GetUsageQuotas()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"quotas": quotas,
+	})
+}
+
+// setUsageQuota creates a quota or changes the limit of an existing one (admin only)
+// A quota is identified by its scope, subject and period
+func setUsageQuota(c *gin.Context) {
+	type QuotaRequest struct {
+		Scope      string `json:"scope" binding:"required"`
+		Subject    string `json:"subject" binding:"required"`
+		Period     string `json:"period" binding:"required"`
+		TokenLimit int64  `json:"token_limit" binding:"required"`
+	}
+
+	var req QuotaRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	quota := models.UsageQuota{
+		Scope:      req.Scope,
+		Subject:    req.Subject,
+		Period:     req.Period,
+		TokenLimit: req.TokenLimit,
+		UpdatedBy:  getUserID(c),
+	}
+
+	if err := quota.ValidateQuota(); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := quota.Save(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Quota saved successfully",
+		"quota":   quota,
+	})
+}
+
+// deleteUsageQuota removes a quota (admin only)
+func deleteUsageQuota(c *gin.Context) {
+	quotaID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid quota ID"})
+		return
+	}
+
+	err = models.DeleteUsageQuota(quotaID)
+	if errors.Is(err, models.ErrUsageQuotaNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Quota not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Quota deleted successfully",
+	})
+}
+
+// setUserTeam assigns a user to a team, an empty team removes them from it (admin only)
+func setUserTeam(c *gin.Context) {
+	type TeamRequest struct {
+		Team string `json:"team"`
+	}
+
+	userID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return
+	}
+
+	var req TeamRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	err = models.SetUserTeam(userID.String(), req.Team)
+	if errors.Is(err, models.ErrUserNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Team updated successfully",
+		"user_id": userID,
+		"team":    req.Team,
+	})
+}
+
+// parseReportRange reads the ?from= and ?to= parameters of the usage and guardrail reports
+// The range defaults to the start of the current month until now
+func parseReportRange(c *gin.Context) (time.Time, time.Time, error) {
+	now := time.Now()
+	from := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, now.Location())
+	to := now
+
+	fromQuery, err := parseTimeQuery(c, "from")
+	if err != nil {
+		return from, to, err
+	}
+	if fromQuery != nil {
+		from = *fromQuery
+	}
+
+	toQuery, err := parseTimeQuery(c, "to")
+	if err != nil {
+		return from, to, err
+	}
+	if toQuery != nil {
+		to = *toQuery
+	}
+
+	if !from.Before(to) {
+		return from, to, errInvalidQueryParam("from")
+	}
+	return from, to, nil
+}
+
+// checkUsageQuota rejects the request with 429 when a quota of the user or their team is exhausted
+// It returns false when the request was rejected
+// A failure to read the quotas must not block users, so it is only logged
+func checkUsageQuota(c *gin.Context) bool {
+	err := models.CheckQuota(getUserID(c))
+
+	var quotaErr *models.QuotaExceededError
+	if errors.As(err, &quotaErr) {
+		status := quotaErr.Status
+		retryAfter := int64(math.Ceil(time.Until(status.ResetsAt).Seconds()))
+		if retryAfter > 0 {
+			c.Header("Retry-After", strconv.FormatInt(retryAfter, 10))
+		}
+
+		utils.LogWarn("Token quota exceeded",
+			"user_id", getUserID(c),
+			"scope", status.Scope,
+			"subject", status.Subject,
+			"period", status.Period,
+			"used", status.Used,
+			"limit", status.Limit)
+
+		c.JSON(http.StatusTooManyRequests, gin.H{
+			"error":   "Token quota exceeded",
+			"message": quotaErr.Error(),
+			"quota":   status,
+		})
+		return false
+	}
+	if err != nil {
+		utils.LogError("Failed to check token quota", err, "user_id", getUserID(c))
+	}
+	return true
+}
+
+// recordUsage stores the token usage collected while handling a request
+// A failure to record must never fail the request itself, so errors are only logged
+func recordUsage(c *gin.Context, questionID string, collector *utils.UsageCollector) {
+	if err := models.RecordUsage(getUserID(c), questionID, collector.Summary()); err != nil {
+		utils.LogError("Failed to record token usage", err, "user_id", getUserID(c))
+	}
+}
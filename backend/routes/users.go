@@ -98,7 +98,16 @@ func login(context *gin.Context) {
 	// The id is not part of the incoming request, so we need to get it from the database
 	// in ValidateCredentials method we get the id and the email from the database
 	// the id is stored in the user struct, hence is accessible here
-	tokens, err := utils.GenerateTokenPair(user.ID, user.Email)
+	// The access token carries the user's roles and permissions
+	access, err := models.ResolveUserAccess(user.ID, user.Email)
+	if err != nil {
+		context.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Could not get user roles.",
+		})
+		return
+	}
+
+	tokens, err := utils.GenerateTokenPair(user.ID, user.Email, access)
 
 	if err != nil {
 		context.JSON(http.StatusInternalServerError, gin.H{
@@ -270,16 +279,7 @@ func getUser(context *gin.Context) {
 }
 
 func getAllUsers(context *gin.Context) {
-	// See if user is admin
-	isAdmin, exists := context.Get("isAdmin")
-	if !exists || !isAdmin.(bool) {
-		context.JSON(http.StatusForbidden, gin.H{
-			"message": "Admin access required",
-		})
-		return
-	}
-
-	// Get all users from the database just if the user is admin
+	// Get all users from the database, the route requires the users:manage permission
 	users, err := models.GetAllUsers()
 	if err != nil {
 		context.JSON(http.StatusInternalServerError, gin.H{
@@ -335,11 +335,15 @@ func getOwnProfile(context *gin.Context) {
 	}
 
 	// Return the user profile
+	// The roles and permissions are the ones of the current access token
+	access := getUserAccess(context)
 	context.JSON(http.StatusOK, gin.H{
-		"id":     user.ID,
-		"name":   user.Name,
-		"email":  user.Email,
-		"avatar": user.Avatar,
+		"id":          user.ID,
+		"name":        user.Name,
+		"email":       user.Email,
+		"avatar":      user.Avatar,
+		"roles":       access.Roles,
+		"permissions": access.Permissions,
 	})
 }
 
@@ -372,8 +376,18 @@ func refreshToken(context *gin.Context) {
 		return
 	}
 
+	// Resolve the roles again, so role changes apply at the latest when the access token is refreshed
+	access, err := models.ResolveUserAccess(user.ID, user.Email)
+	if err != nil {
+		utils.LogError("Failed to get user roles", err, "user_id", user.ID)
+		context.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Could not get user roles",
+		})
+		return
+	}
+
 	// Generate new token pair
-	tokens, err := utils.GenerateTokenPair(user.ID, user.Email)
+	tokens, err := utils.GenerateTokenPair(user.ID, user.Email, access)
 	if err != nil {
 		context.JSON(http.StatusInternalServerError, gin.H{
 			"message": "Could not generate new tokens",
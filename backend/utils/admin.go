@@ -2,27 +2,14 @@ package utils
 
 import (
 	"strings"
-
-	"github.com/MauricioAliendre182/backend/db"
 )
 
-// CheckIfAdmin checks if a user is an admin based on their user ID
-// This function queries the database to get the user's email and checks
-// if it matches any of the admin emails configured in the environment
-func CheckIfAdmin(userID string) bool {
-	// Get the user's email from the database
-	userEmail, err := getUserEmailByID(userID)
-	if err != nil {
-		// If we can't get the user's email, they're not an admin
-		return false
-	}
-
-	// Get admin emails from environment configuration
-	adminEmails := getAdminEmails()
-
-	// Check if the user's email is in the admin list
-	for _, adminEmail := range adminEmails {
-		if strings.EqualFold(userEmail, adminEmail) {
+// IsAdminEmail reports whether an email is listed in ADMIN_EMAILS
+// The users listed get the admin role in their tokens on top of their own roles,
+// which bootstraps the first admin before any role is assigned in the database
+func IsAdminEmail(email string) bool {
+	for _, adminEmail := range getAdminEmails() {
+		if strings.EqualFold(email, adminEmail) {
 			return true
 		}
 	}
@@ -30,25 +17,6 @@ func CheckIfAdmin(userID string) bool {
 	return false
 }
 
-// getUserEmailByID retrieves a user's email from the database by their ID
-func getUserEmailByID(userID string) (string, error) {
-	query := `SELECT email FROM users WHERE id = $1`
-
-	stmt, err := db.DB.Prepare(query)
-	if err != nil {
-		return "", err
-	}
-	defer stmt.Close()
-
-	var email string
-	err = stmt.QueryRow(userID).Scan(&email)
-	if err != nil {
-		return "", err
-	}
-
-	return email, nil
-}
-
 // getAdminEmails returns a list of admin emails from environment configuration
 // Expected format: ADMIN_EMAILS=admin1@company.com,admin2@company.com,admin3@company.com
 func getAdminEmails() []string {
@@ -68,24 +68,21 @@ func TestGetAdminEmails(t *testing.T) {
 	}
 }
 
-func TestCheckIfAdmin_NoDatabase(t *testing.T) {
-	// This test only checks the logic without database interaction
-	// We can't easily test the full function without a test database
-
+func TestIsAdminEmail(t *testing.T) {
 	// Set up admin emails
 	os.Setenv("ADMIN_EMAILS", "admin@company.com,superuser@company.com")
 	defer os.Unsetenv("ADMIN_EMAILS")
 
-	adminEmails := getAdminEmails()
-	expectedEmails := []string{"admin@company.com", "superuser@company.com"}
-
-	if len(adminEmails) != len(expectedEmails) {
-		t.Errorf("Expected %d admin emails, got %d", len(expectedEmails), len(adminEmails))
+	tests := map[string]bool{
+		"admin@company.com":     true,
+		"SuperUser@Company.com": true,
+		"user@company.com":      false,
+		"":                      false,
 	}
 
-	for i, expected := range expectedEmails {
-		if adminEmails[i] != expected {
-			t.Errorf("Expected admin email at index %d to be '%s', got '%s'", i, expected, adminEmails[i])
+	for email, expected := range tests {
+		if result := IsAdminEmail(email); result != expected {
+			t.Errorf("IsAdminEmail(%q) = %v, expected %v", email, result, expected)
 		}
 	}
 }
@@ -1,25 +1,31 @@
 package utils
 
 import (
+	"context"
 	"fmt"
+	"strings"
 )
 
 // AIProvider represents the type of AI provider
 type AIProvider string
 
 const (
-	OpenAIProvider AIProvider = "openai"
-	GeminiProvider AIProvider = "gemini"
-	OllamaProvider AIProvider = "ollama"
+	OpenAIProvider           AIProvider = "openai"
+	GeminiProvider           AIProvider = "gemini"
+	OllamaProvider           AIProvider = "ollama"
+	OpenAICompatibleProvider AIProvider = "openai_compatible"
+	FakeProvider             AIProvider = "fake"
+	LexicalProvider          AIProvider = "lexical"
 )
 
 // EmbeddingService interface for embedding generation
 // This interface defines methods for generating embeddings
 // and getting the provider name
 // It allows different AI services to implement their own embedding generation logic
+// Every call takes a context so a client disconnect, a stage deadline or a shutdown cancels the provider request
 type EmbeddingService interface {
-	GenerateEmbedding(text string) (Vector, error)
-	GenerateBatchEmbeddings(texts []string) ([]Vector, error)
+	GenerateEmbedding(ctx context.Context, text string) (Vector, error)
+	GenerateBatchEmbeddings(ctx context.Context, texts []string) ([]Vector, error)
 	GetProviderName() string
 }
 
@@ -27,8 +33,11 @@ type EmbeddingService interface {
 // This interface defines methods for generating chat responses
 // It allows different AI services to implement their own chat response generation logic
 // It also provides methods to get the provider name and model used
+// Every call takes a context so a client disconnect, a stage deadline or a shutdown cancels the provider request
+// GenerateResponse sends the prompt as the user message and systemPrompt as the system instructions
+// Providers must not add instructions of their own, prompts are rendered from templates (see prompt_templates.go)
 type ChatService interface {
-	GenerateResponse(question, context string) (string, error)
+	GenerateResponse(ctx context.Context, prompt, systemPrompt string) (string, error)
 	GetProviderName() string
 	GetModel() string
 }
@@ -49,12 +58,81 @@ func NewAIServiceFactory(config *Config) *AIServiceFactory {
 	}
 }
 
+// KnownProviders returns every provider the factory can create
+func KnownProviders() []AIProvider {
+	return []AIProvider{OpenAIProvider, GeminiProvider, OllamaProvider, OpenAICompatibleProvider, FakeProvider, LexicalProvider}
+}
+
 // CreateEmbeddingService creates an embedding service based on configuration
 // It returns an instance of EmbeddingService for the configured provider
 // For example, it can return OpenAIEmbeddingService, GeminiEmbeddingService, or OllamaEmbeddingService
+// With a fallback chain, providers after the first one are only used for embeddings when they
+// produce vectors with the same model and dimension, otherwise stored and query vectors would not be comparable
 func (f *AIServiceFactory) CreateEmbeddingService() (EmbeddingService, error) {
-	provider := f.determineProvider()
+	chain := f.ProviderChain()
+	primary := chain[0]
+
+	primaryService, err := f.createEmbeddingServiceFor(primary)
+	if err != nil {
+		return nil, err
+	}
+
+	services := []EmbeddingService{primaryService}
+	breakers := []*CircuitBreaker{circuitBreakerFor("embedding:"+string(primary), f.config)}
+
+	for _, provider := range chain[1:] {
+		if !f.embeddingsCompatible(primary, provider) {
+			LogInfo("Provider excluded from embedding fallback, vectors would not be comparable",
+				"provider", provider,
+				"model", f.config.EmbeddingModelFor(provider),
+				"dimension", f.config.EmbeddingDimensionFor(provider),
+				"primary_model", f.config.EmbeddingModelFor(primary),
+				"primary_dimension", f.config.EmbeddingDimensionFor(primary),
+			)
+			continue
+		}
+
+		service, err := f.createEmbeddingServiceFor(provider)
+		if err != nil {
+			return nil, err
+		}
+		services = append(services, service)
+		breakers = append(breakers, circuitBreakerFor("embedding:"+string(provider), f.config))
+	}
+
+	if len(services) == 1 {
+		return primaryService, nil
+	}
+
+	return NewFailoverEmbeddingService(services, breakers), nil
+}
+
+// CreateChatService creates a chat service based on configuration
+// It returns an instance of ChatService for the configured provider
+// For example, it can return OpenAIChatService, GeminiChatService, or OllamaChatService
+// With a fallback chain it returns a FailoverChatService trying the providers in order
+func (f *AIServiceFactory) CreateChatService() (ChatService, error) {
+	chain := f.ProviderChain()
+	if len(chain) == 1 {
+		return f.createChatServiceFor(chain[0])
+	}
+
+	services := make([]ChatService, 0, len(chain))
+	breakers := make([]*CircuitBreaker, 0, len(chain))
+	for _, provider := range chain {
+		service, err := f.createChatServiceFor(provider)
+		if err != nil {
+			return nil, err
+		}
+		services = append(services, service)
+		breakers = append(breakers, circuitBreakerFor("chat:"+string(provider), f.config))
+	}
+
+	return NewFailoverChatService(services, breakers), nil
+}
 
+// createEmbeddingServiceFor creates the embedding service of a single provider
+func (f *AIServiceFactory) createEmbeddingServiceFor(provider AIProvider) (EmbeddingService, error) {
 	switch provider {
 	case OpenAIProvider:
 		return NewOpenAIEmbeddingService(f.config), nil
@@ -62,17 +140,19 @@ func (f *AIServiceFactory) CreateEmbeddingService() (EmbeddingService, error) {
 		return NewGeminiEmbeddingService(f.config), nil
 	case OllamaProvider:
 		return NewOllamaEmbeddingService(f.config), nil
+	case OpenAICompatibleProvider:
+		return NewOpenAICompatibleEmbeddingService(f.config), nil
+	case FakeProvider:
+		return NewFakeEmbeddingService(f.config), nil
+	case LexicalProvider:
+		return NewLexicalEmbeddingService(f.config), nil
 	default:
 		return nil, fmt.Errorf("unsupported AI provider: %s", provider)
 	}
 }
 
-// CreateChatService creates a chat service based on configuration
-// It returns an instance of ChatService for the configured provider
-// For example, it can return OpenAIChatService, GeminiChatService, or OllamaChatService
-func (f *AIServiceFactory) CreateChatService() (ChatService, error) {
-	provider := f.determineProvider()
-
+// createChatServiceFor creates the chat service of a single provider
+func (f *AIServiceFactory) createChatServiceFor(provider AIProvider) (ChatService, error) {
 	switch provider {
 	case OpenAIProvider:
 		return NewOpenAIChatService(f.config), nil
@@ -80,15 +160,41 @@ func (f *AIServiceFactory) CreateChatService() (ChatService, error) {
 		return NewGeminiChatService(f.config), nil
 	case OllamaProvider:
 		return NewOllamaChatService(f.config), nil
+	case OpenAICompatibleProvider:
+		return NewOpenAICompatibleChatService(f.config), nil
+	case FakeProvider:
+		return NewFakeChatService(), nil
+	case LexicalProvider:
+		return NewLexicalChatService(), nil
 	default:
 		return nil, fmt.Errorf("unsupported AI provider: %s", provider)
 	}
 }
 
+// embeddingsCompatible reports whether two providers produce comparable vectors
+func (f *AIServiceFactory) embeddingsCompatible(a, b AIProvider) bool {
+	return f.config.EmbeddingModelFor(a) == f.config.EmbeddingModelFor(b) &&
+		f.config.EmbeddingDimensionFor(a) == f.config.EmbeddingDimensionFor(b)
+}
+
+// ProviderChain returns the providers to use, in order
+// It is AI_FALLBACK_CHAIN when configured, otherwise the single provider picked by determineProvider
+func (f *AIServiceFactory) ProviderChain() []AIProvider {
+	if len(f.config.AIFallbackChain) > 0 {
+		return f.config.AIFallbackChain
+	}
+	return []AIProvider{f.determineProvider()}
+}
+
 // determineProvider determines which AI provider to use based on configuration
-// It checks the configuration for the presence of API keys or URLs
+// AI_PROVIDER selects the provider explicitly
+// Without it, the configuration is checked for the presence of API keys or URLs
 // Priority: Local AI (Ollama) > Gemini > OpenAI
 func (f *AIServiceFactory) determineProvider() AIProvider {
+	if f.config.Provider != "" {
+		return f.config.Provider
+	}
+
 	// Priority: Local AI (Ollama) > Gemini > OpenAI
 	if f.config.UseLocalAI {
 		return OllamaProvider
@@ -109,18 +215,38 @@ func (f *AIServiceFactory) determineProvider() AIProvider {
 // GetCurrentProvider returns the currently configured provider
 // This is useful for logging or debugging purposes
 // It returns the AIProvider enum value
-// that indicates which provider is currently set
+// that indicates which provider is currently set (the first one of a fallback chain)
 func (f *AIServiceFactory) GetCurrentProvider() AIProvider {
-	return f.determineProvider()
+	return f.ProviderChain()[0]
+}
+
+// UsesProvider reports whether a provider is part of the provider chain
+func (f *AIServiceFactory) UsesProvider(provider AIProvider) bool {
+	for _, p := range f.ProviderChain() {
+		if p == provider {
+			return true
+		}
+	}
+	return false
 }
 
 // ValidateConfiguration validates that the required configuration is present for the determined provider
 // It checks for the presence of API keys or URLs based on the provider
 // This ensures that the application has the necessary credentials to interact with the AI service
 // It returns an error if the configuration is invalid
+// With a fallback chain every provider of the chain must be configured
 func (f *AIServiceFactory) ValidateConfiguration() error {
-	provider := f.determineProvider()
+	for _, provider := range f.ProviderChain() {
+		if err := f.validateProvider(provider); err != nil {
+			return err
+		}
+	}
 
+	return nil
+}
+
+// validateProvider checks the configuration of a single provider
+func (f *AIServiceFactory) validateProvider(provider AIProvider) error {
 	switch provider {
 	case OpenAIProvider:
 		if f.config.OpenAIAPIKey == "" {
@@ -134,6 +260,17 @@ func (f *AIServiceFactory) ValidateConfiguration() error {
 		if f.config.OllamaBaseURL == "" {
 			return fmt.Errorf("OLLAMA_BASE_URL is required for Ollama provider")
 		}
+	case OpenAICompatibleProvider:
+		if f.config.OpenAICompatibleBaseURL == "" {
+			return fmt.Errorf("OPENAI_COMPATIBLE_BASE_URL is required for OpenAI-compatible provider")
+		}
+		if !strings.HasPrefix(f.config.OpenAICompatibleBaseURL, "http://") && !strings.HasPrefix(f.config.OpenAICompatibleBaseURL, "https://") {
+			return fmt.Errorf("OPENAI_COMPATIBLE_BASE_URL must start with http:// or https://")
+		}
+	case FakeProvider, LexicalProvider:
+		// These providers run in-process and need no configuration
+	default:
+		return fmt.Errorf("unsupported AI provider: %s", provider)
 	}
 
 	return nil
@@ -0,0 +1,163 @@
+package utils
+
+import (
+	"sync"
+	"time"
+)
+
+// CircuitState is the state of a circuit breaker
+type CircuitState string
+
+const (
+	// CircuitClosed lets every call through
+	CircuitClosed CircuitState = "closed"
+	// CircuitOpen rejects calls until the cooldown has passed
+	CircuitOpen CircuitState = "open"
+	// CircuitHalfOpen lets a single trial call through to probe the provider
+	CircuitHalfOpen CircuitState = "half_open"
+)
+
+// CircuitBreaker stops calling a provider after repeated failures
+// After failureThreshold consecutive failures the circuit opens and calls are skipped for cooldown,
+// then one trial call decides whether the circuit closes again or stays open for another cooldown
+type CircuitBreaker struct {
+	openedAt         time.Time
+	now              func() time.Time
+	name             string
+	state            CircuitState
+	failures         int
+	failureThreshold int
+	cooldown         time.Duration
+	trialInFlight    bool
+	mutex            sync.Mutex
+}
+
+// NewCircuitBreaker creates a closed circuit breaker
+func NewCircuitBreaker(name string, failureThreshold int, cooldown time.Duration) *CircuitBreaker {
+	if failureThreshold <= 0 {
+		failureThreshold = 1
+	}
+
+	return &CircuitBreaker{
+		name:             name,
+		state:            CircuitClosed,
+		failureThreshold: failureThreshold,
+		cooldown:         cooldown,
+		now:              time.Now,
+	}
+}
+
+// Allow reports whether a call may be made now
+// When the cooldown of an open circuit has passed, exactly one caller gets a trial call
+func (b *CircuitBreaker) Allow() bool {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	switch b.state {
+	case CircuitOpen:
+		if b.now().Sub(b.openedAt) < b.cooldown {
+			return false
+		}
+		b.state = CircuitHalfOpen
+		b.trialInFlight = true
+		LogInfo("Circuit breaker half-open, trying provider again", "breaker", b.name)
+		return true
+	case CircuitHalfOpen:
+		if b.trialInFlight {
+			return false
+		}
+		b.trialInFlight = true
+		return true
+	default:
+		return true
+	}
+}
+
+// RecordSuccess closes the circuit and resets the failure count
+func (b *CircuitBreaker) RecordSuccess() {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	if b.state != CircuitClosed {
+		LogInfo("Circuit breaker closed", "breaker", b.name)
+	}
+	b.state = CircuitClosed
+	b.failures = 0
+	b.trialInFlight = false
+}
+
+// RecordFailure counts a failed call and opens the circuit when the threshold is reached
+// A failed trial call reopens the circuit immediately
+func (b *CircuitBreaker) RecordFailure() {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	b.failures++
+	b.trialInFlight = false
+
+	if b.state == CircuitHalfOpen || b.failures >= b.failureThreshold {
+		if b.state != CircuitOpen {
+			LogWarn("Circuit breaker opened", "breaker", b.name, "failures", b.failures, "cooldown", b.cooldown.String())
+		}
+		b.state = CircuitOpen
+		b.openedAt = b.now()
+	}
+}
+
+// Release ends a call without counting it as a success or a failure
+// It is used when the caller gave up (e.g. the request was cancelled), so a half-open circuit can try again
+func (b *CircuitBreaker) Release() {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.trialInFlight = false
+}
+
+// State returns the current state of the circuit
+func (b *CircuitBreaker) State() CircuitState {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return b.state
+}
+
+// Circuit breakers are shared per provider and service kind,
+// so every RAG service created for a request sees the same provider health
+var (
+	circuitBreakers      = map[string]*CircuitBreaker{}
+	circuitBreakersMutex sync.Mutex
+)
+
+// circuitBreakerFor returns the shared circuit breaker for a name, creating it on first use
+func circuitBreakerFor(name string, config *Config) *CircuitBreaker {
+	circuitBreakersMutex.Lock()
+	defer circuitBreakersMutex.Unlock()
+
+	if breaker, ok := circuitBreakers[name]; ok {
+		return breaker
+	}
+
+	threshold := int(config.CircuitBreakerThreshold)
+	if threshold <= 0 {
+		threshold = 3
+	}
+	cooldown := time.Duration(config.CircuitBreakerCooldownSecs) * time.Second
+	if cooldown <= 0 {
+		cooldown = 30 * time.Second
+	}
+
+	breaker := NewCircuitBreaker(name, threshold, cooldown)
+	circuitBreakers[name] = breaker
+	return breaker
+}
+
+// GetCircuitBreakerStates returns the state of every circuit breaker created so far
+// It is used by the health endpoints to show which providers are being skipped
+func GetCircuitBreakerStates() map[string]CircuitState {
+	circuitBreakersMutex.Lock()
+	defer circuitBreakersMutex.Unlock()
+
+	states := make(map[string]CircuitState, len(circuitBreakers))
+	for name, breaker := range circuitBreakers {
+		states[name] = breaker.State()
+	}
+	return states
+}
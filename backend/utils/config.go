@@ -5,6 +5,7 @@ import (
 	"os"
 	"strconv"
 	"strings"
+	"time"
 )
 
 // Config holds application configuration
@@ -25,7 +26,82 @@ type Config struct {
 	ChunkSize           int64
 	RateLimitMaxTokens  int64
 	RateLimitRefillRate int64
-	UseLocalAI          bool
+	ContextWindowTokens int64
+	MaxAnswerTokens     int64
+	EmbeddingDimension  int64
+
+	// EmbeddingParallelism bounds the embedding requests of one batch running at the same time
+	EmbeddingParallelism int64
+
+	// API rate limiting, per client and per route
+	APIRateLimitEnabled  bool
+	APIRateLimitDefault  RouteRateLimit
+	APIRateLimitRoutes   map[string]RouteRateLimit
+	APIRateLimitIdleSecs int64
+
+	// TrustedProxies are the proxy IPs or CIDRs whose X-Forwarded-For / X-Real-IP headers give the client IP
+	TrustedProxies []string
+
+	// StructuredAnswers makes the structured JSON answer mode the default of /query
+	StructuredAnswers bool
+
+	// Grounding check of the answers against the retrieved chunks
+	// GroundingMinSupport is the share of supported claims below which the answer is replaced by an abstention (0 only flags)
+	// GroundingClaimThreshold is the share of a claim's terms that must appear in one chunk for the lexical check
+	GroundingCheckEnabled   bool
+	GroundingLLMJudge       bool
+	GroundingMinSupport     float64
+	GroundingClaimThreshold float64
+
+	// Guardrail policy file (YAML or JSON), empty uses the built-in policy
+	// GuardrailPolicyReloadSecs is the interval between checks of the file for changes (0 = only on SIGHUP)
+	GuardrailPolicyFile       string
+	GuardrailPolicyReloadSecs int64
+
+	// DefaultUserRole is the role of the users without any role in the database
+	DefaultUserRole string
+
+	// EvalDataDir is the directory of the evaluation data sets (CSV) the admin endpoint can run
+	EvalDataDir string
+
+	// ModelPrices overrides or extends the built-in prices used to estimate usage cost
+	ModelPrices map[string]ModelPrice
+
+	// Provider failover
+	// AIFallbackChain is the ordered list of providers tried for chat (empty = single provider)
+	// The per-provider maps override ChatModel, EmbeddingModel and EmbeddingDimension for one provider
+	AIFallbackChain            []AIProvider
+	ProviderChatModels         map[AIProvider]string
+	ProviderEmbeddingModels    map[AIProvider]string
+	ProviderEmbeddingDims      map[AIProvider]int64
+	CircuitBreakerThreshold    int64
+	CircuitBreakerCooldownSecs int64
+
+	// Explicit provider selection and OpenAI-compatible servers
+	// Provider is AI_PROVIDER, empty means "pick by configured keys"
+	Provider                   AIProvider
+	OpenAICompatibleBaseURL    string
+	OpenAICompatibleAPIKey     string
+	OpenAICompatibleAuthHeader string
+
+	// Per-stage deadlines in seconds, applied on top of the request context
+	EmbeddingTimeoutSecs int64
+	ChatTimeoutSecs      int64
+	RetrievalTimeoutSecs int64
+	IngestionTimeoutSecs int64
+
+	// Provider HTTP transport
+	AIHTTPProxyURL                string
+	AIHTTPCAFile                  string
+	AIHTTPTimeoutSecs             int64
+	AIHTTPConnectTimeoutSecs      int64
+	AIHTTPTLSHandshakeTimeoutSecs int64
+	AIHTTPIdleConnTimeoutSecs     int64
+	AIHTTPMaxIdleConns            int64
+	AIHTTPMaxIdleConnsPerHost     int64
+	AIHTTPDebug                   bool
+
+	UseLocalAI bool
 }
 
 // LoadConfig loads configuration from environment variables with fallbacks
@@ -46,6 +122,62 @@ func LoadConfig() (*Config, error) {
 		EmbeddingModel: getEnvWithDefault("EMBEDDING_MODEL", "text-embedding-3-small"),
 		ChatModel:      getEnvWithDefault("CHAT_MODEL", "gpt-3.5-turbo"),
 
+		// AI_PROVIDER selects the provider explicitly: openai, gemini, ollama, openai_compatible, fake or lexical
+		// fake runs offline with hash-based embeddings and extractive answers (development and CI only)
+		// lexical is a no-LLM search mode: TF-IDF embeddings computed in-process and extractive answers
+		// OPENAI_COMPATIBLE_* configure a self-hosted server speaking the OpenAI API (vLLM, LM Studio, llama.cpp server)
+		// The API key is optional, OPENAI_COMPATIBLE_AUTH_HEADER changes the header it is sent in (default Authorization: Bearer)
+		Provider:                   AIProvider(strings.ToLower(strings.TrimSpace(os.Getenv("AI_PROVIDER")))),
+		OpenAICompatibleBaseURL:    os.Getenv("OPENAI_COMPATIBLE_BASE_URL"),
+		OpenAICompatibleAPIKey:     os.Getenv("OPENAI_COMPATIBLE_API_KEY"),
+		OpenAICompatibleAuthHeader: getEnvWithDefault("OPENAI_COMPATIBLE_AUTH_HEADER", "Authorization"),
+
+		// EMBEDDING_DIMENSION must match the vector column of the chunks table
+		EmbeddingDimension: getEnvIntWithDefault("EMBEDDING_DIMENSION", 1536),
+
+		// EMBEDDING_PARALLELISM is the number of concurrent embedding requests while ingesting a document
+		// Requests still go through the provider rate limiter, raise RATE_LIMIT_* together with it
+		EmbeddingParallelism: getEnvIntWithDefault("EMBEDDING_PARALLELISM", 4),
+
+		// Provider failover
+		// AI_FALLBACK_CHAIN is a comma-separated list such as "ollama,gemini,openai"
+		// <PROVIDER>_CHAT_MODEL, <PROVIDER>_EMBEDDING_MODEL and <PROVIDER>_EMBEDDING_DIMENSION configure each provider of the chain
+		// A provider whose circuit breaker is open is skipped for CIRCUIT_BREAKER_COOLDOWN_SECONDS
+		AIFallbackChain:            parseProviderList(os.Getenv("AI_FALLBACK_CHAIN")),
+		ProviderChatModels:         getProviderEnv("CHAT_MODEL"),
+		ProviderEmbeddingModels:    getProviderEnv("EMBEDDING_MODEL"),
+		ProviderEmbeddingDims:      getProviderIntEnv("EMBEDDING_DIMENSION"),
+		CircuitBreakerThreshold:    getEnvIntWithDefault("CIRCUIT_BREAKER_FAILURE_THRESHOLD", 3),
+		CircuitBreakerCooldownSecs: getEnvIntWithDefault("CIRCUIT_BREAKER_COOLDOWN_SECONDS", 30),
+
+		// Stage deadlines
+		// Each stage of a request gets its own deadline so a stuck provider cannot hold a request forever
+		// INGESTION_TIMEOUT_SECONDS covers chunking and embedding a whole upload
+		EmbeddingTimeoutSecs: getEnvIntWithDefault("EMBEDDING_TIMEOUT_SECONDS", 30),
+		ChatTimeoutSecs:      getEnvIntWithDefault("CHAT_TIMEOUT_SECONDS", 60),
+		RetrievalTimeoutSecs: getEnvIntWithDefault("RETRIEVAL_TIMEOUT_SECONDS", 10),
+		IngestionTimeoutSecs: getEnvIntWithDefault("INGESTION_TIMEOUT_SECONDS", 300),
+
+		// Provider HTTP transport, shared by every AI provider
+		// AI_HTTP_PROXY_URL overrides the standard HTTP(S)_PROXY variables for provider calls only
+		// AI_HTTP_CA_FILE is a PEM bundle trusted in addition to the system roots (corporate TLS proxy)
+		// AI_HTTP_DEBUG logs requests and responses with credentials redacted, bodies include user content
+		AIHTTPProxyURL:                os.Getenv("AI_HTTP_PROXY_URL"),
+		AIHTTPCAFile:                  os.Getenv("AI_HTTP_CA_FILE"),
+		AIHTTPTimeoutSecs:             getEnvIntWithDefault("AI_HTTP_TIMEOUT_SECONDS", 120),
+		AIHTTPConnectTimeoutSecs:      getEnvIntWithDefault("AI_HTTP_CONNECT_TIMEOUT_SECONDS", 10),
+		AIHTTPTLSHandshakeTimeoutSecs: getEnvIntWithDefault("AI_HTTP_TLS_HANDSHAKE_TIMEOUT_SECONDS", 10),
+		AIHTTPIdleConnTimeoutSecs:     getEnvIntWithDefault("AI_HTTP_IDLE_CONN_TIMEOUT_SECONDS", 90),
+		AIHTTPMaxIdleConns:            getEnvIntWithDefault("AI_HTTP_MAX_IDLE_CONNS", 100),
+		AIHTTPMaxIdleConnsPerHost:     getEnvIntWithDefault("AI_HTTP_MAX_IDLE_CONNS_PER_HOST", 10),
+		AIHTTPDebug:                   getBoolEnvWithDefault("AI_HTTP_DEBUG", false),
+
+		// Context assembly
+		// CONTEXT_WINDOW_TOKENS overrides the chat model's known context window (0 = use the known window)
+		// MAX_ANSWER_TOKENS is the room reserved for the answer and the max output tokens sent to providers
+		ContextWindowTokens: getEnvIntWithDefault("CONTEXT_WINDOW_TOKENS", 0),
+		MaxAnswerTokens:     getEnvIntWithDefault("MAX_ANSWER_TOKENS", 1000),
+
 		// Application defaults
 		Environment: getEnvWithDefault("ENVIRONMENT", "development"),
 		Port:        getEnvWithDefault("PORT", "8090"),
@@ -57,6 +189,50 @@ func LoadConfig() (*Config, error) {
 		// Rate limiting defaults
 		RateLimitMaxTokens:  getEnvIntWithDefault("RATE_LIMIT_MAX_TOKENS", 10),
 		RateLimitRefillRate: getEnvIntWithDefault("RATE_LIMIT_REFILL_RATE", 1),
+
+		// API rate limiting
+		// Every client (user ID, or IP for /auth routes) gets its own bucket per route
+		// API_RATE_LIMIT_DEFAULT applies to authenticated routes, API_RATE_LIMIT_ROUTES overrides named routes
+		// Limits are <requests>/<period>, e.g. "120/m" or "10/30s", idle buckets are dropped after API_RATE_LIMIT_IDLE_SECONDS
+		APIRateLimitEnabled:  getBoolEnvWithDefault("API_RATE_LIMIT_ENABLED", true),
+		APIRateLimitDefault:  getRouteRateLimitEnv("API_RATE_LIMIT_DEFAULT", "120/m"),
+		APIRateLimitRoutes:   parseRouteRateLimits(getEnvWithDefault("API_RATE_LIMIT_ROUTES", "auth=10/m,query=20/m,upload=10/m")),
+		APIRateLimitIdleSecs: getEnvIntWithDefault("API_RATE_LIMIT_IDLE_SECONDS", 600),
+
+		// Proxies allowed to set the client IP (comma-separated IPs or CIDRs, e.g. "10.0.0.0/8")
+		// Without any, the client IP is the address of the connection, so a client cannot pick its rate limit bucket
+		// by sending X-Forwarded-For
+		TrustedProxies: getListEnv("TRUSTED_PROXIES"),
+
+		// Structured answers (JSON with sources, confidence and an answerable flag)
+		// Clients can always choose per request with "structured" in the query body
+		StructuredAnswers: getBoolEnvWithDefault("STRUCTURED_ANSWERS", false),
+
+		// Grounding check
+		// Answers are split into claims verified against the chunks sent as context, unsupported claims are flagged
+		// It is off by default, an answer that fails it is replaced by an abstention
+		// GROUNDING_LLM_JUDGE asks the chat model for the final verdict (one extra call per answer)
+		GroundingCheckEnabled:   getBoolEnvWithDefault("GROUNDING_CHECK_ENABLED", false),
+		GroundingLLMJudge:       getBoolEnvWithDefault("GROUNDING_LLM_JUDGE", false),
+		GroundingMinSupport:     getFloatEnvWithDefault("GROUNDING_MIN_SUPPORT", 0.5),
+		GroundingClaimThreshold: getFloatEnvWithDefault("GROUNDING_CLAIM_THRESHOLD", 0.6),
+
+		// Guardrail policy
+		// GUARDRAIL_POLICY_FILE replaces the built-in blocked phrases, topics and length limits
+		// The file is reloaded on SIGHUP and when it changes, an invalid edit keeps the previous policy
+		GuardrailPolicyFile:       os.Getenv("GUARDRAIL_POLICY_FILE"),
+		GuardrailPolicyReloadSecs: getEnvIntWithDefault("GUARDRAIL_POLICY_RELOAD_SECONDS", 10),
+
+		// Role based access control
+		// DEFAULT_USER_ROLE is given to users without any role, ADMIN_EMAILS adds the admin role (see IsAdminEmail)
+		DefaultUserRole: getEnvWithDefault("DEFAULT_USER_ROLE", RoleViewer),
+
+		// Evaluation data sets, see the eval package
+		EvalDataDir: getEnvWithDefault("EVAL_DATA_DIR", "test-data"),
+
+		// Usage cost estimation
+		// MODEL_PRICES lists <model>=<input>:<output> USD prices per million tokens, e.g. "gpt-4o-mini=0.15:0.60"
+		ModelPrices: parseModelPrices(getEnvWithDefault("MODEL_PRICES", "")),
 	}
 
 	// Validate configuration
@@ -65,7 +241,12 @@ func LoadConfig() (*Config, error) {
 	}
 
 	// Validate AI configuration
-	if config.UseLocalAI {
+	// An explicit provider or a fallback chain is validated provider by provider by the AI service factory
+	if config.Provider != "" || len(config.AIFallbackChain) > 0 {
+		if err := NewAIServiceFactory(config).ValidateConfiguration(); err != nil {
+			return nil, err
+		}
+	} else if config.UseLocalAI {
 		if config.OllamaBaseURL == "" {
 			return nil, fmt.Errorf("OLLAMA_BASE_URL is required when USE_LOCAL_AI=true")
 		}
@@ -76,6 +257,121 @@ func LoadConfig() (*Config, error) {
 	return config, nil
 }
 
+// ChatModelFor returns the chat model used with a provider
+// <PROVIDER>_CHAT_MODEL takes precedence over CHAT_MODEL
+func (c *Config) ChatModelFor(provider AIProvider) string {
+	if model := c.ProviderChatModels[provider]; model != "" {
+		return model
+	}
+	return c.ChatModel
+}
+
+// inProcessEmbeddingModels names the embedding "models" of the providers running in-process
+// They are fixed so these providers are never mistaken for a compatible embedding fallback
+var inProcessEmbeddingModels = map[AIProvider]string{
+	FakeProvider:    "fake-feature-hashing",
+	LexicalProvider: "lexical-tfidf",
+}
+
+// EmbeddingModelFor returns the embedding model used with a provider
+// <PROVIDER>_EMBEDDING_MODEL takes precedence over EMBEDDING_MODEL
+func (c *Config) EmbeddingModelFor(provider AIProvider) string {
+	if model, ok := inProcessEmbeddingModels[provider]; ok {
+		return model
+	}
+	if model := c.ProviderEmbeddingModels[provider]; model != "" {
+		return model
+	}
+	return c.EmbeddingModel
+}
+
+// EmbeddingDimensionFor returns the dimension of the vectors produced by a provider
+// <PROVIDER>_EMBEDDING_DIMENSION takes precedence over EMBEDDING_DIMENSION
+func (c *Config) EmbeddingDimensionFor(provider AIProvider) int {
+	if dimension := c.ProviderEmbeddingDims[provider]; dimension > 0 {
+		return int(dimension)
+	}
+	if c.EmbeddingDimension > 0 {
+		return int(c.EmbeddingDimension)
+	}
+	return 1536
+}
+
+// parseProviderList parses a comma-separated list of provider names
+// Names are lower-cased and duplicates are dropped, unknown names are kept so validation can report them
+func parseProviderList(value string) []AIProvider {
+	var providers []AIProvider
+	seen := make(map[AIProvider]bool)
+
+	for _, name := range strings.Split(value, ",") {
+		provider := AIProvider(strings.ToLower(strings.TrimSpace(name)))
+		if provider == "" || seen[provider] {
+			continue
+		}
+		seen[provider] = true
+		providers = append(providers, provider)
+	}
+
+	return providers
+}
+
+// getProviderEnv reads <PROVIDER>_<suffix> for every known provider
+func getProviderEnv(suffix string) map[AIProvider]string {
+	values := make(map[AIProvider]string)
+	for _, provider := range KnownProviders() {
+		if value := os.Getenv(strings.ToUpper(string(provider)) + "_" + suffix); value != "" {
+			values[provider] = value
+		}
+	}
+	return values
+}
+
+// getProviderIntEnv reads <PROVIDER>_<suffix> as an int for every known provider
+func getProviderIntEnv(suffix string) map[AIProvider]int64 {
+	values := make(map[AIProvider]int64)
+	for _, provider := range KnownProviders() {
+		if value := getEnvIntWithDefault(strings.ToUpper(string(provider))+"_"+suffix, 0); value > 0 {
+			values[provider] = value
+		}
+	}
+	return values
+}
+
+// RouteRateLimitFor returns the API rate limit of a route, falling back to the default limit
+func (c *Config) RouteRateLimitFor(route string) RouteRateLimit {
+	if limit, ok := c.APIRateLimitRoutes[strings.ToLower(route)]; ok {
+		return limit
+	}
+	if c.APIRateLimitDefault.Requests > 0 {
+		return c.APIRateLimitDefault
+	}
+	return RouteRateLimit{Requests: 120, Per: time.Minute}
+}
+
+// getRouteRateLimitEnv parses a rate limit variable, using the default when it is missing or invalid
+func getRouteRateLimitEnv(key, defaultValue string) RouteRateLimit {
+	if value := os.Getenv(key); value != "" {
+		limit, err := ParseRouteRateLimit(value)
+		if err == nil {
+			return limit
+		}
+		LogWarn("Invalid rate limit, using the default", "key", key, "value", value, "default", defaultValue)
+	}
+	limit, _ := ParseRouteRateLimit(defaultValue)
+	return limit
+}
+
+// getListEnv returns the comma-separated values of an environment variable, trimmed and without empty ones
+func getListEnv(key string) []string {
+	var values []string
+	for _, value := range strings.Split(os.Getenv(key), ",") {
+		if value = strings.TrimSpace(value); value != "" {
+			values = append(values, value)
+		}
+	}
+	return values
+}
+
 // getEnvWithDefault returns environment variable value or default
 func getEnvWithDefault(key, defaultValue string) string {
 	if value := os.Getenv(key); value != "" {
@@ -104,6 +400,16 @@ func getBoolEnvWithDefault(key string, defaultValue bool) bool {
 	return defaultValue
 }
 
+// getFloatEnvWithDefault reads a decimal environment variable, using the default when it is missing or invalid
+func getFloatEnvWithDefault(key string, defaultValue float64) float64 {
+	if value := os.Getenv(key); value != "" {
+		if parsed, err := strconv.ParseFloat(value, 64); err == nil {
+			return parsed
+		}
+	}
+	return defaultValue
+}
+
 // Global config instance
 // This variable is used throughout the application to access configuration settings
 // It is initialized in the InitConfig function
@@ -139,7 +445,8 @@ func ValidateConfig(config *Config) error {
 	}
 
 	// Validate OpenAI API key format if provided
-	if config.OpenAIAPIKey != "" {
+	// Only keys used with the hosted OpenAI API have the "sk-" format
+	if config.OpenAIAPIKey != "" && NewAIServiceFactory(config).UsesProvider(OpenAIProvider) {
 		if len(config.OpenAIAPIKey) < 10 || !strings.HasPrefix(config.OpenAIAPIKey, "sk-") {
 			return fmt.Errorf("invalid OpenAI API key format")
 		}
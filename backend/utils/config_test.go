@@ -38,6 +38,7 @@ func TestLoadConfig(t *testing.T) {
 				assert.Equal(t, "gpt-3.5-turbo", config.ChatModel)
 				assert.Equal(t, "8090", config.Port)
 				assert.Equal(t, "test", config.Environment)
+				assert.False(t, config.GroundingCheckEnabled)
 			},
 		},
 		{
@@ -0,0 +1,300 @@
+package utils
+
+import (
+	"strings"
+	"sync"
+	"unicode"
+
+	tiktoken "github.com/pkoukk/tiktoken-go"
+)
+
+// defaultContextWindow is used for chat models that are not listed in modelContextWindows
+// It is deliberately small so unknown local models are not overloaded
+const defaultContextWindow = 4096
+
+// contextSafetyMargin is the share of the context window kept free
+// Token counts for non-OpenAI models are estimates, so we never fill the window completely
+const contextSafetyMargin = 0.05
+
+// modelContextWindows maps chat model name prefixes to their context window in tokens
+// The longest matching prefix wins, so "gpt-4o" is matched before "gpt-4"
+var modelContextWindows = map[string]int{
+	// OpenAI
+	"gpt-3.5-turbo": 16385,
+	"gpt-4":         8192,
+	"gpt-4-32k":     32768,
+	"gpt-4-turbo":   128000,
+	"gpt-4o":        128000,
+	"gpt-4.1":       1047576,
+	"o1":            200000,
+	"o3":            200000,
+
+	// Gemini
+	"gemini-pro":       32760,
+	"gemini-1.0-pro":   32760,
+	"gemini-1.5-flash": 1048576,
+	"gemini-1.5-pro":   2097152,
+	"gemini-2.0-flash": 1048576,
+	"gemini-2.5":       1048576,
+
+	// Local models served by Ollama
+	"llama2":    4096,
+	"llama3":    8192,
+	"llama3.1":  131072,
+	"llama3.2":  131072,
+	"mistral":   32768,
+	"mixtral":   32768,
+	"phi3":      4096,
+	"gemma":     8192,
+	"gemma2":    8192,
+	"qwen2":     32768,
+	"qwen2.5":   32768,
+	"tinyllama": 2048,
+}
+
+// ContextWindowForModel returns the context window of a chat model in tokens
+// Provider prefixes ("models/") and Ollama tags (":8b") are ignored when matching
+func ContextWindowForModel(model string) int {
+	name := strings.ToLower(strings.TrimPrefix(model, "models/"))
+	if i := strings.Index(name, ":"); i >= 0 {
+		name = name[:i]
+	}
+
+	bestPrefix := ""
+	window := defaultContextWindow
+	for prefix, size := range modelContextWindows {
+		if strings.HasPrefix(name, prefix) && len(prefix) > len(bestPrefix) {
+			bestPrefix = prefix
+			window = size
+		}
+	}
+
+	return window
+}
+
+// ChatContextWindow returns the context window used for the configured chat model
+// CONTEXT_WINDOW_TOKENS takes precedence over the known window of the model
+// With a fallback chain the smallest window of the chain is used, so any provider can take the prompt
+func ChatContextWindow(config *Config) int {
+	if config.ContextWindowTokens > 0 {
+		return int(config.ContextWindowTokens)
+	}
+	return ContextWindowForModel(ChatContextModel(config))
+}
+
+// ChatContextModel returns the chat model the context is sized for: the one with the smallest window of the chain
+// Its tokenizer counts the prompt and the chunks, so the count and the budget refer to the same model
+func ChatContextModel(config *Config) string {
+	model, window := config.ChatModel, 0
+	for _, provider := range NewAIServiceFactory(config).ProviderChain() {
+		candidate := config.ChatModelFor(provider)
+		if size := ContextWindowForModel(candidate); window == 0 || size < window {
+			model, window = candidate, size
+		}
+	}
+	return model
+}
+
+// AnswerTokenLimit returns the number of tokens reserved for the generated answer
+// Providers use the same value as their max output tokens so the reservation always holds
+func AnswerTokenLimit(config *Config) int {
+	if config.MaxAnswerTokens > 0 {
+		return int(config.MaxAnswerTokens)
+	}
+	return 1000
+}
+
+// TokenCounter returns the number of tokens in a text
+type TokenCounter func(text string) int
+
+// encodingCache keeps the tiktoken encoding per model
+// A nil entry means no encoding is available and the estimator must be used
+var (
+	encodingCache      = map[string]*tiktoken.Tiktoken{}
+	encodingCacheMutex sync.Mutex
+)
+
+// NewChatTokenCounter returns a TokenCounter for a chat model
+// It uses the model's tiktoken encoding when known, cl100k_base for other models,
+// and falls back to EstimateTokens when no encoding can be loaded (e.g. offline)
+func NewChatTokenCounter(model string) TokenCounter {
+	encodingCacheMutex.Lock()
+	enc, cached := encodingCache[model]
+	if !cached {
+		var err error
+		enc, err = tiktoken.EncodingForModel(model)
+		if err != nil {
+			enc, err = tiktoken.GetEncoding("cl100k_base")
+		}
+		if err != nil {
+			LogWarn("No tokenizer available for chat model, estimating token counts", "model", model, "error", err)
+			enc = nil
+		}
+		encodingCache[model] = enc
+	}
+	encodingCacheMutex.Unlock()
+
+	if enc == nil {
+		return EstimateTokens
+	}
+
+	return func(text string) int {
+		return len(enc.Encode(text, nil, nil))
+	}
+}
+
+// EstimateTokens approximates the token count of a text (about 4 characters per token)
+func EstimateTokens(text string) int {
+	runes := len([]rune(text))
+	return (runes + 3) / 4
+}
+
+// ContextTokenBudget returns how many tokens can be spent on retrieved context
+// contextWindow is the model's window, promptTokens the size of the prompt without context
+// and answerTokens the room reserved for the generated answer
+func ContextTokenBudget(contextWindow, promptTokens, answerTokens int) int {
+	margin := int(float64(contextWindow) * contextSafetyMargin)
+	budget := contextWindow - margin - promptTokens - answerTokens
+	if budget < 0 {
+		return 0
+	}
+	return budget
+}
+
+// PackedContext is the result of packing ranked chunks into a token budget
+type PackedContext struct {
+	// Text is the assembled context
+	Text string
+	// Included holds the indexes of the chunks that were (fully or partially) included
+	Included []int
+	// Tokens is the token count of Text
+	Tokens int
+	// Truncated is true when the last included chunk was cut at a sentence boundary
+	Truncated bool
+}
+
+// ContextPacker fills a token budget with chunks in rank order
+type ContextPacker struct {
+	// CountTokens counts the tokens of a text
+	CountTokens TokenCounter
+	// Format renders a chunk as it appears in the context, position is 0-based
+	Format func(position int, content string) string
+	// MaxTokens is the token budget for the whole context
+	MaxTokens int
+}
+
+// Pack adds chunks by rank until the budget is used
+// The first chunk that does not fit is truncated at the last sentence boundary that fits,
+// or at the last word that fits when not even its first sentence does (e.g. a chunk that is one long sentence),
+// and packing stops there so lower ranked chunks never displace higher ranked ones
+func (p *ContextPacker) Pack(chunks []string) PackedContext {
+	var packed PackedContext
+	var builder strings.Builder
+
+	format := p.Format
+	if format == nil {
+		format = func(_ int, content string) string { return content }
+	}
+	count := p.CountTokens
+	if count == nil {
+		count = EstimateTokens
+	}
+
+	for i, chunk := range chunks {
+		position := len(packed.Included)
+		remaining := p.MaxTokens - packed.Tokens
+
+		entry := format(position, chunk)
+		entryTokens := count(entry)
+		if entryTokens <= remaining {
+			builder.WriteString(entry)
+			packed.Tokens += entryTokens
+			packed.Included = append(packed.Included, i)
+			continue
+		}
+
+		// Keep as many whole sentences of this chunk as fit in the remaining budget,
+		// or as many words when no sentence fits
+		entry, entryTokens = p.truncate(position, chunk, remaining)
+		if entry != "" {
+			builder.WriteString(entry)
+			packed.Tokens += entryTokens
+			packed.Included = append(packed.Included, i)
+			packed.Truncated = true
+		}
+		break
+	}
+
+	packed.Text = builder.String()
+	return packed
+}
+
+// truncate returns the longest formatted prefix of a chunk that fits in remaining tokens, with its token count
+// The prefix ends at a sentence boundary when one fits, at a word boundary otherwise, it is empty when nothing fits
+func (p *ContextPacker) truncate(position int, chunk string, remaining int) (string, int) {
+	format := p.Format
+	if format == nil {
+		format = func(_ int, content string) string { return content }
+	}
+	count := p.CountTokens
+	if count == nil {
+		count = EstimateTokens
+	}
+
+	sentences := SplitSentences(chunk)
+	for n := len(sentences) - 1; n > 0; n-- {
+		entry := format(position, strings.Join(sentences[:n], " "))
+		if tokens := count(entry); tokens <= remaining {
+			return entry, tokens
+		}
+	}
+
+	// Not even the first sentence fits, binary search the number of its words that do
+	words := strings.Fields(chunk)
+	if len(sentences) > 0 {
+		words = strings.Fields(sentences[0])
+	}
+	best, bestTokens := "", 0
+	low, high := 1, len(words)
+	for low <= high {
+		n := (low + high) / 2
+		entry := format(position, strings.Join(words[:n], " "))
+		if tokens := count(entry); tokens <= remaining {
+			best, bestTokens = entry, tokens
+			low = n + 1
+		} else {
+			high = n - 1
+		}
+	}
+	return best, bestTokens
+}
+
+// SplitSentences splits a text into sentences
+// A sentence ends with '.', '!' or '?' followed by whitespace, or with a line break
+func SplitSentences(text string) []string {
+	var sentences []string
+	runes := []rune(text)
+	start := 0
+
+	appendSentence := func(end int) {
+		sentence := strings.TrimSpace(string(runes[start:end]))
+		if sentence != "" {
+			sentences = append(sentences, sentence)
+		}
+		start = end
+	}
+
+	for i, r := range runes {
+		switch {
+		case r == '\n':
+			appendSentence(i + 1)
+		case r == '.' || r == '!' || r == '?':
+			if i+1 == len(runes) || unicode.IsSpace(runes[i+1]) {
+				appendSentence(i + 1)
+			}
+		}
+	}
+	appendSentence(len(runes))
+
+	return sentences
+}
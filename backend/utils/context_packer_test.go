@@ -0,0 +1,150 @@
+package utils
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestContextWindowForModel(t *testing.T) {
+	tests := []struct {
+		model    string
+		expected int
+	}{
+		{"gpt-3.5-turbo", 16385},
+		{"gpt-4", 8192},
+		{"gpt-4o-mini", 128000},
+		{"models/gemini-1.5-flash", 1048576},
+		{"llama3.1:8b", 131072},
+		{"llama3:latest", 8192},
+		{"some-unknown-model", defaultContextWindow},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.model, func(t *testing.T) {
+			assert.Equal(t, tt.expected, ContextWindowForModel(tt.model))
+		})
+	}
+}
+
+func TestChatContextWindowOverride(t *testing.T) {
+	config := &Config{ChatModel: "llama3", ContextWindowTokens: 2048}
+	assert.Equal(t, 2048, ChatContextWindow(config))
+
+	config.ContextWindowTokens = 0
+	assert.Equal(t, 8192, ChatContextWindow(config))
+}
+
+func TestChatContextModel(t *testing.T) {
+	config := &Config{
+		ChatModel:          "gpt-4o",
+		AIFallbackChain:    []AIProvider{OpenAIProvider, OllamaProvider},
+		ProviderChatModels: map[AIProvider]string{OllamaProvider: "llama3"},
+	}
+
+	// The window and the tokenizer both come from the model with the smallest window of the chain
+	assert.Equal(t, "llama3", ChatContextModel(config))
+	assert.Equal(t, 8192, ChatContextWindow(config))
+
+	config.AIFallbackChain = []AIProvider{OpenAIProvider}
+	assert.Equal(t, "gpt-4o", ChatContextModel(config))
+	assert.Equal(t, 128000, ChatContextWindow(config))
+}
+
+func TestContextTokenBudget(t *testing.T) {
+	// 5% of 1000 is kept as safety margin
+	assert.Equal(t, 1000-50-200-300, ContextTokenBudget(1000, 200, 300))
+	assert.Equal(t, 0, ContextTokenBudget(1000, 900, 300))
+}
+
+func TestSplitSentences(t *testing.T) {
+	text := "Employees get 15 days. Part-time staff get pro-rated days!\nIs it paid? Version 1.5 applies"
+
+	assert.Equal(t, []string{
+		"Employees get 15 days.",
+		"Part-time staff get pro-rated days!",
+		"Is it paid?",
+		"Version 1.5 applies",
+	}, SplitSentences(text))
+}
+
+func TestContextPackerPack(t *testing.T) {
+	// One token per word keeps the expectations easy to read
+	countWords := func(text string) int { return len(strings.Fields(text)) }
+	format := func(position int, content string) string {
+		return fmt.Sprintf("Doc%d: %s\n", position+1, content)
+	}
+
+	chunks := []string{
+		"one two three.",                        // 4 tokens with the header
+		"four five. six seven. eight nine ten.", // 9 tokens with the header
+		"eleven twelve.",
+	}
+
+	tests := []struct {
+		name              string
+		expectedText      string
+		expectedIncluded  []int
+		maxTokens         int
+		expectedTruncated bool
+	}{
+		{
+			name:             "Everything fits",
+			maxTokens:        100,
+			expectedIncluded: []int{0, 1, 2},
+			expectedText:     "Doc1: one two three.\nDoc2: four five. six seven. eight nine ten.\nDoc3: eleven twelve.\n",
+		},
+		{
+			name:              "Last chunk truncated at a sentence boundary",
+			maxTokens:         10,
+			expectedIncluded:  []int{0, 1},
+			expectedTruncated: true,
+			expectedText:      "Doc1: one two three.\nDoc2: four five. six seven.\n",
+		},
+		{
+			name:              "First sentence of the last chunk cut at a word boundary",
+			maxTokens:         7,
+			expectedIncluded:  []int{0, 1},
+			expectedTruncated: true,
+			expectedText:      "Doc1: one two three.\nDoc2: four five.\n",
+		},
+		{
+			name:             "Nothing of the second chunk fits",
+			maxTokens:        5,
+			expectedIncluded: []int{0},
+			expectedText:     "Doc1: one two three.\n",
+		},
+		{
+			name:         "Budget too small for anything",
+			maxTokens:    1,
+			expectedText: "",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			packer := ContextPacker{MaxTokens: tt.maxTokens, CountTokens: countWords, Format: format}
+			packed := packer.Pack(chunks)
+
+			assert.Equal(t, tt.expectedText, packed.Text)
+			assert.Equal(t, tt.expectedIncluded, packed.Included)
+			assert.Equal(t, tt.expectedTruncated, packed.Truncated)
+			assert.LessOrEqual(t, packed.Tokens, tt.maxTokens)
+		})
+	}
+}
+
+func TestContextPackerTruncatesOversizedSentence(t *testing.T) {
+	countWords := func(text string) int { return len(strings.Fields(text)) }
+	chunks := []string{"a single sentence that is far longer than the whole budget of the context"}
+
+	packer := ContextPacker{MaxTokens: 4, CountTokens: countWords}
+	packed := packer.Pack(chunks)
+
+	assert.Equal(t, "a single sentence that", packed.Text)
+	assert.Equal(t, []int{0}, packed.Included, "the chunk is truncated, not dropped")
+	assert.True(t, packed.Truncated)
+	assert.Equal(t, 4, packed.Tokens)
+}
@@ -1,6 +1,8 @@
 package utils
 
 import (
+	"context"
+	"database/sql"
 	"fmt"
 )
 
@@ -36,22 +38,54 @@ func InitEmbeddingService() error {
 // GetEmbedding generates embeddings using the configured AI service
 // This function takes a text input and returns its embedding as a Vector
 // It uses the global embedding service instance initialized in InitEmbeddingService
-func GetEmbedding(text string) (Vector, error) {
+func GetEmbedding(ctx context.Context, text string) (Vector, error) {
 	if embeddingService == nil {
 		return nil, fmt.Errorf("embedding service not initialized")
 	}
 
-	return embeddingService.GenerateEmbedding(text)
+	return embeddingService.GenerateEmbedding(ctx, text)
 }
 
 // GetBatchEmbeddings generates embeddings for multiple texts
 // This is useful for processing multiple inputs in a single API call
 // It returns a slice of Vector, one for each input text
 // It uses the global embedding service instance initialized in InitEmbeddingService
-func GetBatchEmbeddings(texts []string) ([]Vector, error) {
+func GetBatchEmbeddings(ctx context.Context, texts []string) ([]Vector, error) {
 	if embeddingService == nil {
 		return nil, fmt.Errorf("embedding service not initialized")
 	}
 
-	return embeddingService.GenerateBatchEmbeddings(texts)
+	return embeddingService.GenerateBatchEmbeddings(ctx, texts)
+}
+
+// CorpusEmbeddingService is implemented by embedding services that learn statistics from the indexed chunks
+// (e.g. the lexical provider's document frequencies)
+type CorpusEmbeddingService interface {
+	AddToCorpus(tx *sql.Tx, texts []string) error
+	RemoveFromCorpus(tx *sql.Tx, texts []string) error
+}
+
+// KeepsCorpusStatistics reports whether the configured embedding service keeps corpus statistics
+func KeepsCorpusStatistics() bool {
+	_, ok := embeddingService.(CorpusEmbeddingService)
+	return ok
+}
+
+// AddToCorpus tells the embedding service about newly indexed chunks
+// It runs in the upload transaction so the statistics never count chunks that were not stored
+// It is a no-op for embedding services that do not keep corpus statistics
+func AddToCorpus(tx *sql.Tx, texts []string) error {
+	if corpusService, ok := embeddingService.(CorpusEmbeddingService); ok {
+		return corpusService.AddToCorpus(tx, texts)
+	}
+	return nil
+}
+
+// RemoveFromCorpus tells the embedding service about deleted chunks
+// It is a no-op for embedding services that do not keep corpus statistics
+func RemoveFromCorpus(tx *sql.Tx, texts []string) error {
+	if corpusService, ok := embeddingService.(CorpusEmbeddingService); ok {
+		return corpusService.RemoveFromCorpus(tx, texts)
+	}
+	return nil
 }
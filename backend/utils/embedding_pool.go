@@ -0,0 +1,108 @@
+package utils
+
+import (
+	"context"
+	"fmt"
+	"sync"
+)
+
+// defaultEmbeddingParallelism is used when EMBEDDING_PARALLELISM is not set or not positive
+const defaultEmbeddingParallelism = 4
+
+// embedBatchFunc embeds one batch of texts, returning one vector per text in the same order
+type embedBatchFunc func(ctx context.Context, batch []string) ([]Vector, error)
+
+// EmbeddingParallelism returns how many embedding requests may run at the same time
+func EmbeddingParallelism(config *Config) int {
+	if config == nil || config.EmbeddingParallelism <= 0 {
+		return defaultEmbeddingParallelism
+	}
+	return int(config.EmbeddingParallelism)
+}
+
+// embedInBatches splits texts into batches of at most batchSize and embeds them with a bounded worker pool
+// parallelism: maximum number of batches in flight
+// limiter: when not nil, every batch waits for a token before its request (a batch is one HTTP call)
+// The result keeps the order of texts whatever the order in which batches complete
+// The first error cancels the batches still running and is returned with the index range it concerns
+func embedInBatches(ctx context.Context, texts []string, batchSize, parallelism int, limiter *RateLimiter, embed embedBatchFunc) ([]Vector, error) {
+	if batchSize <= 0 {
+		batchSize = 1
+	}
+	if parallelism <= 0 {
+		parallelism = 1
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	embeddings := make([]Vector, len(texts))
+
+	var (
+		wg       sync.WaitGroup
+		once     sync.Once
+		firstErr error
+	)
+	fail := func(err error) {
+		once.Do(func() {
+			firstErr = err
+			cancel()
+		})
+	}
+
+	// slots is a semaphore holding one value per batch in flight
+	slots := make(chan struct{}, parallelism)
+
+	for start := 0; start < len(texts); start += batchSize {
+		end := start + batchSize
+		if end > len(texts) {
+			end = len(texts)
+		}
+
+		// Stop scheduling once a batch failed or the caller gave up
+		select {
+		case slots <- struct{}{}:
+		case <-ctx.Done():
+		}
+		if ctx.Err() != nil {
+			break
+		}
+
+		wg.Add(1)
+		go func(start, end int) {
+			defer wg.Done()
+			defer func() { <-slots }()
+
+			if limiter != nil {
+				if err := limiter.Wait(ctx); err != nil {
+					fail(err)
+					return
+				}
+			}
+
+			vectors, err := embed(ctx, texts[start:end])
+			if err != nil {
+				fail(fmt.Errorf("failed to embed texts %d-%d: %w", start, end-1, err))
+				return
+			}
+			if len(vectors) != end-start {
+				fail(fmt.Errorf("expected %d embeddings for texts %d-%d, got %d", end-start, start, end-1, len(vectors)))
+				return
+			}
+
+			// Each goroutine writes its own range of the slice, no lock is needed
+			copy(embeddings[start:end], vectors)
+		}(start, end)
+	}
+
+	wg.Wait()
+
+	if firstErr != nil {
+		return nil, firstErr
+	}
+	// The parent context ended before every batch was scheduled
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return embeddings, nil
+}
@@ -0,0 +1,96 @@
+package utils
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEmbedInBatchesKeepsOrder(t *testing.T) {
+	texts := make([]string, 23)
+	for i := range texts {
+		texts[i] = fmt.Sprintf("%d", i)
+	}
+
+	var inFlight, maxInFlight atomic.Int32
+	embed := func(ctx context.Context, batch []string) ([]Vector, error) {
+		current := inFlight.Add(1)
+		defer inFlight.Add(-1)
+		for {
+			observed := maxInFlight.Load()
+			if current <= observed || maxInFlight.CompareAndSwap(observed, current) {
+				break
+			}
+		}
+
+		// Later batches finish first, the output must still follow the input order
+		time.Sleep(time.Duration(30-len(batch[0])*10) * time.Millisecond)
+
+		vectors := make([]Vector, len(batch))
+		for i, text := range batch {
+			var value float32
+			fmt.Sscanf(text, "%g", &value)
+			vectors[i] = Vector{value}
+		}
+		return vectors, nil
+	}
+
+	embeddings, err := embedInBatches(context.Background(), texts, 5, 3, nil, embed)
+	require.NoError(t, err)
+	require.Len(t, embeddings, len(texts))
+	for i, embedding := range embeddings {
+		assert.Equal(t, Vector{float32(i)}, embedding)
+	}
+	assert.LessOrEqual(t, maxInFlight.Load(), int32(3))
+}
+
+func TestEmbedInBatchesStopsOnError(t *testing.T) {
+	texts := make([]string, 50)
+	for i := range texts {
+		texts[i] = "text"
+	}
+
+	var calls atomic.Int32
+	embed := func(ctx context.Context, batch []string) ([]Vector, error) {
+		if calls.Add(1) == 1 {
+			return nil, errors.New("provider down")
+		}
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(10 * time.Millisecond):
+		}
+		return make([]Vector, len(batch)), nil
+	}
+
+	_, err := embedInBatches(context.Background(), texts, 1, 2, nil, embed)
+	assert.ErrorContains(t, err, "provider down")
+	assert.Less(t, calls.Load(), int32(50))
+}
+
+func TestEmbedInBatchesWaitsForRateLimiter(t *testing.T) {
+	// One token available, refilled at 20 per second: the second batch waits instead of failing
+	limiter := NewRateLimiter(1, 20)
+	embed := func(ctx context.Context, batch []string) ([]Vector, error) {
+		return []Vector{{1}}, nil
+	}
+
+	embeddings, err := embedInBatches(context.Background(), []string{"a", "b", "c"}, 1, 3, limiter, embed)
+	require.NoError(t, err)
+	assert.Len(t, embeddings, 3)
+}
+
+func TestRateLimiterWaitHonoursContext(t *testing.T) {
+	limiter := NewRateLimiter(1, 0)
+	require.NoError(t, limiter.Wait(context.Background()))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	assert.ErrorIs(t, limiter.Wait(ctx), context.DeadlineExceeded)
+}
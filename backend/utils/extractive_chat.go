@@ -0,0 +1,204 @@
+package utils
+
+import (
+	"context"
+	"encoding/json"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// ExtractiveChatService implements ChatService without a language model
+// It answers with the context sentences that share the most terms with the question,
+// which keeps the upload → query flow usable offline and in search-only deployments
+type ExtractiveChatService struct {
+	name         string
+	maxSentences int
+}
+
+// extractiveNoAnswer is returned when no context sentence shares a term with the question
+// It is the same wording the prompt templates ask language models to use
+const extractiveNoAnswer = "I don't have that information in the provided documents"
+
+// documentMarker matches the "Document N:" headers written by the context packer in models/rag.go
+var documentMarker = regexp.MustCompile(`Document (\d+):`)
+
+// NewExtractiveChatService creates an extractive chat service reported under the given provider name
+func NewExtractiveChatService(name string) *ExtractiveChatService {
+	return &ExtractiveChatService{
+		name:         name,
+		maxSentences: 3,
+	}
+}
+
+// GenerateResponse returns the best matching context sentences in document order
+// The question terms are read from the prompt (user message) and the sentences from the
+// documents found in the system prompt, or in the prompt when the template puts the context there
+// When the structured answer instructions are present, the answer is returned as the requested JSON
+func (s *ExtractiveChatService) GenerateResponse(ctx context.Context, prompt, systemPrompt string) (string, error) {
+	structured := strings.Contains(systemPrompt, structuredAnswerInstructions)
+	systemPrompt = strings.Replace(systemPrompt, structuredAnswerInstructions, "", 1)
+
+	queryTerms := make(map[string]bool)
+	for _, term := range LexicalTerms(prompt) {
+		queryTerms[term] = true
+	}
+
+	source := systemPrompt
+	if !documentMarker.MatchString(source) {
+		source = prompt
+	}
+
+	type scoredSentence struct {
+		text     string
+		document int
+		position int
+		score    int
+	}
+	var candidates []scoredSentence
+
+	for _, sentence := range contextSentences(source) {
+		// Sentences of the user message itself (e.g. the question line) are not evidence
+		if strings.Contains(prompt, sentence.text) {
+			continue
+		}
+
+		score := 0
+		seen := make(map[string]bool)
+		for _, term := range LexicalTerms(sentence.text) {
+			if queryTerms[term] && !seen[term] {
+				seen[term] = true
+				score++
+			}
+		}
+		if score > 0 {
+			candidates = append(candidates, scoredSentence{text: sentence.text, document: sentence.document, position: len(candidates), score: score})
+		}
+	}
+
+	if len(candidates) == 0 {
+		if structured {
+			return structuredExtractiveAnswer(StructuredAnswer{Answer: extractiveNoAnswer, Sources: []int{}})
+		}
+		return extractiveNoAnswer, nil
+	}
+
+	// Keep the best sentences, then restore document order so the answer reads naturally
+	sort.SliceStable(candidates, func(i, j int) bool { return candidates[i].score > candidates[j].score })
+	if len(candidates) > s.maxSentences {
+		candidates = candidates[:s.maxSentences]
+	}
+	sort.Slice(candidates, func(i, j int) bool { return candidates[i].position < candidates[j].position })
+
+	sentences := make([]string, len(candidates))
+	var sources []int
+	cited := make(map[int]bool)
+	bestScore := 0
+	for i, candidate := range candidates {
+		sentences[i] = candidate.text
+		if candidate.document > 0 && !cited[candidate.document] {
+			cited[candidate.document] = true
+			sources = append(sources, candidate.document)
+		}
+		if candidate.score > bestScore {
+			bestScore = candidate.score
+		}
+	}
+	answer := "According to the documents: " + strings.Join(sentences, " ")
+
+	if structured {
+		// The confidence is the share of question terms found in the best sentence
+		sort.Ints(sources)
+		return structuredExtractiveAnswer(StructuredAnswer{
+			Answer:     answer,
+			Sources:    sources,
+			Confidence: float64(bestScore) / float64(len(queryTerms)),
+			Answerable: len(sources) > 0,
+		})
+	}
+	return answer, nil
+}
+
+// structuredExtractiveAnswer encodes an extractive answer in the structured answer format
+func structuredExtractiveAnswer(answer StructuredAnswer) (string, error) {
+	if answer.Confidence > 1 {
+		answer.Confidence = 1
+	}
+	encoded, err := json.Marshal(answer)
+	if err != nil {
+		return "", err
+	}
+	return string(encoded), nil
+}
+
+// GetProviderName returns the provider name
+func (s *ExtractiveChatService) GetProviderName() string {
+	return s.name
+}
+
+// GetModel returns the model name
+func (s *ExtractiveChatService) GetModel() string {
+	return "extractive"
+}
+
+// contextSentence is a sentence of the context with the number of the document it comes from
+// document is 0 when the context has no "Document N:" markers
+type contextSentence struct {
+	text     string
+	document int
+}
+
+// contextSentences splits the document part of a prompt into sentences
+// Text before the first "Document N:" marker holds the template instructions and is skipped
+func contextSentences(text string) []contextSentence {
+	markers := documentMarker.FindAllStringSubmatchIndex(text, -1)
+	if len(markers) == 0 {
+		var sentences []contextSentence
+		for _, sentence := range SplitSentences(text) {
+			sentences = append(sentences, contextSentence{text: sentence})
+		}
+		return sentences
+	}
+
+	var sentences []contextSentence
+	for i, marker := range markers {
+		document, _ := strconv.Atoi(text[marker[2]:marker[3]])
+		end := len(text)
+		if i+1 < len(markers) {
+			end = markers[i+1][0]
+		}
+		for _, sentence := range SplitSentences(text[marker[1]:end]) {
+			sentences = append(sentences, contextSentence{text: sentence, document: document})
+		}
+	}
+	return sentences
+}
+
+// stopWords are ignored when matching terms, they carry no meaning for retrieval
+var stopWords = map[string]bool{
+	"a": true, "an": true, "and": true, "are": true, "as": true, "at": true, "be": true, "by": true,
+	"can": true, "do": true, "does": true, "for": true, "from": true, "how": true, "in": true,
+	"is": true, "it": true, "of": true, "on": true, "or": true, "our": true, "that": true,
+	"the": true, "this": true, "to": true, "was": true, "we": true, "what": true, "when": true,
+	"where": true, "which": true, "who": true, "why": true, "will": true, "with": true, "you": true,
+	"your": true, "question": true, "document": true, "documents": true,
+}
+
+// LexicalTerms splits a text into lower-case terms, dropping stop words and single characters
+// It is the tokenizer shared by the extractive chat and the lexical embedding providers
+func LexicalTerms(text string) []string {
+	fields := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+
+	terms := make([]string, 0, len(fields))
+	for _, field := range fields {
+		if len([]rune(field)) < 2 || stopWords[field] {
+			continue
+		}
+		terms = append(terms, field)
+	}
+	return terms
+}
@@ -0,0 +1,169 @@
+package utils
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// ErrAllProvidersUnavailable is returned when every provider of a chain failed or was skipped
+var ErrAllProvidersUnavailable = errors.New("all AI providers are unavailable")
+
+// recordProviderFailure counts a failed call against the provider's circuit breaker
+// Only transient failures (timeouts, 5xx, rate limits) say the provider is unhealthy, a bad request or
+// a rejected key fails the same way on any attempt, so they end the call without counting
+func recordProviderFailure(breaker *CircuitBreaker, err error) {
+	if IsRetryableError(err) {
+		breaker.RecordFailure()
+		return
+	}
+	breaker.Release()
+}
+
+// RoutedChatService is implemented by chat services that delegate to other chat services
+// GenerateRoutedResponse also returns the service that actually produced the answer,
+// so the answer can be recorded with the right provider and model
+type RoutedChatService interface {
+	ChatService
+	GenerateRoutedResponse(ctx context.Context, prompt, systemPrompt string) (string, ChatService, error)
+}
+
+// FailoverChatService tries an ordered chain of chat services until one answers
+// Each service has its own circuit breaker so a provider that is down is skipped without waiting for its timeouts
+type FailoverChatService struct {
+	services []ChatService
+	breakers []*CircuitBreaker
+}
+
+// NewFailoverChatService creates a chat service over the given chain
+// breakers[i] guards services[i]
+func NewFailoverChatService(services []ChatService, breakers []*CircuitBreaker) *FailoverChatService {
+	return &FailoverChatService{
+		services: services,
+		breakers: breakers,
+	}
+}
+
+// GenerateResponse generates a response with the first available provider of the chain
+func (s *FailoverChatService) GenerateResponse(ctx context.Context, prompt, systemPrompt string) (string, error) {
+	response, _, err := s.GenerateRoutedResponse(ctx, prompt, systemPrompt)
+	return response, err
+}
+
+// GenerateRoutedResponse generates a response and returns the service that produced it
+func (s *FailoverChatService) GenerateRoutedResponse(ctx context.Context, prompt, systemPrompt string) (string, ChatService, error) {
+	var errs []error
+
+	for i, service := range s.services {
+		breaker := s.breakers[i]
+		if !breaker.Allow() {
+			LogWarn("Skipping chat provider with open circuit", "provider", service.GetProviderName())
+			errs = append(errs, fmt.Errorf("%s: circuit open", service.GetProviderName()))
+			continue
+		}
+
+		response, err := service.GenerateResponse(ctx, prompt, systemPrompt)
+		if err != nil {
+			// A cancelled or expired request says nothing about the provider, and no fallback can help
+			if ctx.Err() != nil {
+				breaker.Release()
+				return "", nil, err
+			}
+			recordProviderFailure(breaker, err)
+			LogWarn("Chat provider failed, trying next provider", "provider", service.GetProviderName(), "error", err)
+			errs = append(errs, fmt.Errorf("%s: %w", service.GetProviderName(), err))
+			continue
+		}
+
+		breaker.RecordSuccess()
+		if i > 0 {
+			LogInfo("Chat response generated by fallback provider", "provider", service.GetProviderName(), "position", i)
+		}
+		return response, service, nil
+	}
+
+	return "", nil, fmt.Errorf("%w: %w", ErrAllProvidersUnavailable, errors.Join(errs...))
+}
+
+// GetProviderName returns the name of the primary provider
+func (s *FailoverChatService) GetProviderName() string {
+	return s.services[0].GetProviderName()
+}
+
+// GetModel returns the model of the primary provider
+func (s *FailoverChatService) GetModel() string {
+	return s.services[0].GetModel()
+}
+
+// FailoverEmbeddingService tries an ordered chain of embedding services until one succeeds
+// The factory only puts providers in the chain that produce comparable vectors (same model and dimension)
+type FailoverEmbeddingService struct {
+	services []EmbeddingService
+	breakers []*CircuitBreaker
+}
+
+// NewFailoverEmbeddingService creates an embedding service over the given chain
+// breakers[i] guards services[i]
+func NewFailoverEmbeddingService(services []EmbeddingService, breakers []*CircuitBreaker) *FailoverEmbeddingService {
+	return &FailoverEmbeddingService{
+		services: services,
+		breakers: breakers,
+	}
+}
+
+// GenerateEmbedding generates an embedding with the first available provider of the chain
+func (s *FailoverEmbeddingService) GenerateEmbedding(ctx context.Context, text string) (Vector, error) {
+	var embedding Vector
+	err := s.try(ctx, func(service EmbeddingService) error {
+		var err error
+		embedding, err = service.GenerateEmbedding(ctx, text)
+		return err
+	})
+	return embedding, err
+}
+
+// GenerateBatchEmbeddings generates embeddings with the first available provider of the chain
+// A batch is never split across providers
+func (s *FailoverEmbeddingService) GenerateBatchEmbeddings(ctx context.Context, texts []string) ([]Vector, error) {
+	var embeddings []Vector
+	err := s.try(ctx, func(service EmbeddingService) error {
+		var err error
+		embeddings, err = service.GenerateBatchEmbeddings(ctx, texts)
+		return err
+	})
+	return embeddings, err
+}
+
+// GetProviderName returns the name of the primary provider
+func (s *FailoverEmbeddingService) GetProviderName() string {
+	return s.services[0].GetProviderName()
+}
+
+// try calls fn with each available service until one succeeds
+func (s *FailoverEmbeddingService) try(ctx context.Context, fn func(service EmbeddingService) error) error {
+	var errs []error
+
+	for i, service := range s.services {
+		breaker := s.breakers[i]
+		if !breaker.Allow() {
+			errs = append(errs, fmt.Errorf("%s: circuit open", service.GetProviderName()))
+			continue
+		}
+
+		if err := fn(service); err != nil {
+			if ctx.Err() != nil {
+				breaker.Release()
+				return err
+			}
+			recordProviderFailure(breaker, err)
+			LogWarn("Embedding provider failed, trying next provider", "provider", service.GetProviderName(), "error", err)
+			errs = append(errs, fmt.Errorf("%s: %w", service.GetProviderName(), err))
+			continue
+		}
+
+		breaker.RecordSuccess()
+		return nil
+	}
+
+	return fmt.Errorf("%w: %w", ErrAllProvidersUnavailable, errors.Join(errs...))
+}
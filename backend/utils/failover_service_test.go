@@ -0,0 +1,196 @@
+package utils
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// stubChatService is a ChatService returning a fixed answer or error
+type stubChatService struct {
+	err      error
+	name     string
+	response string
+	calls    int
+}
+
+func (s *stubChatService) GenerateResponse(ctx context.Context, prompt, systemPrompt string) (string, error) {
+	s.calls++
+	return s.response, s.err
+}
+
+func (s *stubChatService) GetProviderName() string { return s.name }
+func (s *stubChatService) GetModel() string        { return s.name + "-model" }
+
+func TestCircuitBreaker(t *testing.T) {
+	now := time.Now()
+	breaker := NewCircuitBreaker("test", 2, time.Minute)
+	breaker.now = func() time.Time { return now }
+
+	assert.True(t, breaker.Allow())
+	breaker.RecordFailure()
+	assert.Equal(t, CircuitClosed, breaker.State())
+
+	breaker.RecordFailure()
+	assert.Equal(t, CircuitOpen, breaker.State())
+	assert.False(t, breaker.Allow())
+
+	// After the cooldown a single trial call is allowed
+	now = now.Add(time.Minute)
+	assert.True(t, breaker.Allow())
+	assert.Equal(t, CircuitHalfOpen, breaker.State())
+	assert.False(t, breaker.Allow())
+
+	// A failed trial reopens the circuit
+	breaker.RecordFailure()
+	assert.Equal(t, CircuitOpen, breaker.State())
+	assert.False(t, breaker.Allow())
+
+	// A successful trial closes it
+	now = now.Add(time.Minute)
+	assert.True(t, breaker.Allow())
+	breaker.RecordSuccess()
+	assert.Equal(t, CircuitClosed, breaker.State())
+	assert.True(t, breaker.Allow())
+}
+
+func TestFailoverChatService(t *testing.T) {
+	primary := &stubChatService{name: "primary", err: NewNetworkProviderError("primary", errors.New("connection refused"))}
+	secondary := &stubChatService{name: "secondary", response: "answer"}
+	primaryBreaker := NewCircuitBreaker("primary", 1, time.Minute)
+
+	service := NewFailoverChatService(
+		[]ChatService{primary, secondary},
+		[]*CircuitBreaker{primaryBreaker, NewCircuitBreaker("secondary", 1, time.Minute)},
+	)
+
+	response, responder, err := service.GenerateRoutedResponse(context.Background(), "question", "system")
+	require.NoError(t, err)
+	assert.Equal(t, "answer", response)
+	assert.Equal(t, "secondary", responder.GetProviderName())
+	assert.Equal(t, CircuitOpen, primaryBreaker.State())
+
+	// The primary is skipped while its circuit is open
+	_, _, err = service.GenerateRoutedResponse(context.Background(), "question", "system")
+	require.NoError(t, err)
+	assert.Equal(t, 1, primary.calls)
+	assert.Equal(t, 2, secondary.calls)
+
+	// The primary name and model are reported for the chain
+	assert.Equal(t, "primary", service.GetProviderName())
+	assert.Equal(t, "primary-model", service.GetModel())
+}
+
+func TestFailoverChatServiceAllFail(t *testing.T) {
+	service := NewFailoverChatService(
+		[]ChatService{&stubChatService{name: "a", err: errors.New("down")}, &stubChatService{name: "b", err: errors.New("down")}},
+		[]*CircuitBreaker{NewCircuitBreaker("a", 3, time.Minute), NewCircuitBreaker("b", 3, time.Minute)},
+	)
+
+	_, err := service.GenerateResponse(context.Background(), "question", "system")
+	assert.ErrorIs(t, err, ErrAllProvidersUnavailable)
+}
+
+func TestFailoverKeepsProviderErrors(t *testing.T) {
+	rejected := &ProviderError{Provider: "a", Kind: ErrorKindBadRequest, StatusCode: 400, Message: "invalid prompt"}
+	breaker := NewCircuitBreaker("a", 1, time.Minute)
+	service := NewFailoverChatService(
+		[]ChatService{&stubChatService{name: "a", err: rejected}, &stubChatService{name: "b", err: NewNetworkProviderError("b", errors.New("timeout"))}},
+		[]*CircuitBreaker{breaker, NewCircuitBreaker("b", 3, time.Minute)},
+	)
+
+	_, err := service.GenerateResponse(context.Background(), "question", "system")
+	assert.ErrorIs(t, err, ErrAllProvidersUnavailable)
+
+	// The provider errors stay reachable for the error kind metrics
+	var providerErr *ProviderError
+	require.ErrorAs(t, err, &providerErr)
+	assert.Equal(t, ErrorKindBadRequest, providerErr.Kind)
+
+	// A bad request says nothing about the provider's health, its circuit stays closed
+	assert.Equal(t, CircuitClosed, breaker.State())
+	assert.True(t, breaker.Allow())
+}
+
+func TestFailoverChatServiceStopsOnCancelledContext(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	primary := &stubChatService{name: "primary", err: ctx.Err()}
+	secondary := &stubChatService{name: "secondary", response: "answer"}
+	primaryBreaker := NewCircuitBreaker("primary", 1, time.Minute)
+
+	service := NewFailoverChatService(
+		[]ChatService{primary, secondary},
+		[]*CircuitBreaker{primaryBreaker, NewCircuitBreaker("secondary", 1, time.Minute)},
+	)
+
+	// A cancelled request is neither a provider failure nor a reason to fall back
+	_, _, err := service.GenerateRoutedResponse(ctx, "question", "system")
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.Equal(t, 0, secondary.calls)
+	assert.Equal(t, CircuitClosed, primaryBreaker.State())
+}
+
+func TestCreateServicesWithFallbackChain(t *testing.T) {
+	config := &Config{
+		AIFallbackChain: []AIProvider{OllamaProvider, GeminiProvider, OpenAIProvider},
+		OllamaBaseURL:   "http://localhost:11434",
+		GoogleAIAPIKey:  "AIza-test-key",
+		OpenAIAPIKey:    "sk-test-key",
+		ChatModel:       "gpt-3.5-turbo",
+		EmbeddingModel:  "text-embedding-3-small",
+		ProviderChatModels: map[AIProvider]string{
+			OllamaProvider: "llama3.1:8b",
+			GeminiProvider: "models/gemini-1.5-flash",
+		},
+		ProviderEmbeddingModels: map[AIProvider]string{
+			OllamaProvider: "nomic-embed-text",
+			GeminiProvider: "nomic-embed-text",
+		},
+		ProviderEmbeddingDims: map[AIProvider]int64{
+			OllamaProvider: 768,
+			GeminiProvider: 768,
+		},
+	}
+	factory := NewAIServiceFactory(config)
+	require.NoError(t, factory.ValidateConfiguration())
+
+	chat, err := factory.CreateChatService()
+	require.NoError(t, err)
+	failover, ok := chat.(*FailoverChatService)
+	require.True(t, ok)
+	assert.Len(t, failover.services, 3)
+	assert.Equal(t, "llama3.1:8b", chat.GetModel())
+
+	// OpenAI uses a different embedding model, so it must not be an embedding fallback
+	embedding, err := factory.CreateEmbeddingService()
+	require.NoError(t, err)
+	embeddingFailover, ok := embedding.(*FailoverEmbeddingService)
+	require.True(t, ok)
+	assert.Len(t, embeddingFailover.services, 2)
+	assert.Equal(t, "Ollama", embedding.GetProviderName())
+
+	// The smallest window of the chain bounds the context
+	assert.Equal(t, ContextWindowForModel("gpt-3.5-turbo"), ChatContextWindow(config))
+}
+
+func TestValidateConfigurationFallbackChain(t *testing.T) {
+	config := &Config{
+		AIFallbackChain: []AIProvider{OllamaProvider, OpenAIProvider},
+		OllamaBaseURL:   "http://localhost:11434",
+	}
+	assert.Error(t, NewAIServiceFactory(config).ValidateConfiguration())
+
+	config.AIFallbackChain = []AIProvider{"unknown"}
+	assert.Error(t, NewAIServiceFactory(config).ValidateConfiguration())
+}
+
+func TestParseProviderList(t *testing.T) {
+	assert.Equal(t, []AIProvider{OllamaProvider, GeminiProvider}, parseProviderList(" Ollama, gemini,,ollama "))
+	assert.Empty(t, parseProviderList(""))
+}
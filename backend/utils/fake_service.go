@@ -0,0 +1,113 @@
+package utils
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"math"
+	"strings"
+)
+
+// FakeEmbeddingService implements EmbeddingService without any external service
+// Vectors are built by hashing the terms of the text into a fixed number of dimensions,
+// so the same text always gets the same vector and texts sharing terms are close to each other
+// It is meant for development and CI, not for production retrieval quality
+type FakeEmbeddingService struct {
+	dimension int
+}
+
+// NewFakeEmbeddingService creates a fake embedding service
+// The dimension is FAKE_EMBEDDING_DIMENSION or EMBEDDING_DIMENSION so vectors fit the chunks table
+func NewFakeEmbeddingService(config *Config) *FakeEmbeddingService {
+	return &FakeEmbeddingService{
+		dimension: config.EmbeddingDimensionFor(FakeProvider),
+	}
+}
+
+// NewFakeChatService creates the chat service of the fake provider
+// It answers extractively from the context so answers still depend on the uploaded documents
+func NewFakeChatService() *ExtractiveChatService {
+	return NewExtractiveChatService("Fake")
+}
+
+// GenerateEmbedding generates a deterministic embedding for a text
+func (s *FakeEmbeddingService) GenerateEmbedding(ctx context.Context, text string) (Vector, error) {
+	cleanedText := strings.TrimSpace(text)
+	if cleanedText == "" {
+		return nil, fmt.Errorf("text cannot be empty")
+	}
+
+	return HashedEmbedding(cleanedText, s.dimension), nil
+}
+
+// GenerateBatchEmbeddings generates deterministic embeddings for multiple texts
+func (s *FakeEmbeddingService) GenerateBatchEmbeddings(ctx context.Context, texts []string) ([]Vector, error) {
+	if len(texts) == 0 {
+		return nil, fmt.Errorf("texts cannot be empty")
+	}
+
+	result := make([]Vector, 0, len(texts))
+	for _, text := range texts {
+		embedding, err := s.GenerateEmbedding(ctx, text)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, embedding)
+	}
+
+	return result, nil
+}
+
+// GetProviderName returns the provider name
+func (s *FakeEmbeddingService) GetProviderName() string {
+	return "Fake"
+}
+
+// HashedEmbedding builds an L2-normalized feature-hashed vector from the terms of a text
+// Each term adds +1 or -1 (decided by the hash) to the dimension it hashes to
+// A text without any term gets a vector derived from the whole text, so it is never all zeros
+func HashedEmbedding(text string, dimension int) Vector {
+	vector := make(Vector, dimension)
+
+	terms := LexicalTerms(text)
+	if len(terms) == 0 {
+		terms = []string{text}
+	}
+
+	for _, term := range terms {
+		index, sign := hashTerm(term, dimension)
+		vector[index] += sign
+	}
+
+	normalizeVector(vector)
+	return vector
+}
+
+// hashTerm maps a term to a dimension and a sign
+func hashTerm(term string, dimension int) (int, float32) {
+	hasher := fnv.New64a()
+	hasher.Write([]byte(term))
+	sum := hasher.Sum64()
+
+	sign := float32(1)
+	if sum>>63 == 1 {
+		sign = -1
+	}
+	return int(sum % uint64(dimension)), sign
+}
+
+// normalizeVector scales a vector to unit length in place
+func normalizeVector(vector Vector) {
+	var norm float64
+	for _, value := range vector {
+		norm += float64(value) * float64(value)
+	}
+	if norm == 0 {
+		return
+	}
+
+	scale := float32(1 / math.Sqrt(norm))
+	for i := range vector {
+		vector[i] *= scale
+	}
+}
@@ -0,0 +1,102 @@
+package utils
+
+import (
+	"context"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHashedEmbedding(t *testing.T) {
+	a := HashedEmbedding("The company allows 15 days of vacation per year.", 64)
+	b := HashedEmbedding("The company allows 15 days of vacation per year.", 64)
+	related := HashedEmbedding("How many vacation days per year?", 64)
+	unrelated := HashedEmbedding("Expense reports are submitted monthly.", 64)
+
+	assert.Len(t, a, 64)
+	assert.Equal(t, a, b, "embeddings must be deterministic")
+	assert.InDelta(t, 1.0, dot(a, a), 1e-5, "embeddings must be normalized")
+	assert.Greater(t, dot(a, related), dot(a, unrelated))
+
+	// Text without terms still gets a non-zero vector
+	assert.InDelta(t, 1.0, dot(HashedEmbedding("?!", 8), HashedEmbedding("?!", 8)), 1e-5)
+}
+
+func TestFakeProvider(t *testing.T) {
+	config := &Config{Provider: FakeProvider, EmbeddingDimension: 1536}
+	factory := NewAIServiceFactory(config)
+	require.NoError(t, factory.ValidateConfiguration())
+
+	embedding, err := factory.CreateEmbeddingService()
+	require.NoError(t, err)
+	vectors, err := embedding.GenerateBatchEmbeddings(context.Background(), []string{"first text", "second text"})
+	require.NoError(t, err)
+	assert.Len(t, vectors, 2)
+	assert.Len(t, vectors[0], 1536)
+
+	_, err = embedding.GenerateEmbedding(context.Background(), "   ")
+	assert.Error(t, err)
+
+	chat, err := factory.CreateChatService()
+	require.NoError(t, err)
+	assert.Equal(t, "Fake", chat.GetProviderName())
+}
+
+func TestLoadConfigFakeProvider(t *testing.T) {
+	for key, value := range map[string]string{"DB_PASSWORD": "test_password", "AI_PROVIDER": "fake"} {
+		original, present := os.LookupEnv(key)
+		os.Setenv(key, value)
+		defer func(key, original string, present bool) {
+			if present {
+				os.Setenv(key, original)
+			} else {
+				os.Unsetenv(key)
+			}
+		}(key, original, present)
+	}
+
+	config, err := LoadConfig()
+	require.NoError(t, err)
+	assert.Equal(t, FakeProvider, config.Provider)
+}
+
+func TestExtractiveChatService(t *testing.T) {
+	tmpl, err := BuiltinPromptTemplate(RAGAnswerTemplate)
+	require.NoError(t, err)
+
+	contextText := "Based on the following information from the documents:\n\n" +
+		"Document 1:\nThe office opens at 8am. Employees get 15 vacation days per year.\n\n" +
+		"Document 2:\nExpense reports are due monthly.\n\n"
+	prompt, err := tmpl.Render(PromptData{Question: "How many vacation days do employees get?", Context: contextText})
+	require.NoError(t, err)
+
+	service := NewExtractiveChatService("Extractive")
+	answer, err := service.GenerateResponse(context.Background(), prompt.User, prompt.System)
+	require.NoError(t, err)
+	assert.Contains(t, answer, "Employees get 15 vacation days per year.")
+	assert.NotContains(t, answer, "Expense reports")
+	assert.NotContains(t, answer, "IMPORTANT GUIDELINES")
+	assert.Empty(t, ValidateResponse(answer))
+
+	prompt, err = tmpl.Render(PromptData{Question: "Who is the CEO?", Context: contextText})
+	require.NoError(t, err)
+	answer, err = service.GenerateResponse(context.Background(), prompt.User, prompt.System)
+	require.NoError(t, err)
+	assert.Equal(t, extractiveNoAnswer, answer)
+}
+
+func TestLexicalTerms(t *testing.T) {
+	assert.Equal(t, []string{"vacation", "policy", "2024"}, LexicalTerms("What is the Vacation-Policy for 2024?"))
+	assert.Empty(t, LexicalTerms("a I ?"))
+}
+
+// dot returns the dot product of two vectors of the same length
+func dot(a, b Vector) float64 {
+	var sum float64
+	for i := range a {
+		sum += float64(a[i]) * float64(b[i])
+	}
+	return sum
+}
@@ -2,6 +2,7 @@ package utils
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
@@ -11,10 +12,17 @@ import (
 	"github.com/lib/pq"
 )
 
+// geminiBaseURL is the endpoint of the Gemini API
+const geminiBaseURL = "https://generativelanguage.googleapis.com/v1beta"
+
+// geminiBatchSize is the maximum number of requests accepted by batchEmbedContents
+const geminiBatchSize = 100
+
 // GeminiEmbeddingService implements EmbeddingService for Google AI (Gemini)
 type GeminiEmbeddingService struct {
-	config *Config
-	apiKey string
+	config  *Config
+	apiKey  string
+	baseURL string
 }
 
 // Gemini API structures for embeddings
@@ -33,20 +41,33 @@ type geminiEmbeddingResponse struct {
 	} `json:"embedding"`
 }
 
+// geminiBatchEmbeddingRequest is the request of batchEmbedContents, one embedContent request per text
+type geminiBatchEmbeddingRequest struct {
+	Requests []geminiEmbeddingRequest `json:"requests"`
+}
+
+// geminiBatchEmbeddingResponse holds one embedding per request, in request order
+type geminiBatchEmbeddingResponse struct {
+	Embeddings []struct {
+		Values []float32 `json:"values"`
+	} `json:"embeddings"`
+}
+
 // NewGeminiEmbeddingService creates a new Gemini embedding service
 // It initializes the service with the provided configuration
 // This allows the service to use the correct API key and model for embedding generation
 func NewGeminiEmbeddingService(config *Config) *GeminiEmbeddingService {
 	return &GeminiEmbeddingService{
-		config: config,
-		apiKey: config.GoogleAIAPIKey,
+		config:  config,
+		apiKey:  config.GoogleAIAPIKey,
+		baseURL: geminiBaseURL,
 	}
 }
 
 // GenerateEmbedding generates embeddings using Gemini API
 // It returns a Vector containing the embedding values
 // It handles rate limiting and retries using the configured retry strategy
-func (s *GeminiEmbeddingService) GenerateEmbedding(text string) (Vector, error) {
+func (s *GeminiEmbeddingService) GenerateEmbedding(ctx context.Context, text string) (Vector, error) {
 	// Trim whitespace and check for empty text
 	cleanedText := strings.TrimSpace(text)
 	if cleanedText == "" {
@@ -68,45 +89,131 @@ func (s *GeminiEmbeddingService) GenerateEmbedding(text string) (Vector, error)
 
 	// Retry the embedding request with backoff
 	// This allows the service to handle transient errors gracefully
-	err := RetryWithBackoff(retryConfig, func() error {
+	retryResult, err := Retry(ctx, retryConfig, "gemini.embedding", func() error {
 		// If the request fails, it will retry according to the retry configuration
 		// Make the actual API request to generate the embedding
-		return s.makeEmbeddingRequest(cleanedText, &embedding)
+		return s.makeEmbeddingRequest(ctx, cleanedText, &embedding)
 	})
 
 	if err != nil {
-		LogError("Failed to get Gemini embedding after retries", err, "text_length", len(cleanedText))
+		LogError("Failed to get Gemini embedding after retries", err, "attempts", retryResult.Attempts, "text_length", len(cleanedText))
 		return nil, err
 	}
 
 	return Vector(embedding), nil
 }
 
-// GenerateBatchEmbeddings generates embeddings for multiple texts (Gemini doesn't support batch, so we call individually)
-func (s *GeminiEmbeddingService) GenerateBatchEmbeddings(texts []string) ([]Vector, error) {
+// GenerateBatchEmbeddings generates embeddings for multiple texts
+// Texts are sent to batchEmbedContents in batches of geminiBatchSize, EMBEDDING_PARALLELISM batches at a time
+// Every batch waits for the rate limiter instead of failing when the bucket is empty
+func (s *GeminiEmbeddingService) GenerateBatchEmbeddings(ctx context.Context, texts []string) ([]Vector, error) {
 	// Check for empty input
 	if len(texts) == 0 {
 		return nil, fmt.Errorf("texts cannot be empty")
 	}
 
-	// Prepare a slice to hold the embeddings
-	// This will hold the embeddings for each text in the input slice
-	embeddings := make([]Vector, len(texts))
+	cleanedTexts := make([]string, len(texts))
 	for i, text := range texts {
-		// Generate embedding for each text
-		// This will hold the embeddings for each text in the input slice
-		embedding, err := s.GenerateEmbedding(text)
-		if err != nil {
-			return nil, fmt.Errorf("failed to get embedding for text %d: %v", i, err)
+		cleanedTexts[i] = strings.TrimSpace(text)
+		if cleanedTexts[i] == "" {
+			return nil, fmt.Errorf("failed to get embedding for text %d: text cannot be empty", i)
 		}
-		// Store the embedding in the slice
-		embeddings[i] = embedding
+	}
+
+	embeddings, err := embedInBatches(ctx, cleanedTexts, geminiBatchSize, EmbeddingParallelism(s.config), OpenAIRateLimiter, s.generateBatch)
+	if err != nil {
+		return nil, err
 	}
 
 	LogInfo("Successfully generated Gemini batch embeddings", "text_count", len(texts))
 	return embeddings, nil
 }
 
+// generateBatch embeds one batch through batchEmbedContents with retries
+func (s *GeminiEmbeddingService) generateBatch(ctx context.Context, batch []string) ([]Vector, error) {
+	var embeddings []Vector
+	retryResult, err := Retry(ctx, DefaultRetryConfig(), "gemini.batch_embedding", func() error {
+		return s.makeBatchEmbeddingRequest(ctx, batch, &embeddings)
+	})
+	if err != nil {
+		LogError("Failed to get Gemini batch embeddings after retries", err, "attempts", retryResult.Attempts, "text_count", len(batch))
+		return nil, err
+	}
+	return embeddings, nil
+}
+
+// makeBatchEmbeddingRequest makes one batchEmbedContents request for several texts
+func (s *GeminiEmbeddingService) makeBatchEmbeddingRequest(ctx context.Context, texts []string, embeddings *[]Vector) error {
+	model := s.config.EmbeddingModelFor(GeminiProvider)
+
+	request := geminiBatchEmbeddingRequest{Requests: make([]geminiEmbeddingRequest, len(texts))}
+	for i, text := range texts {
+		request.Requests[i].Model = model
+		request.Requests[i].Content.Parts = []struct {
+			Text string `json:"text"`
+		}{{Text: text}}
+	}
+
+	jsonData, err := json.Marshal(request)
+	if err != nil {
+		return fmt.Errorf("failed to marshal request: %v", err)
+	}
+
+	url := fmt.Sprintf("%s/%s:batchEmbedContents?key=%s", s.baseURL, model, s.apiKey)
+	req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewBuffer(jsonData))
+	if err != nil {
+		return fmt.Errorf("failed to create request: %v", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := ProviderHTTPClient.Do(req)
+	if err != nil {
+		// The API key is part of the URL, it must not reach the logs or the caller
+		err = redactRequestError(err)
+		LogError("Failed to make Gemini batch embedding request", err, "text_count", len(texts))
+		return NewNetworkProviderError("Gemini", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		LogError("Gemini batch API error", fmt.Errorf("status: %s", resp.Status), "response_body", string(body))
+		return NewHTTPProviderError("Gemini", resp, body)
+	}
+
+	var response geminiBatchEmbeddingResponse
+	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
+		LogError("Failed to decode Gemini batch response", err)
+		return fmt.Errorf("failed to decode response: %v", err)
+	}
+
+	if len(response.Embeddings) != len(texts) {
+		return fmt.Errorf("expected %d embeddings, got %d", len(texts), len(response.Embeddings))
+	}
+
+	*embeddings = make([]Vector, len(response.Embeddings))
+	for i, embedding := range response.Embeddings {
+		if len(embedding.Values) == 0 {
+			return fmt.Errorf("no embedding data received for text %d", i)
+		}
+		(*embeddings)[i] = Vector(embedding.Values)
+	}
+	s.recordUsage(ctx, texts...)
+	return nil
+}
+
+// recordUsage records the estimated prompt tokens of an embedding request
+// The embedding endpoints of Gemini do not report token usage
+func (s *GeminiEmbeddingService) recordUsage(ctx context.Context, texts ...string) {
+	RecordTokenUsage(ctx, TokenUsage{
+		Operation:    UsageOperationEmbedding,
+		Provider:     string(GeminiProvider),
+		Model:        s.config.EmbeddingModelFor(GeminiProvider),
+		PromptTokens: estimatedPromptTokens(texts...),
+		Estimated:    true,
+	})
+}
+
 // GetProviderName returns the provider name
 // This is used to identify the AI service provider
 // It allows the system to know which AI service is being used for embedding generation
@@ -115,11 +222,11 @@ func (s *GeminiEmbeddingService) GetProviderName() string {
 }
 
 // makeEmbeddingRequest makes an embedding request to Gemini
-func (s *GeminiEmbeddingService) makeEmbeddingRequest(text string, embedding *pq.Float32Array) error {
+func (s *GeminiEmbeddingService) makeEmbeddingRequest(ctx context.Context, text string, embedding *pq.Float32Array) error {
 	// Create request
 	// This request structure is specific to Gemini's embedding API
 	request := geminiEmbeddingRequest{
-		Model: s.config.EmbeddingModel,
+		Model: s.config.EmbeddingModelFor(GeminiProvider),
 	}
 
 	// Set the content parts with the text to be embedded
@@ -138,11 +245,11 @@ func (s *GeminiEmbeddingService) makeEmbeddingRequest(text string, embedding *pq
 	// Gemini API endpoint
 	// This is the URL for the Gemini embedding API
 	// It includes the model name and API key for authentication
-	url := fmt.Sprintf("https://generativelanguage.googleapis.com/v1beta/%s:embedContent?key=%s", s.config.EmbeddingModel, s.apiKey)
+	url := fmt.Sprintf("%s/%s:embedContent?key=%s", s.baseURL, s.config.EmbeddingModelFor(GeminiProvider), s.apiKey)
 
 	// Create a new HTTP request
 	// This request will be sent to the Gemini API to generate the embedding
-	req, err := http.NewRequest("POST", url, bytes.NewBuffer(jsonData))
+	req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewBuffer(jsonData))
 	if err != nil {
 		return fmt.Errorf("failed to create request: %v", err)
 	}
@@ -153,11 +260,12 @@ func (s *GeminiEmbeddingService) makeEmbeddingRequest(text string, embedding *pq
 
 	// Send the request to the Gemini API
 	// Do() is used to execute the HTTP request
-	client := &http.Client{}
-	resp, err := client.Do(req)
+	resp, err := ProviderHTTPClient.Do(req)
 	if err != nil {
+		// The API key is part of the URL, it must not reach the logs or the caller
+		err = redactRequestError(err)
 		LogError("Failed to make Gemini API request", err, "text_length", len(text))
-		return fmt.Errorf("failed to make request: %v", err)
+		return NewNetworkProviderError("Gemini", err)
 	}
 
 	// Ensure the response body is closed after reading
@@ -168,7 +276,7 @@ func (s *GeminiEmbeddingService) makeEmbeddingRequest(text string, embedding *pq
 	if resp.StatusCode != http.StatusOK {
 		body, _ := io.ReadAll(resp.Body)
 		LogError("Gemini API error", fmt.Errorf("status: %s", resp.Status), "response_body", string(body))
-		return fmt.Errorf("Gemini API error: %s - %s", resp.Status, string(body))
+		return NewHTTPProviderError("Gemini", resp, body)
 	}
 
 	// Decode the response body into the geminiEmbeddingResponse structure
@@ -190,6 +298,7 @@ func (s *GeminiEmbeddingService) makeEmbeddingRequest(text string, embedding *pq
 	// This will be used by the caller to access the generated embeddings
 	// *embedding is to dereference the pointer and assign the values
 	*embedding = pq.Float32Array(response.Embedding.Values)
+	s.recordUsage(ctx, text)
 	LogInfo("Successfully generated Gemini embedding", "text_length", len(text), "embedding_size", len(*embedding))
 	return nil
 }
@@ -203,8 +312,9 @@ type GeminiChatService struct {
 
 // Gemini API structures for chat
 type geminiChatRequest struct {
-	Contents         []geminiContent `json:"contents"`
-	GenerationConfig struct {
+	SystemInstruction *geminiContent  `json:"systemInstruction,omitempty"`
+	Contents          []geminiContent `json:"contents"`
+	GenerationConfig  struct {
 		Temperature     float32 `json:"temperature,omitempty"`
 		MaxOutputTokens int     `json:"maxOutputTokens,omitempty"`
 	} `json:"generationConfig,omitempty"`
@@ -232,6 +342,10 @@ type geminiChatResponse struct {
 			} `json:"parts"`
 		} `json:"content"`
 	} `json:"candidates"`
+	UsageMetadata struct {
+		PromptTokenCount     int `json:"promptTokenCount"`
+		CandidatesTokenCount int `json:"candidatesTokenCount"`
+	} `json:"usageMetadata"`
 }
 
 // NewGeminiChatService creates a new Gemini chat service
@@ -241,12 +355,12 @@ func NewGeminiChatService(config *Config) *GeminiChatService {
 	return &GeminiChatService{
 		config: config,
 		apiKey: config.GoogleAIAPIKey,
-		model:  config.ChatModel,
+		model:  config.ChatModelFor(GeminiProvider),
 	}
 }
 
 // GenerateResponse generates a response using Gemini chat completion
-func (s *GeminiChatService) GenerateResponse(question, context string) (string, error) {
+func (s *GeminiChatService) GenerateResponse(ctx context.Context, prompt, systemPrompt string) (string, error) {
 	// Rate limiting
 	// Check if the rate limiter allows the request
 	// This prevents exceeding the API rate limits
@@ -263,19 +377,19 @@ func (s *GeminiChatService) GenerateResponse(question, context string) (string,
 
 	// Retry the chat request with backoff
 	// This allows the service to handle transient errors gracefully
-	err := RetryWithBackoff(retryConfig, func() error {
+	retryResult, err := Retry(ctx, retryConfig, "gemini.chat", func() error {
 		// If the request fails, it will retry according to the retry configuration
 		// Make the actual API request to generate the response
 		// *string means that the response will be written to the provided string pointer
-		return s.makeChatRequest(question, context, &response)
+		return s.makeChatRequest(ctx, prompt, systemPrompt, &response)
 	})
 
 	if err != nil {
-		LogError("Failed to generate Gemini response after retries", err, "question", question)
+		LogError("Failed to generate Gemini response after retries", err, "attempts", retryResult.Attempts, "prompt_length", len(prompt))
 		return "", err
 	}
 
-	LogInfo("Successfully generated Gemini response", "question_length", len(question), "response_length", len(response))
+	LogInfo("Successfully generated Gemini response", "prompt_length", len(prompt), "response_length", len(response), "attempts", retryResult.Attempts)
 	return response, nil
 }
 
@@ -294,31 +408,26 @@ func (s *GeminiChatService) GetModel() string {
 }
 
 // makeChatRequest makes a chat completion request to Gemini
-func (s *GeminiChatService) makeChatRequest(question, context string, response *string) error {
-	// Create system message with context
-	systemPrompt := fmt.Sprintf(`You are a helpful assistant that answers questions based on provided context. 
-Use the following context to answer the user's question. If the context doesn't contain enough information to answer the question, say so clearly.
-
-Context:
-%s
-
-Question: %s`, context, question)
-
+// The system prompt is sent as Gemini's systemInstruction, the prompt as the user content
+func (s *GeminiChatService) makeChatRequest(ctx context.Context, prompt, systemPrompt string, response *string) error {
 	// Create request
 	request := geminiChatRequest{
 		Contents: []geminiContent{
 			{
-				Parts: []geminiPart{{Text: systemPrompt}},
+				Parts: []geminiPart{{Text: prompt}},
 				Role:  "user",
 			},
 		},
 	}
+	if systemPrompt != "" {
+		request.SystemInstruction = &geminiContent{Parts: []geminiPart{{Text: systemPrompt}}}
+	}
 	// Temperature and max output tokens can be adjusted based on requirements
 	// These parameters control the randomness and length of the generated response
 	request.GenerationConfig.Temperature = 0.1
-	// Max output tokens can be adjusted based on requirements
+	// Max output tokens is the room the context packer reserves for the answer
 	// This parameter controls the maximum length of the generated response
-	request.GenerationConfig.MaxOutputTokens = 1000
+	request.GenerationConfig.MaxOutputTokens = AnswerTokenLimit(s.config)
 
 	// Marshal the request to JSON
 	// This converts the request structure into a format that can be sent over HTTP
@@ -331,9 +440,9 @@ Question: %s`, context, question)
 	// Gemini API endpoint
 	// This is the URL for the Gemini chat API
 	// It includes the model name and API key for authentication
-	url := fmt.Sprintf("https://generativelanguage.googleapis.com/v1beta/%s:generateContent?key=%s", s.model, s.apiKey)
+	url := fmt.Sprintf("%s/%s:generateContent?key=%s", geminiBaseURL, s.model, s.apiKey)
 
-	req, err := http.NewRequest("POST", url, bytes.NewBuffer(jsonData))
+	req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewBuffer(jsonData))
 	if err != nil {
 		return fmt.Errorf("failed to create request: %v", err)
 	}
@@ -344,11 +453,12 @@ Question: %s`, context, question)
 
 	// Send the request to the Gemini API
 	// Do() is used to execute the HTTP request
-	client := &http.Client{}
-	resp, err := client.Do(req)
+	resp, err := ProviderHTTPClient.Do(req)
 	if err != nil {
+		// The API key is part of the URL, it must not reach the logs or the caller
+		err = redactRequestError(err)
 		LogError("Failed to make Gemini chat request", err)
-		return fmt.Errorf("failed to make request: %v", err)
+		return NewNetworkProviderError("Gemini", err)
 	}
 
 	// Ensure the response body is closed after reading
@@ -360,7 +470,7 @@ Question: %s`, context, question)
 	if resp.StatusCode != http.StatusOK {
 		body, _ := io.ReadAll(resp.Body)
 		LogError("Gemini chat API error", fmt.Errorf("status: %s", resp.Status), "response_body", string(body))
-		return fmt.Errorf("Gemini API error: %s - %s", resp.Status, string(body))
+		return NewHTTPProviderError("Gemini", resp, body)
 	}
 
 	// Decode the response body into the geminiChatResponse structure
@@ -382,5 +492,20 @@ Question: %s`, context, question)
 	// This is the text that will be returned as the response to the user's question
 	// *response is to dereference the pointer and assign the text
 	*response = strings.TrimSpace(chatResponse.Candidates[0].Content.Parts[0].Text)
+
+	// Record the token usage reported in usageMetadata, estimated when it is missing
+	usage := TokenUsage{
+		Operation:        UsageOperationChat,
+		Provider:         string(GeminiProvider),
+		Model:            s.model,
+		PromptTokens:     chatResponse.UsageMetadata.PromptTokenCount,
+		CompletionTokens: chatResponse.UsageMetadata.CandidatesTokenCount,
+	}
+	if usage.TotalTokens() == 0 {
+		usage.PromptTokens = estimatedPromptTokens(systemPrompt, prompt)
+		usage.CompletionTokens = estimatedPromptTokens(*response)
+		usage.Estimated = true
+	}
+	RecordTokenUsage(ctx, usage)
 	return nil
 }
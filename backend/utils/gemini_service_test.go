@@ -0,0 +1,50 @@
+package utils
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGeminiBatchEmbeddings(t *testing.T) {
+	var batchSizes []int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/models/text-embedding-004:batchEmbedContents", r.URL.Path)
+		assert.Equal(t, "test-key", r.URL.Query().Get("key"))
+
+		var request geminiBatchEmbeddingRequest
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&request))
+		batchSizes = append(batchSizes, len(request.Requests))
+
+		var response geminiBatchEmbeddingResponse
+		response.Embeddings = make([]struct {
+			Values []float32 `json:"values"`
+		}, len(request.Requests))
+		for i, embedRequest := range request.Requests {
+			assert.Equal(t, "models/text-embedding-004", embedRequest.Model)
+			response.Embeddings[i].Values = []float32{float32(len(embedRequest.Content.Parts[0].Text))}
+		}
+		json.NewEncoder(w).Encode(response)
+	}))
+	defer server.Close()
+
+	service := NewGeminiEmbeddingService(&Config{
+		GoogleAIAPIKey:       "test-key",
+		EmbeddingModel:       "models/text-embedding-004",
+		EmbeddingParallelism: 1,
+	})
+	service.baseURL = server.URL
+
+	embeddings, err := service.GenerateBatchEmbeddings(context.Background(), []string{"a", "bb", "ccc"})
+	require.NoError(t, err)
+	assert.Equal(t, []Vector{{1}, {2}, {3}}, embeddings)
+	assert.Equal(t, []int{3}, batchSizes)
+
+	_, err = service.GenerateBatchEmbeddings(context.Background(), []string{"a", "  "})
+	assert.ErrorContains(t, err, "text 1")
+}
@@ -0,0 +1,97 @@
+package utils
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// judgeStub is a ChatService returning a fixed reply, used as grounding judge
+type judgeStub struct {
+	err    error
+	reply  string
+	prompt string
+}
+
+func (j *judgeStub) GenerateResponse(ctx context.Context, prompt, systemPrompt string) (string, error) {
+	j.prompt = prompt
+	return j.reply, j.err
+}
+
+func (j *judgeStub) GetProviderName() string { return "Judge" }
+func (j *judgeStub) GetModel() string        { return "judge-model" }
+
+var groundingSources = []string{
+	"The office opens at 8am on weekdays.",
+	"Employees get 15 vacation days per year. Unused vacation days expire at the end of March.",
+}
+
+func TestSplitClaims(t *testing.T) {
+	claims := SplitClaims("According to the documents: employees get 15 vacation days.\n- Unused days expire in March.\nYes.")
+	assert.Equal(t, []string{
+		"According to the documents: employees get 15 vacation days.",
+		"Unused days expire in March.",
+	}, claims)
+}
+
+func TestGroundingCheckerLexical(t *testing.T) {
+	checker := NewGroundingChecker(&Config{}, nil)
+
+	report := checker.Check(context.Background(), "Employees get 15 vacation days per year. The office opens at 8am.", groundingSources)
+	require.Len(t, report.Claims, 2)
+	assert.Equal(t, 2, report.SupportedClaims)
+	assert.Equal(t, 1.0, report.SupportRatio)
+	assert.Equal(t, 2, report.Claims[0].Source)
+	assert.Equal(t, 1, report.Claims[1].Source)
+	assert.Empty(t, report.Violations())
+
+	// A changed figure is not supported even though every other word matches
+	report = checker.Check(context.Background(), "Employees get 25 vacation days per year. Remote work requires manager approval and a signed agreement.", groundingSources)
+	require.Len(t, report.Claims, 2)
+	assert.False(t, report.Claims[0].Supported)
+	assert.Contains(t, report.Claims[0].Reason, "number")
+	assert.False(t, report.Claims[1].Supported)
+	assert.Equal(t, 0.0, report.SupportRatio)
+	assert.Len(t, report.Violations(), 2)
+
+	report.Abstained = true
+	violations := report.Violations()
+	require.Len(t, violations, 1)
+	assert.Equal(t, "ungrounded_answer", violations[0].Type)
+}
+
+func TestGroundingCheckerNoClaims(t *testing.T) {
+	report := NewGroundingChecker(nil, nil).Check(context.Background(), "Yes.", groundingSources)
+	assert.Empty(t, report.Claims)
+	assert.Equal(t, 1.0, report.SupportRatio)
+}
+
+func TestGroundingCheckerJudge(t *testing.T) {
+	judge := &judgeStub{reply: "1: UNSUPPORTED\n2: SUPPORTED"}
+	checker := NewGroundingChecker(&Config{GroundingLLMJudge: true}, judge)
+
+	report := checker.Check(context.Background(), "Employees get 15 vacation days per year. Vacation days can be carried over until March.", groundingSources)
+	require.Len(t, report.Claims, 2)
+	assert.Contains(t, judge.prompt, "Document 2:\n"+groundingSources[1])
+	assert.Contains(t, judge.prompt, "2. Vacation days can be carried over until March.")
+	assert.Equal(t, GroundingMethodJudge, report.Claims[0].Method)
+	assert.False(t, report.Claims[0].Supported)
+	assert.True(t, report.Claims[1].Supported)
+
+	// A failing judge keeps the lexical verdicts
+	judge = &judgeStub{err: errors.New("provider down")}
+	checker = NewGroundingChecker(&Config{GroundingLLMJudge: true}, judge)
+	report = checker.Check(context.Background(), "Employees get 15 vacation days per year.", groundingSources)
+	require.Len(t, report.Claims, 1)
+	assert.Equal(t, GroundingMethodLexical, report.Claims[0].Method)
+	assert.True(t, report.Claims[0].Supported)
+}
+
+func TestIsAbstention(t *testing.T) {
+	assert.True(t, IsAbstention("I don't have that information in the provided documents"))
+	assert.True(t, IsAbstention("I couldn't find any relevant information in the documents to answer your question."))
+	assert.False(t, IsAbstention("Employees get 15 vacation days."))
+}
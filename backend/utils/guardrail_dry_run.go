@@ -0,0 +1,242 @@
+package utils
+
+import (
+	"context"
+	"encoding/json"
+	"reflect"
+	"sort"
+)
+
+// dryRunClassifierSkipped explains why the LLM classifier does not run in a dry run
+const dryRunClassifierSkipped = "the dry run does not call the LLM"
+
+// GuardrailDryRun is the outcome of a question, and optionally an answer, checked against a policy
+// Blocked tells whether the question would be refused, Withheld whether the answer would be replaced
+type GuardrailDryRun struct {
+	PolicyVersion string                 `json:"policy_version"`
+	InputChecks   []GuardrailCheckResult `json:"input_checks"`
+	OutputChecks  []GuardrailCheckResult `json:"output_checks,omitempty"`
+	Blocked       bool                   `json:"blocked"`
+	Withheld      bool                   `json:"withheld"`
+}
+
+// DryRunGuardrails traces the question through the input checks of config, and the answer through
+// the output checks when it is not empty, without calling any AI provider
+// The LLM classifier is listed as skipped when the policy enables it
+func DryRunGuardrails(ctx context.Context, config *GuardrailConfig, question, answer string) GuardrailDryRun {
+	run := GuardrailDryRun{
+		PolicyVersion: config.Version,
+		InputChecks:   NewInputGuardrailPipeline(config, nil).Trace(ctx, question),
+	}
+	run.InputChecks = appendSkippedClassifier(config, GuardrailStageInput, run.InputChecks)
+	run.Blocked = enabledBlockingResult(run.InputChecks)
+
+	if answer != "" {
+		run.OutputChecks = NewOutputGuardrailPipeline(config, nil).Trace(ctx, answer)
+		run.OutputChecks = appendSkippedClassifier(config, GuardrailStageOutput, run.OutputChecks)
+		run.Withheld = enabledBlockingResult(run.OutputChecks)
+	}
+	return run
+}
+
+// appendSkippedClassifier lists the LLM classifier in the trace when the policy enables it
+func appendSkippedClassifier(config *GuardrailConfig, stage string, results []GuardrailCheckResult) []GuardrailCheckResult {
+	if !config.CheckEnabled(GuardrailCheckLLMClassifier) {
+		return results
+	}
+	return append(results, GuardrailCheckResult{
+		Check:    GuardrailCheckLLMClassifier,
+		Stage:    stage,
+		Severity: config.CheckSeverity(GuardrailCheckLLMClassifier, GuardrailSeverityWarning),
+		Skipped:  dryRunClassifierSkipped,
+		Enabled:  true,
+	})
+}
+
+// enabledBlockingResult reports whether an enabled check reported an error
+func enabledBlockingResult(results []GuardrailCheckResult) bool {
+	for _, result := range results {
+		if result.Enabled && HasBlockingViolation(result.Violations) {
+			return true
+		}
+	}
+	return false
+}
+
+// GuardrailCheckChange is a check whose outcome differs between two dry runs
+type GuardrailCheckChange struct {
+	Current  *GuardrailCheckResult `json:"current"`
+	Proposed *GuardrailCheckResult `json:"proposed"`
+	Check    string                `json:"check"`
+	Stage    string                `json:"stage"`
+}
+
+// CompareGuardrailDryRuns returns the checks whose outcome changes from current to proposed:
+// enabled, matched, severity or the rules that matched
+// A check present in only one of the runs (e.g. the pii check) has a nil side
+func CompareGuardrailDryRuns(current, proposed GuardrailDryRun) []GuardrailCheckChange {
+	changes := compareCheckResults(current.InputChecks, proposed.InputChecks)
+	return append(changes, compareCheckResults(current.OutputChecks, proposed.OutputChecks)...)
+}
+
+// compareCheckResults compares the results of one stage, in the order of the current run
+func compareCheckResults(current, proposed []GuardrailCheckResult) []GuardrailCheckChange {
+	changes := []GuardrailCheckChange{}
+
+	proposedByCheck := make(map[string]*GuardrailCheckResult, len(proposed))
+	for i := range proposed {
+		proposedByCheck[proposed[i].Check] = &proposed[i]
+	}
+
+	seen := make(map[string]bool, len(current))
+	for i := range current {
+		result := &current[i]
+		seen[result.Check] = true
+		other := proposedByCheck[result.Check]
+		if other != nil && sameCheckOutcome(*result, *other) {
+			continue
+		}
+		changes = append(changes, GuardrailCheckChange{Check: result.Check, Stage: result.Stage, Current: result, Proposed: other})
+	}
+	for i := range proposed {
+		if !seen[proposed[i].Check] {
+			changes = append(changes, GuardrailCheckChange{Check: proposed[i].Check, Stage: proposed[i].Stage, Proposed: &proposed[i]})
+		}
+	}
+	return changes
+}
+
+// sameCheckOutcome reports whether a check has the same outcome in two runs
+func sameCheckOutcome(a, b GuardrailCheckResult) bool {
+	if a.Enabled != b.Enabled || a.Matched != b.Matched || a.Severity != b.Severity || len(a.Violations) != len(b.Violations) {
+		return false
+	}
+	for i := range a.Violations {
+		if a.Violations[i].Rule != b.Violations[i].Rule || a.Violations[i].Severity != b.Violations[i].Severity {
+			return false
+		}
+	}
+	return true
+}
+
+// GuardrailPolicyChange is a setting that differs between two policies
+// Lists of strings (blocked phrases, topics, PII types) report the added and removed entries,
+// other settings their current and proposed values
+type GuardrailPolicyChange struct {
+	Current  any      `json:"current,omitempty"`
+	Proposed any      `json:"proposed,omitempty"`
+	Field    string   `json:"field"`
+	Added    []string `json:"added,omitempty"`
+	Removed  []string `json:"removed,omitempty"`
+}
+
+// DiffGuardrailConfigs returns the settings that differ between two policies, sorted by field
+// Fields are named as in the policy file, nested sections as "checks.prompt_injection" or "pii.types"
+func DiffGuardrailConfigs(current, proposed *GuardrailConfig) ([]GuardrailPolicyChange, error) {
+	currentFields, err := guardrailConfigFields(current)
+	if err != nil {
+		return nil, err
+	}
+	proposedFields, err := guardrailConfigFields(proposed)
+	if err != nil {
+		return nil, err
+	}
+
+	changes := []GuardrailPolicyChange{}
+	diffGuardrailFields("", currentFields, proposedFields, &changes)
+	sort.Slice(changes, func(i, j int) bool { return changes[i].Field < changes[j].Field })
+	return changes, nil
+}
+
+// guardrailConfigFields converts a policy to the generic form of its JSON encoding
+func guardrailConfigFields(config *GuardrailConfig) (map[string]any, error) {
+	data, err := json.Marshal(config)
+	if err != nil {
+		return nil, err
+	}
+	var fields map[string]any
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return nil, err
+	}
+	return fields, nil
+}
+
+// diffGuardrailFields compares two sections of a policy, nested sections are compared setting by setting
+func diffGuardrailFields(prefix string, current, proposed map[string]any, changes *[]GuardrailPolicyChange) {
+	keys := make(map[string]bool, len(current)+len(proposed))
+	for key := range current {
+		keys[key] = true
+	}
+	for key := range proposed {
+		keys[key] = true
+	}
+
+	for key := range keys {
+		field := prefix + key
+		currentValue, proposedValue := current[key], proposed[key]
+		if reflect.DeepEqual(currentValue, proposedValue) {
+			continue
+		}
+
+		currentSection, currentIsSection := currentValue.(map[string]any)
+		proposedSection, proposedIsSection := proposedValue.(map[string]any)
+		if currentIsSection || proposedIsSection {
+			if !currentIsSection {
+				currentSection = map[string]any{}
+			}
+			if !proposedIsSection {
+				proposedSection = map[string]any{}
+			}
+			diffGuardrailFields(field+".", currentSection, proposedSection, changes)
+			continue
+		}
+
+		currentList, currentIsList := stringList(currentValue)
+		proposedList, proposedIsList := stringList(proposedValue)
+		if currentIsList && proposedIsList {
+			*changes = append(*changes, GuardrailPolicyChange{
+				Field:   field,
+				Added:   missingFrom(proposedList, currentList),
+				Removed: missingFrom(currentList, proposedList),
+			})
+			continue
+		}
+
+		*changes = append(*changes, GuardrailPolicyChange{Field: field, Current: currentValue, Proposed: proposedValue})
+	}
+}
+
+// stringList converts a decoded JSON value to a list of strings, nil counts as an empty list
+func stringList(value any) ([]string, bool) {
+	if value == nil {
+		return nil, true
+	}
+	items, ok := value.([]any)
+	if !ok {
+		return nil, false
+	}
+	list := make([]string, 0, len(items))
+	for _, item := range items {
+		text, ok := item.(string)
+		if !ok {
+			return nil, false
+		}
+		list = append(list, text)
+	}
+	return list, true
+}
+
+// missingFrom returns the values of a that are not in b, in the order of a
+func missingFrom(a, b []string) []string {
+	present := make(map[string]bool, len(b))
+	for _, value := range b {
+		present[value] = true
+	}
+	var missing []string
+	for _, value := range a {
+		if !present[value] {
+			missing = append(missing, value)
+		}
+	}
+	return missing
+}
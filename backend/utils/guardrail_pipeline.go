@@ -0,0 +1,274 @@
+package utils
+
+import (
+	"context"
+	"strings"
+)
+
+// Guardrail stages: input checks run on the question, output checks on the answer
+// and document checks on the chunks of uploaded documents, at ingestion
+// The history stage only appears in the audit log, for personal data redacted before a question is stored
+const (
+	GuardrailStageInput    = "input"
+	GuardrailStageOutput   = "output"
+	GuardrailStageDocument = "document"
+	GuardrailStageHistory  = "history"
+)
+
+// Guardrail severities
+// An error blocks the question (input) or withholds the answer (output), a warning is only reported
+const (
+	GuardrailSeverityError   = "error"
+	GuardrailSeverityWarning = "warning"
+)
+
+// Names of the built-in checks, used as keys of the "checks" section of the policy
+const (
+	GuardrailCheckLength             = "length"
+	GuardrailCheckBlockedPhrases     = "blocked_phrases"
+	GuardrailCheckPromptInjection    = "prompt_injection"
+	GuardrailCheckDocumentFocus      = "document_focus"
+	GuardrailCheckSuspiciousPatterns = "suspicious_patterns"
+	GuardrailCheckRegexRules         = "regex_rules"
+	GuardrailCheckLLMClassifier      = "llm_classifier"
+	GuardrailCheckResponseScope      = "response_scope"
+	GuardrailCheckResponseLength     = "response_length"
+	GuardrailCheckDocumentInjection  = "document_injection"
+	GuardrailCheckPII                = "pii"
+)
+
+// guardrailCheckNames are the check names accepted in the policy, a misspelled name is rejected on load
+// A check added in code must be listed here to be configurable
+var guardrailCheckNames = map[string]bool{
+	GuardrailCheckLength:             true,
+	GuardrailCheckBlockedPhrases:     true,
+	GuardrailCheckPromptInjection:    true,
+	GuardrailCheckDocumentFocus:      true,
+	GuardrailCheckSuspiciousPatterns: true,
+	GuardrailCheckRegexRules:         true,
+	GuardrailCheckLLMClassifier:      true,
+	GuardrailCheckResponseScope:      true,
+	GuardrailCheckResponseLength:     true,
+	GuardrailCheckDocumentInjection:  true,
+	GuardrailCheckPII:                true,
+}
+
+// guardrailChecksOffByDefault are the checks that only run when the policy enables them
+// The LLM classifier costs one chat call per question
+var guardrailChecksOffByDefault = map[string]bool{
+	GuardrailCheckLLMClassifier: true,
+}
+
+// GuardrailCheckSettings enables a check and sets the severity of its violations
+// Enabled is a pointer so a policy can set only the severity and keep the default
+type GuardrailCheckSettings struct {
+	Enabled  *bool  `json:"enabled,omitempty" yaml:"enabled"`
+	Severity string `json:"severity,omitempty" yaml:"severity"`
+}
+
+// GuardrailInput is the text a check inspects
+// Normalized is the trimmed, lower-cased text most checks match against
+// Config is the policy the pipeline runs with, checks read their limits and lists from it
+// Blocked is set once an earlier check of the pipeline reported an error, costly checks can skip the text
+type GuardrailInput struct {
+	Config     *GuardrailConfig
+	Stage      string
+	Text       string
+	Normalized string
+	Blocked    bool
+}
+
+// Guardrail is one check of a pipeline
+// Check returns the violations found in the input; a violation without a severity gets the check's
+// configured severity, or DefaultSeverity when the policy does not set one
+type Guardrail interface {
+	Name() string
+	DefaultSeverity() string
+	Check(ctx context.Context, input GuardrailInput) []GuardrailViolation
+}
+
+// CheckEnabled reports whether the policy runs a check
+func (c *GuardrailConfig) CheckEnabled(name string) bool {
+	if settings, ok := c.Checks[name]; ok && settings.Enabled != nil {
+		return *settings.Enabled
+	}
+	if name == GuardrailCheckDocumentFocus {
+		return c.RequireDocumentFocus
+	}
+	if name == GuardrailCheckPII {
+		return c.PII.MaskAnswers
+	}
+	return !guardrailChecksOffByDefault[name]
+}
+
+// CheckSeverity returns the severity the policy gives to a check, defaultSeverity when it sets none
+func (c *GuardrailConfig) CheckSeverity(name, defaultSeverity string) string {
+	if settings, ok := c.Checks[name]; ok && settings.Severity != "" {
+		return settings.Severity
+	}
+	return defaultSeverity
+}
+
+// GuardrailPipeline runs an ordered list of checks on a question or an answer
+type GuardrailPipeline struct {
+	Stage  string
+	config *GuardrailConfig
+	checks []Guardrail
+}
+
+// NewGuardrailPipeline creates a pipeline running checks in order, with the settings of config
+func NewGuardrailPipeline(stage string, config *GuardrailConfig, checks ...Guardrail) *GuardrailPipeline {
+	if config == nil {
+		config = ActiveGuardrailConfig()
+	}
+	return &GuardrailPipeline{Stage: stage, config: config, checks: checks}
+}
+
+// NewInputGuardrailPipeline creates the pipeline of the built-in question checks
+// chat is used by the LLM classifier, the check is skipped when chat is nil
+func NewInputGuardrailPipeline(config *GuardrailConfig, chat ChatService) *GuardrailPipeline {
+	pipeline := NewGuardrailPipeline(GuardrailStageInput, config,
+		lengthGuardrail{},
+		blockedPhrasesGuardrail{},
+		promptInjectionGuardrail{},
+		documentFocusGuardrail{},
+		suspiciousPatternsGuardrail{},
+	)
+	pipeline.Add(&RegexRulesGuardrail{Rules: pipeline.config.RegexRules})
+	if chat != nil {
+		pipeline.Add(&LLMClassifierGuardrail{Chat: chat})
+	}
+	return pipeline
+}
+
+// NewOutputGuardrailPipeline creates the pipeline of the built-in answer checks
+// chat is used by the LLM classifier, the check is skipped when chat is nil
+func NewOutputGuardrailPipeline(config *GuardrailConfig, chat ChatService) *GuardrailPipeline {
+	pipeline := NewGuardrailPipeline(GuardrailStageOutput, config,
+		responseScopeGuardrail{},
+		responseLengthGuardrail{},
+		piiGuardrail{},
+	)
+	pipeline.Add(&RegexRulesGuardrail{Rules: pipeline.config.RegexRules})
+	if chat != nil {
+		pipeline.Add(&LLMClassifierGuardrail{Chat: chat})
+	}
+	return pipeline
+}
+
+// NewDocumentGuardrailPipeline creates the pipeline scanning the chunks of uploaded documents
+// A chunk with an error violation is flagged, see ScanChunkForInjection
+func NewDocumentGuardrailPipeline(config *GuardrailConfig) *GuardrailPipeline {
+	pipeline := NewGuardrailPipeline(GuardrailStageDocument, config, documentInjectionGuardrail{})
+	pipeline.Add(&RegexRulesGuardrail{Rules: pipeline.config.RegexRules})
+	return pipeline
+}
+
+// Add appends a check to the pipeline, it runs after the existing ones
+func (p *GuardrailPipeline) Add(check Guardrail) *GuardrailPipeline {
+	p.checks = append(p.checks, check)
+	return p
+}
+
+// Checks returns the names of the checks the pipeline runs, in order
+func (p *GuardrailPipeline) Checks() []string {
+	var names []string
+	for _, check := range p.checks {
+		if p.config.CheckEnabled(check.Name()) {
+			names = append(names, check.Name())
+		}
+	}
+	return names
+}
+
+// Run passes the text through every enabled check and returns all the violations found
+// Every check runs, so the response and the question history list all the problems of a text
+func (p *GuardrailPipeline) Run(ctx context.Context, text string) []GuardrailViolation {
+	input := p.input(text)
+
+	var violations []GuardrailViolation
+	for _, check := range p.checks {
+		if !p.config.CheckEnabled(check.Name()) {
+			continue
+		}
+
+		found := p.runCheck(ctx, check, input)
+		if HasBlockingViolation(found) {
+			input.Blocked = true
+		}
+		violations = append(violations, found...)
+	}
+	return violations
+}
+
+// GuardrailCheckResult is the outcome of one check in a trace of the pipeline
+// Disabled checks run too, Matched then tells what the check would report once enabled
+type GuardrailCheckResult struct {
+	Check      string               `json:"check"`
+	Stage      string               `json:"stage"`
+	Severity   string               `json:"severity"`
+	Skipped    string               `json:"skipped,omitempty"`
+	Violations []GuardrailViolation `json:"violations,omitempty"`
+	Enabled    bool                 `json:"enabled"`
+	Matched    bool                 `json:"matched"`
+}
+
+// Trace runs every check of the pipeline, enabled or not, and returns the outcome of each in order
+// Only enabled checks can block the text, as in Run
+func (p *GuardrailPipeline) Trace(ctx context.Context, text string) []GuardrailCheckResult {
+	input := p.input(text)
+
+	results := make([]GuardrailCheckResult, 0, len(p.checks))
+	for _, check := range p.checks {
+		enabled := p.config.CheckEnabled(check.Name())
+		found := p.runCheck(ctx, check, input)
+		if enabled && HasBlockingViolation(found) {
+			input.Blocked = true
+		}
+
+		results = append(results, GuardrailCheckResult{
+			Check:      check.Name(),
+			Stage:      p.Stage,
+			Severity:   p.config.CheckSeverity(check.Name(), check.DefaultSeverity()),
+			Violations: found,
+			Enabled:    enabled,
+			Matched:    len(found) > 0,
+		})
+	}
+	return results
+}
+
+// input prepares the text checks inspect
+func (p *GuardrailPipeline) input(text string) GuardrailInput {
+	return GuardrailInput{
+		Config:     p.config,
+		Stage:      p.Stage,
+		Text:       text,
+		Normalized: strings.TrimSpace(strings.ToLower(text)),
+	}
+}
+
+// runCheck runs a check and completes its violations with the check name, the stage and the severity
+func (p *GuardrailPipeline) runCheck(ctx context.Context, check Guardrail, input GuardrailInput) []GuardrailViolation {
+	severity := p.config.CheckSeverity(check.Name(), check.DefaultSeverity())
+
+	violations := check.Check(ctx, input)
+	for i := range violations {
+		if violations[i].Severity == "" {
+			violations[i].Severity = severity
+		}
+		violations[i].Check = check.Name()
+		violations[i].Stage = p.Stage
+	}
+	return violations
+}
+
+// HasBlockingViolation reports whether a violation has the error severity
+func HasBlockingViolation(violations []GuardrailViolation) bool {
+	for _, violation := range violations {
+		if violation.Severity == GuardrailSeverityError {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,375 @@
+package utils
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// BuiltinGuardrailPolicyVersion is the version reported when no policy file is configured
+const BuiltinGuardrailPolicyVersion = "builtin"
+
+// GuardrailPolicy is a validated guardrail configuration and where it was loaded from
+// Version is the "version" of the file, or its checksum when the file has none
+// Config is shared by every request using the policy and must not be modified
+type GuardrailPolicy struct {
+	Config   *GuardrailConfig `json:"config"`
+	Version  string           `json:"version"`
+	Checksum string           `json:"checksum,omitempty"`
+	Source   string           `json:"source"`
+	LoadedAt time.Time        `json:"loaded_at"`
+}
+
+// BuiltinGuardrailPolicy returns the policy of DefaultGuardrailConfig
+func BuiltinGuardrailPolicy() *GuardrailPolicy {
+	config := DefaultGuardrailConfig()
+	config.Version = BuiltinGuardrailPolicyVersion
+	return &GuardrailPolicy{
+		Config:   config,
+		Version:  BuiltinGuardrailPolicyVersion,
+		Source:   BuiltinGuardrailPolicyVersion,
+		LoadedAt: time.Now(),
+	}
+}
+
+// ParseGuardrailConfig decodes a policy file, format is "json" or "yaml"
+// Fields missing from the file keep the value of DefaultGuardrailConfig, unknown fields are rejected
+// so a misspelled setting does not silently leave the default in place
+func ParseGuardrailConfig(data []byte, format string) (*GuardrailConfig, error) {
+	config := DefaultGuardrailConfig()
+
+	var err error
+	switch format {
+	case "json":
+		decoder := json.NewDecoder(bytes.NewReader(data))
+		decoder.DisallowUnknownFields()
+		err = decoder.Decode(config)
+	case "yaml":
+		decoder := yaml.NewDecoder(bytes.NewReader(data))
+		decoder.KnownFields(true)
+		err = decoder.Decode(config)
+	default:
+		return nil, fmt.Errorf("unsupported guardrail policy format %q, use json or yaml", format)
+	}
+
+	if errors.Is(err, io.EOF) {
+		return nil, errors.New("guardrail policy file is empty")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid guardrail policy: %v", err)
+	}
+
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+	return config, nil
+}
+
+// Validate checks that the configuration can be enforced
+// Phrases and topics are trimmed, an empty blocked phrase would match every question and is rejected
+// Check names and severities must be known, regex rules are compiled and flagged_chunks defaults to neutralize
+func (c *GuardrailConfig) Validate() error {
+	if c.MinQuestionLength < 0 {
+		return fmt.Errorf("min_question_length must not be negative, got %d", c.MinQuestionLength)
+	}
+	if c.MaxQuestionLength <= 0 {
+		return fmt.Errorf("max_question_length must be positive, got %d", c.MaxQuestionLength)
+	}
+	if c.MinQuestionLength > c.MaxQuestionLength {
+		return fmt.Errorf("min_question_length (%d) must not exceed max_question_length (%d)", c.MinQuestionLength, c.MaxQuestionLength)
+	}
+
+	for i, phrase := range c.BlockedPhrases {
+		c.BlockedPhrases[i] = strings.TrimSpace(phrase)
+		if c.BlockedPhrases[i] == "" {
+			return fmt.Errorf("blocked_phrases[%d] is empty", i)
+		}
+	}
+	for i, topic := range c.AllowedTopics {
+		c.AllowedTopics[i] = strings.TrimSpace(topic)
+		if c.AllowedTopics[i] == "" {
+			return fmt.Errorf("allowed_topics[%d] is empty", i)
+		}
+	}
+
+	for name, settings := range c.Checks {
+		if !guardrailCheckNames[name] {
+			return fmt.Errorf("checks: unknown check %q", name)
+		}
+		if err := validateGuardrailSeverity(settings.Severity); err != nil {
+			return fmt.Errorf("checks.%s: %v", name, err)
+		}
+	}
+
+	ruleNames := make(map[string]bool, len(c.RegexRules))
+	for i := range c.RegexRules {
+		rule := &c.RegexRules[i]
+		if err := rule.validate(); err != nil {
+			return fmt.Errorf("regex_rules[%d]: %v", i, err)
+		}
+		if ruleNames[rule.Name] {
+			return fmt.Errorf("regex_rules[%d]: duplicate rule name %q", i, rule.Name)
+		}
+		ruleNames[rule.Name] = true
+	}
+
+	if err := c.PII.validate(); err != nil {
+		return err
+	}
+
+	switch c.FlaggedChunks {
+	case "":
+		c.FlaggedChunks = FlaggedChunksNeutralize
+	case FlaggedChunksNeutralize, FlaggedChunksExclude:
+	default:
+		return fmt.Errorf("flagged_chunks must be %s or %s, got %q", FlaggedChunksNeutralize, FlaggedChunksExclude, c.FlaggedChunks)
+	}
+
+	c.Version = strings.TrimSpace(c.Version)
+	return nil
+}
+
+// guardrailPolicyFormat returns the format of a policy file from its extension
+func guardrailPolicyFormat(path string) (string, error) {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json":
+		return "json", nil
+	case ".yaml", ".yml":
+		return "yaml", nil
+	}
+	return "", fmt.Errorf("guardrail policy file %s must end in .json, .yaml or .yml", path)
+}
+
+// LoadGuardrailPolicy reads and validates a policy file
+func LoadGuardrailPolicy(path string) (*GuardrailPolicy, error) {
+	format, err := guardrailPolicyFormat(path)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read guardrail policy: %v", err)
+	}
+
+	config, err := ParseGuardrailConfig(data, format)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+
+	sum := sha256.Sum256(data)
+	checksum := hex.EncodeToString(sum[:])[:12]
+
+	// Without an explicit version the checksum identifies the policy, so any edit changes it
+	if config.Version == "" {
+		config.Version = "sha256:" + checksum
+	}
+
+	return &GuardrailPolicy{
+		Config:   config,
+		Version:  config.Version,
+		Checksum: checksum,
+		Source:   path,
+		LoadedAt: time.Now(),
+	}, nil
+}
+
+// GuardrailPolicyStore holds the active guardrail policy and reloads it from its file
+// A reload that fails keeps the previous policy, the error is reported in the status until the next successful load
+type GuardrailPolicyStore struct {
+	path        string
+	policy      *GuardrailPolicy
+	lastError   error
+	lastErrorAt time.Time
+	modTime     time.Time
+	size        int64
+	mutex       sync.RWMutex
+}
+
+// NewGuardrailPolicyStore creates a store, path is empty to use the built-in policy
+// An invalid file at startup is an error: the service should not start with a policy nobody wrote
+func NewGuardrailPolicyStore(path string) (*GuardrailPolicyStore, error) {
+	store := &GuardrailPolicyStore{path: path, policy: BuiltinGuardrailPolicy()}
+	if path == "" {
+		return store, nil
+	}
+
+	if err := store.Reload(); err != nil {
+		return nil, err
+	}
+	return store, nil
+}
+
+// Policy returns the active policy
+func (s *GuardrailPolicyStore) Policy() *GuardrailPolicy {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	return s.policy
+}
+
+// Reload reads the policy file again and makes it active when it is valid
+func (s *GuardrailPolicyStore) Reload() error {
+	if s.path == "" {
+		return nil
+	}
+
+	// The file state is read before the content, so a write landing in between triggers one more reload
+	info, statErr := os.Stat(s.path)
+	policy, err := LoadGuardrailPolicy(s.path)
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if statErr == nil {
+		s.modTime = info.ModTime()
+		s.size = info.Size()
+	}
+
+	if err != nil {
+		s.lastError = err
+		s.lastErrorAt = time.Now()
+		return err
+	}
+
+	previous := s.policy.Version
+	s.policy = policy
+	s.lastError = nil
+
+	LogInfo("Guardrail policy loaded",
+		"source", policy.Source,
+		"version", policy.Version,
+		"previous_version", previous,
+		"blocked_phrases", len(policy.Config.BlockedPhrases))
+	return nil
+}
+
+// changed reports whether the policy file was modified since the last load
+func (s *GuardrailPolicyStore) changed() bool {
+	info, err := os.Stat(s.path)
+	if err != nil {
+		return false
+	}
+
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	return !info.ModTime().Equal(s.modTime) || info.Size() != s.size
+}
+
+// Watch reloads the policy on SIGHUP and when the file changes, until ctx is cancelled
+// The file is polled every interval (0 disables polling, SIGHUP still works)
+// Polling is used rather than file system events so edits through symlinks (Kubernetes ConfigMaps) are seen too
+func (s *GuardrailPolicyStore) Watch(ctx context.Context, interval time.Duration) {
+	if s.path == "" {
+		return
+	}
+
+	hangup := make(chan os.Signal, 1)
+	signal.Notify(hangup, syscall.SIGHUP)
+
+	go func() {
+		defer signal.Stop(hangup)
+
+		var tick <-chan time.Time
+		if interval > 0 {
+			ticker := time.NewTicker(interval)
+			defer ticker.Stop()
+			tick = ticker.C
+		}
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-hangup:
+				LogInfo("SIGHUP received, reloading guardrail policy", "source", s.path)
+				s.reloadLogged()
+			case <-tick:
+				if s.changed() {
+					s.reloadLogged()
+				}
+			}
+		}
+	}()
+}
+
+// reloadLogged reloads the policy, logging a failure since nobody waits for the result
+func (s *GuardrailPolicyStore) reloadLogged() {
+	if err := s.Reload(); err != nil {
+		LogError("Failed to reload guardrail policy, keeping the previous one", err,
+			"source", s.path,
+			"active_version", s.Policy().Version)
+	}
+}
+
+// Status describes the active policy for GET /guardrails/status
+func (s *GuardrailPolicyStore) Status() map[string]interface{} {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	status := map[string]interface{}{
+		"version":   s.policy.Version,
+		"source":    s.policy.Source,
+		"loaded_at": s.policy.LoadedAt,
+	}
+	if s.policy.Checksum != "" {
+		status["checksum"] = s.policy.Checksum
+	}
+	if s.lastError != nil {
+		status["reload_error"] = s.lastError.Error()
+		status["reload_error_at"] = s.lastErrorAt
+	}
+	return status
+}
+
+// Global guardrail policy, set by InitGuardrailPolicy
+// Before initialization (tests, tools) the built-in policy is used
+var guardrailPolicies *GuardrailPolicyStore
+
+// InitGuardrailPolicy loads the policy of GUARDRAIL_POLICY_FILE, or the built-in policy when it is not set
+func InitGuardrailPolicy(config *Config) error {
+	store, err := NewGuardrailPolicyStore(config.GuardrailPolicyFile)
+	if err != nil {
+		return err
+	}
+
+	guardrailPolicies = store
+	if config.GuardrailPolicyFile == "" {
+		LogInfo("Using the built-in guardrail policy")
+	}
+	return nil
+}
+
+// WatchGuardrailPolicy starts reloading the global policy on SIGHUP and file changes
+func WatchGuardrailPolicy(ctx context.Context, config *Config) {
+	if guardrailPolicies == nil {
+		return
+	}
+	guardrailPolicies.Watch(ctx, time.Duration(config.GuardrailPolicyReloadSecs)*time.Second)
+}
+
+// ActiveGuardrailPolicy returns the policy enforced on questions
+func ActiveGuardrailPolicy() *GuardrailPolicy {
+	if guardrailPolicies == nil {
+		return BuiltinGuardrailPolicy()
+	}
+	return guardrailPolicies.Policy()
+}
+
+// ActiveGuardrailConfig returns the configuration of the active policy
+func ActiveGuardrailConfig() *GuardrailConfig {
+	return ActiveGuardrailPolicy().Config
+}
@@ -0,0 +1,178 @@
+package utils
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseGuardrailConfig(t *testing.T) {
+	yamlPolicy := `
+version: "2025-06"
+max_question_length: 500
+blocked_phrases:
+  - "  salary of "
+  - project zeus
+`
+	config, err := ParseGuardrailConfig([]byte(yamlPolicy), "yaml")
+	require.NoError(t, err)
+	assert.Equal(t, "2025-06", config.Version)
+	assert.Equal(t, 500, config.MaxQuestionLength)
+	assert.Equal(t, []string{"salary of", "project zeus"}, config.BlockedPhrases)
+
+	// Settings left out keep the built-in value
+	assert.Equal(t, 3, config.MinQuestionLength)
+	assert.True(t, config.RequireDocumentFocus)
+	assert.Equal(t, DefaultGuardrailConfig().AllowedTopics, config.AllowedTopics)
+
+	config, err = ParseGuardrailConfig([]byte(`{"require_document_focus": false, "min_question_length": 10}`), "json")
+	require.NoError(t, err)
+	assert.False(t, config.RequireDocumentFocus)
+	assert.Equal(t, 10, config.MinQuestionLength)
+	assert.Equal(t, DefaultGuardrailConfig().BlockedPhrases, config.BlockedPhrases)
+}
+
+func TestParseGuardrailConfigInvalid(t *testing.T) {
+	tests := []struct {
+		name   string
+		data   string
+		format string
+		errMsg string
+	}{
+		{"Empty blocked phrase", "blocked_phrases: [\"hack\", \" \"]", "yaml", "blocked_phrases[1] is empty"},
+		{"Min above max", `{"min_question_length": 50, "max_question_length": 10}`, "json", "must not exceed"},
+		{"Negative min", "min_question_length: -1", "yaml", "must not be negative"},
+		{"Zero max", "max_question_length: 0", "yaml", "must be positive"},
+		{"Misspelled setting", "blocked_phrase: [hack]", "yaml", "invalid guardrail policy"},
+		{"Unknown JSON field", `{"strict": true}`, "json", "invalid guardrail policy"},
+		{"Wrong type", "max_question_length: lots", "yaml", "invalid guardrail policy"},
+		{"Empty file", "", "yaml", "is empty"},
+		{"Unsupported format", "max_question_length = 10", "toml", "unsupported"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ParseGuardrailConfig([]byte(tt.data), tt.format)
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), tt.errMsg)
+		})
+	}
+}
+
+// TestGuardrailPolicyExampleFile checks that the example policy shipped with the backend is the built-in policy
+func TestGuardrailPolicyExampleFile(t *testing.T) {
+	policy, err := LoadGuardrailPolicy(filepath.Join("..", "guardrails.example.yaml"))
+	require.NoError(t, err)
+
+	defaults := DefaultGuardrailConfig()
+	assert.Equal(t, "1", policy.Version)
+	assert.Equal(t, defaults.BlockedPhrases, policy.Config.BlockedPhrases)
+	assert.Equal(t, defaults.AllowedTopics, policy.Config.AllowedTopics)
+	assert.Equal(t, defaults.MaxQuestionLength, policy.Config.MaxQuestionLength)
+	assert.Equal(t, defaults.MinQuestionLength, policy.Config.MinQuestionLength)
+}
+
+func TestLoadGuardrailPolicyVersion(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "policy.json")
+	require.NoError(t, os.WriteFile(path, []byte(`{"max_question_length": 200}`), 0o600))
+
+	// Without a version the checksum identifies the policy
+	policy, err := LoadGuardrailPolicy(path)
+	require.NoError(t, err)
+	assert.Equal(t, "sha256:"+policy.Checksum, policy.Version)
+	assert.Equal(t, path, policy.Source)
+
+	_, err = LoadGuardrailPolicy(filepath.Join(t.TempDir(), "policy.txt"))
+	assert.ErrorContains(t, err, "must end in .json, .yaml or .yml")
+}
+
+func TestGuardrailPolicyStoreReload(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "policy.yaml")
+	require.NoError(t, os.WriteFile(path, []byte("version: v1\nblocked_phrases: [project zeus]\n"), 0o600))
+
+	store, err := NewGuardrailPolicyStore(path)
+	require.NoError(t, err)
+	assert.Equal(t, "v1", store.Policy().Version)
+	assert.False(t, store.changed())
+
+	violations := ValidateQuestion("What is the policy on project zeus?", store.Policy().Config)
+	require.NotEmpty(t, violations)
+	assert.Equal(t, "content_violation", violations[0].Type)
+
+	// An invalid edit keeps the previous policy and is reported in the status
+	require.NoError(t, os.WriteFile(path, []byte("version: v2\nmin_question_length: -5\n"), 0o600))
+	assert.True(t, store.changed())
+	assert.Error(t, store.Reload())
+	assert.Equal(t, "v1", store.Policy().Version)
+	assert.Contains(t, store.Status()["reload_error"], "must not be negative")
+	assert.False(t, store.changed())
+
+	require.NoError(t, os.WriteFile(path, []byte("version: v3\nblocked_phrases: [project apollo]\n"), 0o600))
+	require.NoError(t, store.Reload())
+	assert.Equal(t, "v3", store.Policy().Version)
+	assert.NotContains(t, store.Status(), "reload_error")
+	assert.Empty(t, ValidateQuestion("What is the policy on project zeus?", store.Policy().Config))
+}
+
+func TestGuardrailPolicyStoreInvalidAtStartup(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "policy.yaml")
+	require.NoError(t, os.WriteFile(path, []byte("blocked_phrases: [\"\"]\n"), 0o600))
+
+	_, err := NewGuardrailPolicyStore(path)
+	assert.Error(t, err)
+
+	_, err = NewGuardrailPolicyStore(filepath.Join(t.TempDir(), "missing.yaml"))
+	assert.ErrorContains(t, err, "failed to read guardrail policy")
+
+	// No file configured: built-in policy
+	store, err := NewGuardrailPolicyStore("")
+	require.NoError(t, err)
+	assert.Equal(t, BuiltinGuardrailPolicyVersion, store.Policy().Version)
+	assert.NoError(t, store.Reload())
+}
+
+func TestGuardrailPolicyStoreWatch(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "policy.yaml")
+	require.NoError(t, os.WriteFile(path, []byte("version: v1\n"), 0o600))
+
+	store, err := NewGuardrailPolicyStore(path)
+	require.NoError(t, err)
+
+	ctx := t.Context()
+	store.Watch(ctx, 10*time.Millisecond)
+
+	require.NoError(t, os.WriteFile(path, []byte("version: v2-longer\n"), 0o600))
+	assert.Eventually(t, func() bool {
+		return store.Policy().Version == "v2-longer"
+	}, 2*time.Second, 10*time.Millisecond)
+}
+
+func TestActiveGuardrailPolicy(t *testing.T) {
+	previous := guardrailPolicies
+	defer func() { guardrailPolicies = previous }()
+
+	guardrailPolicies = nil
+	assert.Equal(t, BuiltinGuardrailPolicyVersion, ActiveGuardrailPolicy().Version)
+	assert.Equal(t, BuiltinGuardrailPolicyVersion, GetGuardrailStatus()["policy_version"])
+
+	path := filepath.Join(t.TempDir(), "policy.yaml")
+	require.NoError(t, os.WriteFile(path, []byte("version: security-review\nmax_question_length: 250\nrequire_document_focus: false\n"), 0o600))
+	require.NoError(t, InitGuardrailPolicy(&Config{GuardrailPolicyFile: path}))
+
+	assert.Equal(t, 250, ActiveGuardrailConfig().MaxQuestionLength)
+
+	status := GetGuardrailStatus()
+	assert.Equal(t, "security-review", status["policy_version"])
+	assert.Equal(t, 250, status["max_question_length"])
+	assert.Equal(t, false, status["document_focus_required"])
+
+	// A nil config falls back to the active policy
+	violations := ValidateQuestion("What does the company policy say about "+strings.Repeat("leave ", 50), nil)
+	require.NotEmpty(t, violations)
+	assert.Equal(t, "length_violation", violations[0].Type)
+}
@@ -0,0 +1,289 @@
+package utils
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// ProviderHTTPClient is the HTTP client shared by all AI provider services
+// Sharing one client lets connections to a provider be reused between requests
+// It starts with default settings and is rebuilt from the configuration by InitProviderHTTPClient
+var ProviderHTTPClient = newDefaultProviderHTTPClient()
+
+// InitProviderHTTPClient builds the shared provider client from the configuration
+// This should be called after AppConfig is loaded, it fails when the proxy URL or the CA file is invalid
+func InitProviderHTTPClient() error {
+	if AppConfig == nil {
+		return nil
+	}
+
+	client, err := NewProviderHTTPClient(AppConfig)
+	if err != nil {
+		return err
+	}
+	ProviderHTTPClient = client
+
+	LogInfo("Provider HTTP client initialized",
+		"timeout_seconds", AppConfig.AIHTTPTimeoutSecs,
+		"max_idle_conns_per_host", AppConfig.AIHTTPMaxIdleConnsPerHost,
+		"proxy", redactURL(AppConfig.AIHTTPProxyURL),
+		"ca_file", AppConfig.AIHTTPCAFile,
+		"debug", AppConfig.AIHTTPDebug)
+	return nil
+}
+
+// newDefaultProviderHTTPClient returns the client used before the configuration is loaded (tests, tools)
+func newDefaultProviderHTTPClient() *http.Client {
+	client, _ := NewProviderHTTPClient(&Config{})
+	return client
+}
+
+// NewProviderHTTPClient creates an HTTP client for AI provider calls
+// Timeouts: the overall timeout is an upper bound, the stage deadlines of the request context usually fire first
+// Proxy: AI_HTTP_PROXY_URL when set, otherwise the standard HTTP_PROXY / HTTPS_PROXY / NO_PROXY variables
+// TLS: the certificates of AI_HTTP_CA_FILE are trusted in addition to the system roots (corporate TLS proxies)
+// Zero values fall back to the defaults below
+func NewProviderHTTPClient(config *Config) (*http.Client, error) {
+	proxy := http.ProxyFromEnvironment
+	if config.AIHTTPProxyURL != "" {
+		proxyURL, err := url.Parse(config.AIHTTPProxyURL)
+		if err != nil || proxyURL.Scheme == "" || proxyURL.Host == "" {
+			return nil, fmt.Errorf("invalid AI_HTTP_PROXY_URL: %q", redactURL(config.AIHTTPProxyURL))
+		}
+		proxy = http.ProxyURL(proxyURL)
+	}
+
+	tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12}
+	if config.AIHTTPCAFile != "" {
+		rootCAs, err := loadRootCAs(config.AIHTTPCAFile)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.RootCAs = rootCAs
+	}
+
+	dialer := &net.Dialer{
+		Timeout:   secondsOrDefault(config.AIHTTPConnectTimeoutSecs, 10),
+		KeepAlive: 30 * time.Second,
+	}
+
+	transport := &http.Transport{
+		Proxy:                 proxy,
+		DialContext:           dialer.DialContext,
+		TLSClientConfig:       tlsConfig,
+		TLSHandshakeTimeout:   secondsOrDefault(config.AIHTTPTLSHandshakeTimeoutSecs, 10),
+		MaxIdleConns:          intOrDefault(config.AIHTTPMaxIdleConns, 100),
+		MaxIdleConnsPerHost:   intOrDefault(config.AIHTTPMaxIdleConnsPerHost, 10),
+		IdleConnTimeout:       secondsOrDefault(config.AIHTTPIdleConnTimeoutSecs, 90),
+		ExpectContinueTimeout: 1 * time.Second,
+		ForceAttemptHTTP2:     true,
+	}
+
+	var roundTripper http.RoundTripper = transport
+	if config.AIHTTPDebug {
+		roundTripper = &debugTransport{next: transport, secrets: configuredSecrets(config)}
+	}
+
+	return &http.Client{
+		Transport: roundTripper,
+		Timeout:   secondsOrDefault(config.AIHTTPTimeoutSecs, 120),
+	}, nil
+}
+
+// loadRootCAs returns the system roots extended with the PEM certificates of a file
+func loadRootCAs(path string) (*x509.CertPool, error) {
+	pem, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read AI_HTTP_CA_FILE: %v", err)
+	}
+
+	pool, err := x509.SystemCertPool()
+	if err != nil || pool == nil {
+		pool = x509.NewCertPool()
+	}
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("AI_HTTP_CA_FILE %s contains no PEM certificate", path)
+	}
+	return pool, nil
+}
+
+// secondsOrDefault converts a number of seconds, using the default when it is not positive
+func secondsOrDefault(seconds, defaultSeconds int64) time.Duration {
+	if seconds <= 0 {
+		seconds = defaultSeconds
+	}
+	return time.Duration(seconds) * time.Second
+}
+
+// intOrDefault returns value, or the default when it is not positive
+func intOrDefault(value, defaultValue int64) int {
+	if value <= 0 {
+		return int(defaultValue)
+	}
+	return int(value)
+}
+
+// configuredSecrets returns the API keys that must never appear in debug logs
+func configuredSecrets(config *Config) []string {
+	var secrets []string
+	for _, secret := range []string{config.OpenAIAPIKey, config.GoogleAIAPIKey, config.OpenAICompatibleAPIKey} {
+		if secret != "" {
+			secrets = append(secrets, secret)
+		}
+	}
+	return secrets
+}
+
+// debugBodyLimit is the number of body bytes written to the debug log
+const debugBodyLimit = 2048
+
+// sensitiveHeaders are the request headers that carry credentials
+var sensitiveHeaders = map[string]bool{
+	"authorization":       true,
+	"proxy-authorization": true,
+	"x-api-key":           true,
+	"api-key":             true,
+	"x-goog-api-key":      true,
+	"cookie":              true,
+}
+
+// sensitiveQueryParams are the query parameters that carry credentials (Gemini sends its key as ?key=)
+var sensitiveQueryParams = []string{"key", "api_key", "apikey", "access_token", "token"}
+
+// bearerTokenPattern matches bearer tokens and OpenAI-style keys that may be echoed in bodies
+var bearerTokenPattern = regexp.MustCompile(`(?i)(bearer\s+)[A-Za-z0-9._\-]+|sk-[A-Za-z0-9_\-]{8,}`)
+
+// debugTransport logs provider requests and responses with their secrets redacted
+// It is only installed when AI_HTTP_DEBUG=true because bodies contain user questions and documents
+type debugTransport struct {
+	next    http.RoundTripper
+	secrets []string
+}
+
+// RoundTrip logs the request, forwards it, then logs the response
+// Bodies are read, logged up to debugBodyLimit and restored so the caller still sees them
+func (t *debugTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	requestBody, err := peekBody(&req.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	LogInfo("Provider HTTP request",
+		"method", req.Method,
+		"url", redactURL(req.URL.String()),
+		"headers", t.redactHeaders(req.Header),
+		"body", t.redactBody(requestBody))
+
+	start := time.Now()
+	resp, err := t.next.RoundTrip(req)
+	if err != nil {
+		LogInfo("Provider HTTP request failed", "url", redactURL(req.URL.String()), "duration", time.Since(start), "error", t.redactBody(err.Error()))
+		return nil, err
+	}
+
+	responseBody, err := peekBody(&resp.Body)
+	if err != nil {
+		resp.Body.Close()
+		return nil, err
+	}
+
+	LogInfo("Provider HTTP response",
+		"url", redactURL(req.URL.String()),
+		"status", resp.StatusCode,
+		"duration", time.Since(start),
+		"body", t.redactBody(responseBody))
+	return resp, nil
+}
+
+// peekBody reads a body and replaces it with an identical reader
+func peekBody(body *io.ReadCloser) (string, error) {
+	if *body == nil || *body == http.NoBody {
+		return "", nil
+	}
+
+	data, err := io.ReadAll(*body)
+	(*body).Close()
+	if err != nil {
+		return "", fmt.Errorf("failed to read body for debug logging: %v", err)
+	}
+	*body = io.NopCloser(bytes.NewReader(data))
+	return string(data), nil
+}
+
+// redactHeaders returns the headers as a map with credential values replaced
+func (t *debugTransport) redactHeaders(header http.Header) map[string]string {
+	redacted := make(map[string]string, len(header))
+	for name, values := range header {
+		value := strings.Join(values, ", ")
+		if sensitiveHeaders[strings.ToLower(name)] {
+			value = "[REDACTED]"
+		}
+		redacted[name] = t.redactBody(value)
+	}
+	return redacted
+}
+
+// redactBody replaces configured keys and token-looking strings, then truncates to debugBodyLimit
+func (t *debugTransport) redactBody(body string) string {
+	for _, secret := range t.secrets {
+		body = strings.ReplaceAll(body, secret, "[REDACTED]")
+	}
+	body = bearerTokenPattern.ReplaceAllString(body, "${1}[REDACTED]")
+
+	if len(body) > debugBodyLimit {
+		body = body[:debugBodyLimit] + fmt.Sprintf("... (%d bytes truncated)", len(body)-debugBodyLimit)
+	}
+	return body
+}
+
+// redactURL removes the password and credential query parameters from a URL
+func redactURL(rawURL string) string {
+	if rawURL == "" {
+		return ""
+	}
+
+	parsed, err := url.Parse(rawURL)
+	if err != nil {
+		return "[invalid URL]"
+	}
+
+	if parsed.User != nil {
+		if _, hasPassword := parsed.User.Password(); hasPassword {
+			parsed.User = url.UserPassword(parsed.User.Username(), "REDACTED")
+		}
+	}
+
+	query := parsed.Query()
+	changed := false
+	for _, name := range sensitiveQueryParams {
+		if query.Has(name) {
+			query.Set(name, "REDACTED")
+			changed = true
+		}
+	}
+	if changed {
+		parsed.RawQuery = query.Encode()
+	}
+	return parsed.String()
+}
+
+// redactRequestError removes credentials from the URL embedded in a client error
+// http.Client.Do returns a *url.Error that repeats the full request URL, including Gemini's ?key=
+func redactRequestError(err error) error {
+	var urlErr *url.Error
+	if errors.As(err, &urlErr) {
+		return &url.Error{Op: urlErr.Op, URL: redactURL(urlErr.URL), Err: urlErr.Err}
+	}
+	return err
+}
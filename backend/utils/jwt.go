@@ -26,7 +26,10 @@ type RefreshTokenRequest struct {
 	RefreshToken string `json:"refreshToken" binding:"required"`
 }
 
-func generateToken(userID string, email string, tokenType string, expiration time.Duration) (string, error) {
+// generateToken signs a token of the given type
+// Access tokens carry the roles and permissions of the user (access), refresh tokens do not:
+// they are resolved again when the tokens are refreshed, so role changes apply within one access token lifetime
+func generateToken(userID string, email string, tokenType string, expiration time.Duration, access *UserAccess) (string, error) {
 	// Create a new JWT token
 	// NewWithClaims creates a new JWT token with the given claims
 	// jwt.SigningMethodHS256 is a signing approach that uses a secret key to sign the token
@@ -34,13 +37,18 @@ func generateToken(userID string, email string, tokenType string, expiration tim
 	// when clients send such a token to the server to verify that it is a valid token
 	// jwt.MapClaims is a struct that contains the claims of the token
 	// "exp" will be used internally by the server to check if the token is expired
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+	claims := jwt.MapClaims{
 		"userId": userID,
 		"email":  email,
 		"type":   tokenType,         // New field to identify token type
 		"iat":    time.Now().Unix(), // Issued at time
 		"exp":    time.Now().Add(expiration).Unix(),
-	})
+	}
+	if access != nil {
+		claims["roles"] = access.Roles
+		claims["permissions"] = access.Permissions
+	}
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
 
 	// Sign the token with the secret key
 	// the key will be used to verify incoming tokens
@@ -49,7 +57,8 @@ func generateToken(userID string, email string, tokenType string, expiration tim
 	return token.SignedString([]byte(os.Getenv("JWT_SECRET")))
 }
 
-func validateTokenWithType(token string) (string, string, error) {
+// validateTokenWithType validates a token and returns its user ID, type and the access it carries (empty for refresh tokens)
+func validateTokenWithType(token string) (string, string, UserAccess, error) {
 	// Check if the token starts with "Bearer " and extract the actual token
 	const bearerPrefix = "Bearer "
 	if len(token) > len(bearerPrefix) && token[:len(bearerPrefix)] == bearerPrefix {
@@ -83,15 +92,15 @@ func validateTokenWithType(token string) (string, string, error) {
 	if err != nil {
 		// Check if the error is about token expiration
 		if errors.Is(err, jwt.ErrTokenExpired) {
-			return "", "", errors.New("Token has expired")
+			return "", "", UserAccess{}, errors.New("Token has expired")
 		}
-		return "", "", errors.New("Could not parse token")
+		return "", "", UserAccess{}, errors.New("Could not parse token")
 	}
 
 	tokenIsValid := parsedToken.Valid
 
 	if !tokenIsValid {
-		return "", "", errors.New("Invalid Token!")
+		return "", "", UserAccess{}, errors.New("Invalid Token!")
 	}
 
 	// We want to check that whether the claims we got for this token
@@ -100,7 +109,7 @@ func validateTokenWithType(token string) (string, string, error) {
 
 	// claims are of type jwt.MapClaims which is more specific
 	if !ok {
-		return "", "", errors.New("Invalid token claims.")
+		return "", "", UserAccess{}, errors.New("Invalid token claims.")
 	}
 
 	// claims is essentially a map
@@ -108,31 +117,55 @@ func validateTokenWithType(token string) (string, string, error) {
 	// claims["userId"] will return a string for UUID
 	userId, ok := claims["userId"].(string)
 	if !ok {
-		return "", "", errors.New("Invalid user ID in token")
+		return "", "", UserAccess{}, errors.New("Invalid user ID in token")
 	}
 
 	// Get token type
 	tokenType, ok := claims["type"].(string)
 	if !ok {
-		return "", "", errors.New("Invalid token type")
+		return "", "", UserAccess{}, errors.New("Invalid token type")
+	}
+
+	// Roles and permissions are JSON arrays, decoded as []interface{}
+	// A token issued before roles existed has none, the user gets them back on the next refresh
+	access := UserAccess{
+		Roles:       claimStrings(claims, "roles"),
+		Permissions: claimStrings(claims, "permissions"),
 	}
 
 	// We want to return the actual userId
 	// and nil if there is no error
 	// this is to avoid having a harcoded UserId in routes/events.go
-	return userId, tokenType, nil
+	return userId, tokenType, access, nil
+}
+
+// claimStrings reads a list of strings from the claims of a token, missing or malformed lists are empty
+func claimStrings(claims jwt.MapClaims, key string) []string {
+	values, ok := claims[key].([]interface{})
+	if !ok {
+		return []string{}
+	}
+
+	result := make([]string, 0, len(values))
+	for _, value := range values {
+		if text, ok := value.(string); ok {
+			result = append(result, text)
+		}
+	}
+	return result
 }
 
 // GenerateTokenPair generates both access and refresh tokens
-func GenerateTokenPair(userID string, email string) (TokenResponse, error) {
+// access is embedded in the access token, see generateToken
+func GenerateTokenPair(userID string, email string, access UserAccess) (TokenResponse, error) {
 	// Create access token (short-lived, e.g., 15 minutes)
-	accessToken, err := generateToken(userID, email, AccessToken, time.Minute*15)
+	accessToken, err := generateToken(userID, email, AccessToken, time.Minute*15, &access)
 	if err != nil {
 		return TokenResponse{}, err
 	}
 
 	// Create refresh token (long-lived, e.g., 7 days)
-	refreshToken, err := generateToken(userID, email, RefreshToken, time.Hour*24*7)
+	refreshToken, err := generateToken(userID, email, RefreshToken, time.Hour*24*7, nil)
 	if err != nil {
 		return TokenResponse{}, err
 	}
@@ -144,24 +177,24 @@ func GenerateTokenPair(userID string, email string) (TokenResponse, error) {
 	}, nil
 }
 
-// ValidateAccessToken validates an access token and returns the user ID
-func ValidateAccessToken(tokenString string) (string, error) {
-	userId, tokenType, err := validateTokenWithType(tokenString)
+// ValidateAccessToken validates an access token and returns the user ID and the roles and permissions it carries
+func ValidateAccessToken(tokenString string) (string, UserAccess, error) {
+	userId, tokenType, access, err := validateTokenWithType(tokenString)
 	if err != nil {
-		return "", err
+		return "", UserAccess{}, err
 	}
 
 	// Ensure this is an access token
 	if tokenType != AccessToken {
-		return "", errors.New("Not an access token")
+		return "", UserAccess{}, errors.New("Not an access token")
 	}
 
-	return userId, nil
+	return userId, access, nil
 }
 
 // ValidateRefreshToken validates a refresh token and returns the user ID
 func ValidateRefreshToken(tokenString string) (string, error) {
-	userId, tokenType, err := validateTokenWithType(tokenString)
+	userId, tokenType, _, err := validateTokenWithType(tokenString)
 	if err != nil {
 		return "", err
 	}
@@ -0,0 +1,186 @@
+package utils
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// RouteRateLimit is the number of requests allowed per period for one client of a route
+// It is a token bucket of Requests tokens refilled evenly over Per, so bursts up to Requests are allowed
+type RouteRateLimit struct {
+	Requests int64
+	Per      time.Duration
+}
+
+// String formats the limit like the configuration ("60/1m0s")
+func (l RouteRateLimit) String() string {
+	return fmt.Sprintf("%d/%s", l.Requests, l.Per)
+}
+
+// ParseRouteRateLimit parses a limit such as "60/m", "10/30s" or "1000/h"
+// The period is a Go duration ("30s", "1h") or one of the units s, m, h
+func ParseRouteRateLimit(value string) (RouteRateLimit, error) {
+	parts := strings.SplitN(strings.TrimSpace(value), "/", 2)
+	if len(parts) != 2 {
+		return RouteRateLimit{}, fmt.Errorf("invalid rate limit %q, expected <requests>/<period>", value)
+	}
+
+	requests, err := strconv.ParseInt(strings.TrimSpace(parts[0]), 10, 64)
+	if err != nil || requests <= 0 {
+		return RouteRateLimit{}, fmt.Errorf("invalid request count in rate limit %q", value)
+	}
+
+	period := strings.TrimSpace(parts[1])
+	switch period {
+	case "s":
+		period = "1s"
+	case "m":
+		period = "1m"
+	case "h":
+		period = "1h"
+	}
+	per, err := time.ParseDuration(period)
+	if err != nil || per <= 0 {
+		return RouteRateLimit{}, fmt.Errorf("invalid period in rate limit %q", value)
+	}
+
+	return RouteRateLimit{Requests: requests, Per: per}, nil
+}
+
+// parseRouteRateLimits parses a comma-separated list such as "query=10/m,auth=5/m"
+// Invalid entries are skipped with a warning so a typo does not prevent the server from starting
+func parseRouteRateLimits(value string) map[string]RouteRateLimit {
+	limits := make(map[string]RouteRateLimit)
+	for _, entry := range strings.Split(value, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		route, limitValue, found := strings.Cut(entry, "=")
+		if !found {
+			LogWarn("Ignoring invalid route rate limit", "entry", entry)
+			continue
+		}
+		limit, err := ParseRouteRateLimit(limitValue)
+		if err != nil {
+			LogWarn("Ignoring invalid route rate limit", "entry", entry, "error", err)
+			continue
+		}
+		limits[strings.ToLower(strings.TrimSpace(route))] = limit
+	}
+	return limits
+}
+
+// RateLimitDecision is the outcome of one KeyedRateLimiter.Allow call
+// Remaining is the number of requests the client can still make right now
+// ResetAfter is the time until the bucket is full again, RetryAfter the time until the next token (0 when allowed)
+type RateLimitDecision struct {
+	Allowed    bool
+	Limit      int64
+	Remaining  int64
+	ResetAfter time.Duration
+	RetryAfter time.Duration
+}
+
+// keyedBucket is the token bucket of one client
+type keyedBucket struct {
+	lastSeen time.Time
+	tokens   float64
+}
+
+// KeyedRateLimiter keeps one token bucket per key (user ID, client IP)
+// Buckets idle for longer than idleTTL are evicted, a returning client simply starts with a full bucket
+type KeyedRateLimiter struct {
+	now       func() time.Time
+	buckets   map[string]*keyedBucket
+	lastSweep time.Time
+	limit     RouteRateLimit
+	idleTTL   time.Duration
+	mutex     sync.Mutex
+}
+
+// NewKeyedRateLimiter creates a limiter applying limit to every key
+// idleTTL is raised to the limit period: evicting a bucket before it could refill would reset it early
+func NewKeyedRateLimiter(limit RouteRateLimit, idleTTL time.Duration) *KeyedRateLimiter {
+	if idleTTL < limit.Per {
+		idleTTL = limit.Per
+	}
+
+	return &KeyedRateLimiter{
+		now:       time.Now,
+		buckets:   make(map[string]*keyedBucket),
+		lastSweep: time.Now(),
+		limit:     limit,
+		idleTTL:   idleTTL,
+	}
+}
+
+// Allow consumes one token of the key's bucket when one is available
+func (l *KeyedRateLimiter) Allow(key string) RateLimitDecision {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	now := l.now()
+	l.evictIdle(now)
+
+	capacity := float64(l.limit.Requests)
+	refillPerSecond := capacity / l.limit.Per.Seconds()
+
+	bucket, exists := l.buckets[key]
+	if !exists {
+		bucket = &keyedBucket{tokens: capacity, lastSeen: now}
+		l.buckets[key] = bucket
+	}
+
+	// Refill for the time elapsed since the last request of this key
+	elapsed := now.Sub(bucket.lastSeen).Seconds()
+	if elapsed > 0 {
+		bucket.tokens = math.Min(capacity, bucket.tokens+elapsed*refillPerSecond)
+	}
+	bucket.lastSeen = now
+
+	decision := RateLimitDecision{Limit: l.limit.Requests}
+	if bucket.tokens >= 1 {
+		bucket.tokens--
+		decision.Allowed = true
+	} else {
+		decision.RetryAfter = secondsDuration((1 - bucket.tokens) / refillPerSecond)
+	}
+
+	decision.Remaining = int64(math.Floor(bucket.tokens))
+	decision.ResetAfter = secondsDuration((capacity - bucket.tokens) / refillPerSecond)
+	return decision
+}
+
+// Len returns the number of keys currently tracked
+func (l *KeyedRateLimiter) Len() int {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	return len(l.buckets)
+}
+
+// evictIdle removes the buckets of keys idle for longer than idleTTL
+// It runs at most once per idleTTL, so the cost is spread over many requests
+// The caller must hold the mutex
+func (l *KeyedRateLimiter) evictIdle(now time.Time) {
+	if l.idleTTL <= 0 || now.Sub(l.lastSweep) < l.idleTTL {
+		return
+	}
+	l.lastSweep = now
+
+	for key, bucket := range l.buckets {
+		if now.Sub(bucket.lastSeen) > l.idleTTL {
+			delete(l.buckets, key)
+		}
+	}
+}
+
+// secondsDuration converts a number of seconds to a duration
+func secondsDuration(seconds float64) time.Duration {
+	return time.Duration(seconds * float64(time.Second))
+}
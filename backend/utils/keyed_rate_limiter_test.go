@@ -0,0 +1,77 @@
+package utils
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseRouteRateLimit(t *testing.T) {
+	limit, err := ParseRouteRateLimit("60/m")
+	require.NoError(t, err)
+	assert.Equal(t, RouteRateLimit{Requests: 60, Per: time.Minute}, limit)
+
+	limit, err = ParseRouteRateLimit(" 10 / 30s ")
+	require.NoError(t, err)
+	assert.Equal(t, RouteRateLimit{Requests: 10, Per: 30 * time.Second}, limit)
+
+	for _, invalid := range []string{"", "60", "0/m", "ten/m", "10/week"} {
+		_, err := ParseRouteRateLimit(invalid)
+		assert.Error(t, err, invalid)
+	}
+
+	limits := parseRouteRateLimits("Query=5/m, broken, upload=1/h")
+	assert.Equal(t, map[string]RouteRateLimit{
+		"query":  {Requests: 5, Per: time.Minute},
+		"upload": {Requests: 1, Per: time.Hour},
+	}, limits)
+
+	config := &Config{APIRateLimitRoutes: limits}
+	assert.Equal(t, int64(5), config.RouteRateLimitFor("query").Requests)
+	assert.Equal(t, int64(120), config.RouteRateLimitFor("default").Requests)
+}
+
+func TestKeyedRateLimiter(t *testing.T) {
+	now := time.Now()
+	limiter := NewKeyedRateLimiter(RouteRateLimit{Requests: 2, Per: 10 * time.Second}, time.Minute)
+	limiter.now = func() time.Time { return now }
+
+	first := limiter.Allow("user:1")
+	assert.True(t, first.Allowed)
+	assert.Equal(t, int64(1), first.Remaining)
+	assert.True(t, limiter.Allow("user:1").Allowed)
+
+	rejected := limiter.Allow("user:1")
+	assert.False(t, rejected.Allowed)
+	assert.Equal(t, int64(0), rejected.Remaining)
+	assert.Equal(t, 5*time.Second, rejected.RetryAfter)
+	assert.Equal(t, 10*time.Second, rejected.ResetAfter)
+
+	// Another client keeps its own bucket
+	assert.True(t, limiter.Allow("user:2").Allowed)
+
+	// One token is back after half the period
+	now = now.Add(5 * time.Second)
+	assert.True(t, limiter.Allow("user:1").Allowed)
+	assert.False(t, limiter.Allow("user:1").Allowed)
+}
+
+func TestKeyedRateLimiterEvictsIdleKeys(t *testing.T) {
+	now := time.Now()
+	limiter := NewKeyedRateLimiter(RouteRateLimit{Requests: 1, Per: time.Second}, time.Minute)
+	limiter.now = func() time.Time { return now }
+	limiter.lastSweep = now
+
+	limiter.Allow("ip:10.0.0.1")
+	limiter.Allow("ip:10.0.0.2")
+	assert.Equal(t, 2, limiter.Len())
+
+	now = now.Add(30 * time.Second)
+	limiter.Allow("ip:10.0.0.2")
+
+	now = now.Add(31 * time.Second)
+	limiter.Allow("ip:10.0.0.3")
+	assert.Equal(t, 2, limiter.Len(), "only the key idle for more than a minute is evicted")
+}
@@ -0,0 +1,224 @@
+package utils
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"math"
+	"strings"
+
+	"github.com/MauricioAliendre182/backend/db"
+	"github.com/lib/pq"
+)
+
+// LexicalEmbeddingService implements EmbeddingService in-process with feature-hashed TF-IDF vectors
+// It needs no model or external service, which makes it usable on air-gapped sites
+//
+// Chunks are embedded with term frequencies only, and the IDF weights are applied to the query vector,
+// so the cosine distance computed by pgvector ranks chunks by TF-IDF while stored vectors never
+// have to be recomputed when the corpus (and therefore the IDF) changes
+// The vocabulary and document frequencies are persisted in the lexical_vocabulary and lexical_stats tables
+type LexicalEmbeddingService struct {
+	store     LexicalStatsStore
+	dimension int
+}
+
+// LexicalStatsStore persists the corpus statistics used for IDF weights
+type LexicalStatsStore interface {
+	// DocumentFrequencies returns the number of indexed chunks and the document frequency of the given terms
+	DocumentFrequencies(terms []string) (int64, map[string]int64, error)
+	// ApplyDelta adds (or with negative values removes) chunks and term document frequencies
+	ApplyDelta(tx *sql.Tx, documentCount int64, frequencies map[string]int64) error
+}
+
+// NewLexicalEmbeddingService creates a lexical embedding service backed by Postgres
+// The dimension is LEXICAL_EMBEDDING_DIMENSION or EMBEDDING_DIMENSION so vectors fit the chunks table
+func NewLexicalEmbeddingService(config *Config) *LexicalEmbeddingService {
+	return NewLexicalEmbeddingServiceWithStore(config, postgresLexicalStore{})
+}
+
+// NewLexicalEmbeddingServiceWithStore creates a lexical embedding service with a custom statistics store
+func NewLexicalEmbeddingServiceWithStore(config *Config, store LexicalStatsStore) *LexicalEmbeddingService {
+	return &LexicalEmbeddingService{
+		store:     store,
+		dimension: config.EmbeddingDimensionFor(LexicalProvider),
+	}
+}
+
+// NewLexicalChatService creates the chat service paired with the lexical provider
+// Without a language model, answers are extracted from the retrieved chunks
+func NewLexicalChatService() *ExtractiveChatService {
+	return NewExtractiveChatService("Lexical")
+}
+
+// GenerateEmbedding embeds a query, weighting each term by its inverse document frequency
+func (s *LexicalEmbeddingService) GenerateEmbedding(ctx context.Context, text string) (Vector, error) {
+	cleanedText := strings.TrimSpace(text)
+	if cleanedText == "" {
+		return nil, fmt.Errorf("text cannot be empty")
+	}
+
+	counts := termCounts(cleanedText)
+	terms := make([]string, 0, len(counts))
+	for term := range counts {
+		terms = append(terms, term)
+	}
+
+	documentCount, frequencies, err := s.store.DocumentFrequencies(terms)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load lexical statistics: %v", err)
+	}
+
+	vector := make(Vector, s.dimension)
+	for term, count := range counts {
+		index, sign := hashTerm(term, s.dimension)
+		vector[index] += sign * termWeight(count) * inverseDocumentFrequency(documentCount, frequencies[term])
+	}
+
+	normalizeVector(vector)
+	return vector, nil
+}
+
+// GenerateBatchEmbeddings embeds chunks with sublinear term frequencies
+// This is the method used at ingestion, the IDF part is applied on the query side
+func (s *LexicalEmbeddingService) GenerateBatchEmbeddings(ctx context.Context, texts []string) ([]Vector, error) {
+	if len(texts) == 0 {
+		return nil, fmt.Errorf("texts cannot be empty")
+	}
+
+	result := make([]Vector, 0, len(texts))
+	for _, text := range texts {
+		cleanedText := strings.TrimSpace(text)
+		if cleanedText == "" {
+			return nil, fmt.Errorf("text cannot be empty")
+		}
+
+		vector := make(Vector, s.dimension)
+		for term, count := range termCounts(cleanedText) {
+			index, sign := hashTerm(term, s.dimension)
+			vector[index] += sign * termWeight(count)
+		}
+
+		normalizeVector(vector)
+		result = append(result, vector)
+	}
+
+	return result, nil
+}
+
+// GetProviderName returns the provider name
+func (s *LexicalEmbeddingService) GetProviderName() string {
+	return "Lexical"
+}
+
+// AddToCorpus records the terms of newly indexed chunks
+func (s *LexicalEmbeddingService) AddToCorpus(tx *sql.Tx, texts []string) error {
+	return s.store.ApplyDelta(tx, int64(len(texts)), corpusFrequencies(texts, 1))
+}
+
+// RemoveFromCorpus forgets the terms of deleted chunks
+func (s *LexicalEmbeddingService) RemoveFromCorpus(tx *sql.Tx, texts []string) error {
+	return s.store.ApplyDelta(tx, -int64(len(texts)), corpusFrequencies(texts, -1))
+}
+
+// termCounts counts the occurrences of each term in a text
+func termCounts(text string) map[string]int {
+	counts := make(map[string]int)
+	for _, term := range LexicalTerms(text) {
+		counts[term]++
+	}
+	return counts
+}
+
+// corpusFrequencies counts in how many texts each term appears, multiplied by sign
+func corpusFrequencies(texts []string, sign int64) map[string]int64 {
+	frequencies := make(map[string]int64)
+	for _, text := range texts {
+		for term := range termCounts(text) {
+			frequencies[term] += sign
+		}
+	}
+	return frequencies
+}
+
+// termWeight is the sublinear term frequency 1 + ln(tf)
+func termWeight(count int) float32 {
+	return float32(1 + math.Log(float64(count)))
+}
+
+// inverseDocumentFrequency is the smoothed IDF ln((1 + N) / (1 + df)) + 1
+func inverseDocumentFrequency(documentCount, documentFrequency int64) float32 {
+	return float32(math.Log(float64(1+documentCount)/float64(1+documentFrequency)) + 1)
+}
+
+// postgresLexicalStore stores the lexical statistics in Postgres
+type postgresLexicalStore struct{}
+
+// DocumentFrequencies reads the chunk count and the document frequencies of the given terms
+func (postgresLexicalStore) DocumentFrequencies(terms []string) (int64, map[string]int64, error) {
+	frequencies := make(map[string]int64, len(terms))
+
+	var documentCount int64
+	err := db.DB.QueryRow(`SELECT COALESCE((SELECT document_count FROM lexical_stats WHERE id = 1), 0)`).Scan(&documentCount)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	if len(terms) == 0 {
+		return documentCount, frequencies, nil
+	}
+
+	rows, err := db.DB.Query(`SELECT term, document_frequency FROM lexical_vocabulary WHERE term = ANY($1)`, pq.Array(terms))
+	if err != nil {
+		return 0, nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var term string
+		var frequency int64
+		if err := rows.Scan(&term, &frequency); err != nil {
+			return 0, nil, err
+		}
+		frequencies[term] = frequency
+	}
+
+	return documentCount, frequencies, rows.Err()
+}
+
+// ApplyDelta updates the chunk count and the vocabulary within the given transaction
+// Terms whose document frequency drops to zero are removed from the vocabulary
+func (postgresLexicalStore) ApplyDelta(tx *sql.Tx, documentCount int64, frequencies map[string]int64) error {
+	terms := make([]string, 0, len(frequencies))
+	deltas := make([]int64, 0, len(frequencies))
+	for term, delta := range frequencies {
+		terms = append(terms, term)
+		deltas = append(deltas, delta)
+	}
+
+	_, err := tx.Exec(`
+	INSERT INTO lexical_vocabulary (term, document_frequency)
+	SELECT * FROM unnest($1::text[], $2::bigint[])
+	ON CONFLICT (term) DO UPDATE
+	SET document_frequency = lexical_vocabulary.document_frequency + EXCLUDED.document_frequency
+	`, pq.Array(terms), pq.Array(deltas))
+	if err != nil {
+		return fmt.Errorf("failed to update lexical vocabulary: %v", err)
+	}
+
+	if _, err := tx.Exec(`DELETE FROM lexical_vocabulary WHERE document_frequency <= 0`); err != nil {
+		return fmt.Errorf("failed to prune lexical vocabulary: %v", err)
+	}
+
+	_, err = tx.Exec(`
+	INSERT INTO lexical_stats (id, document_count, updated_at)
+	VALUES (1, GREATEST($1::bigint, 0), now())
+	ON CONFLICT (id) DO UPDATE
+	SET document_count = GREATEST(lexical_stats.document_count + $1::bigint, 0), updated_at = now()
+	`, documentCount)
+	if err != nil {
+		return fmt.Errorf("failed to update lexical stats: %v", err)
+	}
+
+	return nil
+}
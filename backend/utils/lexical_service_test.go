@@ -0,0 +1,84 @@
+package utils
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// memoryLexicalStore keeps lexical statistics in memory
+type memoryLexicalStore struct {
+	frequencies   map[string]int64
+	documentCount int64
+}
+
+func (m *memoryLexicalStore) DocumentFrequencies(terms []string) (int64, map[string]int64, error) {
+	result := make(map[string]int64)
+	for _, term := range terms {
+		if frequency, ok := m.frequencies[term]; ok {
+			result[term] = frequency
+		}
+	}
+	return m.documentCount, result, nil
+}
+
+func (m *memoryLexicalStore) ApplyDelta(_ *sql.Tx, documentCount int64, frequencies map[string]int64) error {
+	m.documentCount += documentCount
+	for term, delta := range frequencies {
+		m.frequencies[term] += delta
+		if m.frequencies[term] <= 0 {
+			delete(m.frequencies, term)
+		}
+	}
+	return nil
+}
+
+func TestLexicalEmbeddingService(t *testing.T) {
+	store := &memoryLexicalStore{frequencies: map[string]int64{}}
+	service := NewLexicalEmbeddingServiceWithStore(&Config{EmbeddingDimension: 256}, store)
+
+	chunks := []string{
+		"Employees receive 15 vacation days per year. Vacation requests go to the manager.",
+		"The manager approves expense reports every month.",
+		"The manager schedules the yearly review.",
+	}
+	require.NoError(t, service.AddToCorpus(nil, chunks))
+	assert.Equal(t, int64(3), store.documentCount)
+	assert.Equal(t, int64(3), store.frequencies["manager"])
+	assert.Equal(t, int64(1), store.frequencies["vacation"])
+
+	vectors, err := service.GenerateBatchEmbeddings(context.Background(), chunks)
+	require.NoError(t, err)
+	require.Len(t, vectors, 3)
+	assert.Len(t, vectors[0], 256)
+
+	// The rare term "vacation" outweighs "manager", which appears in every chunk
+	query, err := service.GenerateEmbedding(context.Background(), "vacation manager")
+	require.NoError(t, err)
+	assert.Greater(t, dot(query, vectors[0]), dot(query, vectors[1]))
+	assert.Greater(t, dot(query, vectors[0]), dot(query, vectors[2]))
+
+	require.NoError(t, service.RemoveFromCorpus(nil, chunks[:1]))
+	assert.Equal(t, int64(2), store.documentCount)
+	assert.NotContains(t, store.frequencies, "vacation")
+	assert.Equal(t, int64(2), store.frequencies["manager"])
+
+	_, err = service.GenerateEmbedding(context.Background(), "  ")
+	assert.Error(t, err)
+}
+
+func TestInProcessProvidersAreNotEmbeddingFallbacks(t *testing.T) {
+	config := &Config{
+		AIFallbackChain: []AIProvider{LexicalProvider, OpenAIProvider},
+		OpenAIAPIKey:    "sk-test-key",
+		EmbeddingModel:  "text-embedding-3-small",
+	}
+
+	embedding, err := NewAIServiceFactory(config).CreateEmbeddingService()
+	require.NoError(t, err)
+	_, isLexical := embedding.(*LexicalEmbeddingService)
+	assert.True(t, isLexical, "OpenAI vectors are not comparable with lexical vectors")
+}
@@ -2,19 +2,30 @@ package utils
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
 	"strings"
+	"sync/atomic"
 
 	"github.com/lib/pq"
 )
 
+// ollamaBatchSize is the number of texts sent in one /api/embed request
+const ollamaBatchSize = 32
+
 // OllamaEmbeddingService implements EmbeddingService for Ollama
+// batchUnsupported is set once the server answered that /api/embed does not exist (Ollama before 0.3.4),
+// later batches then go straight to one /api/embeddings request per text
+// limiter paces the batch requests (and the per-text fallback) to this Ollama server
 type OllamaEmbeddingService struct {
-	config  *Config
-	baseURL string
+	config           *Config
+	baseURL          string
+	limiter          *RateLimiter
+	batchUnsupported atomic.Bool
 }
 
 // Ollama API structures for embeddings
@@ -29,6 +40,18 @@ type ollamaEmbeddingResponse struct {
 	Embedding []float32 `json:"embedding"`
 }
 
+// ollamaBatchEmbeddingRequest is the request of the batch endpoint /api/embed
+type ollamaBatchEmbeddingRequest struct {
+	Model string   `json:"model"`
+	Input []string `json:"input"`
+}
+
+// ollamaBatchEmbeddingResponse holds one embedding per input, in input order
+type ollamaBatchEmbeddingResponse struct {
+	Embeddings      [][]float32 `json:"embeddings"`
+	PromptEvalCount int         `json:"prompt_eval_count"`
+}
+
 // NewOllamaEmbeddingService creates a new Ollama embedding service
 // It initializes the service with the configuration and base URL for Ollama
 // This allows the service to make requests to the Ollama API for generating embeddings
@@ -36,11 +59,12 @@ func NewOllamaEmbeddingService(config *Config) *OllamaEmbeddingService {
 	return &OllamaEmbeddingService{
 		config:  config,
 		baseURL: config.OllamaBaseURL,
+		limiter: NewProviderRateLimiter(config),
 	}
 }
 
 // GenerateEmbedding generates embeddings using Ollama API
-func (s *OllamaEmbeddingService) GenerateEmbedding(text string) (Vector, error) {
+func (s *OllamaEmbeddingService) GenerateEmbedding(ctx context.Context, text string) (Vector, error) {
 	// trim whitespace from the input text
 	// If the text is empty after trimming, return an error
 	cleanedText := strings.TrimSpace(text)
@@ -55,47 +79,164 @@ func (s *OllamaEmbeddingService) GenerateEmbedding(text string) (Vector, error)
 
 	// Retry the embedding request with exponential backoff
 	// This helps to handle temporary network issues or API rate limits
-	err := RetryWithBackoff(retryConfig, func() error {
+	retryResult, err := Retry(ctx, retryConfig, "ollama.embedding", func() error {
 		// Make the actual embedding request to Ollama
 		// *embedding is to dereference the pointer and assign the embedding data
-		return s.makeEmbeddingRequest(cleanedText, &embedding)
+		return s.makeEmbeddingRequest(ctx, cleanedText, &embedding)
 	})
 
 	if err != nil {
-		LogError("Failed to get Ollama embedding after retries", err, "text_length", len(cleanedText))
+		LogError("Failed to get Ollama embedding after retries", err, "attempts", retryResult.Attempts, "text_length", len(cleanedText))
 		return nil, err
 	}
 
 	return Vector(embedding), nil
 }
 
-// GenerateBatchEmbeddings generates embeddings for multiple texts (Ollama doesn't support batch, so we call individually)
-func (s *OllamaEmbeddingService) GenerateBatchEmbeddings(texts []string) ([]Vector, error) {
+// GenerateBatchEmbeddings generates embeddings for multiple texts
+// Texts are sent in batches of ollamaBatchSize to /api/embed, EMBEDDING_PARALLELISM batches at a time
+// Servers without /api/embed get one /api/embeddings request per text through the same worker pool
+// Every request, batch or single text, waits for a token of the service limiter
+// Both endpoints return vectors compared by cosine distance, so /api/embed normalizing them makes no difference
+func (s *OllamaEmbeddingService) GenerateBatchEmbeddings(ctx context.Context, texts []string) ([]Vector, error) {
 	// Check if the input texts slice is empty
 	// If it is empty, return an error
 	if len(texts) == 0 {
 		return nil, fmt.Errorf("texts cannot be empty")
 	}
 
-	// Create a slice to hold the embeddings for each text
-	// This will store the embeddings generated for each input text
-	embeddings := make([]Vector, len(texts))
+	cleanedTexts := make([]string, len(texts))
 	for i, text := range texts {
-		// Generate embedding for each text
-		// This will call the GenerateEmbedding method for each text
-		embedding, err := s.GenerateEmbedding(text)
+		cleanedTexts[i] = strings.TrimSpace(text)
+		if cleanedTexts[i] == "" {
+			return nil, fmt.Errorf("failed to get embedding for text %d: text cannot be empty", i)
+		}
+	}
+
+	parallelism := EmbeddingParallelism(s.config)
+
+	if !s.batchUnsupported.Load() {
+		embeddings, err := embedInBatches(ctx, cleanedTexts, ollamaBatchSize, parallelism, s.limiter, s.generateBatch)
+		if err == nil {
+			LogInfo("Successfully generated Ollama batch embeddings", "text_count", len(texts), "endpoint", "/api/embed")
+			return embeddings, nil
+		}
+		if !isMissingOllamaEndpoint(err) {
+			return nil, err
+		}
+
+		s.batchUnsupported.Store(true)
+		LogWarn("Ollama server has no /api/embed endpoint, embedding texts one by one", "base_url", s.baseURL)
+	}
+
+	// Fallback: one request per text, still bounded by the worker pool
+	embeddings, err := embedInBatches(ctx, cleanedTexts, 1, parallelism, s.limiter, func(ctx context.Context, batch []string) ([]Vector, error) {
+		embedding, err := s.GenerateEmbedding(ctx, batch[0])
 		if err != nil {
-			return nil, fmt.Errorf("failed to get embedding for text %d: %v", i, err)
+			return nil, err
 		}
-		// Store the embedding in the slice
-		// embeddings[i] is the ith embedding for the ith text
-		embeddings[i] = embedding
+		return []Vector{embedding}, nil
+	})
+	if err != nil {
+		return nil, err
 	}
 
-	LogInfo("Successfully generated Ollama batch embeddings", "text_count", len(texts))
+	LogInfo("Successfully generated Ollama batch embeddings", "text_count", len(texts), "endpoint", "/api/embeddings")
 	return embeddings, nil
 }
 
+// generateBatch embeds one batch through /api/embed with retries
+func (s *OllamaEmbeddingService) generateBatch(ctx context.Context, batch []string) ([]Vector, error) {
+	var embeddings []Vector
+	retryResult, err := Retry(ctx, DefaultRetryConfig(), "ollama.batch_embedding", func() error {
+		return s.makeBatchEmbeddingRequest(ctx, batch, &embeddings)
+	})
+	if err != nil {
+		LogError("Failed to get Ollama batch embeddings after retries", err, "attempts", retryResult.Attempts, "text_count", len(batch))
+		return nil, err
+	}
+	return embeddings, nil
+}
+
+// makeBatchEmbeddingRequest makes one /api/embed request for several texts
+func (s *OllamaEmbeddingService) makeBatchEmbeddingRequest(ctx context.Context, texts []string, embeddings *[]Vector) error {
+	request := ollamaBatchEmbeddingRequest{
+		Model: s.config.EmbeddingModelFor(OllamaProvider),
+		Input: texts,
+	}
+
+	jsonData, err := json.Marshal(request)
+	if err != nil {
+		return fmt.Errorf("failed to marshal request: %v", err)
+	}
+
+	url := fmt.Sprintf("%s/api/embed", s.baseURL)
+	req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewBuffer(jsonData))
+	if err != nil {
+		return fmt.Errorf("failed to create request: %v", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := ProviderHTTPClient.Do(req)
+	if err != nil {
+		LogError("Failed to make Ollama batch embedding request", err, "text_count", len(texts))
+		return NewNetworkProviderError("Ollama", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		LogError("Ollama batch API error", fmt.Errorf("status: %s", resp.Status), "response_body", string(body))
+		return NewHTTPProviderError("Ollama", resp, body)
+	}
+
+	var response ollamaBatchEmbeddingResponse
+	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
+		LogError("Failed to decode Ollama batch response", err)
+		return fmt.Errorf("failed to decode response: %v", err)
+	}
+
+	if len(response.Embeddings) != len(texts) {
+		return fmt.Errorf("expected %d embeddings, got %d", len(texts), len(response.Embeddings))
+	}
+
+	*embeddings = make([]Vector, len(response.Embeddings))
+	for i, values := range response.Embeddings {
+		if len(values) == 0 {
+			return fmt.Errorf("no embedding data received for text %d", i)
+		}
+		(*embeddings)[i] = Vector(values)
+	}
+	s.recordUsage(ctx, response.PromptEvalCount, texts...)
+	return nil
+}
+
+// recordUsage records the prompt tokens of an embedding request
+// The legacy /api/embeddings endpoint reports no usage, the tokens are then estimated from the texts
+func (s *OllamaEmbeddingService) recordUsage(ctx context.Context, promptTokens int, texts ...string) {
+	usage := TokenUsage{
+		Operation:    UsageOperationEmbedding,
+		Provider:     string(OllamaProvider),
+		Model:        s.config.EmbeddingModelFor(OllamaProvider),
+		PromptTokens: promptTokens,
+	}
+	if usage.PromptTokens == 0 {
+		usage.PromptTokens = estimatedPromptTokens(texts...)
+		usage.Estimated = true
+	}
+	RecordTokenUsage(ctx, usage)
+}
+
+// isMissingOllamaEndpoint reports whether an error is the 404 of a server that has no /api/embed
+// Ollama also answers 404 for an unknown model, that error mentions the model and must not trigger the fallback
+func isMissingOllamaEndpoint(err error) bool {
+	var providerErr *ProviderError
+	if !errors.As(err, &providerErr) || providerErr.StatusCode != http.StatusNotFound {
+		return false
+	}
+	return !strings.Contains(strings.ToLower(providerErr.Message), "model")
+}
+
 // GetProviderName returns the provider name
 // This is used to identify the AI service provider
 // In this case, it returns "Ollama" as the provider name
@@ -104,10 +245,10 @@ func (s *OllamaEmbeddingService) GetProviderName() string {
 }
 
 // makeEmbeddingRequest makes an embedding request to Ollama
-func (s *OllamaEmbeddingService) makeEmbeddingRequest(text string, embedding *pq.Float32Array) error {
+func (s *OllamaEmbeddingService) makeEmbeddingRequest(ctx context.Context, text string, embedding *pq.Float32Array) error {
 	// Create request
 	request := ollamaEmbeddingRequest{
-		Model:  s.config.EmbeddingModel,
+		Model:  s.config.EmbeddingModelFor(OllamaProvider),
 		Prompt: text,
 	}
 
@@ -122,7 +263,7 @@ func (s *OllamaEmbeddingService) makeEmbeddingRequest(text string, embedding *pq
 	// This sends the JSON data to the Ollama API endpoint for embeddings
 	// The response will contain the embedding data
 	url := fmt.Sprintf("%s/api/embeddings", s.baseURL)
-	req, err := http.NewRequest("POST", url, bytes.NewBuffer(jsonData))
+	req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewBuffer(jsonData))
 	if err != nil {
 		return fmt.Errorf("failed to create request: %v", err)
 	}
@@ -134,11 +275,10 @@ func (s *OllamaEmbeddingService) makeEmbeddingRequest(text string, embedding *pq
 	// Make the HTTP request to Ollama
 	// This sends the request to the Ollama API and waits for the response
 	// Do() is used to execute the request
-	client := &http.Client{}
-	resp, err := client.Do(req)
+	resp, err := ProviderHTTPClient.Do(req)
 	if err != nil {
 		LogError("Failed to make Ollama API request", err, "text_length", len(text))
-		return fmt.Errorf("failed to make request: %v", err)
+		return NewNetworkProviderError("Ollama", err)
 	}
 
 	// Ensure the response body is closed after reading
@@ -150,7 +290,7 @@ func (s *OllamaEmbeddingService) makeEmbeddingRequest(text string, embedding *pq
 	if resp.StatusCode != http.StatusOK {
 		body, _ := io.ReadAll(resp.Body)
 		LogError("Ollama API error", fmt.Errorf("status: %s", resp.Status), "response_body", string(body))
-		return fmt.Errorf("Ollama API error: %s - %s", resp.Status, string(body))
+		return NewHTTPProviderError("Ollama", resp, body)
 	}
 
 	// Decode the response body into the ollamaEmbeddingResponse struct
@@ -172,6 +312,7 @@ func (s *OllamaEmbeddingService) makeEmbeddingRequest(text string, embedding *pq
 	// This allows the caller to receive the generated embedding
 	// *embedding is to dereference the pointer and assign the embedding data
 	*embedding = pq.Float32Array(response.Embedding)
+	s.recordUsage(ctx, 0, text)
 	LogInfo("Successfully generated Ollama embedding", "text_length", len(text), "embedding_size", len(*embedding))
 	return nil
 }
@@ -185,16 +326,28 @@ type OllamaChatService struct {
 
 // Ollama API structures for chat
 type ollamaChatRequest struct {
-	Model  string `json:"model"`
-	Prompt string `json:"prompt"`
-	Stream bool   `json:"stream"`
+	Options *ollamaOptions `json:"options,omitempty"`
+	Model   string         `json:"model"`
+	Prompt  string         `json:"prompt"`
+	System  string         `json:"system,omitempty"`
+	Stream  bool           `json:"stream"`
+}
+
+// ollamaOptions holds the model parameters sent with a generate request
+// Ollama uses a small default context (2048 tokens) unless num_ctx is set,
+// so we send the same window the context packer assumed
+type ollamaOptions struct {
+	NumCtx     int `json:"num_ctx,omitempty"`
+	NumPredict int `json:"num_predict,omitempty"`
 }
 
 // ollamaChatResponse represents the response structure from Ollama chat API
 // It contains the generated response and a done flag indicating if the response is complete
 type ollamaChatResponse struct {
-	Response string `json:"response"`
-	Done     bool   `json:"done"`
+	Response        string `json:"response"`
+	Done            bool   `json:"done"`
+	PromptEvalCount int    `json:"prompt_eval_count"`
+	EvalCount       int    `json:"eval_count"`
 }
 
 // NewOllamaChatService creates a new Ollama chat service
@@ -204,12 +357,12 @@ func NewOllamaChatService(config *Config) *OllamaChatService {
 	return &OllamaChatService{
 		config:  config,
 		baseURL: config.OllamaBaseURL,
-		model:   config.ChatModel,
+		model:   config.ChatModelFor(OllamaProvider),
 	}
 }
 
 // GenerateResponse generates a response using Ollama chat completion
-func (s *OllamaChatService) GenerateResponse(question, context string) (string, error) {
+func (s *OllamaChatService) GenerateResponse(ctx context.Context, prompt, systemPrompt string) (string, error) {
 	// Default retry configuration for chat requests
 	// This allows us to handle transient errors and retry the request
 	var response string
@@ -217,20 +370,20 @@ func (s *OllamaChatService) GenerateResponse(question, context string) (string,
 
 	// Retry the chat request with exponential backoff
 	// This helps to handle temporary network issues or API rate limits
-	err := RetryWithBackoff(retryConfig, func() error {
+	retryResult, err := Retry(ctx, retryConfig, "ollama.chat", func() error {
 		// Make the actual chat request to Ollama
-		// This sends the question and context to the Ollama API for generating a response
+		// This sends the prompt and system prompt to the Ollama API for generating a response
 		// *response is to dereference the pointer and assign the response data
 		// This allows us to modify the response directly without returning it
-		return s.makeChatRequest(question, context, &response)
+		return s.makeChatRequest(ctx, prompt, systemPrompt, &response)
 	})
 
 	if err != nil {
-		LogError("Failed to generate Ollama response after retries", err, "question", question)
+		LogError("Failed to generate Ollama response after retries", err, "attempts", retryResult.Attempts, "prompt_length", len(prompt))
 		return "", err
 	}
 
-	LogInfo("Successfully generated Ollama response", "question_length", len(question), "response_length", len(response))
+	LogInfo("Successfully generated Ollama response", "prompt_length", len(prompt), "response_length", len(response), "attempts", retryResult.Attempts)
 	return response, nil
 }
 
@@ -247,23 +400,18 @@ func (s *OllamaChatService) GetModel() string {
 // makeChatRequest makes a chat completion request to Ollama
 // *string means that the response will be written to the provided string pointer
 // This allows us to modify the response directly without returning it
-func (s *OllamaChatService) makeChatRequest(question, context string, response *string) error {
-	// Build prompt with context
-	prompt := fmt.Sprintf(`You are a helpful assistant that answers questions based on provided context. 
-Use the following context to answer the user's question. If the context doesn't contain enough information to answer the question, say so clearly.
-
-Context:
-%s
-
-Question: %s
-
-Answer:`, context, question)
-
+func (s *OllamaChatService) makeChatRequest(ctx context.Context, prompt, systemPrompt string, response *string) error {
 	// Create request
+	// The system prompt goes into Ollama's "system" field, which replaces the model's default system message
 	request := ollamaChatRequest{
 		Model:  s.model,
 		Prompt: prompt,
+		System: systemPrompt,
 		Stream: false,
+		Options: &ollamaOptions{
+			NumCtx:     ChatContextWindow(s.config),
+			NumPredict: AnswerTokenLimit(s.config),
+		},
 	}
 
 	// Marshal the request into JSON
@@ -277,7 +425,7 @@ Answer:`, context, question)
 	// This sends the JSON data to the Ollama API endpoint for chat completion
 	// The response will contain the generated answer
 	url := fmt.Sprintf("%s/api/generate", s.baseURL)
-	req, err := http.NewRequest("POST", url, bytes.NewBuffer(jsonData))
+	req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewBuffer(jsonData))
 	if err != nil {
 		return fmt.Errorf("failed to create request: %v", err)
 	}
@@ -288,11 +436,10 @@ Answer:`, context, question)
 
 	// Make the HTTP request to Ollama
 	// This sends the request to the Ollama API and waits for the response
-	client := &http.Client{}
-	resp, err := client.Do(req)
+	resp, err := ProviderHTTPClient.Do(req)
 	if err != nil {
 		LogError("Failed to make Ollama chat request", err)
-		return fmt.Errorf("failed to make request: %v", err)
+		return NewNetworkProviderError("Ollama", err)
 	}
 
 	// Defer the closing of the response body
@@ -304,7 +451,7 @@ Answer:`, context, question)
 	if resp.StatusCode != http.StatusOK {
 		body, _ := io.ReadAll(resp.Body)
 		LogError("Ollama chat API error", fmt.Errorf("status: %s", resp.Status), "response_body", string(body))
-		return fmt.Errorf("Ollama API error: %s - %s", resp.Status, string(body))
+		return NewHTTPProviderError("Ollama", resp, body)
 	}
 
 	// Decode the response body into the ollamaChatResponse struct
@@ -326,5 +473,20 @@ Answer:`, context, question)
 	// This allows the caller to receive the generated response
 	// *response is to dereference the pointer and assign the response data
 	*response = strings.TrimSpace(chatResponse.Response)
+
+	// Record the token usage reported by Ollama (prompt_eval_count is omitted when the prompt was cached)
+	usage := TokenUsage{
+		Operation:        UsageOperationChat,
+		Provider:         string(OllamaProvider),
+		Model:            s.model,
+		PromptTokens:     chatResponse.PromptEvalCount,
+		CompletionTokens: chatResponse.EvalCount,
+	}
+	if usage.TotalTokens() == 0 {
+		usage.PromptTokens = estimatedPromptTokens(systemPrompt, prompt)
+		usage.CompletionTokens = estimatedPromptTokens(*response)
+		usage.Estimated = true
+	}
+	RecordTokenUsage(ctx, usage)
 	return nil
 }
@@ -0,0 +1,96 @@
+package utils
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestOllamaBatchEmbeddings(t *testing.T) {
+	var batchCalls atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "/api/embed", r.URL.Path)
+		batchCalls.Add(1)
+
+		var request ollamaBatchEmbeddingRequest
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&request))
+		assert.Equal(t, "nomic-embed-text", request.Model)
+
+		response := ollamaBatchEmbeddingResponse{}
+		for _, input := range request.Input {
+			response.Embeddings = append(response.Embeddings, []float32{float32(len(input))})
+		}
+		json.NewEncoder(w).Encode(response)
+	}))
+	defer server.Close()
+
+	service := NewOllamaEmbeddingService(&Config{OllamaBaseURL: server.URL, EmbeddingModel: "nomic-embed-text"})
+
+	texts := make([]string, ollamaBatchSize+3)
+	for i := range texts {
+		texts[i] = strings.Repeat("x", i+1)
+	}
+
+	embeddings, err := service.GenerateBatchEmbeddings(context.Background(), texts)
+	require.NoError(t, err)
+	require.Len(t, embeddings, len(texts))
+	for i, embedding := range embeddings {
+		assert.Equal(t, Vector{float32(i + 1)}, embedding)
+	}
+	assert.Equal(t, int32(2), batchCalls.Load())
+
+	// Each batch took a token of the provider limiter (10 by default)
+	assert.Equal(t, int64(8), service.limiter.GetTokens())
+}
+
+func TestOllamaBatchEmbeddingsFallsBackWithoutEmbedEndpoint(t *testing.T) {
+	var embedCalls, legacyCalls atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/embed":
+			embedCalls.Add(1)
+			http.NotFound(w, r)
+		case "/api/embeddings":
+			legacyCalls.Add(1)
+			var request ollamaEmbeddingRequest
+			require.NoError(t, json.NewDecoder(r.Body).Decode(&request))
+			json.NewEncoder(w).Encode(ollamaEmbeddingResponse{Embedding: []float32{float32(len(request.Prompt))}})
+		}
+	}))
+	defer server.Close()
+
+	service := NewOllamaEmbeddingService(&Config{OllamaBaseURL: server.URL, EmbeddingModel: "nomic-embed-text"})
+
+	embeddings, err := service.GenerateBatchEmbeddings(context.Background(), []string{"a", "bb", "ccc"})
+	require.NoError(t, err)
+	assert.Equal(t, []Vector{{1}, {2}, {3}}, embeddings)
+
+	// The missing endpoint is remembered, the next batch goes straight to /api/embeddings
+	_, err = service.GenerateBatchEmbeddings(context.Background(), []string{"dddd"})
+	require.NoError(t, err)
+	assert.Equal(t, int32(1), embedCalls.Load())
+	assert.Equal(t, int32(4), legacyCalls.Load())
+
+	// The failed batch and every text of the fallback took a token of the provider limiter
+	assert.Equal(t, int64(5), service.limiter.GetTokens())
+}
+
+func TestOllamaUnknownModelDoesNotFallBack(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte(`{"error":"model \"missing\" not found, try pulling it first"}`))
+	}))
+	defer server.Close()
+
+	service := NewOllamaEmbeddingService(&Config{OllamaBaseURL: server.URL, EmbeddingModel: "missing"})
+	_, err := service.GenerateBatchEmbeddings(context.Background(), []string{"a"})
+	assert.ErrorContains(t, err, "not found")
+	assert.False(t, service.batchUnsupported.Load())
+}
@@ -2,6 +2,7 @@ package utils
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
@@ -11,10 +12,67 @@ import (
 	"github.com/lib/pq"
 )
 
-// OpenAIEmbeddingService implements EmbeddingService for OpenAI
+// openAIBaseURL is the endpoint of the hosted OpenAI API
+const openAIBaseURL = "https://api.openai.com/v1"
+
+// openAIEndpoint holds what differs between OpenAI and an OpenAI-compatible server
+// The request and response formats are the same, only the URL, credentials and name change
+type openAIEndpoint struct {
+	provider   AIProvider
+	name       string
+	baseURL    string
+	apiKey     string
+	authHeader string
+}
+
+// newOpenAIEndpoint returns the endpoint of the hosted OpenAI API
+func newOpenAIEndpoint(config *Config) openAIEndpoint {
+	return openAIEndpoint{
+		provider:   OpenAIProvider,
+		name:       "OpenAI",
+		baseURL:    openAIBaseURL,
+		apiKey:     config.OpenAIAPIKey,
+		authHeader: "Authorization",
+	}
+}
+
+// newOpenAICompatibleEndpoint returns the endpoint of a self-hosted OpenAI-compatible server
+// (vLLM, LM Studio, llama.cpp server, ...)
+func newOpenAICompatibleEndpoint(config *Config) openAIEndpoint {
+	authHeader := config.OpenAICompatibleAuthHeader
+	if authHeader == "" {
+		authHeader = "Authorization"
+	}
+
+	return openAIEndpoint{
+		provider:   OpenAICompatibleProvider,
+		name:       "OpenAI-compatible",
+		baseURL:    strings.TrimRight(config.OpenAICompatibleBaseURL, "/"),
+		apiKey:     config.OpenAICompatibleAPIKey,
+		authHeader: authHeader,
+	}
+}
+
+// setHeaders sets the content type and the credentials of a request
+// The Authorization header gets a Bearer token, any other header gets the raw key
+// No credentials are sent when no key is configured (common for local servers)
+func (e openAIEndpoint) setHeaders(req *http.Request) {
+	req.Header.Set("Content-Type", "application/json")
+	if e.apiKey == "" {
+		return
+	}
+
+	if strings.EqualFold(e.authHeader, "Authorization") {
+		req.Header.Set("Authorization", "Bearer "+e.apiKey)
+	} else {
+		req.Header.Set(e.authHeader, e.apiKey)
+	}
+}
+
+// OpenAIEmbeddingService implements EmbeddingService for OpenAI and OpenAI-compatible servers
 type OpenAIEmbeddingService struct {
-	config *Config
-	apiKey string
+	config   *Config
+	endpoint openAIEndpoint
 }
 
 // OpenAI API structures for embeddings
@@ -44,13 +102,22 @@ type openAIEmbeddingResponse struct {
 // This allows the service to use the OpenAI API for generating embeddings
 func NewOpenAIEmbeddingService(config *Config) *OpenAIEmbeddingService {
 	return &OpenAIEmbeddingService{
-		config: config,
-		apiKey: config.OpenAIAPIKey,
+		config:   config,
+		endpoint: newOpenAIEndpoint(config),
+	}
+}
+
+// NewOpenAICompatibleEmbeddingService creates an embedding service for an OpenAI-compatible server
+// It uses OPENAI_COMPATIBLE_BASE_URL instead of the hosted OpenAI API
+func NewOpenAICompatibleEmbeddingService(config *Config) *OpenAIEmbeddingService {
+	return &OpenAIEmbeddingService{
+		config:   config,
+		endpoint: newOpenAICompatibleEndpoint(config),
 	}
 }
 
 // GenerateEmbedding generates embeddings using OpenAI API
-func (s *OpenAIEmbeddingService) GenerateEmbedding(text string) (Vector, error) {
+func (s *OpenAIEmbeddingService) GenerateEmbedding(ctx context.Context, text string) (Vector, error) {
 	// Trim whitespace from the input text
 	// If the text is empty after trimming, return an error
 	cleanedText := strings.TrimSpace(text)
@@ -75,16 +142,16 @@ func (s *OpenAIEmbeddingService) GenerateEmbedding(text string) (Vector, error)
 
 	// Retry the embedding request with backoff
 	// This helps to handle transient errors and ensures reliability
-	err := RetryWithBackoff(retryConfig, func() error {
+	retryResult, err := Retry(ctx, retryConfig, string(s.endpoint.provider)+".embedding", func() error {
 		// Make the actual request to OpenAI API
 		// This function will handle the HTTP request and response parsing
 		// It will populate the embedding variable with the result
 		// *embedding is a pointer to pq.Float32Array
-		return s.makeEmbeddingRequest(cleanedText, &embedding)
+		return s.makeEmbeddingRequest(ctx, cleanedText, &embedding)
 	})
 
 	if err != nil {
-		LogError("Failed to get OpenAI embedding after retries", err, "text_length", len(cleanedText))
+		LogError("Failed to get OpenAI embedding after retries", err, "attempts", retryResult.Attempts, "text_length", len(cleanedText))
 		return nil, err
 	}
 
@@ -95,7 +162,7 @@ func (s *OpenAIEmbeddingService) GenerateEmbedding(text string) (Vector, error)
 
 // GenerateBatchEmbeddings generates embeddings for multiple texts
 // This is necessary for processing multiple inputs in a single API call (Open AI supports batch embeddings)
-func (s *OpenAIEmbeddingService) GenerateBatchEmbeddings(texts []string) ([]Vector, error) {
+func (s *OpenAIEmbeddingService) GenerateBatchEmbeddings(ctx context.Context, texts []string) ([]Vector, error) {
 	// Check if the input texts are empty
 	// If the texts slice is empty, return an error
 	if len(texts) == 0 {
@@ -134,20 +201,20 @@ func (s *OpenAIEmbeddingService) GenerateBatchEmbeddings(texts []string) ([]Vect
 
 	// Retry the batch embedding request with backoff
 	// This helps to handle transient errors and ensures reliability
-	err := RetryWithBackoff(retryConfig, func() error {
+	retryResult, err := Retry(ctx, retryConfig, string(s.endpoint.provider)+".batch_embedding", func() error {
 		// Make the actual request to OpenAI API
 		// This function will handle the HTTP request and response parsing
 		// It will populate the embeddings variable with the result
 		// *embeddings is a pointer to []pq.Float32Array
-		return s.makeBatchEmbeddingRequest(cleanedTexts, &embeddings)
+		return s.makeBatchEmbeddingRequest(ctx, cleanedTexts, &embeddings)
 	})
 
 	if err != nil {
-		LogError("Failed to get OpenAI batch embeddings after retries", err, "text_count", len(cleanedTexts))
+		LogError("Failed to get OpenAI batch embeddings after retries", err, "attempts", retryResult.Attempts, "text_count", len(cleanedTexts))
 		return nil, err
 	}
 
-	LogInfo("Successfully generated OpenAI batch embeddings", "text_count", len(cleanedTexts))
+	LogInfo("Successfully generated OpenAI batch embeddings", "text_count", len(cleanedTexts), "attempts", retryResult.Attempts)
 
 	// Convert []pq.Float32Array to []Vector
 	result := make([]Vector, len(embeddings))
@@ -160,15 +227,15 @@ func (s *OpenAIEmbeddingService) GenerateBatchEmbeddings(texts []string) ([]Vect
 
 // GetProviderName returns the provider name
 func (s *OpenAIEmbeddingService) GetProviderName() string {
-	return "OpenAI"
+	return s.endpoint.name
 }
 
 // makeEmbeddingRequest makes a single embedding request to OpenAI
-func (s *OpenAIEmbeddingService) makeEmbeddingRequest(text string, embedding *pq.Float32Array) error {
+func (s *OpenAIEmbeddingService) makeEmbeddingRequest(ctx context.Context, text string, embedding *pq.Float32Array) error {
 	// Create the request structure for OpenAI embedding
 	request := openAIEmbeddingRequest{
 		Input:          []string{text},
-		Model:          s.config.EmbeddingModel,
+		Model:          s.config.EmbeddingModelFor(s.endpoint.provider),
 		EncodingFormat: "float",
 	}
 
@@ -179,22 +246,20 @@ func (s *OpenAIEmbeddingService) makeEmbeddingRequest(text string, embedding *pq
 	}
 
 	// Create the HTTP request to get the embedding
-	req, err := http.NewRequest("POST", "https://api.openai.com/v1/embeddings", bytes.NewBuffer(jsonData))
+	req, err := http.NewRequestWithContext(ctx, "POST", s.endpoint.baseURL+"/embeddings", bytes.NewBuffer(jsonData))
 	if err != nil {
 		return fmt.Errorf("failed to create request: %v", err)
 	}
 
 	// Set the necessary headers for the request
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("Authorization", "Bearer "+s.apiKey)
+	s.endpoint.setHeaders(req)
 
 	// Make the HTTP request to OpenAI API
 	// Do() executes the request and returns the response
-	client := &http.Client{}
-	resp, err := client.Do(req)
+	resp, err := ProviderHTTPClient.Do(req)
 	if err != nil {
 		LogError("Failed to make OpenAI API request", err, "text_length", len(text))
-		return fmt.Errorf("failed to make request: %v", err)
+		return NewNetworkProviderError(s.endpoint.name, err)
 	}
 
 	// Ensure the response body is closed after reading
@@ -206,7 +271,7 @@ func (s *OpenAIEmbeddingService) makeEmbeddingRequest(text string, embedding *pq
 	if resp.StatusCode != http.StatusOK {
 		body, _ := io.ReadAll(resp.Body)
 		LogError("OpenAI API error", fmt.Errorf("status: %s", resp.Status), "response_body", string(body))
-		return fmt.Errorf("OpenAI API error: %s - %s", resp.Status, string(body))
+		return NewHTTPProviderError(s.endpoint.name, resp, body)
 	}
 
 	// Decode the response body into the openAIEmbeddingResponse structure
@@ -227,16 +292,17 @@ func (s *OpenAIEmbeddingService) makeEmbeddingRequest(text string, embedding *pq
 	// This is the expected format from OpenAI's embedding API
 	// *embedding is a pointer to pq.Float32Array
 	*embedding = pq.Float32Array(response.Data[0].Embedding)
+	s.recordUsage(ctx, response.Usage.PromptTokens, text)
 	LogInfo("Successfully generated OpenAI embedding", "text_length", len(text), "embedding_size", len(*embedding))
 	return nil
 }
 
 // makeBatchEmbeddingRequest makes a batch embedding request to OpenAI
-func (s *OpenAIEmbeddingService) makeBatchEmbeddingRequest(texts []string, embeddings *[]pq.Float32Array) error {
+func (s *OpenAIEmbeddingService) makeBatchEmbeddingRequest(ctx context.Context, texts []string, embeddings *[]pq.Float32Array) error {
 	// Create the request structure for OpenAI batch embedding
 	request := openAIEmbeddingRequest{
 		Input:          texts,
-		Model:          s.config.EmbeddingModel,
+		Model:          s.config.EmbeddingModelFor(s.endpoint.provider),
 		EncodingFormat: "float",
 	}
 
@@ -249,23 +315,21 @@ func (s *OpenAIEmbeddingService) makeBatchEmbeddingRequest(texts []string, embed
 
 	// Create the HTTP request to get the batch embeddings
 	// This includes the model and encoding format
-	req, err := http.NewRequest("POST", "https://api.openai.com/v1/embeddings", bytes.NewBuffer(jsonData))
+	req, err := http.NewRequestWithContext(ctx, "POST", s.endpoint.baseURL+"/embeddings", bytes.NewBuffer(jsonData))
 	if err != nil {
 		return fmt.Errorf("failed to create request: %v", err)
 	}
 
 	// Set the necessary headers for the request
 	// This includes the content type and authorization header with the API key
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("Authorization", "Bearer "+s.apiKey)
+	s.endpoint.setHeaders(req)
 
 	// Make the HTTP request to OpenAI API
 	// Do() executes the request and returns the response
-	client := &http.Client{}
-	resp, err := client.Do(req)
+	resp, err := ProviderHTTPClient.Do(req)
 	if err != nil {
 		LogError("Failed to make OpenAI batch API request", err, "text_count", len(texts))
-		return fmt.Errorf("failed to make request: %v", err)
+		return NewNetworkProviderError(s.endpoint.name, err)
 	}
 
 	// Ensure the response body is closed after reading
@@ -277,7 +341,7 @@ func (s *OpenAIEmbeddingService) makeBatchEmbeddingRequest(texts []string, embed
 	if resp.StatusCode != http.StatusOK {
 		body, _ := io.ReadAll(resp.Body)
 		LogError("OpenAI batch API error", fmt.Errorf("status: %s", resp.Status), "response_body", string(body))
-		return fmt.Errorf("OpenAI API error: %s - %s", resp.Status, string(body))
+		return NewHTTPProviderError(s.endpoint.name, resp, body)
 	}
 
 	// Decode the response body into the openAIEmbeddingResponse structure
@@ -310,14 +374,31 @@ func (s *OpenAIEmbeddingService) makeBatchEmbeddingRequest(texts []string, embed
 	// Ensure the embeddings slice is populated with the results
 	// This is the expected format from OpenAI's batch embedding API
 	*embeddings = result
+	s.recordUsage(ctx, response.Usage.PromptTokens, texts...)
 	return nil
 }
 
-// OpenAIChatService implements ChatService for OpenAI
+// recordUsage records the prompt tokens of an embedding request
+// The tokens are estimated from the texts when the server reports no usage
+func (s *OpenAIEmbeddingService) recordUsage(ctx context.Context, promptTokens int, texts ...string) {
+	usage := TokenUsage{
+		Operation:    UsageOperationEmbedding,
+		Provider:     string(s.endpoint.provider),
+		Model:        s.config.EmbeddingModelFor(s.endpoint.provider),
+		PromptTokens: promptTokens,
+	}
+	if usage.PromptTokens == 0 {
+		usage.PromptTokens = estimatedPromptTokens(texts...)
+		usage.Estimated = true
+	}
+	RecordTokenUsage(ctx, usage)
+}
+
+// OpenAIChatService implements ChatService for OpenAI and OpenAI-compatible servers
 type OpenAIChatService struct {
-	config *Config
-	apiKey string
-	model  string
+	config   *Config
+	endpoint openAIEndpoint
+	model    string
 }
 
 // OpenAI API structures for chat
@@ -363,14 +444,24 @@ type openAIChatResponse struct {
 // This allows the service to use the OpenAI API for generating chat responses
 func NewOpenAIChatService(config *Config) *OpenAIChatService {
 	return &OpenAIChatService{
-		config: config,
-		apiKey: config.OpenAIAPIKey,
-		model:  config.ChatModel,
+		config:   config,
+		endpoint: newOpenAIEndpoint(config),
+		model:    config.ChatModelFor(OpenAIProvider),
+	}
+}
+
+// NewOpenAICompatibleChatService creates a chat service for an OpenAI-compatible server
+// It uses OPENAI_COMPATIBLE_BASE_URL instead of the hosted OpenAI API
+func NewOpenAICompatibleChatService(config *Config) *OpenAIChatService {
+	return &OpenAIChatService{
+		config:   config,
+		endpoint: newOpenAICompatibleEndpoint(config),
+		model:    config.ChatModelFor(OpenAICompatibleProvider),
 	}
 }
 
 // GenerateResponse generates a response using OpenAI chat completion
-func (s *OpenAIChatService) GenerateResponse(question, context string) (string, error) {
+func (s *OpenAIChatService) GenerateResponse(ctx context.Context, prompt, systemPrompt string) (string, error) {
 	// Rate limiting
 	// Check if the rate limiter allows the request
 	// If the rate limit is exceeded, log a warning and return an error
@@ -386,26 +477,26 @@ func (s *OpenAIChatService) GenerateResponse(question, context string) (string,
 
 	// Retry the chat request with backoff
 	// This helps to handle transient errors and ensures reliability
-	err := RetryWithBackoff(retryConfig, func() error {
+	retryResult, err := Retry(ctx, retryConfig, string(s.endpoint.provider)+".chat", func() error {
 		// Make the actual request to OpenAI API
 		// This function will handle the HTTP request and response parsing
 		// It will populate the response variable with the result
 		// *response is a pointer to string
-		return s.makeChatRequest(question, context, &response)
+		return s.makeChatRequest(ctx, prompt, systemPrompt, &response)
 	})
 
 	if err != nil {
-		LogError("Failed to generate OpenAI response after retries", err, "question", question)
+		LogError("Failed to generate OpenAI response after retries", err, "attempts", retryResult.Attempts, "prompt_length", len(prompt))
 		return "", err
 	}
 
-	LogInfo("Successfully generated OpenAI response", "question_length", len(question), "response_length", len(response))
+	LogInfo("Successfully generated OpenAI response", "prompt_length", len(prompt), "response_length", len(response), "attempts", retryResult.Attempts)
 	return response, nil
 }
 
 // GetProviderName returns the provider name
 func (s *OpenAIChatService) GetProviderName() string {
-	return "OpenAI"
+	return s.endpoint.name
 }
 
 // GetModel returns the model name
@@ -414,22 +505,19 @@ func (s *OpenAIChatService) GetModel() string {
 }
 
 // makeChatRequest makes a chat completion request to OpenAI
-func (s *OpenAIChatService) makeChatRequest(question, context string, response *string) error {
-	// Create system message with context
-	systemMessage := fmt.Sprintf(`You are a helpful assistant that answers questions based on provided context. 
-Use the following context to answer the user's question. If the context doesn't contain enough information to answer the question, say so clearly.
-
-Context:
-%s`, context)
+// The system prompt is sent as a system message, the prompt as the user message
+func (s *OpenAIChatService) makeChatRequest(ctx context.Context, prompt, systemPrompt string, response *string) error {
+	var messages []openAIChatMessage
+	if systemPrompt != "" {
+		messages = append(messages, openAIChatMessage{Role: "system", Content: systemPrompt})
+	}
+	messages = append(messages, openAIChatMessage{Role: "user", Content: prompt})
 
 	request := openAIChatRequest{
-		Model: s.model,
-		Messages: []openAIChatMessage{
-			{Role: "system", Content: systemMessage},
-			{Role: "user", Content: question},
-		},
+		Model:       s.model,
+		Messages:    messages,
 		Temperature: 0.1,
-		MaxTokens:   2000, // Adjust max tokens as needed
+		MaxTokens:   AnswerTokenLimit(s.config), // Same value the context packer reserves for the answer
 	}
 
 	// Marshal the request to JSON
@@ -441,23 +529,21 @@ Context:
 
 	// Create the HTTP request to OpenAI chat completion
 	// This includes the model, messages, temperature, and max tokens
-	req, err := http.NewRequest("POST", "https://api.openai.com/v1/chat/completions", bytes.NewBuffer(jsonData))
+	req, err := http.NewRequestWithContext(ctx, "POST", s.endpoint.baseURL+"/chat/completions", bytes.NewBuffer(jsonData))
 	if err != nil {
 		return fmt.Errorf("failed to create request: %v", err)
 	}
 
 	// Set the necessary headers for the request
 	// This includes the content type and authorization header with the API key
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("Authorization", "Bearer "+s.apiKey)
+	s.endpoint.setHeaders(req)
 
 	// Make the HTTP request to OpenAI API
 	// Do() executes the request and returns the response
-	client := &http.Client{}
-	resp, err := client.Do(req)
+	resp, err := ProviderHTTPClient.Do(req)
 	if err != nil {
 		LogError("Failed to make OpenAI chat request", err)
-		return fmt.Errorf("failed to make request: %v", err)
+		return NewNetworkProviderError(s.endpoint.name, err)
 	}
 
 	// Ensure the response body is closed after reading
@@ -469,7 +555,7 @@ Context:
 	if resp.StatusCode != http.StatusOK {
 		body, _ := io.ReadAll(resp.Body)
 		LogError("OpenAI chat API error", fmt.Errorf("status: %s", resp.Status), "response_body", string(body))
-		return fmt.Errorf("OpenAI API error: %s - %s", resp.Status, string(body))
+		return NewHTTPProviderError(s.endpoint.name, resp, body)
 	}
 
 	// Decode the response body into the openAIChatResponse structure
@@ -491,5 +577,21 @@ Context:
 	// This is the expected format from OpenAI's chat completion API
 	// *response is a pointer to string
 	*response = strings.TrimSpace(chatResponse.Choices[0].Message.Content)
+
+	// Record the token usage reported by the API
+	// Some OpenAI-compatible servers omit usage, the tokens are then estimated from the texts
+	usage := TokenUsage{
+		Operation:        UsageOperationChat,
+		Provider:         string(s.endpoint.provider),
+		Model:            s.model,
+		PromptTokens:     chatResponse.Usage.PromptTokens,
+		CompletionTokens: chatResponse.Usage.CompletionTokens,
+	}
+	if usage.TotalTokens() == 0 {
+		usage.PromptTokens = estimatedPromptTokens(systemPrompt, prompt)
+		usage.CompletionTokens = estimatedPromptTokens(*response)
+		usage.Estimated = true
+	}
+	RecordTokenUsage(ctx, usage)
 	return nil
 }
@@ -0,0 +1,94 @@
+package utils
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestOpenAICompatibleServices(t *testing.T) {
+	var paths, auth, apiKeys, models []string
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		paths = append(paths, r.URL.Path)
+		auth = append(auth, r.Header.Get("Authorization"))
+		apiKeys = append(apiKeys, r.Header.Get("X-Api-Key"))
+
+		var body struct {
+			Model string `json:"model"`
+		}
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&body))
+		models = append(models, body.Model)
+
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Path {
+		case "/v1/chat/completions":
+			w.Write([]byte(`{"choices":[{"message":{"role":"assistant","content":"local answer"}}]}`))
+		case "/v1/embeddings":
+			w.Write([]byte(`{"data":[{"embedding":[0.1,0.2,0.3],"index":0}]}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	config := &Config{
+		Provider:                OpenAICompatibleProvider,
+		OpenAICompatibleBaseURL: server.URL + "/v1/",
+		ChatModel:               "gpt-3.5-turbo",
+		EmbeddingModel:          "text-embedding-3-small",
+		ProviderChatModels:      map[AIProvider]string{OpenAICompatibleProvider: "mistral-7b-instruct"},
+		ProviderEmbeddingModels: map[AIProvider]string{OpenAICompatibleProvider: "bge-small-en"},
+	}
+	factory := NewAIServiceFactory(config)
+	require.NoError(t, factory.ValidateConfiguration())
+
+	chat, err := factory.CreateChatService()
+	require.NoError(t, err)
+	answer, err := chat.GenerateResponse(context.Background(), "question", "system")
+	require.NoError(t, err)
+	assert.Equal(t, "local answer", answer)
+	assert.Equal(t, "OpenAI-compatible", chat.GetProviderName())
+
+	// No key configured: no credentials are sent
+	embedding, err := factory.CreateEmbeddingService()
+	require.NoError(t, err)
+	vector, err := embedding.GenerateEmbedding(context.Background(), "text")
+	require.NoError(t, err)
+	assert.Len(t, vector, 3)
+
+	// A custom auth header receives the raw key
+	config.OpenAICompatibleAPIKey = "secret"
+	config.OpenAICompatibleAuthHeader = "X-API-Key"
+	_, err = NewOpenAICompatibleEmbeddingService(config).GenerateEmbedding(context.Background(), "text")
+	require.NoError(t, err)
+
+	assert.Equal(t, []string{"/v1/chat/completions", "/v1/embeddings", "/v1/embeddings"}, paths)
+	assert.Equal(t, []string{"", "", ""}, auth)
+	assert.Equal(t, []string{"", "", "secret"}, apiKeys)
+	assert.Equal(t, []string{"mistral-7b-instruct", "bge-small-en", "bge-small-en"}, models)
+}
+
+func TestDetermineProviderExplicit(t *testing.T) {
+	// AI_PROVIDER wins over the implicit key-based priority
+	config := &Config{
+		Provider:                OpenAICompatibleProvider,
+		OpenAICompatibleBaseURL: "http://inference.internal:8000/v1",
+		GoogleAIAPIKey:          "AIza-test-key",
+	}
+	assert.Equal(t, OpenAICompatibleProvider, NewAIServiceFactory(config).GetCurrentProvider())
+
+	config.OpenAICompatibleBaseURL = ""
+	assert.Error(t, NewAIServiceFactory(config).ValidateConfiguration())
+
+	// The "sk-" key format is only required when the hosted OpenAI API is used
+	config = &Config{DBHost: "localhost", Provider: OpenAICompatibleProvider, OpenAIAPIKey: "local-key"}
+	assert.NoError(t, ValidateConfig(config))
+	config.Provider = OpenAIProvider
+	assert.Error(t, ValidateConfig(config))
+}
@@ -0,0 +1,331 @@
+package utils
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// Kinds of personal data the PII detectors find
+const (
+	PIITypeEmail      = "email"
+	PIITypePhone      = "phone"
+	PIITypeCreditCard = "credit_card"
+	PIITypeIBAN       = "iban"
+	PIITypeNationalID = "national_id"
+)
+
+// piiTypes are the kinds accepted in pii.types of the policy, in detection order
+var piiTypes = []string{PIITypeEmail, PIITypeIBAN, PIITypeCreditCard, PIITypeNationalID, PIITypePhone}
+
+// PIISettings configures the detection of personal data in the guardrail policy
+// Types limits the detectors that run, all of them when empty
+// RedactDocuments removes personal data from uploaded documents before they are chunked and embedded
+// MaskAnswers masks personal data in answers, it enables the "pii" check of the answer pipeline
+// ScrubHistory redacts personal data from the question, the answer and the violations before they are stored
+type PIISettings struct {
+	Types           []string `json:"types,omitempty" yaml:"types"`
+	RedactDocuments bool     `json:"redact_documents" yaml:"redact_documents"`
+	MaskAnswers     bool     `json:"mask_answers" yaml:"mask_answers"`
+	ScrubHistory    bool     `json:"scrub_history" yaml:"scrub_history"`
+}
+
+// validate checks that every type of the settings has a detector
+func (s *PIISettings) validate() error {
+	for i, kind := range s.Types {
+		s.Types[i] = strings.TrimSpace(kind)
+		if !slices.Contains(piiTypes, s.Types[i]) {
+			return fmt.Errorf("pii.types[%d]: unknown type %q, use one of %s", i, kind, strings.Join(piiTypes, ", "))
+		}
+	}
+	return nil
+}
+
+// PIIMatch is a piece of personal data found in a text, Start and End are byte offsets
+type PIIMatch struct {
+	Type  string
+	Value string
+	Start int
+	End   int
+}
+
+// PIICounts counts the matches per PII type
+type PIICounts map[string]int
+
+// Total returns the number of matches of every type
+func (c PIICounts) Total() int {
+	total := 0
+	for _, count := range c {
+		total += count
+	}
+	return total
+}
+
+// Add adds the counts of other
+func (c PIICounts) Add(other PIICounts) {
+	for kind, count := range other {
+		c[kind] += count
+	}
+}
+
+// piiDetector finds one kind of personal data: the pattern finds candidates, valid rejects the ones
+// failing a checksum or a format rule, so order numbers are not taken for card numbers
+type piiDetector struct {
+	pattern *regexp.Regexp
+	valid   func(value string) bool
+	kind    string
+}
+
+// piiDetectors run in order, a candidate overlapping the match of an earlier detector is ignored
+// IBANs and card numbers come before phones, which would otherwise match their digit groups
+var piiDetectors = []piiDetector{
+	{kind: PIITypeEmail, pattern: regexp.MustCompile(`\b[A-Za-z0-9._%+-]+@[A-Za-z0-9-]+(?:\.[A-Za-z0-9-]+)*\.[A-Za-z]{2,}\b`)},
+	{kind: PIITypeIBAN, pattern: regexp.MustCompile(`\b[A-Z]{2}\d{2}(?: ?[A-Z0-9]{4}){2,7}(?: ?[A-Z0-9]{1,3})?\b`), valid: validIBAN},
+	{kind: PIITypeCreditCard, pattern: regexp.MustCompile(`\b\d(?:[ -]?\d){12,18}\b`), valid: validCardNumber},
+	// US social security numbers and Spanish DNI numbers
+	{kind: PIITypeNationalID, pattern: regexp.MustCompile(`\b\d{3}-\d{2}-\d{4}\b`), valid: validSSN},
+	{kind: PIITypeNationalID, pattern: regexp.MustCompile(`\b\d{8}-?[A-Za-z]\b`), valid: validDNI},
+	// International numbers, North American numbers and national numbers with a trunk prefix
+	{kind: PIITypePhone, pattern: regexp.MustCompile(`\+\d{1,3}[ .-]?(?:\(\d{1,4}\)[ .-]?)?\d{1,4}(?:[ .-]?\d{2,4}){1,4}\b`), valid: validPhone},
+	{kind: PIITypePhone, pattern: regexp.MustCompile(`(?:\(\d{3}\) ?|\b\d{3}[ .-])\d{3}[ .-]\d{4}\b`), valid: validPhone},
+	{kind: PIITypePhone, pattern: regexp.MustCompile(`\b0\d{1,4}[ .-]\d{2,4}(?:[ .-]\d{2,4}){1,3}\b`), valid: validPhone},
+}
+
+// DetectPII returns the personal data found in text, in text order
+// types limits the detectors that run, all of them when empty
+func DetectPII(text string, types []string) []PIIMatch {
+	var matches []PIIMatch
+	for _, detector := range piiDetectors {
+		if len(types) > 0 && !slices.Contains(types, detector.kind) {
+			continue
+		}
+
+		for _, loc := range detector.pattern.FindAllStringIndex(text, -1) {
+			value := text[loc[0]:loc[1]]
+			if detector.valid != nil && !detector.valid(value) {
+				continue
+			}
+			if overlapsPIIMatch(matches, loc[0], loc[1]) {
+				continue
+			}
+			matches = append(matches, PIIMatch{Type: detector.kind, Value: value, Start: loc[0], End: loc[1]})
+		}
+	}
+
+	sort.Slice(matches, func(i, j int) bool { return matches[i].Start < matches[j].Start })
+	return matches
+}
+
+// RedactPII replaces the personal data of text with a placeholder such as [REDACTED_EMAIL]
+func RedactPII(text string, types []string) (string, PIICounts) {
+	return replacePII(text, types, func(match PIIMatch) string {
+		return "[REDACTED_" + strings.ToUpper(match.Type) + "]"
+	})
+}
+
+// MaskPII hides most of the personal data of text but keeps enough to recognize it:
+// the first letter and the domain of emails, the last four characters of numbers
+func MaskPII(text string, types []string) (string, PIICounts) {
+	return replacePII(text, types, maskPIIMatch)
+}
+
+// replacePII replaces every match of text with the value returned by replacement
+func replacePII(text string, types []string, replacement func(PIIMatch) string) (string, PIICounts) {
+	counts := PIICounts{}
+	matches := DetectPII(text, types)
+	if len(matches) == 0 {
+		return text, counts
+	}
+
+	var result strings.Builder
+	previous := 0
+	for _, match := range matches {
+		result.WriteString(text[previous:match.Start])
+		result.WriteString(replacement(match))
+		previous = match.End
+		counts[match.Type]++
+	}
+	result.WriteString(text[previous:])
+	return result.String(), counts
+}
+
+// maskPIIMatch masks a single match, keeping its separators so the format stays readable
+func maskPIIMatch(match PIIMatch) string {
+	if match.Type == PIITypeEmail {
+		at := strings.LastIndex(match.Value, "@")
+		return match.Value[:1] + "***" + match.Value[at:]
+	}
+
+	// Keep the last four letters or digits, and the country code of IBANs
+	alphanumeric := 0
+	for _, r := range match.Value {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			alphanumeric++
+		}
+	}
+	keepFrom := alphanumeric - 4
+
+	var masked strings.Builder
+	position := 0
+	for _, r := range match.Value {
+		if !unicode.IsLetter(r) && !unicode.IsDigit(r) {
+			masked.WriteRune(r)
+			continue
+		}
+		if position >= keepFrom || (match.Type == PIITypeIBAN && position < 2) {
+			masked.WriteRune(r)
+		} else {
+			masked.WriteRune('*')
+		}
+		position++
+	}
+	return masked.String()
+}
+
+// overlapsPIIMatch reports whether [start, end) overlaps one of the matches
+func overlapsPIIMatch(matches []PIIMatch, start, end int) bool {
+	for _, match := range matches {
+		if start < match.End && match.Start < end {
+			return true
+		}
+	}
+	return false
+}
+
+// PIIViolations reports redactions in the guardrail audit log, one warning per PII type
+// action tells what happened to the values ("redacted", "masked"), stage where it happened
+func PIIViolations(stage string, counts PIICounts, action string) []GuardrailViolation {
+	violations := piiViolations(counts, action)
+	for i := range violations {
+		violations[i].Stage = stage
+		violations[i].Severity = GuardrailSeverityWarning
+	}
+	return violations
+}
+
+// piiViolations returns one violation of the pii check per PII type found, sorted by type
+func piiViolations(counts PIICounts, action string) []GuardrailViolation {
+	kinds := make([]string, 0, len(counts))
+	for kind, count := range counts {
+		if count > 0 {
+			kinds = append(kinds, kind)
+		}
+	}
+	sort.Strings(kinds)
+
+	violations := make([]GuardrailViolation, 0, len(kinds))
+	for _, kind := range kinds {
+		violations = append(violations, GuardrailViolation{
+			Check:      GuardrailCheckPII,
+			Type:       "pii:" + kind,
+			Rule:       kind,
+			Message:    fmt.Sprintf("%d %s value(s) %s", counts[kind], strings.ReplaceAll(kind, "_", " "), action),
+			Redactions: counts[kind],
+		})
+	}
+	return violations
+}
+
+// piiGuardrail reports the personal data found in answers, the answer is then masked with MaskPII
+// It follows pii.mask_answers unless the policy enables or disables it in "checks"
+type piiGuardrail struct{}
+
+func (piiGuardrail) Name() string            { return GuardrailCheckPII }
+func (piiGuardrail) DefaultSeverity() string { return GuardrailSeverityWarning }
+
+func (piiGuardrail) Check(_ context.Context, input GuardrailInput) []GuardrailViolation {
+	counts := PIICounts{}
+	for _, match := range DetectPII(input.Text, input.Config.PII.Types) {
+		counts[match.Type]++
+	}
+
+	return piiViolations(counts, "masked")
+}
+
+// validCardNumber checks the length and the Luhn checksum of a card number
+func validCardNumber(value string) bool {
+	digits := onlyDigits(value)
+	if len(digits) < 13 || len(digits) > 19 || strings.Count(digits, digits[:1]) == len(digits) {
+		return false
+	}
+
+	sum := 0
+	double := false
+	for i := len(digits) - 1; i >= 0; i-- {
+		digit := int(digits[i] - '0')
+		if double {
+			digit *= 2
+			if digit > 9 {
+				digit -= 9
+			}
+		}
+		sum += digit
+		double = !double
+	}
+	return sum%10 == 0
+}
+
+// validIBAN checks the length and the ISO 7064 mod 97 checksum of an IBAN
+func validIBAN(value string) bool {
+	iban := strings.ReplaceAll(value, " ", "")
+	if len(iban) < 15 || len(iban) > 34 {
+		return false
+	}
+
+	// The country code and check digits move to the end, letters count as 10 (A) to 35 (Z)
+	rearranged := iban[4:] + iban[:4]
+	remainder := 0
+	for _, r := range rearranged {
+		switch {
+		case r >= '0' && r <= '9':
+			remainder = (remainder*10 + int(r-'0')) % 97
+		case r >= 'A' && r <= 'Z':
+			remainder = (remainder*100 + int(r-'A'+10)) % 97
+		default:
+			return false
+		}
+	}
+	return remainder == 1
+}
+
+// validSSN rejects the social security numbers that are never issued
+func validSSN(value string) bool {
+	parts := strings.Split(value, "-")
+	if len(parts) != 3 {
+		return false
+	}
+	area, group, serial := parts[0], parts[1], parts[2]
+	return area != "000" && area != "666" && area[0] != '9' && group != "00" && serial != "0000"
+}
+
+// validDNI checks the control letter of a Spanish DNI number
+func validDNI(value string) bool {
+	value = strings.ReplaceAll(value, "-", "")
+	number := 0
+	for _, r := range value[:8] {
+		number = number*10 + int(r-'0')
+	}
+	const letters = "TRWAGMYFPDXBNJZSQVHLCKE"
+	return strings.EqualFold(value[8:], string(letters[number%23]))
+}
+
+// validPhone accepts the numbers with 9 to 15 digits, the E.164 maximum
+func validPhone(value string) bool {
+	digits := len(onlyDigits(value))
+	return digits >= 9 && digits <= 15
+}
+
+// onlyDigits returns the digits of value
+func onlyDigits(value string) string {
+	var digits strings.Builder
+	for _, r := range value {
+		if r >= '0' && r <= '9' {
+			digits.WriteRune(r)
+		}
+	}
+	return digits.String()
+}
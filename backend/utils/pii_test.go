@@ -0,0 +1,101 @@
+package utils
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDetectPII(t *testing.T) {
+	tests := []struct {
+		name     string
+		text     string
+		expected []string
+	}{
+		{"Email", "Contact jane.doe@example.com for access", []string{PIITypeEmail}},
+		{"Card number with valid checksum", "Card 4111 1111 1111 1111 was charged", []string{PIITypeCreditCard}},
+		{"Card number with invalid checksum", "Card 4111 1111 1111 1112 was charged", nil},
+		{"IBAN with valid checksum", "Pay to DE89 3704 0044 0532 0130 00 by Friday", []string{PIITypeIBAN}},
+		{"Compact IBAN", "IBAN: GB82WEST12345698765432", []string{PIITypeIBAN}},
+		{"IBAN with invalid checksum", "IBAN: GB82WEST12345698765433", nil},
+		{"Social security number", "SSN 123-45-6789 on file", []string{PIITypeNationalID}},
+		{"Never issued social security number", "SSN 666-45-6789 on file", nil},
+		{"DNI with valid letter", "DNI 12345678Z", []string{PIITypeNationalID}},
+		{"DNI with invalid letter", "DNI 12345678A", nil},
+		{"International phone", "Call +34 612 345 678 after 9", []string{PIITypePhone}},
+		{"North American phone", "Call (555) 123-4567 or 555-987-6543", []string{PIITypePhone, PIITypePhone}},
+		{"National phone", "Call 030 1234 5678", []string{PIITypePhone}},
+		{"Dates and amounts are not personal data", "Filed on 2024-01-15, refund of 1 250.00 for order 88-1234", nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var types []string
+			for _, match := range DetectPII(tt.text, nil) {
+				types = append(types, match.Type)
+			}
+			assert.Equal(t, tt.expected, types)
+		})
+	}
+}
+
+func TestRedactAndMaskPII(t *testing.T) {
+	text := "Send jane.doe@example.com the card 4111 1111 1111 1111 and the IBAN DE89 3704 0044 0532 0130 00."
+
+	redacted, counts := RedactPII(text, nil)
+	assert.Equal(t, "Send [REDACTED_EMAIL] the card [REDACTED_CREDIT_CARD] and the IBAN [REDACTED_IBAN].", redacted)
+	assert.Equal(t, PIICounts{PIITypeEmail: 1, PIITypeCreditCard: 1, PIITypeIBAN: 1}, counts)
+	assert.Equal(t, 3, counts.Total())
+
+	// Redacting again finds nothing, placeholders are not personal data
+	_, counts = RedactPII(redacted, nil)
+	assert.Zero(t, counts.Total())
+
+	masked, _ := MaskPII(text, nil)
+	assert.Equal(t, "Send j***@example.com the card **** **** **** 1111 and the IBAN DE** **** **** **** **30 00.", masked)
+
+	// Only the configured types are touched
+	redacted, counts = RedactPII(text, []string{PIITypeEmail})
+	assert.Equal(t, PIICounts{PIITypeEmail: 1}, counts)
+	assert.Contains(t, redacted, "4111 1111 1111 1111")
+}
+
+func TestPIIPolicy(t *testing.T) {
+	config, err := ParseGuardrailConfig([]byte("pii:\n  mask_answers: true\n  types: [email, iban]\n"), "yaml")
+	require.NoError(t, err)
+	assert.True(t, config.PII.MaskAnswers)
+	assert.False(t, config.PII.ScrubHistory, "settings left out keep their default")
+	assert.False(t, config.PII.RedactDocuments)
+	assert.Contains(t, NewOutputGuardrailPipeline(config, nil).Checks(), GuardrailCheckPII)
+
+	_, err = ParseGuardrailConfig([]byte("pii:\n  types: [passport]\n"), "yaml")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), `unknown type "passport"`)
+
+	// The answer check is off unless the policy masks answers
+	assert.NotContains(t, NewOutputGuardrailPipeline(DefaultGuardrailConfig(), nil).Checks(), GuardrailCheckPII)
+}
+
+func TestPIIGuardrail(t *testing.T) {
+	config := DefaultGuardrailConfig()
+	config.PII.MaskAnswers = true
+
+	violations := NewOutputGuardrailPipeline(config, nil).Run(context.Background(),
+		"Reach HR at hr@example.com or payroll@example.com, or call +1 555 123 4567.")
+	require.Len(t, violations, 2)
+	assert.Equal(t, "pii:email", violations[0].Type)
+	assert.Equal(t, 2, violations[0].Redactions)
+	assert.Equal(t, "pii:phone", violations[1].Type)
+	assert.Equal(t, 1, violations[1].Redactions)
+	assert.Equal(t, GuardrailSeverityWarning, violations[1].Severity)
+	assert.Equal(t, GuardrailStageOutput, violations[1].Stage)
+	assert.False(t, HasBlockingViolation(violations))
+
+	history := PIIViolations(GuardrailStageHistory, PIICounts{PIITypeIBAN: 3, PIITypeEmail: 0}, "redacted")
+	require.Len(t, history, 1)
+	assert.Equal(t, "3 iban value(s) redacted", history[0].Message)
+	assert.Equal(t, GuardrailCheckPII, history[0].Check)
+	assert.Equal(t, GuardrailStageHistory, history[0].Stage)
+}
@@ -0,0 +1,107 @@
+package utils
+
+import (
+	"strconv"
+	"strings"
+)
+
+// ModelPrice is the price of a model in USD per million tokens
+// Embedding models only have an input price
+type ModelPrice struct {
+	InputPerMillion  float64 `json:"input_per_million"`
+	OutputPerMillion float64 `json:"output_per_million"`
+}
+
+// builtinModelPrices are the list prices of common hosted models, used when MODEL_PRICES does not set one
+// Prices change over time, MODEL_PRICES should be used to keep estimates accurate
+// Local models (Ollama, OpenAI-compatible servers) are not listed and cost nothing
+var builtinModelPrices = map[string]ModelPrice{
+	"gpt-4o":                 {InputPerMillion: 2.50, OutputPerMillion: 10.00},
+	"gpt-4o-mini":            {InputPerMillion: 0.15, OutputPerMillion: 0.60},
+	"gpt-4-turbo":            {InputPerMillion: 10.00, OutputPerMillion: 30.00},
+	"gpt-4":                  {InputPerMillion: 30.00, OutputPerMillion: 60.00},
+	"gpt-3.5-turbo":          {InputPerMillion: 0.50, OutputPerMillion: 1.50},
+	"text-embedding-3-small": {InputPerMillion: 0.02},
+	"text-embedding-3-large": {InputPerMillion: 0.13},
+	"text-embedding-ada-002": {InputPerMillion: 0.10},
+	"gemini-2.0-flash":       {InputPerMillion: 0.10, OutputPerMillion: 0.40},
+	"gemini-1.5-flash":       {InputPerMillion: 0.075, OutputPerMillion: 0.30},
+	"gemini-1.5-pro":         {InputPerMillion: 1.25, OutputPerMillion: 5.00},
+}
+
+// parseModelPrices parses a comma-separated list such as "gpt-4o-mini=0.15:0.60,text-embedding-3-small=0.02"
+// The output price is optional, invalid entries are skipped with a warning
+func parseModelPrices(value string) map[string]ModelPrice {
+	prices := make(map[string]ModelPrice)
+	for _, entry := range strings.Split(value, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		model, priceValue, found := strings.Cut(entry, "=")
+		if !found {
+			LogWarn("Ignoring invalid model price", "entry", entry)
+			continue
+		}
+
+		inputValue, outputValue, hasOutput := strings.Cut(priceValue, ":")
+		input, err := strconv.ParseFloat(strings.TrimSpace(inputValue), 64)
+		if err != nil || input < 0 {
+			LogWarn("Ignoring invalid model price", "entry", entry)
+			continue
+		}
+		price := ModelPrice{InputPerMillion: input}
+		if hasOutput {
+			output, err := strconv.ParseFloat(strings.TrimSpace(outputValue), 64)
+			if err != nil || output < 0 {
+				LogWarn("Ignoring invalid model price", "entry", entry)
+				continue
+			}
+			price.OutputPerMillion = output
+		}
+		prices[normalizeModelName(model)] = price
+	}
+	return prices
+}
+
+// normalizeModelName drops the "models/" prefix of Gemini model names and the case
+func normalizeModelName(model string) string {
+	return strings.ToLower(strings.TrimPrefix(strings.TrimSpace(model), "models/"))
+}
+
+// PriceFor returns the price of a model and whether one is known
+// MODEL_PRICES wins over the built-in prices, and an exact name over a prefix:
+// dated snapshots such as "gpt-4o-mini-2024-07-18" use the price of the longest listed prefix
+func (c *Config) PriceFor(model string) (ModelPrice, bool) {
+	name := normalizeModelName(model)
+
+	for _, prices := range []map[string]ModelPrice{c.ModelPrices, builtinModelPrices} {
+		if price, ok := prices[name]; ok {
+			return price, true
+		}
+
+		bestLength := 0
+		var best ModelPrice
+		for listed, price := range prices {
+			if len(listed) > bestLength && strings.HasPrefix(name, listed+"-") {
+				best = price
+				bestLength = len(listed)
+			}
+		}
+		if bestLength > 0 {
+			return best, true
+		}
+	}
+	return ModelPrice{}, false
+}
+
+// EstimateCost returns the estimated cost in USD of prompt and completion tokens of a model
+// Models without a known price cost nothing
+func (c *Config) EstimateCost(model string, promptTokens, completionTokens int64) float64 {
+	price, ok := c.PriceFor(model)
+	if !ok {
+		return 0
+	}
+	return (float64(promptTokens)*price.InputPerMillion + float64(completionTokens)*price.OutputPerMillion) / 1_000_000
+}
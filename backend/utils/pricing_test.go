@@ -0,0 +1,48 @@
+package utils
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseModelPrices(t *testing.T) {
+	prices := parseModelPrices("gpt-4o-mini=0.2:0.8, my-embedder=0.01,broken,bad=x:1")
+
+	assert.Equal(t, map[string]ModelPrice{
+		"gpt-4o-mini": {InputPerMillion: 0.2, OutputPerMillion: 0.8},
+		"my-embedder": {InputPerMillion: 0.01},
+	}, prices)
+}
+
+func TestPriceFor(t *testing.T) {
+	config := &Config{ModelPrices: parseModelPrices("gpt-4o-mini=0.2:0.8")}
+
+	tests := []struct {
+		name     string
+		model    string
+		expected ModelPrice
+		known    bool
+	}{
+		{name: "Configured price wins", model: "gpt-4o-mini", expected: ModelPrice{InputPerMillion: 0.2, OutputPerMillion: 0.8}, known: true},
+		{name: "Built-in price", model: "gpt-4o", expected: builtinModelPrices["gpt-4o"], known: true},
+		{name: "Dated snapshot uses the longest prefix", model: "gpt-4o-2024-08-06", expected: builtinModelPrices["gpt-4o"], known: true},
+		{name: "Gemini models prefix", model: "models/gemini-1.5-flash", expected: builtinModelPrices["gemini-1.5-flash"], known: true},
+		{name: "Local model", model: "llama3", known: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			price, known := config.PriceFor(tt.model)
+			assert.Equal(t, tt.known, known)
+			assert.Equal(t, tt.expected, price)
+		})
+	}
+}
+
+func TestEstimateCost(t *testing.T) {
+	config := &Config{ModelPrices: parseModelPrices("chat=1:2")}
+
+	assert.InDelta(t, 2.0, config.EstimateCost("chat", 1_000_000, 500_000), 1e-9)
+	assert.Equal(t, 0.0, config.EstimateCost("llama3", 1_000_000, 1_000_000))
+}
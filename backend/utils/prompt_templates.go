@@ -0,0 +1,129 @@
+package utils
+
+import (
+	"bytes"
+	"embed"
+	"fmt"
+	"strings"
+	"text/template"
+)
+
+// RAGAnswerTemplate is the name of the template used to answer questions about the documents
+const RAGAnswerTemplate = "rag_answer"
+
+// BuiltinTemplateVersion is the version reported for templates shipped with the binary
+// Versions stored in the database start at 1, so 0 always means "built-in"
+const BuiltinTemplateVersion = 0
+
+// builtinPrompts holds the default templates, one system and one user file per template name
+//
+//go:embed prompts/*.tmpl
+var builtinPrompts embed.FS
+
+// PromptTemplate is a named, versioned pair of Go text/template sources
+// The system template becomes the system instructions, the user template the user message
+type PromptTemplate struct {
+	Name    string `json:"name"`
+	System  string `json:"system_template"`
+	User    string `json:"user_template"`
+	Version int    `json:"version"`
+}
+
+// PromptData holds the values available to prompt templates
+type PromptData struct {
+	Question string
+	Context  string
+}
+
+// RenderedPrompt is the output of a template, ready to be sent to a ChatService
+type RenderedPrompt struct {
+	System string
+	User   string
+}
+
+// BuiltinPromptTemplate returns the template shipped with the binary for the given name
+func BuiltinPromptTemplate(name string) (PromptTemplate, error) {
+	system, err := builtinPrompts.ReadFile("prompts/" + name + ".system.tmpl")
+	if err != nil {
+		return PromptTemplate{}, fmt.Errorf("no built-in prompt template named %q", name)
+	}
+
+	user, err := builtinPrompts.ReadFile("prompts/" + name + ".user.tmpl")
+	if err != nil {
+		return PromptTemplate{}, fmt.Errorf("no built-in prompt template named %q", name)
+	}
+
+	return PromptTemplate{
+		Name:    name,
+		Version: BuiltinTemplateVersion,
+		System:  strings.TrimSpace(string(system)),
+		User:    strings.TrimSpace(string(user)),
+	}, nil
+}
+
+// Render executes both templates with the given data
+// Question and context are sanitized first, so templates never see control characters
+func (t PromptTemplate) Render(data PromptData) (RenderedPrompt, error) {
+	data.Question = SanitizeQuestion(data.Question)
+	data.Context = SanitizeQuestion(data.Context)
+
+	system, err := executePromptTemplate(t.Name+".system", t.System, data)
+	if err != nil {
+		return RenderedPrompt{}, err
+	}
+
+	user, err := executePromptTemplate(t.Name+".user", t.User, data)
+	if err != nil {
+		return RenderedPrompt{}, err
+	}
+
+	return RenderedPrompt{System: system, User: user}, nil
+}
+
+// ValidatePromptTemplate checks that a template parses, renders and uses the question
+// It is run before a template is stored so a broken template can never be activated
+func ValidatePromptTemplate(t PromptTemplate) error {
+	if strings.TrimSpace(t.Name) == "" {
+		return fmt.Errorf("template name is required")
+	}
+	if strings.TrimSpace(t.User) == "" {
+		return fmt.Errorf("user template is required")
+	}
+
+	// Render with marker values to make sure the question and the context end up in the prompt
+	const questionMarker, contextMarker = "QUESTION_MARKER", "CONTEXT_MARKER"
+	rendered, err := t.Render(PromptData{Question: questionMarker, Context: contextMarker})
+	if err != nil {
+		return err
+	}
+
+	full := rendered.System + "\n" + rendered.User
+	if !strings.Contains(full, questionMarker) {
+		return fmt.Errorf("template must include {{.Question}}")
+	}
+	if !strings.Contains(full, contextMarker) {
+		return fmt.Errorf("template must include {{.Context}}")
+	}
+
+	return nil
+}
+
+// executePromptTemplate parses and executes a single template source
+// Unknown fields are errors so typos in a template are caught by ValidatePromptTemplate
+func executePromptTemplate(name, source string, data PromptData) (string, error) {
+	if source == "" {
+		return "", nil
+	}
+
+	tmpl, err := template.New(name).Option("missingkey=error").Parse(source)
+	if err != nil {
+		return "", fmt.Errorf("failed to parse template %s: %v", name, err)
+	}
+
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, data); err != nil {
+		return "", fmt.Errorf("failed to render template %s: %v", name, err)
+	}
+
+	return buf.String(), nil
+}
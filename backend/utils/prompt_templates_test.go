@@ -0,0 +1,88 @@
+package utils
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBuiltinPromptTemplate(t *testing.T) {
+	tmpl, err := BuiltinPromptTemplate(RAGAnswerTemplate)
+	require.NoError(t, err)
+
+	assert.Equal(t, RAGAnswerTemplate, tmpl.Name)
+	assert.Equal(t, BuiltinTemplateVersion, tmpl.Version)
+	assert.NoError(t, ValidatePromptTemplate(tmpl))
+
+	_, err = BuiltinPromptTemplate("does_not_exist")
+	assert.Error(t, err)
+}
+
+func TestPromptTemplateRender(t *testing.T) {
+	tmpl := PromptTemplate{
+		Name:   "test",
+		System: "Answer from this context only:\n{{.Context}}",
+		User:   "Q: {{.Question}}",
+	}
+
+	rendered, err := tmpl.Render(PromptData{Question: "What is\x00 the policy?", Context: "15 days"})
+	require.NoError(t, err)
+
+	assert.Equal(t, "Answer from this context only:\n15 days", rendered.System)
+	assert.Equal(t, "Q: What is the policy?", rendered.User)
+}
+
+func TestValidatePromptTemplate(t *testing.T) {
+	tests := []struct {
+		name        string
+		template    PromptTemplate
+		expectError bool
+	}{
+		{
+			name:     "Valid template",
+			template: PromptTemplate{Name: "t", System: "{{.Context}}", User: "{{.Question}}"},
+		},
+		{
+			name:        "Missing name",
+			template:    PromptTemplate{System: "{{.Context}}", User: "{{.Question}}"},
+			expectError: true,
+		},
+		{
+			name:        "Missing user template",
+			template:    PromptTemplate{Name: "t", System: "{{.Context}} {{.Question}}"},
+			expectError: true,
+		},
+		{
+			name:        "Parse error",
+			template:    PromptTemplate{Name: "t", System: "{{.Context}", User: "{{.Question}}"},
+			expectError: true,
+		},
+		{
+			name:        "Unknown field",
+			template:    PromptTemplate{Name: "t", System: "{{.Context}} {{.Documents}}", User: "{{.Question}}"},
+			expectError: true,
+		},
+		{
+			name:        "Question not used",
+			template:    PromptTemplate{Name: "t", System: "{{.Context}}", User: "Answer please"},
+			expectError: true,
+		},
+		{
+			name:        "Context not used",
+			template:    PromptTemplate{Name: "t", User: "{{.Question}}"},
+			expectError: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ValidatePromptTemplate(tt.template)
+			if tt.expectError {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,179 @@
+package utils
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ProviderErrorKind classifies why a call to an AI provider failed
+// The kind decides whether the call is worth retrying
+type ProviderErrorKind string
+
+const (
+	// ErrorKindRateLimited is a 429, retried after the delay announced by the provider
+	ErrorKindRateLimited ProviderErrorKind = "rate_limited"
+	// ErrorKindAuth is a 401 or 403, a bad or missing key never succeeds on retry
+	ErrorKindAuth ProviderErrorKind = "auth"
+	// ErrorKindBadRequest is any other 4xx, the same request fails the same way
+	ErrorKindBadRequest ProviderErrorKind = "bad_request"
+	// ErrorKindServer is a 5xx or a 408, usually transient on the provider side
+	ErrorKindServer ProviderErrorKind = "server"
+	// ErrorKindNetwork is a connection, DNS, TLS or timeout failure before a response was received
+	ErrorKindNetwork ProviderErrorKind = "network"
+)
+
+// ProviderError is an error returned by a provider call
+// StatusCode is 0 for network errors, RetryAfter is 0 when the provider did not announce a delay
+type ProviderError struct {
+	Err        error
+	Provider   string
+	Kind       ProviderErrorKind
+	Message    string
+	StatusCode int
+	RetryAfter time.Duration
+}
+
+// Error formats the error like the provider errors logged before the classification existed
+func (e *ProviderError) Error() string {
+	if e.StatusCode != 0 {
+		return fmt.Sprintf("%s API error (%s): %d %s - %s", e.Provider, e.Kind, e.StatusCode, http.StatusText(e.StatusCode), e.Message)
+	}
+	if e.Err != nil {
+		return fmt.Sprintf("%s request failed (%s): %v", e.Provider, e.Kind, e.Err)
+	}
+	return fmt.Sprintf("%s request failed (%s): %s", e.Provider, e.Kind, e.Message)
+}
+
+// Unwrap returns the underlying error, so errors.Is still sees context errors
+func (e *ProviderError) Unwrap() error {
+	return e.Err
+}
+
+// Retryable reports whether the same call may succeed later
+func (e *ProviderError) Retryable() bool {
+	switch e.Kind {
+	case ErrorKindRateLimited, ErrorKindServer, ErrorKindNetwork:
+		return true
+	default:
+		return false
+	}
+}
+
+// NewHTTPProviderError classifies a non-2xx response of a provider
+// body is the response body already read by the caller, it becomes the message
+func NewHTTPProviderError(provider string, resp *http.Response, body []byte) *ProviderError {
+	return &ProviderError{
+		Provider:   provider,
+		Kind:       classifyStatus(resp.StatusCode),
+		Message:    strings.TrimSpace(string(body)),
+		StatusCode: resp.StatusCode,
+		RetryAfter: retryAfterFromHeaders(resp.Header, time.Now()),
+	}
+}
+
+// NewNetworkProviderError wraps an error returned by the HTTP client before any response
+func NewNetworkProviderError(provider string, err error) *ProviderError {
+	return &ProviderError{
+		Provider: provider,
+		Kind:     ErrorKindNetwork,
+		Err:      err,
+	}
+}
+
+// classifyStatus maps an HTTP status code to an error kind
+func classifyStatus(status int) ProviderErrorKind {
+	switch {
+	case status == http.StatusTooManyRequests:
+		return ErrorKindRateLimited
+	case status == http.StatusUnauthorized || status == http.StatusForbidden:
+		return ErrorKindAuth
+	case status == http.StatusRequestTimeout || status >= 500:
+		return ErrorKindServer
+	default:
+		return ErrorKindBadRequest
+	}
+}
+
+// retryAfterFromHeaders returns the delay announced by the provider, 0 when there is none
+// Retry-After holds seconds or an HTTP date (RFC 9110)
+// x-ratelimit-reset-requests / x-ratelimit-reset-tokens hold a Go-style duration such as "1s" or "6m0s" (OpenAI)
+// x-ratelimit-reset holds seconds, or a Unix timestamp when the value is large
+// The longest announced delay wins, since every exhausted limit must reset before the call can succeed
+func retryAfterFromHeaders(header http.Header, now time.Time) time.Duration {
+	var delay time.Duration
+
+	if value := strings.TrimSpace(header.Get("Retry-After")); value != "" {
+		if seconds, err := strconv.ParseFloat(value, 64); err == nil {
+			delay = maxDuration(delay, time.Duration(seconds*float64(time.Second)))
+		} else if date, err := http.ParseTime(value); err == nil {
+			delay = maxDuration(delay, date.Sub(now))
+		}
+	}
+
+	for _, name := range []string{"x-ratelimit-reset-requests", "x-ratelimit-reset-tokens"} {
+		if value := strings.TrimSpace(header.Get(name)); value != "" {
+			if duration, err := time.ParseDuration(value); err == nil {
+				delay = maxDuration(delay, duration)
+			}
+		}
+	}
+
+	if value := strings.TrimSpace(header.Get("x-ratelimit-reset")); value != "" {
+		if seconds, err := strconv.ParseFloat(value, 64); err == nil {
+			// Values past one year of seconds can only be Unix timestamps
+			if seconds > 365*24*3600 {
+				delay = maxDuration(delay, time.Unix(int64(seconds), 0).Sub(now))
+			} else {
+				delay = maxDuration(delay, time.Duration(seconds*float64(time.Second)))
+			}
+		} else if duration, err := time.ParseDuration(value); err == nil {
+			delay = maxDuration(delay, duration)
+		}
+	}
+
+	if delay < 0 {
+		return 0
+	}
+	return delay
+}
+
+// maxDuration returns the longer of two durations
+func maxDuration(a, b time.Duration) time.Duration {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+// IsRetryableError reports whether a failed call may succeed when repeated
+// Only classified transient provider errors are retried, anything else (bad request, auth,
+// an unparsable response, a local validation error) fails the same way every time
+func IsRetryableError(err error) bool {
+	var providerErr *ProviderError
+	if errors.As(err, &providerErr) {
+		return providerErr.Retryable()
+	}
+	return false
+}
+
+// ErrorKindOf returns the kind of a provider error, or "other" for unclassified errors
+// Cancelled and expired requests are reported apart, they say nothing about the provider
+func ErrorKindOf(err error) string {
+	if errors.Is(err, context.Canceled) {
+		return "canceled"
+	}
+	if errors.Is(err, context.DeadlineExceeded) {
+		return "deadline_exceeded"
+	}
+
+	var providerErr *ProviderError
+	if errors.As(err, &providerErr) {
+		return string(providerErr.Kind)
+	}
+	return "other"
+}
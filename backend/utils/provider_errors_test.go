@@ -0,0 +1,68 @@
+package utils
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewHTTPProviderError(t *testing.T) {
+	tests := []struct {
+		kind      ProviderErrorKind
+		status    int
+		retryable bool
+	}{
+		{status: http.StatusTooManyRequests, kind: ErrorKindRateLimited, retryable: true},
+		{status: http.StatusUnauthorized, kind: ErrorKindAuth},
+		{status: http.StatusForbidden, kind: ErrorKindAuth},
+		{status: http.StatusBadRequest, kind: ErrorKindBadRequest},
+		{status: http.StatusNotFound, kind: ErrorKindBadRequest},
+		{status: http.StatusRequestTimeout, kind: ErrorKindServer, retryable: true},
+		{status: http.StatusServiceUnavailable, kind: ErrorKindServer, retryable: true},
+	}
+
+	for _, tt := range tests {
+		err := NewHTTPProviderError("OpenAI", &http.Response{StatusCode: tt.status, Header: http.Header{}}, []byte(" invalid model \n"))
+		assert.Equal(t, tt.kind, err.Kind, "status %d", tt.status)
+		assert.Equal(t, tt.retryable, IsRetryableError(err), "status %d", tt.status)
+		assert.Contains(t, err.Error(), "invalid model")
+	}
+}
+
+func TestRetryAfterFromHeaders(t *testing.T) {
+	now := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	header := http.Header{}
+	header.Set("Retry-After", "3")
+	assert.Equal(t, 3*time.Second, retryAfterFromHeaders(header, now))
+
+	header = http.Header{}
+	header.Set("Retry-After", now.Add(10*time.Second).Format(http.TimeFormat))
+	assert.Equal(t, 10*time.Second, retryAfterFromHeaders(header, now))
+
+	// OpenAI announces one reset per limit, the longest one wins
+	header = http.Header{}
+	header.Set("x-ratelimit-reset-requests", "1s")
+	header.Set("x-ratelimit-reset-tokens", "6m0s")
+	assert.Equal(t, 6*time.Minute, retryAfterFromHeaders(header, now))
+
+	header = http.Header{}
+	header.Set("x-ratelimit-reset", "2")
+	assert.Equal(t, 2*time.Second, retryAfterFromHeaders(header, now))
+
+	header = http.Header{}
+	header.Set("x-ratelimit-reset", "1735732815")
+	assert.Equal(t, 15*time.Second, retryAfterFromHeaders(header, now))
+
+	assert.Zero(t, retryAfterFromHeaders(http.Header{}, now))
+}
+
+func TestErrorKindOf(t *testing.T) {
+	assert.Equal(t, "rate_limited", ErrorKindOf(&ProviderError{Kind: ErrorKindRateLimited}))
+	assert.Equal(t, "other", ErrorKindOf(errors.New("boom")))
+	assert.Equal(t, "canceled", ErrorKindOf(NewNetworkProviderError("Ollama", context.Canceled)))
+}
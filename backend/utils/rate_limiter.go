@@ -1,6 +1,7 @@
 package utils
 
 import (
+	"context"
 	"sync"
 	"time"
 )
@@ -67,6 +68,30 @@ func (r *RateLimiter) Allow() bool {
 	return false
 }
 
+// Wait blocks until a token is available (and consumes it) or ctx is done
+// Unlike Allow it lets batch work proceed at the refill rate instead of failing once the bucket is empty
+func (r *RateLimiter) Wait(ctx context.Context) error {
+	// Poll at the time one token takes to refill, at least every 10ms
+	interval := 10 * time.Millisecond
+	if r.refillRate > 0 && time.Second/time.Duration(r.refillRate) > interval {
+		interval = time.Second / time.Duration(r.refillRate)
+	}
+
+	for {
+		if r.Allow() {
+			return nil
+		}
+
+		timer := time.NewTimer(interval)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
 // GetTokens returns the current number of tokens
 func (r *RateLimiter) GetTokens() int64 {
 	// r.mutex.Lock() is to ensure thread safety
@@ -86,6 +111,17 @@ func (r *RateLimiter) GetTokens() int64 {
 // Initialize with default values, will be updated when config is loaded
 var OpenAIRateLimiter = NewRateLimiter(10, 1) // Default: 10 tokens, refill 1 per second
 
+// NewProviderRateLimiter creates the request limiter of one AI provider from RATE_LIMIT_MAX_TOKENS
+// and RATE_LIMIT_REFILL_RATE, falling back to the defaults when they are not positive
+// Each provider gets its own bucket, so a slow ingestion on one provider does not starve another
+func NewProviderRateLimiter(config *Config) *RateLimiter {
+	maxTokens, refillRate := int64(10), int64(1)
+	if config != nil && config.RateLimitMaxTokens > 0 && config.RateLimitRefillRate > 0 {
+		maxTokens, refillRate = config.RateLimitMaxTokens, config.RateLimitRefillRate
+	}
+	return NewRateLimiter(maxTokens, refillRate)
+}
+
 // InitRateLimiter initializes the rate limiter with config values
 // This should be called after AppConfig is loaded
 func InitRateLimiter() {
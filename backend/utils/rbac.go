@@ -0,0 +1,100 @@
+package utils
+
+import "slices"
+
+// Built-in roles, each one grants the permissions of the previous one
+// Users without any role get DEFAULT_USER_ROLE (viewer by default)
+const (
+	RoleViewer      = "viewer"
+	RoleContributor = "contributor"
+	RoleAdmin       = "admin"
+)
+
+// Permissions checked by the RequirePermission middleware
+const (
+	PermissionDocumentsRead   = "documents:read"
+	PermissionDocumentsQuery  = "documents:query"
+	PermissionDocumentsUpload = "documents:upload"
+	PermissionDocumentsDelete = "documents:delete"
+	PermissionDocumentsAll    = "documents:all"
+	PermissionAdminAccess     = "admin:access"
+	PermissionUsersManage     = "users:manage"
+)
+
+// Permissions lists every permission with its description, in the order they are shown to admins
+var Permissions = []PermissionDefinition{
+	{Name: PermissionDocumentsRead, Description: "List documents and read their chunks"},
+	{Name: PermissionDocumentsQuery, Description: "Ask questions about the documents and rate the answers"},
+	{Name: PermissionDocumentsUpload, Description: "Upload documents"},
+	{Name: PermissionDocumentsDelete, Description: "Delete documents"},
+	{Name: PermissionDocumentsAll, Description: "Read, share and delete every document, whoever owns it or shares it"},
+	{Name: PermissionAdminAccess, Description: "Use the admin endpoints (reports, guardrails, prompt templates, quotas)"},
+	{Name: PermissionUsersManage, Description: "List users and manage roles"},
+}
+
+// BuiltinRoles are the roles created at startup, they cannot be changed through the API
+var BuiltinRoles = []RoleDefinition{
+	{
+		Name:        RoleViewer,
+		Description: "Reads and queries documents",
+		Permissions: []string{PermissionDocumentsRead, PermissionDocumentsQuery},
+	},
+	{
+		Name:        RoleContributor,
+		Description: "Reads, queries and uploads documents",
+		Permissions: []string{PermissionDocumentsRead, PermissionDocumentsQuery, PermissionDocumentsUpload},
+	},
+	{
+		Name:        RoleAdmin,
+		Description: "Every permission",
+		Permissions: []string{
+			PermissionDocumentsRead, PermissionDocumentsQuery, PermissionDocumentsUpload, PermissionDocumentsDelete,
+			PermissionDocumentsAll, PermissionAdminAccess, PermissionUsersManage,
+		},
+	},
+}
+
+// PermissionDefinition is a permission roles can grant
+type PermissionDefinition struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+}
+
+// RoleDefinition is a role with the permissions it grants
+type RoleDefinition struct {
+	Name        string   `json:"name"`
+	Description string   `json:"description"`
+	Permissions []string `json:"permissions"`
+}
+
+// UserAccess is what a user may do: their roles and the permissions the roles grant
+// It is resolved when the tokens are issued and carried by the access token, so requests need no database lookup
+type UserAccess struct {
+	Roles       []string `json:"roles"`
+	Permissions []string `json:"permissions"`
+}
+
+// Can reports whether the user has a permission
+func (a UserAccess) Can(permission string) bool {
+	return slices.Contains(a.Permissions, permission)
+}
+
+// IsPermission reports whether a permission exists
+func IsPermission(name string) bool {
+	for _, permission := range Permissions {
+		if permission.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// IsBuiltinRole reports whether a role is one of the built-in roles
+func IsBuiltinRole(name string) bool {
+	for _, role := range BuiltinRoles {
+		if role.Name == name {
+			return true
+		}
+	}
+	return false
+}
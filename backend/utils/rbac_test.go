@@ -0,0 +1,65 @@
+package utils
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestUserAccessCan(t *testing.T) {
+	access := UserAccess{Roles: []string{RoleViewer}, Permissions: []string{PermissionDocumentsRead, PermissionDocumentsQuery}}
+
+	assert.True(t, access.Can(PermissionDocumentsRead))
+	assert.True(t, access.Can(PermissionDocumentsQuery))
+	assert.False(t, access.Can(PermissionDocumentsUpload))
+	assert.False(t, UserAccess{}.Can(PermissionDocumentsRead), "no permissions without roles")
+}
+
+func TestBuiltinRoles(t *testing.T) {
+	roles := map[string]RoleDefinition{}
+	for _, role := range BuiltinRoles {
+		roles[role.Name] = role
+		assert.True(t, IsBuiltinRole(role.Name))
+		for _, permission := range role.Permissions {
+			assert.True(t, IsPermission(permission), "%s grants an unknown permission %s", role.Name, permission)
+		}
+	}
+	require.Len(t, roles, 3)
+	assert.False(t, IsBuiltinRole("auditor"))
+
+	// Each role grants the permissions of the previous one
+	assert.Subset(t, roles[RoleContributor].Permissions, roles[RoleViewer].Permissions)
+	assert.Subset(t, roles[RoleAdmin].Permissions, roles[RoleContributor].Permissions)
+	assert.Contains(t, roles[RoleContributor].Permissions, PermissionDocumentsUpload)
+	assert.NotContains(t, roles[RoleContributor].Permissions, PermissionDocumentsDelete)
+
+	for _, permission := range Permissions {
+		assert.Contains(t, roles[RoleAdmin].Permissions, permission.Name, "admin has every permission")
+	}
+}
+
+func TestTokenPairCarriesAccess(t *testing.T) {
+	t.Setenv("JWT_SECRET", "test-secret")
+	access := UserAccess{Roles: []string{RoleContributor}, Permissions: []string{PermissionDocumentsRead, PermissionDocumentsUpload}}
+
+	tokens, err := GenerateTokenPair("user-1", "user@example.com", access)
+	require.NoError(t, err)
+
+	userID, decoded, err := ValidateAccessToken("Bearer " + tokens.AccessToken)
+	require.NoError(t, err)
+	assert.Equal(t, "user-1", userID)
+	assert.Equal(t, access, decoded)
+
+	// The refresh token is not an access token and carries no permissions
+	_, _, err = ValidateAccessToken(tokens.RefreshToken)
+	assert.Error(t, err)
+	userID, err = ValidateRefreshToken(tokens.RefreshToken)
+	require.NoError(t, err)
+	assert.Equal(t, "user-1", userID)
+
+	// A token signed with another secret is rejected
+	t.Setenv("JWT_SECRET", "other-secret")
+	_, _, err = ValidateAccessToken(tokens.AccessToken)
+	assert.Error(t, err)
+}
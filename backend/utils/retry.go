@@ -1,6 +1,10 @@
 package utils
 
 import (
+	"context"
+	"errors"
+	"expvar"
+	"fmt"
 	"math"
 	"math/rand"
 	"time"
@@ -32,56 +36,162 @@ func DefaultRetryConfig() RetryConfig {
 	}
 }
 
+// RetryResult reports what the retry loop did, for logs and metrics
+// Attempts counts the calls of fn, Waited is the total time spent between attempts
+type RetryResult struct {
+	Attempts int
+	Waited   time.Duration
+}
+
+// retryMetrics keeps per-operation counters, served by GET /api/v1/admin/metrics
+// Keys are "<operation>.calls", "<operation>.attempts", "<operation>.retries",
+// "<operation>.failures" and "<operation>.errors.<kind>"
+// The map is not published with expvar, so the process details of the default expvar handler
+// (command line, memory statistics) are never served
+var retryMetrics = new(expvar.Map)
+
+// ProviderRetryMetrics returns the provider retry counters
+func ProviderRetryMetrics() *expvar.Map {
+	return retryMetrics
+}
+
 // RetryWithBackoff executes a function with exponential backoff retry logic
 // Retry logic for OpenAI API calls or other operations that may fail
 // config: RetryConfig defines the retry parameters
 // fn: function to execute, which returns an error if it fails
+// It is Retry without an operation name, for callers that need neither attempt counts nor metrics
+func RetryWithBackoff(ctx context.Context, config RetryConfig, fn func() error) error {
+	_, err := Retry(ctx, config, "", fn)
+	return err
+}
+
+// Retry executes fn and retries it while it fails with a transient error
 // This function will retry the execution of fn up to MaxRetries times
 // It will wait for InitialDelay before the first retry, and then apply exponential backoff
-func RetryWithBackoff(config RetryConfig, fn func() error) error {
+// Only errors classified as retryable by IsRetryableError are retried (rate limits, 5xx, network errors),
+// a bad request or a bad key is returned at once
+// When the provider announces a delay (Retry-After, x-ratelimit-reset) that delay is used instead of the backoff,
+// and the loop gives up if the delay exceeds MaxDelay or the context deadline
+// The loop stops as soon as ctx is done, both before an attempt and while waiting between attempts,
+// so a cancelled request never sleeps through the remaining backoff
+// operation names the call in the metrics (e.g. "openai.chat"), an empty operation records nothing
+func Retry(ctx context.Context, config RetryConfig, operation string, fn func() error) (result RetryResult, err error) {
+	if operation != "" {
+		defer func() { recordRetryMetrics(operation, result, err) }()
+	}
+
 	var lastErr error
 
 	// for loop to handle retries
 	// It will attempt to execute the function up to MaxRetries times
 	for attempt := 0; attempt <= config.MaxRetries; attempt++ {
 		if attempt > 0 {
-			// Calculate delay with exponential backoff
-			// backoff starts with InitialDelay and increases exponentially
-			// based on BackoffMultiplier for each subsequent attempt
-			// backoff refers to the strategy of waiting longer between retries
-			// to avoid overwhelming the service and to give it time to recover
-			delay := time.Duration(float64(config.InitialDelay) * math.Pow(config.BackoffMultiplier, float64(attempt-1)))
-
-			// Apply maximum delay cap
-			// delay works as a cap to prevent excessive waiting
-			// This ensures that the delay does not exceed MaxDelay
-			if delay > config.MaxDelay {
-				delay = config.MaxDelay
+			delay, ok := retryDelay(ctx, config, attempt, lastErr)
+			if !ok {
+				return result, lastErr
 			}
 
-			// Add jitter to prevent thundering herd
-			// jitter refers to a small random delay added to the backoff
-			// This helps to spread out the retries across multiple clients
-			if config.Jitter {
-				jitter := time.Duration(rand.Float64() * float64(delay) * 0.1)
-				delay += jitter
+			// Wait for the delay unless the context is done first
+			timer := time.NewTimer(delay)
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+				return result, contextError(ctx, lastErr)
+			case <-timer.C:
 			}
+			result.Waited += delay
+		}
 
-			time.Sleep(delay)
+		if ctx.Err() != nil {
+			return result, contextError(ctx, lastErr)
 		}
 
 		// Execute the function
-		// If it returns an error, we will retry
-		// err := fn() is the function that we are trying to execute
-		// If it returns an error, we will retry
+		// If it returns a transient error, we will retry
+		result.Attempts++
 		if err := fn(); err != nil {
 			lastErr = err
+			// A request aborted by the context must not be retried
+			if ctx.Err() != nil {
+				return result, contextError(ctx, lastErr)
+			}
+			// Neither must an error that will happen again
+			if !IsRetryableError(err) {
+				return result, err
+			}
 			continue
 		}
 
 		// Success
-		return nil
+		return result, nil
 	}
 
-	return lastErr
+	return result, lastErr
+}
+
+// retryDelay returns how long to wait before the given attempt, and false when retrying is pointless
+func retryDelay(ctx context.Context, config RetryConfig, attempt int, lastErr error) (time.Duration, bool) {
+	// A delay announced by the provider replaces the computed backoff
+	var providerErr *ProviderError
+	if errors.As(lastErr, &providerErr) && providerErr.RetryAfter > 0 {
+		delay := providerErr.RetryAfter
+		if delay > config.MaxDelay {
+			LogWarn("Provider retry delay exceeds the maximum, giving up", "retry_after", delay, "max_delay", config.MaxDelay)
+			return 0, false
+		}
+		return delay, fitsDeadline(ctx, delay)
+	}
+
+	// Calculate delay with exponential backoff
+	// backoff starts with InitialDelay and increases exponentially
+	// based on BackoffMultiplier for each subsequent attempt
+	// backoff refers to the strategy of waiting longer between retries
+	// to avoid overwhelming the service and to give it time to recover
+	delay := time.Duration(float64(config.InitialDelay) * math.Pow(config.BackoffMultiplier, float64(attempt-1)))
+
+	// Apply maximum delay cap
+	// delay works as a cap to prevent excessive waiting
+	// This ensures that the delay does not exceed MaxDelay
+	if delay > config.MaxDelay {
+		delay = config.MaxDelay
+	}
+
+	// Add jitter to prevent thundering herd
+	// jitter refers to a small random delay added to the backoff
+	// This helps to spread out the retries across multiple clients
+	if config.Jitter {
+		jitter := time.Duration(rand.Float64() * float64(delay) * 0.1)
+		delay += jitter
+	}
+
+	return delay, true
+}
+
+// fitsDeadline reports whether waiting delay still leaves time before the context deadline
+// Waiting past the deadline would only turn a clear provider error into a timeout
+func fitsDeadline(ctx context.Context, delay time.Duration) bool {
+	deadline, ok := ctx.Deadline()
+	return !ok || time.Until(deadline) > delay
+}
+
+// recordRetryMetrics adds the outcome of a retried call to the expvar counters
+func recordRetryMetrics(operation string, result RetryResult, err error) {
+	retryMetrics.Add(operation+".calls", 1)
+	retryMetrics.Add(operation+".attempts", int64(result.Attempts))
+	if result.Attempts > 1 {
+		retryMetrics.Add(operation+".retries", int64(result.Attempts-1))
+	}
+	if err != nil {
+		retryMetrics.Add(operation+".failures", 1)
+		retryMetrics.Add(operation+".errors."+ErrorKindOf(err), 1)
+	}
+}
+
+// contextError returns the context error, keeping the last attempt's error for the logs
+// The result always matches ctx.Err() with errors.Is
+func contextError(ctx context.Context, lastErr error) error {
+	if lastErr == nil {
+		return ctx.Err()
+	}
+	return fmt.Errorf("%w (last error: %v)", ctx.Err(), lastErr)
 }
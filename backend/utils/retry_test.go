@@ -0,0 +1,103 @@
+package utils
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRetryWithBackoffStopsOnCancel(t *testing.T) {
+	config := RetryConfig{MaxRetries: 5, InitialDelay: time.Hour, MaxDelay: time.Hour, BackoffMultiplier: 2}
+	ctx, cancel := context.WithCancel(context.Background())
+
+	calls := 0
+	done := make(chan error, 1)
+	go func() {
+		done <- RetryWithBackoff(ctx, config, func() error {
+			calls++
+			return &ProviderError{Provider: "test", Kind: ErrorKindServer, StatusCode: 503, Message: "provider unavailable"}
+		})
+	}()
+
+	// The first attempt fails and the loop waits an hour, cancelling must end the wait
+	time.Sleep(20 * time.Millisecond)
+	cancel()
+
+	select {
+	case err := <-done:
+		assert.ErrorIs(t, err, context.Canceled)
+		assert.Contains(t, err.Error(), "provider unavailable")
+		assert.Equal(t, 1, calls)
+	case <-time.After(time.Second):
+		t.Fatal("RetryWithBackoff kept sleeping after the context was cancelled")
+	}
+}
+
+func TestRetryWithBackoffSkipsAttemptsAfterDeadline(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond)
+	defer cancel()
+	time.Sleep(5 * time.Millisecond)
+
+	calls := 0
+	err := RetryWithBackoff(ctx, DefaultRetryConfig(), func() error {
+		calls++
+		return nil
+	})
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.Equal(t, 0, calls)
+}
+
+func TestRetryOnlyRetriesTransientErrors(t *testing.T) {
+	config := RetryConfig{MaxRetries: 3, InitialDelay: time.Millisecond, MaxDelay: 10 * time.Millisecond, BackoffMultiplier: 2}
+
+	tests := []struct {
+		err      error
+		name     string
+		attempts int
+	}{
+		{name: "bad request", err: &ProviderError{Kind: ErrorKindBadRequest, StatusCode: 400}, attempts: 1},
+		{name: "auth", err: &ProviderError{Kind: ErrorKindAuth, StatusCode: 401}, attempts: 1},
+		{name: "unclassified", err: errors.New("failed to decode response"), attempts: 1},
+		{name: "server", err: &ProviderError{Kind: ErrorKindServer, StatusCode: 502}, attempts: 4},
+		{name: "network", err: NewNetworkProviderError("test", errors.New("connection refused")), attempts: 4},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := Retry(context.Background(), config, "test."+tt.name, func() error { return tt.err })
+			assert.ErrorIs(t, err, tt.err)
+			assert.Equal(t, tt.attempts, result.Attempts)
+		})
+	}
+
+	assert.Equal(t, "4", retryMetrics.Get("test.server.attempts").String())
+	assert.Equal(t, "3", retryMetrics.Get("test.server.retries").String())
+	assert.Equal(t, "1", retryMetrics.Get("test.auth.errors.auth").String())
+}
+
+func TestRetryHonoursRetryAfter(t *testing.T) {
+	config := RetryConfig{MaxRetries: 2, InitialDelay: time.Hour, MaxDelay: time.Second, BackoffMultiplier: 2}
+
+	// The announced 20ms replaces the one-hour backoff
+	calls := 0
+	result, err := Retry(context.Background(), config, "", func() error {
+		calls++
+		if calls == 1 {
+			return &ProviderError{Kind: ErrorKindRateLimited, StatusCode: http.StatusTooManyRequests, RetryAfter: 20 * time.Millisecond}
+		}
+		return nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, 2, result.Attempts)
+	assert.Equal(t, 20*time.Millisecond, result.Waited)
+
+	// A delay longer than MaxDelay is not waited for
+	rateLimited := &ProviderError{Kind: ErrorKindRateLimited, StatusCode: http.StatusTooManyRequests, RetryAfter: time.Minute}
+	result, err = Retry(context.Background(), config, "", func() error { return rateLimited })
+	assert.ErrorIs(t, err, rateLimited)
+	assert.Equal(t, 1, result.Attempts)
+}
@@ -0,0 +1,138 @@
+package utils
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// StructuredAnswer is the answer of the structured answer mode
+// Sources are the numbers of the context documents ("Document 2" is 2) the answer is based on
+// Answerable is false when the documents do not contain the answer, the UI shows "not found in docs" instead of an answer
+type StructuredAnswer struct {
+	Answer     string  `json:"answer"`
+	Sources    []int   `json:"sources"`
+	Confidence float64 `json:"confidence"`
+	Answerable bool    `json:"answerable"`
+}
+
+// ErrMalformedStructuredAnswer is returned when the model did not produce a valid structured answer, even after a retry
+var ErrMalformedStructuredAnswer = errors.New("the AI provider did not return a valid structured answer")
+
+// structuredAnswerInstructions is appended to the system prompt in structured mode
+// The format is requested in the prompt rather than with a provider JSON mode, so every provider supports it
+const structuredAnswerInstructions = `
+RESPONSE FORMAT:
+Reply with a single JSON object and nothing else (no markdown, no code fences), with exactly these fields:
+{"answer": string, "sources": array of integers, "confidence": number, "answerable": boolean}
+- "answer": the answer to the question, or a short explanation when the documents do not contain it
+- "sources": the numbers of the documents the answer is based on (1 for "Document 1"), empty when not answerable
+- "confidence": how well the documents support the answer, from 0.0 (not at all) to 1.0 (explicitly stated)
+- "answerable": true only when the documents contain the answer`
+
+// StructuredAnswerPrompt returns the prompt extended with the structured answer instructions
+func StructuredAnswerPrompt(prompt RenderedPrompt) RenderedPrompt {
+	prompt.System = strings.TrimRight(prompt.System, "\n") + "\n" + structuredAnswerInstructions
+	return prompt
+}
+
+// StructuredAnswerRepairPrompt returns the prompt of the retry sent after a malformed reply
+// The previous reply and what was wrong with it are added to the user message
+func StructuredAnswerRepairPrompt(prompt RenderedPrompt, reply string, validationErr error) RenderedPrompt {
+	prompt.User = fmt.Sprintf("%s\n\nYour previous reply was not valid: %v\nPrevious reply:\n%s\n\nReply again with only the JSON object described in the instructions.",
+		prompt.User, validationErr, reply)
+	return prompt
+}
+
+// structuredAnswerFields mirrors StructuredAnswer with pointers, so missing fields can be told from zero values
+type structuredAnswerFields struct {
+	Answer     *string  `json:"answer"`
+	Sources    []int    `json:"sources"`
+	Confidence *float64 `json:"confidence"`
+	Answerable *bool    `json:"answerable"`
+}
+
+// ParseStructuredAnswer decodes and validates a structured reply of the model
+// sourceCount is the number of documents sent as context, cited sources must be within 1..sourceCount
+// Code fences and text around the JSON object are tolerated, unknown fields are not
+func ParseStructuredAnswer(reply string, sourceCount int) (StructuredAnswer, error) {
+	var answer StructuredAnswer
+
+	object, err := extractJSONObject(reply)
+	if err != nil {
+		return answer, err
+	}
+
+	var fields structuredAnswerFields
+	decoder := json.NewDecoder(strings.NewReader(object))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&fields); err != nil {
+		return answer, fmt.Errorf("invalid JSON: %v", err)
+	}
+
+	switch {
+	case fields.Answer == nil:
+		return answer, errors.New(`missing field "answer"`)
+	case fields.Confidence == nil:
+		return answer, errors.New(`missing field "confidence"`)
+	case fields.Answerable == nil:
+		return answer, errors.New(`missing field "answerable"`)
+	}
+
+	answer.Answer = strings.TrimSpace(*fields.Answer)
+	answer.Confidence = *fields.Confidence
+	answer.Answerable = *fields.Answerable
+	answer.Sources = []int{}
+
+	if answer.Confidence < 0 || answer.Confidence > 1 {
+		return answer, fmt.Errorf(`"confidence" must be between 0 and 1, got %v`, answer.Confidence)
+	}
+
+	seen := make(map[int]bool, len(fields.Sources))
+	for _, source := range fields.Sources {
+		if source < 1 || source > sourceCount {
+			return answer, fmt.Errorf(`"sources" contains %d, documents are numbered 1 to %d`, source, sourceCount)
+		}
+		if !seen[source] {
+			seen[source] = true
+			answer.Sources = append(answer.Sources, source)
+		}
+	}
+
+	if answer.Answerable {
+		if answer.Answer == "" {
+			return answer, errors.New(`"answer" cannot be empty when "answerable" is true`)
+		}
+		if len(answer.Sources) == 0 {
+			return answer, errors.New(`"sources" cannot be empty when "answerable" is true`)
+		}
+	} else {
+		// An abstention cites nothing, whatever the model listed
+		answer.Sources = []int{}
+	}
+
+	return answer, nil
+}
+
+// UnanswerableStructuredAnswer is the structured answer returned without calling the model,
+// when no document is relevant to the question
+func UnanswerableStructuredAnswer(message string) StructuredAnswer {
+	return StructuredAnswer{Answer: message, Sources: []int{}, Answerable: false}
+}
+
+// extractJSONObject returns the outermost JSON object of a reply
+// Models often wrap JSON in ```json fences or add a sentence before it
+func extractJSONObject(reply string) (string, error) {
+	start := strings.Index(reply, "{")
+	end := strings.LastIndex(reply, "}")
+	if start < 0 || end < start {
+		return "", errors.New("no JSON object found")
+	}
+
+	object := reply[start : end+1]
+	if !json.Valid([]byte(object)) {
+		return "", errors.New("invalid JSON object")
+	}
+	return object, nil
+}
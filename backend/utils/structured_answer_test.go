@@ -0,0 +1,120 @@
+package utils
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseStructuredAnswer(t *testing.T) {
+	tests := []struct {
+		name        string
+		reply       string
+		expected    StructuredAnswer
+		expectError bool
+	}{
+		{
+			name:     "Answerable",
+			reply:    `{"answer": "15 days", "sources": [2, 1, 2], "confidence": 0.9, "answerable": true}`,
+			expected: StructuredAnswer{Answer: "15 days", Sources: []int{2, 1}, Confidence: 0.9, Answerable: true},
+		},
+		{
+			name:     "Wrapped in a code fence",
+			reply:    "Here is the answer:\n```json\n{\"answer\": \"15 days\", \"sources\": [1], \"confidence\": 1, \"answerable\": true}\n```",
+			expected: StructuredAnswer{Answer: "15 days", Sources: []int{1}, Confidence: 1, Answerable: true},
+		},
+		{
+			name:     "Abstention drops sources",
+			reply:    `{"answer": "Not in the documents", "sources": [1], "confidence": 0, "answerable": false}`,
+			expected: StructuredAnswer{Answer: "Not in the documents", Sources: []int{}, Answerable: false},
+		},
+		{
+			name:        "Free text",
+			reply:       "Employees get 15 vacation days.",
+			expectError: true,
+		},
+		{
+			name:        "Missing field",
+			reply:       `{"answer": "15 days", "sources": [1], "answerable": true}`,
+			expectError: true,
+		},
+		{
+			name:        "Unknown field",
+			reply:       `{"answer": "15 days", "sources": [1], "confidence": 0.5, "answerable": true, "notes": "x"}`,
+			expectError: true,
+		},
+		{
+			name:        "Confidence out of range",
+			reply:       `{"answer": "15 days", "sources": [1], "confidence": 7, "answerable": true}`,
+			expectError: true,
+		},
+		{
+			name:        "Source that was not sent",
+			reply:       `{"answer": "15 days", "sources": [4], "confidence": 0.5, "answerable": true}`,
+			expectError: true,
+		},
+		{
+			name:        "Answerable without sources",
+			reply:       `{"answer": "15 days", "sources": [], "confidence": 0.5, "answerable": true}`,
+			expectError: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			answer, err := ParseStructuredAnswer(tt.reply, 3)
+			if tt.expectError {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, answer)
+		})
+	}
+}
+
+func TestStructuredAnswerPrompts(t *testing.T) {
+	prompt := StructuredAnswerPrompt(RenderedPrompt{System: "Instructions\n", User: "QUESTION: vacation?"})
+	assert.Contains(t, prompt.System, "Instructions\n")
+	assert.Contains(t, prompt.System, `"answerable": boolean`)
+
+	repair := StructuredAnswerRepairPrompt(prompt, "not json", assert.AnError)
+	assert.Equal(t, prompt.System, repair.System)
+	assert.Contains(t, repair.User, "QUESTION: vacation?")
+	assert.Contains(t, repair.User, "not json")
+	assert.Contains(t, repair.User, assert.AnError.Error())
+}
+
+func TestExtractiveChatServiceStructured(t *testing.T) {
+	tmpl, err := BuiltinPromptTemplate(RAGAnswerTemplate)
+	require.NoError(t, err)
+
+	contextText := "Based on the following information from the documents:\n\n" +
+		"Document 1:\nThe office opens at 8am.\n\n" +
+		"Document 2:\nEmployees get 15 vacation days per year.\n\n"
+	service := NewExtractiveChatService("Extractive")
+
+	prompt, err := tmpl.Render(PromptData{Question: "How many vacation days do employees get?", Context: contextText})
+	require.NoError(t, err)
+	reply, err := service.GenerateResponse(context.Background(), prompt.User, StructuredAnswerPrompt(prompt).System)
+	require.NoError(t, err)
+
+	answer, err := ParseStructuredAnswer(reply, 2)
+	require.NoError(t, err)
+	assert.True(t, answer.Answerable)
+	assert.Equal(t, []int{2}, answer.Sources)
+	assert.Contains(t, answer.Answer, "15 vacation days")
+	assert.NotContains(t, answer.Answer, "RESPONSE FORMAT")
+
+	prompt, err = tmpl.Render(PromptData{Question: "Who is the CEO?", Context: contextText})
+	require.NoError(t, err)
+	reply, err = service.GenerateResponse(context.Background(), prompt.User, StructuredAnswerPrompt(prompt).System)
+	require.NoError(t, err)
+
+	answer, err = ParseStructuredAnswer(reply, 2)
+	require.NoError(t, err)
+	assert.False(t, answer.Answerable)
+	assert.Empty(t, answer.Sources)
+}
@@ -0,0 +1,53 @@
+package utils
+
+import (
+	"context"
+	"time"
+)
+
+// Stage identifies a step of a request that has its own deadline
+type Stage string
+
+const (
+	EmbeddingStage Stage = "embedding"
+	ChatStage      Stage = "chat"
+	RetrievalStage Stage = "retrieval"
+	IngestionStage Stage = "ingestion"
+)
+
+// Default stage deadlines, used when the configuration is not loaded (tests) or a value is not positive
+var defaultStageTimeouts = map[Stage]time.Duration{
+	EmbeddingStage: 30 * time.Second,
+	ChatStage:      60 * time.Second,
+	RetrievalStage: 10 * time.Second,
+	IngestionStage: 300 * time.Second,
+}
+
+// StageTimeout returns the deadline configured for a stage
+func StageTimeout(config *Config, stage Stage) time.Duration {
+	var seconds int64
+	if config != nil {
+		switch stage {
+		case EmbeddingStage:
+			seconds = config.EmbeddingTimeoutSecs
+		case ChatStage:
+			seconds = config.ChatTimeoutSecs
+		case RetrievalStage:
+			seconds = config.RetrievalTimeoutSecs
+		case IngestionStage:
+			seconds = config.IngestionTimeoutSecs
+		}
+	}
+
+	if seconds <= 0 {
+		return defaultStageTimeouts[stage]
+	}
+	return time.Duration(seconds) * time.Second
+}
+
+// WithStageTimeout derives a context that expires after the stage deadline
+// The parent keeps its own deadline and cancellation, so a client disconnect still aborts the stage early
+// The returned cancel function must always be called to release the timer
+func WithStageTimeout(ctx context.Context, stage Stage) (context.Context, context.CancelFunc) {
+	return context.WithTimeout(ctx, StageTimeout(AppConfig, stage))
+}
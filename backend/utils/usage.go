@@ -0,0 +1,126 @@
+package utils
+
+import (
+	"context"
+	"sync"
+)
+
+// Usage operations, stored with each usage record
+const (
+	UsageOperationChat      = "chat"
+	UsageOperationEmbedding = "embedding"
+)
+
+// TokenUsage is the token count of one provider call
+// Embedding calls only have prompt tokens
+// Estimated is true when the provider did not report usage and the count was approximated from the text
+type TokenUsage struct {
+	Operation        string `json:"operation"`
+	Provider         string `json:"provider"`
+	Model            string `json:"model"`
+	PromptTokens     int    `json:"prompt_tokens"`
+	CompletionTokens int    `json:"completion_tokens"`
+	Estimated        bool   `json:"estimated"`
+}
+
+// TotalTokens returns the prompt and completion tokens of the call
+func (u TokenUsage) TotalTokens() int {
+	return u.PromptTokens + u.CompletionTokens
+}
+
+// UsageCollector gathers the token usage of the provider calls made for one API request
+// Embedding batches run in parallel, so entries are added under a mutex
+type UsageCollector struct {
+	entries []TokenUsage
+	mutex   sync.Mutex
+}
+
+// NewUsageCollector creates an empty collector
+func NewUsageCollector() *UsageCollector {
+	return &UsageCollector{}
+}
+
+// Add records the usage of one call
+func (c *UsageCollector) Add(usage TokenUsage) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.entries = append(c.entries, usage)
+}
+
+// Entries returns a copy of the recorded usage, in call order
+func (c *UsageCollector) Entries() []TokenUsage {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return append([]TokenUsage(nil), c.entries...)
+}
+
+// TotalTokens returns the tokens of every recorded call
+func (c *UsageCollector) TotalTokens() int {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	total := 0
+	for _, entry := range c.entries {
+		total += entry.TotalTokens()
+	}
+	return total
+}
+
+// Summary returns the recorded usage added up per operation, provider and model, in first-use order
+// An ingestion sends many embedding batches, they become a single entry
+// A summed entry is estimated when any of its calls was
+func (c *UsageCollector) Summary() []TokenUsage {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	var summary []TokenUsage
+	index := make(map[[3]string]int)
+	for _, entry := range c.entries {
+		key := [3]string{entry.Operation, entry.Provider, entry.Model}
+		i, exists := index[key]
+		if !exists {
+			index[key] = len(summary)
+			summary = append(summary, entry)
+			continue
+		}
+		summary[i].PromptTokens += entry.PromptTokens
+		summary[i].CompletionTokens += entry.CompletionTokens
+		summary[i].Estimated = summary[i].Estimated || entry.Estimated
+	}
+	return summary
+}
+
+// usageCollectorKey is the context key of the request's UsageCollector
+type usageCollectorKey struct{}
+
+// WithUsageCollector returns a context whose provider calls are recorded in collector
+func WithUsageCollector(ctx context.Context, collector *UsageCollector) context.Context {
+	return context.WithValue(ctx, usageCollectorKey{}, collector)
+}
+
+// UsageCollectorFrom returns the collector of a context, nil when there is none
+func UsageCollectorFrom(ctx context.Context) *UsageCollector {
+	collector, _ := ctx.Value(usageCollectorKey{}).(*UsageCollector)
+	return collector
+}
+
+// RecordTokenUsage adds the usage of a provider call to the collector of the context
+// Calls made outside an API request (startup checks, tools) have no collector and are not recorded
+// Calls that report no tokens at all are skipped
+func RecordTokenUsage(ctx context.Context, usage TokenUsage) {
+	if usage.TotalTokens() == 0 {
+		return
+	}
+	if collector := UsageCollectorFrom(ctx); collector != nil {
+		collector.Add(usage)
+	}
+}
+
+// estimatedPromptTokens approximates the tokens of texts sent to a provider that reports no usage
+func estimatedPromptTokens(texts ...string) int {
+	total := 0
+	for _, text := range texts {
+		total += EstimateTokens(text)
+	}
+	return total
+}
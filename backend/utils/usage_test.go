@@ -0,0 +1,69 @@
+package utils
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestUsageCollectorSummary(t *testing.T) {
+	collector := NewUsageCollector()
+	ctx := WithUsageCollector(context.Background(), collector)
+
+	RecordTokenUsage(ctx, TokenUsage{Operation: UsageOperationEmbedding, Provider: "openai", Model: "text-embedding-3-small", PromptTokens: 100})
+	RecordTokenUsage(ctx, TokenUsage{Operation: UsageOperationChat, Provider: "openai", Model: "gpt-4o-mini", PromptTokens: 500, CompletionTokens: 80})
+	RecordTokenUsage(ctx, TokenUsage{Operation: UsageOperationEmbedding, Provider: "openai", Model: "text-embedding-3-small", PromptTokens: 50, Estimated: true})
+	// Calls without tokens are not recorded
+	RecordTokenUsage(ctx, TokenUsage{Operation: UsageOperationChat, Provider: "lexical", Model: "extractive"})
+
+	assert.Len(t, collector.Entries(), 3)
+	assert.Equal(t, 730, collector.TotalTokens())
+	assert.Equal(t, []TokenUsage{
+		{Operation: UsageOperationEmbedding, Provider: "openai", Model: "text-embedding-3-small", PromptTokens: 150, Estimated: true},
+		{Operation: UsageOperationChat, Provider: "openai", Model: "gpt-4o-mini", PromptTokens: 500, CompletionTokens: 80},
+	}, collector.Summary())
+}
+
+func TestRecordTokenUsageWithoutCollector(t *testing.T) {
+	assert.NotPanics(t, func() {
+		RecordTokenUsage(context.Background(), TokenUsage{Operation: UsageOperationChat, PromptTokens: 10})
+	})
+	assert.Nil(t, UsageCollectorFrom(context.Background()))
+}
+
+func TestProvidersRecordTokenUsage(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Path {
+		case "/v1/chat/completions":
+			w.Write([]byte(`{"choices":[{"message":{"role":"assistant","content":"answer"}}],"usage":{"prompt_tokens":42,"completion_tokens":7,"total_tokens":49}}`))
+		case "/v1/embeddings":
+			// No usage reported, the tokens are estimated
+			w.Write([]byte(`{"data":[{"embedding":[0.1,0.2],"index":0}]}`))
+		}
+	}))
+	defer server.Close()
+
+	config := &Config{
+		OpenAICompatibleBaseURL: server.URL + "/v1",
+		ChatModel:               "local-chat",
+		EmbeddingModel:          "local-embed",
+	}
+
+	collector := NewUsageCollector()
+	ctx := WithUsageCollector(context.Background(), collector)
+
+	_, err := NewOpenAICompatibleChatService(config).GenerateResponse(ctx, "question", "system")
+	require.NoError(t, err)
+	_, err = NewOpenAICompatibleEmbeddingService(config).GenerateEmbedding(ctx, "sixteen char txt")
+	require.NoError(t, err)
+
+	assert.Equal(t, []TokenUsage{
+		{Operation: UsageOperationChat, Provider: string(OpenAICompatibleProvider), Model: "local-chat", PromptTokens: 42, CompletionTokens: 7},
+		{Operation: UsageOperationEmbedding, Provider: string(OpenAICompatibleProvider), Model: "local-embed", PromptTokens: EstimateTokens("sixteen char txt"), Estimated: true},
+	}, collector.Entries())
+}